@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kriuchkov/power/internal/cli"
+	"github.com/kriuchkov/power/internal/config"
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+
+	"github.com/go-faster/errors"
+	"github.com/joho/godotenv"
+	log "github.com/sirupsen/logrus"
+)
+
+type fetchResult struct {
+	Index    int    `json:"index"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+
+	err error
+}
+
+// fetch gets count messages with concurrency sessions, the timeout bounds every message.
+func fetch(ctx context.Context, args []string) error {
+	powDebug, _ := strconv.ParseBool(os.Getenv("POW_DEBUG"))
+	if powDebug {
+		godotenv.Load(".env") //nolint:errcheck // ok for this case
+		log.SetLevel(log.DebugLevel)
+		log.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+	}
+
+	flags := cli.NewFlagSet(program, "fetch", os.Stderr)
+	printConfig := flags.Bool("print-config", false, "print the effective config and exit")
+	count := flags.Int("count", 1, "the number of the messages")
+	concurrency := flags.Int("concurrency", 1, "the number of the parallel sessions")
+	output := flags.String("output", "text", "the output format: text or json (one object per line)")
+
+	var conf config.ClientConfig
+	loader := &config.Loader{Prefix: "client", Args: args, FlagSet: flags}
+	if err := loader.Load(&conf); err != nil {
+		return cli.ConfigError(err)
+	}
+
+	switch {
+	case *count < 1, *concurrency < 1:
+		return errors.Wrap(cli.ErrUsage, "count and concurrency must be positive")
+	case *output != "text" && *output != "json":
+		return errors.Wrapf(cli.ErrUsage, "unknown output format %q", *output)
+	}
+
+	if *printConfig {
+		return config.Print(os.Stdout, &conf) //nolint:wrapcheck // it's the only error
+	}
+
+	log.WithField("config", config.Redact(conf)).Debug("config loaded")
+
+	dialer, err := newDialer(&conf)
+	if err != nil {
+		return cli.Exit(cli.ExitConfig, errors.Wrap(err, "create a dialer"))
+	}
+
+	pool := client.NewPool(&client.PoolDependencies{
+		Addresses: []string{conf.ServerAddr},
+		Size:      min(*concurrency, *count),
+		Dialer:    dialer,
+		Resource:  conf.Resource,
+		Retry:     client.RetryPolicy{MaxAttempts: conf.RetryMaxAttempts, Jitter: 0.2, RetryInvalidHash: true},
+		Policy: client.SolvePolicy{
+			MaxDifficulty:       conf.MaxDifficulty,
+			MaxExpectedAttempts: conf.MaxExpectedAttempts,
+			SolveBudget:         conf.SolveBudget,
+			Algorithms:          append([]string{pow.AlgorithmSHA256}, conf.Puzzles...),
+		},
+		Puzzles: map[string]pow.PuzzleSolver{
+			pow.AlgorithmRSW:    pow.RSWSolver{MaxSquarings: conf.RSWMaxSquarings},
+			pow.AlgorithmCuckoo: pow.CuckooSolver{MaxEdgeBits: conf.CuckooMaxEdgeBits},
+		},
+		PrivateTokens: conf.PrivateTokens,
+		Hooks: client.Hooks{
+			OnRetry: func(attempt int, delay time.Duration, err error) {
+				log.WithError(err).WithFields(log.Fields{"attempt": attempt, "delay": delay}).Warn("retry")
+			},
+		},
+	})
+	defer pool.Close()
+
+	results := make(chan fetchResult)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(*concurrency, *count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results <- fetchOne(ctx, pool, index, conf.Timeout)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(indexes)
+		for index := range *count {
+			indexes <- index
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	encoder := json.NewEncoder(os.Stdout)
+
+	for result := range results {
+		if result.err != nil && firstErr == nil {
+			firstErr = result.err
+		}
+
+		switch {
+		case *output == "json":
+			if err = encoder.Encode(result); err != nil {
+				return errors.Wrap(err, "write the result")
+			}
+		case result.Error == "":
+			fmt.Println(result.Message)
+		}
+	}
+	return clientError(firstErr)
+}
+
+func fetchOne(ctx context.Context, pool *client.Pool, index int, timeout time.Duration) fetchResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	msg, err := pool.GetMessage(ctx)
+
+	result := fetchResult{Index: index, Message: string(msg), Duration: time.Since(start).String()}
+	if err != nil {
+		log.WithError(err).WithField("index", index).Error("get message")
+		result.Error, result.err = err.Error(), err
+	}
+	return result
+}
+
+func newDialer(conf *config.ClientConfig) (client.Dialer, error) {
+	netDialer := &net.Dialer{Timeout: conf.DialTimeout}
+	if !conf.TLS {
+		return netDialer, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         conf.TLSServerName,
+		InsecureSkipVerify: conf.TLSInsecureSkipVerify, //nolint:gosec // it's an explicit option
+	}
+
+	if conf.TLSCAFile != "" {
+		caPEM, err := os.ReadFile(conf.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read the CA file")
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates in the CA file")
+		}
+	}
+	return &tls.Dialer{NetDialer: netDialer, Config: tlsConfig}, nil
+}
@@ -2,58 +2,56 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
-	"strconv"
-	"time"
 
-	"github.com/kriuchkov/power/internal/config"
-	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/internal/cli"
 	"github.com/kriuchkov/power/pkg/client"
 
-	"github.com/joho/godotenv"
-	"github.com/kelseyhightower/envconfig"
-	log "github.com/sirupsen/logrus"
+	"github.com/go-faster/errors"
 )
 
+const program = "client"
+
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	powDebug, _ := strconv.ParseBool(os.Getenv("POW_DEBUG"))
-	if powDebug {
-		godotenv.Load(".env") //nolint:errcheck // ok for this case
-		log.SetLevel(log.DebugLevel)
-		log.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
-	}
-
-	var conf config.Config
-	err := envconfig.Process("server", &conf)
-	if err != nil {
-		log.Panicf("loading config: %s", err.Error())
-	}
-
-	log.WithField("config", conf).Info("config loaded")
-
-	serverConn, err := net.Dial("tcp", conf.ServerAddr)
-	if err != nil {
-		log.Panicf("connect to server: %s", err.Error())
+	commands := []cli.Command{
+		{Name: "fetch", Usage: "fetch the content from the server (default)", Run: fetch},
+		{Name: "solve", Usage: "solve a challenge from stdin offline and print the nonce", Run: solve},
+		{Name: "verify", Usage: "check a nonce against a challenge from stdin", Run: verify},
+		{Name: "version", Usage: "print the version", Run: version},
 	}
-	defer serverConn.Close()
 
-	client := client.New(&client.Dependencies{ServerConn: serverConn, Hasher: pow.NewPow(conf.Difficulty)})
-	if err != nil {
-		log.WithError(err).Panic("create a new client")
-	}
+	code := cli.Run(ctx, program, commands, "fetch", os.Args[1:], os.Stderr)
+	cancel()
+	os.Exit(code) //nolint:gocritic // the context is canceled above
+}
 
-	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+func version(_ context.Context, _ []string) error {
+	fmt.Println(program, cli.VersionString())
+	return nil
+}
 
-	msg, err := client.GetMessage(ctx)
-	if err != nil {
-		log.WithError(err).Panic("get message")
+// clientError maps the client errors to the exit codes.
+func clientError(err error) error {
+	var opErr *net.OpError
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, client.ErrChallengeRefused), errors.Is(err, client.ErrSolveBudget):
+		return cli.Exit(cli.ExitRefused, err)
+	case errors.Is(err, client.ErrInvalidHash):
+		return cli.Exit(cli.ExitInvalid, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return cli.Exit(cli.ExitTimeout, err)
+	case errors.As(err, &opErr):
+		return cli.Exit(cli.ExitUnavailable, err)
+	default:
+		return err
 	}
-
-	log.WithField("message", string(msg)).Info("message received")
 }
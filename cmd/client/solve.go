@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kriuchkov/power/internal/cli"
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+	"github.com/kriuchkov/power/pkg/common"
+
+	"github.com/go-faster/errors"
+)
+
+var errInvalidSolution = errors.New("invalid solution")
+
+// challengeFlags are the flags of the offline commands: the challenge is the "hash|index|value"
+// verify message read from stdin.
+type challengeFlags struct {
+	difficulty int
+	hexHash    bool
+}
+
+func (f *challengeFlags) register(flags *flag.FlagSet) {
+	flags.IntVar(&f.difficulty, "difficulty", 1, "the number of the leading '0' bytes")
+	flags.BoolVar(&f.hexHash, "hex", false, "the hash of the challenge is hex-encoded")
+}
+
+func (f *challengeFlags) read(r io.Reader) (*client.Challenge, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read the challenge")
+	}
+
+	hash, byteIndex, byteValue, err := common.SplitMessage([]byte(strings.TrimRight(string(raw), "\r\n")))
+	if err != nil {
+		return nil, errors.Wrapf(cli.ErrUsage, `the challenge must be "hash|index|value": %v`, err)
+	}
+
+	if f.hexHash {
+		if hash, err = hex.DecodeString(string(hash)); err != nil {
+			return nil, errors.Wrap(cli.ErrUsage, "the hash isn't hex-encoded")
+		}
+	}
+
+	return &client.Challenge{
+		Algorithm:  pow.AlgorithmSHA256,
+		Difficulty: f.difficulty,
+		Hash:       hash,
+		ByteIndex:  byteIndex,
+		ByteValue:  byteValue,
+	}, nil
+}
+
+// solve finds the nonce of a challenge offline.
+func solve(ctx context.Context, args []string) error {
+	var challengeArgs challengeFlags
+
+	flags := cli.NewFlagSet(program, "solve", os.Stderr)
+	challengeArgs.register(flags)
+	timeout := flags.Duration("timeout", 0, "give up after the timeout, zero means no limit")
+	maxDifficulty := flags.Int("max-difficulty", 0, "refuse the harder challenges, zero means no limit")
+
+	if err := flags.Parse(args); err != nil {
+		return cli.Exit(cli.ExitUsage, err)
+	}
+
+	challenge, err := challengeArgs.read(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	policy := client.SolvePolicy{
+		MaxDifficulty: *maxDifficulty,
+		Algorithms:    []string{pow.AlgorithmSHA256},
+	}
+	if err = policy.Check(challenge); err != nil {
+		return clientError(err)
+	}
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	nonce := pow.NewPow(challenge.Difficulty).FindNonce(ctx, challenge.Hash, challenge.ByteIndex, challenge.ByteValue)
+	if nonce < 0 {
+		return clientError(errors.Wrap(ctx.Err(), "find nonce"))
+	}
+
+	fmt.Println(nonce)
+	return nil
+}
+
+// verify checks the nonce of a challenge, the exit code is cli.ExitInvalid for a wrong one.
+func verify(_ context.Context, args []string) error {
+	var challengeArgs challengeFlags
+
+	flags := cli.NewFlagSet(program, "verify", os.Stderr)
+	challengeArgs.register(flags)
+	rawNonce := flags.String("nonce", "", "the nonce to check")
+
+	if err := flags.Parse(args); err != nil {
+		return cli.Exit(cli.ExitUsage, err)
+	}
+
+	nonce, err := strconv.Atoi(*rawNonce)
+	if err != nil {
+		return errors.Wrap(cli.ErrUsage, "the nonce must be an integer")
+	}
+
+	challenge, err := challengeArgs.read(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	p := pow.NewPow(challenge.Difficulty)
+	if !p.IsValidHash(p.GenerateHash(challenge.Hash, nonce), challenge.ByteIndex, challenge.ByteValue) {
+		return cli.Exit(cli.ExitInvalid, errInvalidSolution)
+	}
+
+	fmt.Println("valid")
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+
+	"github.com/go-faster/errors"
+)
+
+// The kinds of the submissions.
+const (
+	kindValid   = "valid"
+	kindInvalid = "invalid"
+	kindStale   = "stale"
+)
+
+// The outcomes of the submissions, the rejections are counted by the reason.
+const (
+	reasonInvalidHash = "invalid_hash"
+	reasonTimeout     = "timeout"
+	reasonConnection  = "connection"
+	reasonRefused     = "refused"
+	reasonOther       = "other"
+)
+
+// connectionBackoff is the pause of a session after a connection error.
+const connectionBackoff = 100 * time.Millisecond
+
+type benchConfig struct {
+	Address      string        `json:"address"`
+	Sessions     int           `json:"sessions"`
+	Duration     time.Duration `json:"duration"`
+	Ramp         time.Duration `json:"ramp"`
+	Timeout      time.Duration `json:"timeout"`
+	InvalidRatio float64       `json:"invalid_ratio"`
+	StaleRatio   float64       `json:"stale_ratio"`
+	// Difficulty solves the challenges of the servers which don't send the puzzle parameters.
+	Difficulty int `json:"difficulty"`
+}
+
+// sample is the result of one submission.
+type sample struct {
+	kind     string
+	latency  time.Duration
+	accepted bool
+	reason   string
+	hashes   int
+	solving  time.Duration
+	// forged is an accepted solution which doesn't solve the challenge or replays a solved one.
+	forged bool
+}
+
+// bench runs the sessions and collects the samples until the duration ends.
+type bench struct {
+	conf   benchConfig
+	dialer client.Dialer
+
+	mu      sync.Mutex
+	samples []sample
+	active  int
+	peak    int
+}
+
+func newBench(conf benchConfig) *bench {
+	return &bench{conf: conf, dialer: &net.Dialer{Timeout: conf.Timeout}}
+}
+
+// run starts the sessions one by one over the ramp and stops them all when the duration ends.
+func (b *bench) run(ctx context.Context) *report {
+	ctx, cancel := context.WithTimeout(ctx, b.conf.Duration)
+	defer cancel()
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range b.conf.Sessions {
+		delay := time.Duration(0)
+		if b.conf.Sessions > 1 {
+			delay = b.conf.Ramp * time.Duration(i) / time.Duration(b.conf.Sessions-1)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			wait(ctx, delay)
+			if ctx.Err() == nil {
+				b.session(ctx)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return newReport(b.conf, b.samples, time.Since(start), b.peak)
+}
+
+// session submits the solutions on one connection until ctx is done.
+func (b *bench) session(ctx context.Context) {
+	b.track(1)
+	defer b.track(-1)
+
+	cl := client.New(&client.Dependencies{
+		Address: b.conf.Address,
+		Dialer:  b.dialer,
+		Hasher:  pow.NewPow(b.conf.Difficulty),
+	})
+	defer cl.Close()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec // it's a load generator
+	var last *submission
+
+	for ctx.Err() == nil {
+		kind := kindValid
+		switch r := rnd.Float64(); {
+		case r < b.conf.InvalidRatio:
+			kind = kindInvalid
+		case r < b.conf.InvalidRatio+b.conf.StaleRatio && last != nil:
+			kind = kindStale
+		}
+
+		s := b.submit(ctx, cl, kind, last)
+		if ctx.Err() != nil && !s.accepted {
+			return // the bench is over, the interrupted submission isn't counted
+		}
+
+		if kind == kindValid && s.accepted {
+			last = &s
+		}
+		b.add(s.sample)
+
+		if s.reason == reasonConnection {
+			wait(ctx, connectionBackoff) // don't spin on a server which is down
+		}
+	}
+}
+
+func wait(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// submission is a sample with the challenge and the nonce it sent.
+type submission struct {
+	sample
+	hash  []byte
+	nonce int
+}
+
+// submit requests a challenge and sends a solution of the kind: a solved nonce, a nonce which
+// surely doesn't solve the challenge or the nonce of the challenge solved before.
+func (b *bench) submit(ctx context.Context, cl *client.Client, kind string, last *submission) submission {
+	ctx, cancel := context.WithTimeout(ctx, b.conf.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	s := submission{sample: sample{kind: kind}}
+
+	challenge, err := cl.Challenge(ctx)
+	if err != nil {
+		s.latency, s.reason = time.Since(start), rejectionReason(err)
+		return s
+	}
+
+	solver := pow.NewPow(challenge.Difficulty)
+	s.hash = challenge.Hash
+
+	var nonce int
+	switch kind {
+	case kindValid:
+		solveStart := time.Now()
+		nonce = solver.FindNonce(ctx, challenge.Hash, challenge.ByteIndex, challenge.ByteValue)
+		s.solving, s.hashes = time.Since(solveStart), nonce+1
+		if nonce < 0 {
+			s.latency, s.reason, s.hashes = time.Since(start), reasonTimeout, 0
+			return s
+		}
+
+	case kindInvalid:
+		nonce = invalidNonce(solver, challenge)
+
+	case kindStale:
+		nonce = last.nonce
+	}
+
+	s.nonce = nonce
+	_, err = cl.Redeem(ctx, nonce)
+	s.latency = time.Since(start)
+	if err != nil {
+		s.reason = rejectionReason(err)
+		return s
+	}
+
+	s.accepted = true
+	switch kind {
+	case kindInvalid:
+		s.forged = true
+	case kindStale:
+		// The stale nonce may solve the new challenge by chance, it's a replay if the challenge repeats.
+		solved := solver.IsValidHash(solver.GenerateHash(challenge.Hash, nonce), challenge.ByteIndex, challenge.ByteValue)
+		s.forged = !solved || bytes.Equal(challenge.Hash, last.hash)
+	}
+	return s
+}
+
+// invalidNonce returns a nonce which doesn't solve the challenge.
+func invalidNonce(solver *pow.Pow, challenge *client.Challenge) int {
+	for nonce := rand.Intn(math.MaxInt32); ; nonce++ { //nolint:gosec // it's a load generator
+		if !solver.IsValidHash(solver.GenerateHash(challenge.Hash, nonce), challenge.ByteIndex, challenge.ByteValue) {
+			return nonce
+		}
+	}
+}
+
+func rejectionReason(err error) string {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, client.ErrInvalidHash):
+		return reasonInvalidHash
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout
+	case errors.Is(err, client.ErrChallengeRefused):
+		return reasonRefused
+	case errors.As(err, &netErr):
+		return reasonConnection
+	default:
+		return reasonOther
+	}
+}
+
+func (b *bench) track(delta int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.active += delta
+	b.peak = max(b.peak, b.active)
+}
+
+func (b *bench) add(s sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.samples = append(b.samples, s)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serv, err := server.New(&server.Dependencies{
+		TCPAddress:     ":19390",
+		MessageHandler: func() []byte { return []byte("quote") },
+		PowHandler:     pow.NewPow(0), // about 256 hashes per challenge to keep the test fast
+	})
+	require.NoError(t, err)
+	go serv.Listen(ctx)
+
+	r := newBench(benchConfig{
+		Address:      "localhost:19390",
+		Sessions:     4,
+		Duration:     500 * time.Millisecond,
+		Ramp:         100 * time.Millisecond,
+		Timeout:      time.Second,
+		InvalidRatio: 0.2,
+		StaleRatio:   0.2,
+	}).run(ctx)
+
+	require.Equal(t, 4, r.PeakSessions)
+	require.Positive(t, r.Kinds[kindValid].Accepted)
+	require.Positive(t, r.Throughput)
+	require.Positive(t, r.HashRate)
+	require.LessOrEqual(t, r.Latency.P50, r.Latency.P99)
+	if invalid, ok := r.Kinds[kindInvalid]; ok {
+		require.Zero(t, invalid.Accepted)
+	}
+	require.Equal(t, r.Requests-r.Accepted, r.Rejections[reasonInvalidHash])
+
+	var buf bytes.Buffer
+	require.NoError(t, r.writeText(&buf))
+	require.Contains(t, buf.String(), "localhost:19390")
+
+	buf.Reset()
+	require.NoError(t, r.writeJSON(&buf))
+
+	var decoded report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, r.Requests, decoded.Requests)
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	tests := []struct {
+		name     string
+		values   []time.Duration
+		p        int
+		expected time.Duration
+	}{
+		{name: "empty", values: nil, p: 50, expected: 0},
+		{name: "p50", values: sorted, p: 50, expected: 5},
+		{name: "p90", values: sorted, p: 90, expected: 9},
+		{name: "p99", values: sorted, p: 99, expected: 10},
+		{name: "one value", values: sorted[:1], p: 99, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.expected, percentile(tt.values, tt.p))
+		})
+	}
+}
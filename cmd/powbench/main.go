@@ -0,0 +1,93 @@
+// Command powbench is a load tester for the PoW server: it runs concurrent sessions, optionally sends
+// invalid or stale solutions and reports the throughput, the latency and the rejections.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/kriuchkov/power/internal/cli"
+
+	"github.com/go-faster/errors"
+)
+
+const program = "powbench"
+
+// ErrForgedAccepted is returned when the server accepts an invalid or replayed solution.
+var ErrForgedAccepted = errors.New("the server accepted forged solutions")
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	commands := []cli.Command{
+		{Name: "run", Usage: "run the load test (default)", Run: func(ctx context.Context, args []string) error {
+			return run(ctx, args, os.Stdout)
+		}},
+		{Name: "version", Usage: "print the version", Run: version},
+	}
+
+	code := cli.Run(ctx, program, commands, "run", os.Args[1:], os.Stderr)
+	cancel()
+	os.Exit(code) //nolint:gocritic // the context is canceled above
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := cli.NewFlagSet(program, "run", os.Stderr)
+
+	var conf benchConfig
+	flags.StringVar(&conf.Address, "addr", "localhost:9090", "the server address")
+	flags.IntVar(&conf.Sessions, "sessions", 10, "the number of the concurrent sessions")
+	flags.DurationVar(&conf.Duration, "duration", 10*time.Second, "the duration of the test, the ramp included")
+	flags.DurationVar(&conf.Ramp, "ramp", 0, "the time to start all the sessions, they start at once by default")
+	flags.DurationVar(&conf.Timeout, "timeout", 5*time.Second, "the timeout of a submission")
+	flags.Float64Var(&conf.InvalidRatio, "invalid-ratio", 0, "the share of the solutions with a wrong nonce, 0..1")
+	flags.Float64Var(&conf.StaleRatio, "stale-ratio", 0, "the share of the solutions which resend a redeemed nonce, 0..1")
+	flags.IntVar(&conf.Difficulty, "difficulty", 4, "the difficulty of the servers which don't send the puzzle parameters")
+	output := flags.String("output", "text", "the output format: text or json")
+
+	if err := flags.Parse(args); err != nil {
+		return cli.Exit(cli.ExitUsage, err)
+	}
+
+	switch {
+	case conf.Sessions < 1:
+		return errors.Wrap(cli.ErrUsage, "sessions must be positive")
+	case conf.Duration <= 0, conf.Timeout <= 0, conf.Ramp < 0:
+		return errors.Wrap(cli.ErrUsage, "duration and timeout must be positive, ramp can't be negative")
+	case conf.Ramp >= conf.Duration:
+		return errors.Wrap(cli.ErrUsage, "ramp must be shorter than duration")
+	case conf.InvalidRatio < 0, conf.StaleRatio < 0, conf.InvalidRatio+conf.StaleRatio > 1:
+		return errors.Wrap(cli.ErrUsage, "the ratios must be in 0..1 and their sum can't exceed 1")
+	case *output != "text" && *output != "json":
+		return errors.Wrapf(cli.ErrUsage, "unknown output format %q", *output)
+	}
+
+	r := newBench(conf).run(ctx)
+
+	write := r.writeText
+	if *output == "json" {
+		write = r.writeJSON
+	}
+	if err := write(stdout); err != nil {
+		return errors.Wrap(err, "write the report")
+	}
+
+	switch {
+	case r.Forged > 0:
+		return cli.Exit(cli.ExitInvalid, ErrForgedAccepted)
+	case r.Accepted == 0 && r.Rejections[reasonConnection] > 0:
+		return cli.Exit(cli.ExitUnavailable, errors.New("no solution is accepted, the server is unavailable"))
+	default:
+		return nil
+	}
+}
+
+func version(_ context.Context, _ []string) error {
+	fmt.Println(program, cli.VersionString())
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// report is the summary of a bench run.
+type report struct {
+	Config  benchConfig   `json:"config"`
+	Elapsed time.Duration `json:"elapsed"`
+	// PeakSessions is the number of the sessions which ran at the same time.
+	PeakSessions int `json:"peak_sessions"`
+
+	Requests int `json:"requests"`
+	Accepted int `json:"accepted"`
+	// Forged is the number of the invalid and replayed solutions the server accepted.
+	Forged     int     `json:"forged"`
+	Throughput float64 `json:"throughput"` // the accepted valid solutions per second
+
+	Latency latency `json:"latency"`
+
+	// Kinds counts the submissions by the kind and the outcome.
+	Kinds map[string]*kindStats `json:"kinds"`
+	// Rejections counts the failed submissions by the reason.
+	Rejections map[string]int `json:"rejections"`
+
+	Hashes   int     `json:"hashes"`
+	HashRate float64 `json:"hash_rate"` // the hashes per second of the solving time of all the sessions
+}
+
+type kindStats struct {
+	Sent     int `json:"sent"`
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+}
+
+// latency holds the percentiles of the accepted valid solutions, a challenge round trip included.
+type latency struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+func newReport(conf benchConfig, samples []sample, elapsed time.Duration, peak int) *report {
+	r := &report{
+		Config:       conf,
+		Elapsed:      elapsed,
+		PeakSessions: peak,
+		Requests:     len(samples),
+		Kinds:        map[string]*kindStats{},
+		Rejections:   map[string]int{},
+	}
+
+	var (
+		latencies []time.Duration
+		solving   time.Duration
+	)
+
+	for _, s := range samples {
+		stats, ok := r.Kinds[s.kind]
+		if !ok {
+			stats = &kindStats{}
+			r.Kinds[s.kind] = stats
+		}
+		stats.Sent++
+
+		r.Hashes += s.hashes
+		solving += s.solving
+
+		if !s.accepted {
+			stats.Rejected++
+			r.Rejections[s.reason]++
+			continue
+		}
+
+		stats.Accepted++
+		r.Accepted++
+		if s.forged {
+			r.Forged++
+		}
+		if s.kind == kindValid {
+			latencies = append(latencies, s.latency)
+		}
+	}
+
+	if elapsed > 0 {
+		r.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	}
+	if solving > 0 {
+		r.HashRate = float64(r.Hashes) / solving.Seconds()
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.Latency = latency{
+		P50: percentile(latencies, 50),
+		P90: percentile(latencies, 90),
+		P99: percentile(latencies, 99),
+	}
+	if len(latencies) > 0 {
+		r.Latency.Max = latencies[len(latencies)-1]
+	}
+	return r
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r) //nolint:wrapcheck // it's the only error
+}
+
+func (r *report) writeText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "target:      %s\n", r.Config.Address)
+	fmt.Fprintf(&b, "sessions:    %d (peak %d), ramp %s\n", r.Config.Sessions, r.PeakSessions, r.Config.Ramp)
+	fmt.Fprintf(&b, "elapsed:     %s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "requests:    %d, accepted %d\n", r.Requests, r.Accepted)
+	fmt.Fprintf(&b, "throughput:  %.2f solutions/s\n", r.Throughput)
+	fmt.Fprintf(&b, "latency:     p50 %s, p90 %s, p99 %s, max %s\n",
+		r.Latency.P50.Round(time.Microsecond), r.Latency.P90.Round(time.Microsecond),
+		r.Latency.P99.Round(time.Microsecond), r.Latency.Max.Round(time.Microsecond))
+	fmt.Fprintf(&b, "hash rate:   %.0f hashes/s (%d hashes)\n", r.HashRate, r.Hashes)
+
+	b.WriteString("submissions:\n")
+	for _, kind := range []string{kindValid, kindInvalid, kindStale} {
+		if stats, ok := r.Kinds[kind]; ok {
+			fmt.Fprintf(&b, "  %-8s sent %d, accepted %d, rejected %d\n", kind, stats.Sent, stats.Accepted, stats.Rejected)
+		}
+	}
+
+	if len(r.Rejections) > 0 {
+		b.WriteString("rejections:\n")
+
+		reasons := make([]string, 0, len(r.Rejections))
+		for reason := range r.Rejections {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+
+		for _, reason := range reasons {
+			fmt.Fprintf(&b, "  %-13s %d\n", reason, r.Rejections[reason])
+		}
+	}
+
+	if r.Forged > 0 {
+		fmt.Fprintf(&b, "WARNING: the server accepted %d invalid or replayed solutions\n", r.Forged)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err //nolint:wrapcheck // it's the only error
+}
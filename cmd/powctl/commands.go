@@ -0,0 +1,388 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kriuchkov/power/internal/cli"
+	"github.com/kriuchkov/power/pkg/admin"
+	"github.com/kriuchkov/power/pkg/common"
+	"github.com/kriuchkov/power/pkg/geoip"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/go-faster/errors"
+)
+
+// apiFunc calls the admin API, args are the positional arguments.
+type apiFunc func(ctx context.Context, client *admin.Client, args []string, opts *options) error
+
+// apiCommand builds a command with the common flags, setup adds the flags of the command.
+func apiCommand(name, usage string, nargs int, setup func(flags *flag.FlagSet), run apiFunc) cli.Command {
+	return cli.Command{Name: name, Usage: usage, Run: func(ctx context.Context, args []string) error {
+		var opts options
+		flags := newFlagSet(name, &opts)
+		if setup != nil {
+			setup(flags)
+		}
+
+		args, client, err := parse(flags, &opts, args, nargs)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+		return ctlError(run(ctx, client, args, &opts))
+	}}
+}
+
+func commands(stdout io.Writer) []cli.Command {
+	var (
+		setDifficulty, setMin, setMax int
+		banDuration                   time.Duration
+		banReason                     string
+		policyFlags                   *banPolicyFlags
+	)
+
+	return []cli.Command{
+		apiCommand("stats", "print the server counters (default)", 0, nil,
+			func(ctx context.Context, client *admin.Client, _ []string, opts *options) error {
+				stats, err := client.Stats(ctx)
+				if err != nil {
+					return err //nolint:wrapcheck // the client errors are mapped by ctlError
+				}
+				return write(stdout, opts.output, stats, func(w io.Writer) error { return writeStats(w, stats) })
+			}),
+
+		apiCommand("difficulty", "print or change the difficulty and its bounds", 0,
+			func(flags *flag.FlagSet) {
+				flags.IntVar(&setDifficulty, "set", -1, "the new difficulty, it's clamped into the bounds")
+				flags.IntVar(&setMin, "min", -1, "the new lowest difficulty")
+				flags.IntVar(&setMax, "max", -1, "the new highest difficulty")
+			},
+			func(ctx context.Context, client *admin.Client, _ []string, opts *options) error {
+				settings, err := updateDifficulty(ctx, client, setDifficulty, setMin, setMax)
+				if err != nil {
+					return err
+				}
+				return write(stdout, opts.output, settings, func(w io.Writer) error {
+					_, wErr := fmt.Fprintf(w, "difficulty %d, bounds [%d, %d]\n", settings.Difficulty, settings.Min, settings.Max)
+					return wErr //nolint:wrapcheck // it's the only error
+				})
+			}),
+
+		apiCommand("connections", "list the active connections", 0, nil,
+			func(ctx context.Context, client *admin.Client, _ []string, opts *options) error {
+				conns, err := client.Connections(ctx)
+				if err != nil {
+					return err //nolint:wrapcheck // the client errors are mapped by ctlError
+				}
+				return write(stdout, opts.output, conns, func(w io.Writer) error { return writeConnections(w, conns) })
+			}),
+
+		apiCommand("kill", "close a connection: kill <id>", 1, nil,
+			func(ctx context.Context, client *admin.Client, args []string, _ *options) error {
+				id, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					return errors.Wrapf(cli.ErrUsage, "invalid connection id %q", args[0])
+				}
+				return client.KillConnection(ctx, id) //nolint:wrapcheck // the client errors are mapped by ctlError
+			}),
+
+		apiCommand("bans", "list the banned prefixes", 0, nil,
+			func(ctx context.Context, client *admin.Client, _ []string, opts *options) error {
+				bans, err := client.Bans(ctx)
+				if err != nil {
+					return err //nolint:wrapcheck // the client errors are mapped by ctlError
+				}
+				return write(stdout, opts.output, bans, func(w io.Writer) error { return writeBans(w, bans) })
+			}),
+
+		apiCommand("ban", "ban an address or a prefix and close its connections: ban [-duration d] [-reason r] <ip|cidr>", 1,
+			func(flags *flag.FlagSet) {
+				flags.DurationVar(&banDuration, "duration", 0, "the duration of the ban, zero bans forever")
+				flags.StringVar(&banReason, "reason", "", "the reason of the ban")
+			},
+			func(ctx context.Context, client *admin.Client, args []string, opts *options) error {
+				prefix, err := common.ParsePrefix(args[0])
+				if err != nil {
+					return errors.Wrap(cli.ErrUsage, err.Error())
+				}
+				if banDuration < 0 {
+					return errors.Wrap(cli.ErrUsage, "duration can't be negative")
+				}
+
+				ban, err := client.Ban(ctx, prefix, banDuration, banReason)
+				if err != nil {
+					return err //nolint:wrapcheck // the client errors are mapped by ctlError
+				}
+				return write(stdout, opts.output, ban, func(w io.Writer) error { return writeBans(w, []server.Ban{ban}) })
+			}),
+
+		apiCommand("unban", "lift a ban: unban <ip|cidr>", 1, nil,
+			func(ctx context.Context, client *admin.Client, args []string, _ *options) error {
+				prefix, err := common.ParsePrefix(args[0])
+				if err != nil {
+					return errors.Wrap(cli.ErrUsage, err.Error())
+				}
+				return client.Unban(ctx, prefix) //nolint:wrapcheck // the client errors are mapped by ctlError
+			}),
+
+		apiCommand("ban-policy", "print or change the policy of the automatic bans", 0,
+			func(flags *flag.FlagSet) {
+				policyFlags = newBanPolicyFlags(flags)
+			},
+			func(ctx context.Context, client *admin.Client, _ []string, opts *options) error {
+				policy, err := client.BanPolicy(ctx)
+				if err != nil {
+					return err //nolint:wrapcheck // the client errors are mapped by ctlError
+				}
+
+				if policyFlags.apply(&policy) {
+					if policy, err = client.SetBanPolicy(ctx, policy); err != nil {
+						return err //nolint:wrapcheck // the client errors are mapped by ctlError
+					}
+				}
+				return write(stdout, opts.output, policy, func(w io.Writer) error { return writeBanPolicy(w, policy) })
+			}),
+
+		apiCommand("access", "list an access list: access <allow|deny>", 1, nil,
+			func(ctx context.Context, client *admin.Client, args []string, opts *options) error {
+				kind, err := accessKind(args[0])
+				if err != nil {
+					return err
+				}
+
+				entries, err := client.AccessEntries(ctx, kind)
+				if err != nil {
+					return err //nolint:wrapcheck // the client errors are mapped by ctlError
+				}
+				return write(stdout, opts.output, entries, func(w io.Writer) error { return writeAccessEntries(w, entries) })
+			}),
+
+		apiCommand("access-add", "add an address or a prefix to an access list: access-add <allow|deny> <ip|cidr>", 2, nil,
+			func(ctx context.Context, client *admin.Client, args []string, opts *options) error {
+				kind, prefix, err := accessArgs(args)
+				if err != nil {
+					return err
+				}
+
+				entry, err := client.AddAccess(ctx, kind, prefix)
+				if err != nil {
+					return err //nolint:wrapcheck // the client errors are mapped by ctlError
+				}
+				return write(stdout, opts.output, entry, func(w io.Writer) error {
+					return writeAccessEntries(w, []server.AccessEntry{entry})
+				})
+			}),
+
+		apiCommand("access-remove", "remove a runtime entry of an access list: access-remove <allow|deny> <ip|cidr>", 2, nil,
+			func(ctx context.Context, client *admin.Client, args []string, _ *options) error {
+				kind, prefix, err := accessArgs(args)
+				if err != nil {
+					return err
+				}
+				return client.RemoveAccess(ctx, kind, prefix) //nolint:wrapcheck // the client errors are mapped by ctlError
+			}),
+
+		apiCommand("reload", "reload the quote store", 0, nil,
+			func(ctx context.Context, client *admin.Client, _ []string, _ *options) error {
+				return client.ReloadContent(ctx) //nolint:wrapcheck // the client errors are mapped by ctlError
+			}),
+
+		{Name: "version", Usage: "print the version", Run: version},
+	}
+}
+
+// updateDifficulty changes the set values, the negative ones are kept. Without changes it only reads.
+func updateDifficulty(ctx context.Context, client *admin.Client, difficulty, minDifficulty, maxDifficulty int) (server.DifficultySettings, error) {
+	var update admin.DifficultyUpdate
+	if difficulty >= 0 {
+		update.Difficulty = &difficulty
+	}
+	if minDifficulty >= 0 {
+		update.Min = &minDifficulty
+	}
+	if maxDifficulty >= 0 {
+		update.Max = &maxDifficulty
+	}
+
+	if update == (admin.DifficultyUpdate{}) {
+		return client.Difficulty(ctx) //nolint:wrapcheck // the client errors are mapped by ctlError
+	}
+	return client.UpdateDifficulty(ctx, update) //nolint:wrapcheck // the client errors are mapped by ctlError
+}
+
+func writeStats(w io.Writer, stats server.Stats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "uptime\t%s\n", stats.Uptime.Round(time.Second))
+	fmt.Fprintf(tw, "connections\t%d active, %d accepted, %d allowed, %d banned, %d denied\n",
+		stats.ActiveConnections, stats.Connections, stats.AllowedConnections, stats.BannedConnections, stats.DeniedConnections)
+	fmt.Fprintf(tw, "challenges\t%d\n", stats.Challenges)
+	fmt.Fprintf(tw, "requests\t%d served, %d rejected\n", stats.Served, stats.Rejected)
+	fmt.Fprintf(tw, "quota\t%d\n", stats.Quota)
+	fmt.Fprintf(tw, "invalid solutions\t%d\n", stats.InvalidSolutions)
+	fmt.Fprintf(tw, "bans\t%d active, %d automatic\n", stats.Bans, stats.AutoBans)
+	fmt.Fprintf(tw, "tokens\t%d issued, %d redeemed\n", stats.IssuedTokens, stats.RedeemedTokens)
+	fmt.Fprintf(tw, "anonymous tokens\t%d issued, %d redeemed\n", stats.IssuedPrivateTokens, stats.RedeemedPrivateTokens)
+
+	if p := stats.Proxy; p != nil {
+		fmt.Fprintf(tw, "tunnels\t%d active, %d total, %d dial errors\n", p.ActiveTunnels, p.Tunnels, p.DialErrors)
+		fmt.Fprintf(tw, "proxied bytes\t%d up, %d down\n", p.BytesUpstream, p.BytesDownstream)
+	}
+	if len(stats.Countries) > 0 {
+		fmt.Fprintf(tw, "top countries\t%s\n", topCounts(stats.Countries, func(c string) string { return c }))
+		fmt.Fprintf(tw, "top asns\t%s\n", topCounts(stats.ASNs, func(asn uint32) string {
+			return geoip.Info{ASN: asn}.String()
+		}))
+	}
+	return tw.Flush() //nolint:wrapcheck // it's the only error
+}
+
+// topStats is the number of the geoip counters printed by stats.
+const topStats = 5
+
+// topCounts returns the biggest counters as "DE 10, NL 3".
+func topCounts[K cmp.Ordered](counts map[K]int64, name func(K) string) string {
+	keys := make([]K, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b K) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+
+	parts := make([]string, 0, topStats)
+	for _, key := range keys[:min(len(keys), topStats)] {
+		parts = append(parts, fmt.Sprintf("%s %d", name(key), counts[key]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func writeConnections(w io.Writer, conns []server.ConnectionInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tREMOTE\tGEO\tTRANSPORT\tCONNECTED\tSERVED")
+	for _, c := range conns {
+		geo := geoip.Info{Country: c.Country, ASN: c.ASN}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\n",
+			c.ID, c.RemoteAddr, geo, c.Transport, time.Since(c.ConnectedAt).Round(time.Second), c.Served)
+	}
+	return tw.Flush() //nolint:wrapcheck // it's the only error
+}
+
+func writeBans(w io.Writer, bans []server.Ban) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tUNTIL\tREASON")
+	for _, ban := range bans {
+		until := "never"
+		if !ban.Permanent() {
+			until = ban.Until.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", ban.Prefix, until, ban.Reason)
+	}
+	return tw.Flush() //nolint:wrapcheck // it's the only error
+}
+
+func accessKind(arg string) (server.AccessKind, error) {
+	kind := server.AccessKind(arg)
+	if kind != server.Allowlist && kind != server.Denylist {
+		return "", errors.Wrapf(cli.ErrUsage, "unknown access list %q, allow or deny is expected", arg)
+	}
+	return kind, nil
+}
+
+// accessArgs parses the <allow|deny> <ip|cidr> arguments.
+func accessArgs(args []string) (server.AccessKind, netip.Prefix, error) {
+	kind, err := accessKind(args[0])
+	if err != nil {
+		return "", netip.Prefix{}, err
+	}
+
+	prefix, err := common.ParsePrefix(args[1])
+	if err != nil {
+		return "", netip.Prefix{}, errors.Wrap(cli.ErrUsage, err.Error())
+	}
+	return kind, prefix, nil
+}
+
+func writeAccessEntries(w io.Writer, entries []server.AccessEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tSOURCE")
+	for _, entry := range entries {
+		source := "file"
+		if entry.Runtime {
+			source = "runtime"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", entry.Prefix, source)
+	}
+	return tw.Flush() //nolint:wrapcheck // it's the only error
+}
+
+// banPolicyFlags change the fields of the ban policy, the negative values keep them.
+type banPolicyFlags struct {
+	maxFailures, ipv4Prefix, ipv6Prefix        *int
+	window, duration, maxDuration, forgetAfter *time.Duration
+	multiplier                                 *float64
+}
+
+func newBanPolicyFlags(flags *flag.FlagSet) *banPolicyFlags {
+	return &banPolicyFlags{
+		maxFailures: flags.Int("max-failures", -1, "the invalid solutions which ban the source, zero disables the bans"),
+		window:      flags.Duration("window", -1, "the window of the invalid solutions"),
+		duration:    flags.Duration("duration", -1, "the first ban"),
+		maxDuration: flags.Duration("max-duration", -1, "the longest ban"),
+		multiplier:  flags.Float64("multiplier", -1, "the growth of the repeated bans"),
+		forgetAfter: flags.Duration("forget-after", -1, "the time without bans which resets the growth"),
+		ipv4Prefix:  flags.Int("ipv4-prefix", -1, "the length of the banned IPv4 prefixes"),
+		ipv6Prefix:  flags.Int("ipv6-prefix", -1, "the length of the banned IPv6 prefixes"),
+	}
+}
+
+// apply sets the changed fields, it reports whether there are any.
+func (f *banPolicyFlags) apply(policy *server.BanPolicy) bool {
+	changed := false
+	setInt := func(dst *int, v int) {
+		if v >= 0 {
+			*dst, changed = v, true
+		}
+	}
+	setDuration := func(dst *time.Duration, v time.Duration) {
+		if v >= 0 {
+			*dst, changed = v, true
+		}
+	}
+
+	setInt(&policy.MaxFailures, *f.maxFailures)
+	setInt(&policy.IPv4Prefix, *f.ipv4Prefix)
+	setInt(&policy.IPv6Prefix, *f.ipv6Prefix)
+	setDuration(&policy.Window, *f.window)
+	setDuration(&policy.Duration, *f.duration)
+	setDuration(&policy.MaxDuration, *f.maxDuration)
+	setDuration(&policy.ForgetAfter, *f.forgetAfter)
+	if *f.multiplier >= 0 {
+		policy.Multiplier, changed = *f.multiplier, true
+	}
+	return changed
+}
+
+func writeBanPolicy(w io.Writer, policy server.BanPolicy) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if policy.MaxFailures == 0 {
+		fmt.Fprintln(tw, "automatic bans\tdisabled")
+	} else {
+		fmt.Fprintf(tw, "automatic bans\t%d invalid solutions within %s\n", policy.MaxFailures, policy.Window)
+	}
+	fmt.Fprintf(tw, "duration\t%s, x%g per repeat up to %s, reset after %s\n",
+		policy.Duration, policy.Multiplier, policy.MaxDuration, policy.ForgetAfter)
+	fmt.Fprintf(tw, "prefixes\tIPv4 /%d, IPv6 /%d\n", policy.IPv4Prefix, policy.IPv6Prefix)
+	return tw.Flush() //nolint:wrapcheck // it's the only error
+}
@@ -0,0 +1,126 @@
+// Command powctl manages a running PoW server through its admin API, see package admin.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/kriuchkov/power/internal/cli"
+	"github.com/kriuchkov/power/pkg/admin"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/go-faster/errors"
+)
+
+const (
+	program = "powctl"
+
+	defaultAddr = "http://127.0.0.1:9095"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	code := cli.Run(ctx, program, commands(os.Stdout), "stats", os.Args[1:], os.Stderr)
+	cancel()
+	os.Exit(code) //nolint:gocritic // the context is canceled above
+}
+
+func version(_ context.Context, _ []string) error {
+	fmt.Println(program, cli.VersionString())
+	return nil
+}
+
+// options are the flags of every command.
+type options struct {
+	addr    string
+	token   string
+	output  string
+	timeout time.Duration
+}
+
+func newFlagSet(command string, opts *options) *flag.FlagSet {
+	flags := cli.NewFlagSet(program, command, os.Stderr)
+
+	addr := os.Getenv("POWCTL_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	flags.StringVar(&opts.addr, "addr", addr, "the admin API URL, $POWCTL_ADDR")
+	flags.StringVar(&opts.token, "token", os.Getenv("POWCTL_TOKEN"), "the admin token, $POWCTL_TOKEN")
+	flags.StringVar(&opts.output, "output", "text", "the output format: text or json")
+	flags.DurationVar(&opts.timeout, "timeout", admin.DefaultClientTimeout, "the request timeout")
+	return flags
+}
+
+// parse parses the flags and returns the positional arguments and the API client.
+func parse(flags *flag.FlagSet, opts *options, args []string, nargs int) ([]string, *admin.Client, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, cli.Exit(cli.ExitUsage, err)
+	}
+
+	switch {
+	case flags.NArg() != nargs:
+		return nil, nil, errors.Wrapf(cli.ErrUsage, "%d arguments are expected, got %d", nargs, flags.NArg())
+	case opts.token == "":
+		return nil, nil, errors.Wrap(cli.ErrUsage, "the token is required: -token or $POWCTL_TOKEN")
+	case opts.output != "text" && opts.output != "json":
+		return nil, nil, errors.Wrapf(cli.ErrUsage, "unknown output format %q", opts.output)
+	case opts.timeout <= 0:
+		return nil, nil, errors.Wrap(cli.ErrUsage, "timeout must be positive")
+	}
+
+	if u, err := url.ParseRequestURI(opts.addr); err != nil || u.Host == "" {
+		return nil, nil, errors.Wrapf(cli.ErrUsage, "invalid address %q", opts.addr)
+	}
+
+	client := admin.NewClient(&admin.ClientDependencies{Address: opts.addr, Token: opts.token})
+	return flags.Args(), client, nil
+}
+
+// write prints v as JSON or with the text function.
+func write(w io.Writer, output string, v any, text func(w io.Writer) error) error {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v) //nolint:wrapcheck // it's the only error
+	}
+	return text(w)
+}
+
+// ctlError maps the admin API errors to the exit codes.
+func ctlError(err error) error {
+	var (
+		opErr  *net.OpError
+		urlErr *url.Error
+	)
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, admin.ErrUnauthorized):
+		return cli.Exit(cli.ExitRefused, err)
+	case errors.Is(err, admin.ErrBadRequest):
+		return cli.Exit(cli.ExitUsage, err)
+	case errors.Is(err, admin.ErrNotFound):
+		return cli.Exit(cli.ExitInvalid, err)
+	case errors.Is(err, server.ErrNotSupported):
+		return cli.Exit(cli.ExitFailure, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return cli.Exit(cli.ExitTimeout, err)
+	case errors.As(err, &opErr), errors.As(err, &urlErr):
+		return cli.Exit(cli.ExitUnavailable, err)
+	default:
+		return err
+	}
+}
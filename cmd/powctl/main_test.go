@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kriuchkov/power/internal/cli"
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/admin"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands(t *testing.T) {
+	t.Parallel()
+
+	const token = "0123456789abcdef"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serv, err := server.New(&server.Dependencies{
+		Listener:       listener,
+		MessageHandler: func() []byte { return []byte("quote") },
+		PowHandler:     pow.NewPow(3),
+	})
+	require.NoError(t, err)
+	go serv.Listen(ctx)
+
+	api := httptest.NewServer(admin.NewHandler(&admin.Dependencies{Control: serv, Token: token}))
+	defer api.Close()
+
+	run := func(args ...string) (int, []byte) {
+		var stdout bytes.Buffer
+		args = append(args[:1:1], append([]string{"-addr", api.URL, "-token", token}, args[1:]...)...)
+		code := cli.Run(ctx, program, commands(&stdout), "stats", args, io.Discard)
+		return code, stdout.Bytes()
+	}
+
+	code, out := run("difficulty", "-output", "json", "-set", "9", "-max", "6")
+	require.Equal(t, cli.ExitOK, code)
+
+	var settings server.DifficultySettings
+	require.NoError(t, json.Unmarshal(out, &settings))
+	require.Equal(t, server.DifficultySettings{Difficulty: 6, Min: 0, Max: 6}, settings)
+
+	code, out = run("ban", "-duration", "1h", "-reason", "abuse", "192.0.2.1")
+	require.Equal(t, cli.ExitOK, code)
+	require.Contains(t, string(out), "192.0.2.1")
+	require.Contains(t, string(out), "abuse")
+
+	code, out = run("stats", "-output", "json")
+	require.Equal(t, cli.ExitOK, code)
+
+	var stats server.Stats
+	require.NoError(t, json.Unmarshal(out, &stats))
+	require.Equal(t, 1, stats.Bans)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "unban", args: []string{"unban", "192.0.2.1"}, code: cli.ExitOK},
+		{name: "ban a prefix", args: []string{"ban", "2001:db8::/32"}, code: cli.ExitOK},
+		{name: "unban a prefix", args: []string{"unban", "2001:db8::/32"}, code: cli.ExitOK},
+		{name: "ban policy", args: []string{"ban-policy", "-max-failures", "5", "-ipv4-prefix", "24"}, code: cli.ExitOK},
+		{name: "invalid ban policy", args: []string{"ban-policy", "-ipv4-prefix", "40"}, code: cli.ExitUsage},
+		{name: "unknown ban", args: []string{"unban", "192.0.2.1"}, code: cli.ExitInvalid},
+		{name: "unknown connection", args: []string{"kill", "100"}, code: cli.ExitInvalid},
+		{name: "invalid bounds", args: []string{"difficulty", "-min", "7"}, code: cli.ExitUsage},
+		{name: "allow", args: []string{"access-add", "allow", "10.0.0.0/8"}, code: cli.ExitOK},
+		{name: "list allowed", args: []string{"access", "allow"}, code: cli.ExitOK},
+		{name: "disallow", args: []string{"access-remove", "allow", "10.0.0.0/8"}, code: cli.ExitOK},
+		{name: "unknown access entry", args: []string{"access-remove", "deny", "10.0.0.0/8"}, code: cli.ExitInvalid},
+		{name: "unknown access list", args: []string{"access", "block"}, code: cli.ExitUsage},
+		{name: "not supported", args: []string{"reload"}, code: cli.ExitFailure},
+		{name: "wrong token", args: []string{"stats", "-token", "wrong"}, code: cli.ExitRefused},
+		{name: "missing argument", args: []string{"ban"}, code: cli.ExitUsage},
+		{name: "invalid address", args: []string{"ban", "example.com"}, code: cli.ExitUsage},
+		{name: "unavailable", args: []string{"stats", "-addr", "http://127.0.0.1:1"}, code: cli.ExitUnavailable},
+	}
+
+	for _, tt := range tests {
+		code, _ := run(tt.args...)
+		require.Equal(t, tt.code, code, tt.name)
+	}
+
+	policy := serv.BanPolicy()
+	require.Equal(t, 5, policy.MaxFailures)
+	require.Equal(t, 24, policy.IPv4Prefix)
+}
+
+func TestTopCounts(t *testing.T) {
+	t.Parallel()
+
+	counts := map[string]int64{"DE": 3, "NL": 7, "US": 3, "FR": 1, "GB": 2, "unknown": 1}
+	require.Equal(t, "NL 7, DE 3, US 3, GB 2, FR 1", topCounts(counts, func(c string) string { return c }))
+}
@@ -1,63 +1,112 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"math/rand"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
 
+	"github.com/kriuchkov/power/internal/cli"
 	"github.com/kriuchkov/power/internal/config"
-	"github.com/kriuchkov/power/internal/pow"
-	"github.com/kriuchkov/power/pkg/server"
+	"github.com/kriuchkov/power/pkg/token"
 
-	"github.com/joho/godotenv"
-	"github.com/kelseyhightower/envconfig"
-	log "github.com/sirupsen/logrus"
+	"github.com/go-faster/errors"
 )
 
+const program = "server"
+
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	powDebug, _ := strconv.ParseBool(os.Getenv("POW_DEBUG"))
-	if powDebug {
-		godotenv.Load(".env") //nolint:errcheck // ok for this case
-		log.SetLevel(log.DebugLevel)
-		log.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+	commands := []cli.Command{
+		{Name: "serve", Usage: "run the server (default)", Run: serve},
+		{Name: "check-config", Usage: "validate the config and print it with the secrets redacted", Run: checkConfig},
+		{Name: "gen-secret", Usage: "generate a secret for the stateless challenges", Run: genSecret},
+		{Name: "token-key", Usage: "print the public key of the ed25519 access tokens", Run: tokenKey},
+		{Name: "version", Usage: "print the version", Run: version},
 	}
 
+	code := cli.Run(ctx, program, commands, "serve", os.Args[1:], os.Stderr)
+	cancel()
+	os.Exit(code) //nolint:gocritic // the context is canceled above
+}
+
+func checkConfig(_ context.Context, args []string) error {
+	flags := cli.NewFlagSet(program, "check-config", os.Stderr)
+	loader := &config.Loader{Prefix: "server", Args: args, FlagSet: flags}
+
 	var conf config.Config
-	err := envconfig.Process("server", &conf)
-	if err != nil {
-		log.WithError(err).Fatal("process the config")
+	if err := loader.Load(&conf); err != nil {
+		return cli.ConfigError(err)
+	}
+
+	if conf.UpstreamAddr == "" {
+		var quotes quoteStore
+		if err := quotes.load(conf.QuotesFileName); err != nil {
+			return cli.Exit(cli.ExitConfig, errors.Wrap(err, "read quotes file"))
+		}
+	}
+
+	if err := config.Print(os.Stdout, &conf); err != nil {
+		return errors.Wrap(err, "print the config")
+	}
+
+	fmt.Fprintln(os.Stderr, "the config is valid")
+	return nil
+}
+
+func genSecret(_ context.Context, args []string) error {
+	flags := cli.NewFlagSet(program, "gen-secret", os.Stderr)
+	size := flags.Int("bytes", 32, "the number of the random bytes, at least 16")
+	encoding := flags.String("encoding", "hex", "hex or base64")
+
+	if err := flags.Parse(args); err != nil {
+		return cli.Exit(cli.ExitUsage, err)
+	}
+
+	if *size < 16 {
+		return errors.Wrap(cli.ErrUsage, "the secret needs at least 16 bytes")
 	}
 
-	log.WithField("config", conf).Info("config loaded")
+	secret := make([]byte, *size)
+	if _, err := rand.Read(secret); err != nil {
+		return errors.Wrap(err, "read random bytes")
+	}
 
-	quotesRaw, err := os.ReadFile(conf.QuotesFileName)
-	if err != nil {
-		log.WithError(err).Fatal("read quotes file")
+	switch *encoding {
+	case "hex":
+		fmt.Println(hex.EncodeToString(secret))
+	case "base64":
+		fmt.Println(base64.RawURLEncoding.EncodeToString(secret))
+	default:
+		return errors.Wrapf(cli.ErrUsage, "unknown encoding %q", *encoding)
 	}
+	return nil
+}
+
+func tokenKey(_ context.Context, args []string) error {
+	flags := cli.NewFlagSet(program, "token-key", os.Stderr)
+	loader := &config.Loader{Prefix: "server", Args: args, FlagSet: flags}
 
-	quotes := bytes.Split(quotesRaw, []byte("\n"))
-	if len(quotes) == 0 {
-		log.Panic("quotes file is empty")
+	var conf config.Config
+	if err := loader.Load(&conf); err != nil {
+		return cli.ConfigError(err)
 	}
 
-	serv, err := server.New(&server.Dependencies{
-		TCPAddress:     conf.ServerAddr,
-		PowHandler:     pow.NewPow(conf.Difficulty),
-		MessageHandler: func() []byte { return quotes[rand.Intn(len(quotes))] }, //nolint:gosec // it's ok here
-	})
-	if err != nil {
-		log.WithError(err).Fatal("create a new server")
+	key, ok := tokenSigner(&conf).(*token.Ed25519Key)
+	if !ok {
+		return cli.Exit(cli.ExitConfig, errors.New("the tokens aren't signed with ed25519, see token_algorithm"))
 	}
 
-	log.WithField("address", conf.ServerAddr).Info("server started")
-	go serv.Listen(ctx)
+	fmt.Println(key.PublicKey())
+	return nil
+}
 
-	<-ctx.Done()
-	log.Println("server exited properly")
+func version(_ context.Context, _ []string) error {
+	fmt.Println(program, cli.VersionString())
+	return nil
 }
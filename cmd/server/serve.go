@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kriuchkov/power/internal/cli"
+	"github.com/kriuchkov/power/internal/config"
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/admin"
+	"github.com/kriuchkov/power/pkg/geoip"
+	"github.com/kriuchkov/power/pkg/grpcpow"
+	"github.com/kriuchkov/power/pkg/policy"
+	"github.com/kriuchkov/power/pkg/privacypass"
+	"github.com/kriuchkov/power/pkg/reputation"
+	"github.com/kriuchkov/power/pkg/server"
+	"github.com/kriuchkov/power/pkg/token"
+
+	"github.com/go-faster/errors"
+	"github.com/joho/godotenv"
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// serve runs the server until ctx is done.
+func serve(ctx context.Context, args []string) error {
+	powDebug, _ := strconv.ParseBool(os.Getenv("POW_DEBUG"))
+	if powDebug {
+		godotenv.Load(".env") //nolint:errcheck // ok for this case
+		log.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+	}
+
+	flags := cli.NewFlagSet(program, "serve", os.Stderr)
+	printConfig := flags.Bool("print-config", false, "print the effective config and exit")
+
+	loader := &config.Loader{Prefix: "server", Args: args, FlagSet: flags}
+
+	var conf config.Config
+	if err := loader.Load(&conf); err != nil {
+		return cli.ConfigError(err)
+	}
+
+	if *printConfig {
+		return config.Print(os.Stdout, &conf) //nolint:wrapcheck // it's the only error
+	}
+
+	setLogLevel(conf.LogLevel, powDebug)
+	log.WithField("config", config.Redact(conf)).Info("config loaded")
+
+	powHandler := pow.NewPow(conf.Difficulty)
+	if err := powHandler.SetBounds(conf.DifficultyMin, conf.DifficultyMax); err != nil {
+		return cli.Exit(cli.ExitConfig, err)
+	}
+
+	puzzle, err := newPuzzle(&conf)
+	if err != nil {
+		return cli.Exit(cli.ExitConfig, err)
+	}
+
+	// confMu guards conf, it's changed by the reload and read by the admin API
+	var confMu sync.Mutex
+	deps := server.Dependencies{
+		TCPAddress:       conf.ServerAddr,
+		PowHandler:       powHandler,
+		Puzzle:           puzzle,
+		Quota:            conf.Quota,
+		MaxMessageSize:   conf.MaxMessageSize,
+		ReadTimeout:      conf.ReadTimeout,
+		WebSocketOrigins: conf.WebSocketOrigins,
+		BanPolicy:        banPolicy(&conf),
+	}
+
+	if conf.Reputation {
+		deps.Reputation = reputation.New(&reputation.Dependencies{
+			Store: reputation.NewMemoryStore(conf.ReputationMaxClients),
+			Policy: reputation.Policy{
+				HalfLife:  conf.ReputationHalfLife,
+				Discount:  conf.ReputationDiscount,
+				Surcharge: conf.ReputationSurcharge,
+			},
+		})
+	}
+
+	accessFiles := map[server.AccessKind]string{server.Allowlist: conf.AllowlistFile, server.Denylist: conf.DenylistFile}
+	if err := readAccessLists(accessFiles, &deps); err != nil {
+		return cli.Exit(cli.ExitConfig, err)
+	}
+
+	if conf.PolicyFile != "" {
+		rules, err := policy.Load(conf.PolicyFile)
+		if err != nil {
+			return cli.Exit(cli.ExitConfig, errors.Wrap(err, "read policy file"))
+		}
+		deps.Rules = rules
+	}
+
+	if len(conf.GeoIPFiles) > 0 {
+		db, err := geoip.Open(conf.GeoIPFiles...)
+		if err != nil {
+			return cli.Exit(cli.ExitConfig, err)
+		}
+		deps.GeoIP = db
+	}
+
+	if signer := tokenSigner(&conf); signer != nil {
+		deps.Tokens = token.NewIssuer(&token.Dependencies{
+			Signer:      signer,
+			TTL:         conf.TokenTTL,
+			Uses:        conf.TokenUses,
+			Issuer:      program,
+			BindSubject: conf.TokenBindAddress,
+		})
+	}
+
+	if conf.PrivacyPassKeyFile != "" {
+		issuer, err := privacyPassIssuer(&conf)
+		if err != nil {
+			return cli.Exit(cli.ExitConfig, err)
+		}
+		deps.PrivacyPass = issuer
+	}
+
+	var quotes quoteStore
+	if conf.UpstreamAddr != "" {
+		deps.Upstream = &server.Upstream{
+			Network:     conf.UpstreamNetwork,
+			Address:     conf.UpstreamAddr,
+			IdleTimeout: conf.UpstreamIdleTimeout,
+		}
+	} else {
+		if err := quotes.load(conf.QuotesFileName); err != nil {
+			return cli.Exit(cli.ExitConfig, errors.Wrap(err, "read quotes file"))
+		}
+		deps.MessageHandler = quotes.random
+		deps.ReloadContent = func() error {
+			confMu.Lock()
+			fileName := conf.QuotesFileName
+			confMu.Unlock()
+
+			if err := quotes.load(fileName); err != nil {
+				return errors.Wrap(err, "reload quotes file")
+			}
+			log.WithField("file", fileName).Info("quotes reloaded")
+			return nil
+		}
+	}
+
+	serv, err := server.New(&deps)
+	if err != nil {
+		return cli.Exit(cli.ExitUnavailable, errors.Wrap(err, "create a new server"))
+	}
+
+	log.WithField("address", conf.ServerAddr).Info("server started")
+	go serv.Listen(ctx)
+
+	if conf.GRPCAddr != "" {
+		grpcServer, gErr := newGRPCServer(&conf, serv, powHandler, deps.MessageHandler)
+		if gErr != nil {
+			return cli.Exit(cli.ExitUnavailable, errors.Wrap(gErr, "create a grpc server"))
+		}
+		defer grpcServer.GracefulStop()
+	}
+
+	if conf.WebSocketAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/ws", serv.WebSocketHandler())
+
+		httpServer := &http.Server{Addr: conf.WebSocketAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if sErr := httpServer.ListenAndServe(); sErr != nil && !errors.Is(sErr, http.ErrServerClosed) {
+				log.WithError(sErr).Error("serve websocket")
+			}
+		}()
+		defer httpServer.Close()
+
+		log.WithField("address", conf.WebSocketAddr).Info("websocket server started")
+	}
+
+	if conf.AdminAddr != "" {
+		adminServer := &http.Server{
+			Addr:              conf.AdminAddr,
+			Handler:           admin.NewHandler(&admin.Dependencies{Control: serv, Token: conf.AdminToken}),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			if sErr := adminServer.ListenAndServe(); sErr != nil && !errors.Is(sErr, http.ErrServerClosed) {
+				log.WithError(sErr).Error("serve admin api")
+			}
+		}()
+		defer adminServer.Close()
+
+		log.WithField("address", conf.AdminAddr).Info("admin api started")
+	}
+
+	reload := func() {
+		var next config.Config
+		if err := loader.Load(&next); err != nil {
+			log.WithError(err).Error("reload the config")
+			return
+		}
+
+		confMu.Lock()
+		var changed, ignored []string
+		conf, changed, ignored = config.MergeReloadable(conf, next)
+		reloaded := conf
+		confMu.Unlock()
+
+		if len(ignored) > 0 {
+			log.WithField("keys", ignored).Warn("the changes need a restart")
+		}
+
+		// the difficulty and the ban policy are applied only when they're changed in the config,
+		// so the ones set by the admin API survive the reloads of the other keys
+		if slices.Contains(changed, "difficulty_min") || slices.Contains(changed, "difficulty_max") {
+			if err := powHandler.SetBounds(reloaded.DifficultyMin, reloaded.DifficultyMax); err != nil {
+				log.WithError(err).Error("set the difficulty bounds")
+			}
+		}
+		if slices.Contains(changed, "difficulty") {
+			powHandler.SetDifficulty(reloaded.Difficulty)
+		}
+		if slices.ContainsFunc(changed, func(key string) bool { return strings.HasPrefix(key, "ban_") }) {
+			if err := serv.SetBanPolicy(banPolicy(&reloaded)); err != nil {
+				log.WithError(err).Error("set the ban policy")
+			}
+		}
+		serv.SetQuota(reloaded.Quota)
+		setLogLevel(reloaded.LogLevel, powDebug)
+
+		if deps.ReloadContent != nil {
+			if err := deps.ReloadContent(); err != nil {
+				log.WithError(err).Error("reload quotes file")
+			}
+		}
+		reloadAccessLists(serv, accessFiles)
+		reloadRules(serv, reloaded.PolicyFile)
+		if deps.GeoIP != nil {
+			if err := deps.GeoIP.Reload(); err != nil {
+				log.WithError(err).Error("reload the geoip databases")
+			}
+		}
+		log.WithField("keys", changed).Info("config reloaded")
+	}
+
+	watched := append([]string{loader.File, conf.AllowlistFile, conf.DenylistFile, conf.PolicyFile}, conf.GeoIPFiles...)
+	if err := config.Watch(ctx, reload, watched...); err != nil {
+		log.WithError(err).Error("watch the config")
+	}
+
+	<-ctx.Done()
+	log.Println("server exited properly")
+	return nil
+}
+
+func banPolicy(conf *config.Config) server.BanPolicy {
+	return server.BanPolicy{
+		MaxFailures: conf.BanMaxFailures,
+		Window:      conf.BanWindow,
+		Duration:    conf.BanDuration,
+		MaxDuration: conf.BanMaxDuration,
+		Multiplier:  conf.BanMultiplier,
+		ForgetAfter: conf.BanForgetAfter,
+		IPv4Prefix:  conf.BanIPv4Prefix,
+		IPv6Prefix:  conf.BanIPv6Prefix,
+	}
+}
+
+// readAccessLists reads the access list files into the static lists of deps.
+func readAccessLists(files map[server.AccessKind]string, deps *server.Dependencies) error {
+	for kind, file := range files {
+		if file == "" {
+			continue
+		}
+
+		prefixes, err := server.ReadAccessList(file)
+		if err != nil {
+			return errors.Wrapf(err, "read %s list", kind)
+		}
+
+		if kind == server.Allowlist {
+			deps.Allowlist = prefixes
+		} else {
+			deps.Denylist = prefixes
+		}
+	}
+	return nil
+}
+
+// reloadAccessLists replaces the static access lists, a broken file keeps the loaded list.
+func reloadAccessLists(serv *server.Server, files map[server.AccessKind]string) {
+	for kind, file := range files {
+		if file == "" {
+			continue
+		}
+
+		prefixes, err := server.ReadAccessList(file)
+		if err != nil {
+			log.WithError(err).WithField("file", file).Error("reload the access list")
+			continue
+		}
+		if err = serv.SetStaticAccess(kind, prefixes); err != nil {
+			log.WithError(err).Error("set the access list")
+		}
+	}
+}
+
+// reloadRules replaces the difficulty rules, a broken file keeps the loaded rules.
+func reloadRules(serv *server.Server, file string) {
+	if file == "" {
+		return
+	}
+
+	rules, err := policy.Load(file)
+	if err != nil {
+		log.WithError(err).WithField("file", file).Error("reload the policy")
+		return
+	}
+	if err = serv.SetRules(rules); err != nil {
+		log.WithError(err).Error("set the policy")
+	}
+}
+
+// newPuzzle returns the puzzle of the challenges, nil for the sha256 search.
+func newPuzzle(conf *config.Config) (pow.Puzzle, error) {
+	if conf.Puzzle == pow.AlgorithmSHA256 {
+		return nil, nil //nolint:nilnil // the sha256 search isn't a pow.Puzzle
+	}
+	if conf.Reputation || conf.PolicyFile != "" {
+		return nil, errors.New("the reputation and the rules need the sha256 puzzle")
+	}
+
+	if conf.Puzzle == pow.AlgorithmCuckoo {
+		cuckoo, err := pow.NewCuckoo(conf.CuckooEdgeBits, conf.CuckooCycle)
+		if err != nil {
+			return nil, errors.Wrap(err, "create cuckoo puzzle")
+		}
+
+		log.WithFields(log.Fields{"edge_bits": conf.CuckooEdgeBits, "cycle": conf.CuckooCycle}).Info("cuckoo puzzle")
+		return cuckoo, nil
+	}
+
+	rsw, err := pow.NewRSW(conf.RSWBits, conf.RSWSquarings)
+	if err != nil {
+		return nil, errors.Wrap(err, "create rsw puzzle")
+	}
+	if conf.RSWSquarings == 0 {
+		rsw.Calibrate(conf.RSWSolveTime)
+	}
+
+	log.WithFields(log.Fields{"bits": conf.RSWBits, "squarings": rsw.Squarings()}).Info("rsw puzzle")
+	return rsw, nil
+}
+
+// privacyPassIssuer returns the issuer of the anonymous tokens.
+func privacyPassIssuer(conf *config.Config) (*privacypass.Issuer, error) {
+	key, err := privacypass.ReadKey(conf.PrivacyPassKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read privacy pass key")
+	}
+
+	issuer, err := privacypass.NewIssuer(&privacypass.Dependencies{
+		Key:      key,
+		Name:     conf.PrivacyPassIssuer,
+		Store:    privacypass.NewMemoryStore(conf.PrivacyPassMaxSpent),
+		MaxBatch: conf.PrivacyPassBatch,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create privacy pass issuer")
+	}
+	return issuer, nil
+}
+
+// tokenSigner returns the key of the access tokens, nil if they're disabled.
+func tokenSigner(conf *config.Config) token.Signer {
+	switch conf.TokenAlgorithm {
+	case token.Ed25519:
+		return token.NewEd25519Key([]byte(conf.TokenKey))
+	case token.HS256:
+		return token.HMACKey(conf.TokenKey)
+	default:
+		return nil
+	}
+}
+
+func setLogLevel(level string, debug bool) {
+	if debug {
+		log.SetLevel(log.DebugLevel)
+		return
+	}
+
+	logLevel, err := log.ParseLevel(level)
+	if err != nil {
+		log.WithError(err).Error("parse the log level")
+		return
+	}
+	log.SetLevel(logLevel)
+}
+
+// quoteStore keeps the quotes, the file can be reloaded while the server runs.
+type quoteStore struct {
+	quotes atomic.Pointer[[][]byte]
+}
+
+func (s *quoteStore) load(fileName string) error {
+	quotesRaw, err := os.ReadFile(fileName)
+	if err != nil {
+		return errors.Wrap(err, "read the file")
+	}
+
+	quotes := bytes.Split(bytes.TrimSpace(quotesRaw), []byte("\n"))
+	if len(quotes[0]) == 0 {
+		return errors.New("quotes file is empty")
+	}
+
+	s.quotes.Store(&quotes)
+	return nil
+}
+
+func (s *quoteStore) random() []byte {
+	quotes := *s.quotes.Load()
+	return quotes[rand.Intn(len(quotes))] //nolint:gosec // it's ok here
+}
+
+// newGRPCServer serves the gRPC service, its Exchange stream is handled by the server like a TCP connection.
+func newGRPCServer(
+	conf *config.Config, serv *server.Server, powHandler *pow.Pow, msgHandler server.MessageHandler,
+) (*grpc.Server, error) {
+	if msgHandler == nil {
+		return nil, errors.New("the grpc service needs the quotes file")
+	}
+
+	listener, err := net.Listen("tcp", conf.GRPCAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "get a listener")
+	}
+
+	grpcServer := grpc.NewServer(grpc.MaxRecvMsgSize(conf.MaxMessageSize))
+	powerV1.RegisterPowerServer(grpcServer, grpcpow.New(&grpcpow.Dependencies{
+		MessageHandler: grpcpow.MessageHandler(msgHandler),
+		PowHandler:     powHandler,
+		Secret:         []byte(conf.Secret),
+		Server:         serv,
+	}))
+
+	go func() {
+		if sErr := grpcServer.Serve(listener); sErr != nil {
+			log.WithError(sErr).Error("serve grpc")
+		}
+	}()
+
+	log.WithField("address", conf.GRPCAddr).Info("grpc server started")
+	return grpcServer, nil
+}
@@ -0,0 +1,56 @@
+//go:build js && wasm
+
+// The wasm-solver exposes the Go solver to JavaScript, so browsers solve the challenges exactly like pkg/client.
+//
+//	powSolve({message, start, step, maxAttempts, onProgress}) -> nonce
+//
+// message is the power.Message answering Connect as a Uint8Array, the difficulty is read from its challenge.
+// start and step split the nonce space between Web Workers, see web/wasm.js. The call is synchronous,
+// so it must run in a worker. It returns -1 when maxAttempts is exhausted.
+package main
+
+import (
+	"context"
+	"syscall/js"
+
+	"github.com/kriuchkov/power/internal/pow"
+)
+
+func main() {
+	js.Global().Set("powSolve", js.FuncOf(solve))
+	select {} // keep the exported function alive
+}
+
+func solve(_ js.Value, args []js.Value) any {
+	if len(args) != 1 || args[0].Type() != js.TypeObject {
+		return js.Global().Get("Error").New("powSolve expects an options object")
+	}
+	options := args[0]
+
+	message := make([]byte, options.Get("message").Get("length").Int())
+	js.CopyBytesToGo(message, options.Get("message"))
+	challenge, err := parseMessage(message)
+	if err != nil {
+		return js.Global().Get("Error").New(err.Error())
+	}
+
+	search := pow.Search{
+		Start:       intOption(options, "start"),
+		Step:        intOption(options, "step"),
+		MaxAttempts: intOption(options, "maxAttempts"),
+	}
+
+	if onProgress := options.Get("onProgress"); onProgress.Type() == js.TypeFunction {
+		search.Progress = func(attempts int) { onProgress.Invoke(attempts) }
+	}
+
+	solver := pow.NewPow(challenge.difficulty)
+	return solver.FindNonceFrom(context.Background(), challenge.hash, challenge.byteIndex, challenge.byteValue, search)
+}
+
+func intOption(options js.Value, name string) int {
+	if v := options.Get(name); v.Type() == js.TypeNumber {
+		return v.Int()
+	}
+	return 0
+}
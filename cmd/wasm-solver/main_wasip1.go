@@ -0,0 +1,49 @@
+//go:build wasip1
+
+// The WASI entry point of the wasm-solver for edge runtimes. It reads the power.Message answering Connect
+// from stdin and prints the nonce, the difficulty is read from the message challenge:
+//
+//	wasmtime solver.wasm < message
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kriuchkov/power/internal/pow"
+)
+
+func main() {
+	start := flag.Int("start", 0, "the first nonce")
+	step := flag.Int("step", 1, "the distance between the checked nonces")
+	maxAttempts := flag.Int("max-attempts", 0, "stop after the number of attempts, 0 is unlimited")
+	flag.Parse()
+
+	message, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read the message:", err)
+		os.Exit(1)
+	}
+
+	challenge, err := parseMessage(message)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "malformed message:", err)
+		os.Exit(1)
+	}
+
+	solver := pow.NewPow(challenge.difficulty)
+	nonce := solver.FindNonceFrom(context.Background(), challenge.hash, challenge.byteIndex, challenge.byteValue, pow.Search{
+		Start:       *start,
+		Step:        *step,
+		MaxAttempts: *maxAttempts,
+	})
+	if nonce < 0 {
+		fmt.Fprintln(os.Stderr, "nonce not found")
+		os.Exit(1)
+	}
+
+	fmt.Println(nonce) //nolint:forbidigo // it's the output of the command
+}
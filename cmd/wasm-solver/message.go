@@ -0,0 +1,49 @@
+//go:build (js && wasm) || wasip1
+
+package main
+
+import (
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/common"
+
+	"github.com/go-faster/errors"
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// challenge is the verify message of the server.
+type challenge struct {
+	hash       []byte
+	byteIndex  int
+	byteValue  byte
+	difficulty int
+}
+
+// parseMessage parses the power.Message answering Connect: the body is the verify message
+// ("hash|byteIndex|byteValue"), the difficulty is the one of Message.challenge.
+func parseMessage(data []byte) (challenge, error) {
+	var message powerV1.Message
+	if err := proto.Unmarshal(data, &message); err != nil {
+		return challenge{}, errors.Wrap(err, "unmarshal the message")
+	}
+
+	hash, byteIndex, byteValue, err := common.SplitMessage(message.GetBody())
+	if err != nil {
+		return challenge{}, errors.Wrap(err, "split the verify message")
+	}
+
+	params := message.GetChallenge()
+	if params == nil {
+		return challenge{}, errors.New("the server doesn't report the difficulty")
+	}
+	if algorithm := params.GetAlgorithm(); algorithm != "" && algorithm != pow.AlgorithmSHA256 {
+		return challenge{}, errors.Errorf("unsupported algorithm %s", algorithm)
+	}
+
+	return challenge{
+		hash:       hash,
+		byteIndex:  byteIndex,
+		byteValue:  byteValue,
+		difficulty: int(params.GetDifficulty()),
+	}, nil
+}
@@ -0,0 +1,153 @@
+// Package cli runs the subcommands of the binaries and maps their errors to the exit codes.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+
+	"github.com/kriuchkov/power/internal/config"
+
+	"github.com/go-faster/errors"
+)
+
+// The exit codes of the binaries.
+const (
+	ExitOK          = 0
+	ExitFailure     = 1 // an unexpected error
+	ExitUsage       = 2 // wrong arguments
+	ExitConfig      = 3 // the config can't be loaded or is invalid
+	ExitUnavailable = 4 // the server can't be reached or the address can't be listened
+	ExitRefused     = 5 // the challenge is refused by the solve policy
+	ExitInvalid     = 6 // the solution is rejected or doesn't verify
+	ExitTimeout     = 7 // the deadline is exceeded
+)
+
+// Version is set at build time: -ldflags "-X github.com/kriuchkov/power/internal/cli.Version=v1.2.3".
+var Version = ""
+
+// ErrUsage is returned for the wrong arguments.
+var ErrUsage = errors.New("wrong usage")
+
+// ExitError carries the exit code of an error.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string { return e.Err.Error() }
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// Exit attaches the exit code to the error, nil stays nil.
+func Exit(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ExitError{Code: code, Err: err}
+}
+
+// ExitCode returns the code attached by Exit, ExitTimeout for the deadline and ExitUsage for
+// the flag errors.
+func ExitCode(err error) int {
+	var exitErr *ExitError
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, ErrUsage):
+		return ExitUsage
+	default:
+		return ExitFailure
+	}
+}
+
+// ConfigError maps the config loader errors to the exit codes.
+func ConfigError(err error) error {
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return err
+	case errors.Is(err, config.ErrInvalidFlags):
+		return Exit(ExitUsage, err)
+	default:
+		return Exit(ExitConfig, err)
+	}
+}
+
+// Command is a subcommand, Run gets the arguments after its name.
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(ctx context.Context, args []string) error
+}
+
+// Run runs the command named by the first argument. Without a name, or when the first argument is
+// a flag, it runs the default command with all the arguments.
+func Run(ctx context.Context, program string, commands []Command, defaultCommand string, args []string, stderr io.Writer) int {
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage(stderr, program, commands)
+		return ExitOK
+	}
+
+	for _, command := range commands {
+		if command.Name != name {
+			continue
+		}
+
+		err := command.Run(ctx, args)
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(stderr, "%s %s: %v\n", program, name, err)
+		}
+		return ExitCode(err)
+	}
+
+	fmt.Fprintf(stderr, "%s: unknown command %q\n", program, name)
+	printUsage(stderr, program, commands)
+	return ExitUsage
+}
+
+func printUsage(w io.Writer, program string, commands []Command) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", program)
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", command.Name, command.Usage)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", program)
+}
+
+// NewFlagSet returns a flag set which reports the errors instead of exiting.
+func NewFlagSet(program, command string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(program+" "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+// VersionString returns Version or the module version of the build.
+func VersionString() string {
+	if Version != "" {
+		return Version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version += " " + setting.Value
+		}
+	}
+	return version + " " + info.GoVersion
+}
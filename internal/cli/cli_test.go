@@ -0,0 +1,69 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"testing"
+
+	"github.com/kriuchkov/power/internal/cli"
+	"github.com/kriuchkov/power/internal/config"
+
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{name: "no error", expected: cli.ExitOK},
+		{name: "help", err: flag.ErrHelp, expected: cli.ExitOK},
+		{name: "exit error", err: errors.Wrap(cli.Exit(cli.ExitRefused, errors.New("refused")), "fetch"), expected: cli.ExitRefused},
+		{name: "deadline", err: errors.Wrap(context.DeadlineExceeded, "find nonce"), expected: cli.ExitTimeout},
+		{name: "usage", err: errors.Wrap(cli.ErrUsage, "count must be positive"), expected: cli.ExitUsage},
+		{name: "invalid flags", err: cli.ConfigError(errors.Wrap(config.ErrInvalidFlags, "-bogus")), expected: cli.ExitUsage},
+		{name: "config", err: cli.ConfigError(errors.New("validate the config")), expected: cli.ExitConfig},
+		{name: "other", err: errors.New("unexpected"), expected: cli.ExitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.expected, cli.ExitCode(tt.err))
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	var called []string
+	commands := []cli.Command{
+		{Name: "serve", Run: func(_ context.Context, args []string) error {
+			called = append(called, "serve")
+			called = append(called, args...)
+			return nil
+		}},
+		{Name: "check", Run: func(context.Context, []string) error {
+			return cli.Exit(cli.ExitConfig, errors.New("invalid"))
+		}},
+	}
+
+	var stderr bytes.Buffer
+	ctx := context.Background()
+
+	require.Equal(t, cli.ExitOK, cli.Run(ctx, "server", commands, "serve", nil, &stderr))
+	require.Equal(t, cli.ExitOK, cli.Run(ctx, "server", commands, "serve", []string{"-difficulty", "2"}, &stderr))
+	require.Equal(t, []string{"serve", "serve", "-difficulty", "2"}, called)
+
+	require.Equal(t, cli.ExitConfig, cli.Run(ctx, "server", commands, "serve", []string{"check"}, &stderr))
+	require.Contains(t, stderr.String(), "server check: invalid")
+
+	require.Equal(t, cli.ExitUsage, cli.Run(ctx, "server", commands, "serve", []string{"unknown"}, &stderr))
+	require.Contains(t, stderr.String(), `unknown command "unknown"`)
+}
@@ -0,0 +1,36 @@
+package config
+
+import "time"
+
+// ClientConfig is the client configuration, it's read with the "client" prefix. The puzzle
+// parameters aren't configured: the client takes them from the challenge.
+type ClientConfig struct {
+	ServerAddr  string        `yaml:"server_addr" envconfig:"SERVER_ADDR" default:":9090" validate:"required"`
+	DialTimeout time.Duration `yaml:"dial_timeout" envconfig:"DIAL_TIMEOUT" default:"5s" validate:"gte=0"`
+	// Timeout bounds the whole request including the retries and the solving.
+	Timeout time.Duration `yaml:"timeout" envconfig:"TIMEOUT" default:"5s" validate:"gt=0"`
+
+	// TLS dials the server with TLS, TLSCAFile replaces the system roots.
+	TLS                   bool   `yaml:"tls" envconfig:"TLS"`
+	TLSCAFile             string `yaml:"tls_ca_file" envconfig:"TLS_CA_FILE"`
+	TLSServerName         string `yaml:"tls_server_name" envconfig:"TLS_SERVER_NAME"`
+	TLSInsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify" envconfig:"TLS_INSECURE_SKIP_VERIFY"`
+
+	// Resource is sent with the challenge requests, the server rules may set the difficulty by it.
+	Resource string `yaml:"resource" envconfig:"RESOURCE"`
+	// PrivateTokens is the batch of the anonymous tokens a solution buys, the server has to issue them.
+	// The requests redeem the tokens instead of solving a challenge each. Disabled by default.
+	PrivateTokens int `yaml:"private_tokens" envconfig:"PRIVATE_TOKENS" validate:"gte=0,lte=100"`
+
+	RetryMaxAttempts int `yaml:"retry_max_attempts" envconfig:"RETRY_MAX_ATTEMPTS" default:"5" validate:"gte=1"`
+
+	// The solve policy, the zero limits are unlimited.
+	MaxDifficulty       int           `yaml:"max_difficulty" envconfig:"MAX_DIFFICULTY" validate:"gte=0"`
+	MaxExpectedAttempts float64       `yaml:"max_expected_attempts" envconfig:"MAX_EXPECTED_ATTEMPTS" validate:"gte=0"`
+	SolveBudget         time.Duration `yaml:"solve_budget" envconfig:"SOLVE_BUDGET" validate:"gte=0"`
+	// Puzzles are the puzzles solved next to sha256: rsw and cuckoo. RSWMaxSquarings and CuckooMaxEdgeBits
+	// limit their challenges.
+	Puzzles           []string `yaml:"puzzles" envconfig:"PUZZLES" validate:"dive,oneof=rsw cuckoo"`
+	RSWMaxSquarings   uint64   `yaml:"rsw_max_squarings" envconfig:"RSW_MAX_SQUARINGS"`
+	CuckooMaxEdgeBits int      `yaml:"cuckoo_max_edge_bits" envconfig:"CUCKOO_MAX_EDGE_BITS" validate:"gte=0,lte=30"`
+}
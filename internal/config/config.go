@@ -1,7 +1,105 @@
 package config
 
+import "time"
+
+// Config is the server configuration, see Loader for the tags. The fields with `reload:"true"`
+// are applied without a restart on SIGHUP or when the config file changes.
 type Config struct {
-	ServerAddr     string `envconfig:"SERVER_ADDR" default:":9090"`
-	Difficulty     int    `envconfig:"DIFFICULTY" default:"4"`
-	QuotesFileName string `envconfig:"FILE_NAME"`
+	ServerAddr string `yaml:"server_addr" envconfig:"SERVER_ADDR" default:":9090" validate:"required"`
+	Difficulty int    `yaml:"difficulty" envconfig:"DIFFICULTY" default:"4" validate:"gte=0,lte=32" reload:"true"`
+	// DifficultyMin and DifficultyMax bound the difficulty, also the one set by the admin API.
+	DifficultyMin  int    `yaml:"difficulty_min" envconfig:"DIFFICULTY_MIN" default:"0" validate:"gte=0,ltefield=DifficultyMax" reload:"true"`
+	DifficultyMax  int    `yaml:"difficulty_max" envconfig:"DIFFICULTY_MAX" default:"32" validate:"lte=32" reload:"true"`
+	QuotesFileName string `yaml:"quotes_file" envconfig:"FILE_NAME" validate:"required_without=UpstreamAddr" reload:"true"`
+	// Quota is the number of the content requests a solved challenge buys.
+	Quota    int    `yaml:"quota" envconfig:"QUOTA" default:"1" validate:"gte=1" reload:"true"`
+	LogLevel string `yaml:"log_level" envconfig:"LOG_LEVEL" default:"info" validate:"oneof=trace debug info warning error" reload:"true"`
+
+	// Puzzle is the challenge kind: the sha256 search of the difficulty, the rsw time-lock puzzle or
+	// the cuckoo memory-bound puzzle, see pow.RSW and pow.Cuckoo. The rsw modulus of RSWBits is generated
+	// on the start, a challenge is RSWSquarings sequential squarings; zero calibrates them to RSWSolveTime
+	// on this machine. A cuckoo challenge is a cycle of CuckooCycle edges in a graph of 2^CuckooEdgeBits.
+	// The reputation and the rules need sha256.
+	Puzzle         string        `yaml:"puzzle" envconfig:"PUZZLE" default:"sha256" validate:"oneof=sha256 rsw cuckoo"`
+	RSWBits        int           `yaml:"rsw_bits" envconfig:"RSW_BITS" default:"2048" validate:"gte=1024,lte=8192"`
+	RSWSquarings   int           `yaml:"rsw_squarings" envconfig:"RSW_SQUARINGS" validate:"gte=0"`
+	RSWSolveTime   time.Duration `yaml:"rsw_solve_time" envconfig:"RSW_SOLVE_TIME" default:"1s" validate:"gt=0"`
+	CuckooEdgeBits int           `yaml:"cuckoo_edge_bits" envconfig:"CUCKOO_EDGE_BITS" default:"20" validate:"gte=8,lte=30"`
+	CuckooCycle    int           `yaml:"cuckoo_cycle" envconfig:"CUCKOO_CYCLE" default:"42" validate:"gte=4,lte=254"`
+
+	// MaxMessageSize and ReadTimeout bound the client messages: the bigger frames and the slow or idle
+	// connections are dropped.
+	MaxMessageSize int           `yaml:"max_message_size" envconfig:"MAX_MESSAGE_SIZE" default:"65536" validate:"gte=64"`
+	ReadTimeout    time.Duration `yaml:"read_timeout" envconfig:"READ_TIMEOUT" default:"1m" validate:"gt=0"`
+
+	// UpstreamAddr enables the reverse-proxy mode: the solved connections are spliced to this address.
+	UpstreamAddr        string        `yaml:"upstream_addr" envconfig:"UPSTREAM_ADDR"`
+	UpstreamNetwork     string        `yaml:"upstream_network" envconfig:"UPSTREAM_NETWORK" default:"tcp" validate:"oneof=tcp tcp4 tcp6 unix"`
+	UpstreamIdleTimeout time.Duration `yaml:"upstream_idle_timeout" envconfig:"UPSTREAM_IDLE_TIMEOUT" default:"5m" validate:"gte=0"`
+
+	// GRPCAddr enables the gRPC service, Secret signs its stateless challenges. Only the TCP connections
+	// can be spliced, so it excludes the reverse-proxy mode.
+	GRPCAddr string `yaml:"grpc_addr" envconfig:"GRPC_ADDR" validate:"excluded_with=UpstreamAddr"`
+	Secret   string `yaml:"secret" envconfig:"SECRET" secret:"true" validate:"required_with=GRPCAddr,omitempty,min=16"`
+
+	// WebSocketAddr enables the WebSocket endpoint at /ws for browsers, it excludes the reverse-proxy mode.
+	WebSocketAddr    string   `yaml:"ws_addr" envconfig:"WS_ADDR" validate:"excluded_with=UpstreamAddr"`
+	WebSocketOrigins []string `yaml:"ws_origins" envconfig:"WS_ORIGINS" validate:"dive,url"`
+
+	// The ban policy of the sources of the invalid solutions, see server.BanPolicy. Zero BanMaxFailures
+	// disables it.
+	BanMaxFailures int           `yaml:"ban_max_failures" envconfig:"BAN_MAX_FAILURES" default:"10" validate:"gte=0" reload:"true"`
+	BanWindow      time.Duration `yaml:"ban_window" envconfig:"BAN_WINDOW" default:"1m" validate:"gt=0" reload:"true"`
+	BanDuration    time.Duration `yaml:"ban_duration" envconfig:"BAN_DURATION" default:"1m" validate:"gt=0" reload:"true"`
+	BanMaxDuration time.Duration `yaml:"ban_max_duration" envconfig:"BAN_MAX_DURATION" default:"24h" validate:"gtefield=BanDuration" reload:"true"`
+	BanMultiplier  float64       `yaml:"ban_multiplier" envconfig:"BAN_MULTIPLIER" default:"2" validate:"gte=1" reload:"true"`
+	BanForgetAfter time.Duration `yaml:"ban_forget_after" envconfig:"BAN_FORGET_AFTER" default:"24h" validate:"gt=0" reload:"true"`
+	BanIPv4Prefix  int           `yaml:"ban_ipv4_prefix" envconfig:"BAN_IPV4_PREFIX" default:"32" validate:"gte=1,lte=32" reload:"true"`
+	BanIPv6Prefix  int           `yaml:"ban_ipv6_prefix" envconfig:"BAN_IPV6_PREFIX" default:"64" validate:"gte=1,lte=128" reload:"true"`
+
+	// Reputation sets the difficulty of every challenge by the client history, see package reputation:
+	// down by up to ReputationDiscount for the well-behaved clients and up by up to ReputationSurcharge
+	// for the suspicious ones. ReputationMaxClients bounds the tracked prefixes.
+	Reputation           bool          `yaml:"reputation" envconfig:"REPUTATION"`
+	ReputationHalfLife   time.Duration `yaml:"reputation_half_life" envconfig:"REPUTATION_HALF_LIFE" default:"1h" validate:"gt=0"`
+	ReputationMaxClients int           `yaml:"reputation_max_clients" envconfig:"REPUTATION_MAX_CLIENTS" default:"100000" validate:"gt=0"`
+	ReputationDiscount   int           `yaml:"reputation_discount" envconfig:"REPUTATION_DISCOUNT" default:"1" validate:"gte=0,lte=32"`
+	ReputationSurcharge  int           `yaml:"reputation_surcharge" envconfig:"REPUTATION_SURCHARGE" default:"2" validate:"gte=0,lte=32"`
+
+	// AllowlistFile and DenylistFile are the access lists, an address or a CIDR prefix per line, see
+	// server.ParseAccessList. The allowed addresses skip the PoW, the denied ones are refused. The files
+	// are reloaded when they change.
+	AllowlistFile string `yaml:"allowlist_file" envconfig:"ALLOWLIST_FILE"`
+	DenylistFile  string `yaml:"denylist_file" envconfig:"DENYLIST_FILE"`
+
+	// PolicyFile is the difficulty rule set, see package policy. It's reloaded when it changes.
+	PolicyFile string `yaml:"policy_file" envconfig:"POLICY_FILE"`
+
+	// GeoIPFiles are the MaxMind DB files of the country and the ASN of the clients, see package geoip.
+	// They're reloaded when they change, the server works without them.
+	GeoIPFiles []string `yaml:"geoip_files" envconfig:"GEOIP_FILES"`
+
+	// TokenAlgorithm enables the access tokens of the solved challenges, see package token: ed25519 or hs256.
+	// TokenKey is the HMAC secret or the secret the Ed25519 key is derived from, `server token-key` prints
+	// the public one. A token is worth TokenUses requests within TokenTTL, zero is unlimited, so a token
+	// can be shared by any number of clients; the uses are counted in memory, a restart resets them.
+	TokenAlgorithm   string        `yaml:"token_algorithm" envconfig:"TOKEN_ALGORITHM" validate:"omitempty,oneof=ed25519 hs256"`
+	TokenKey         string        `yaml:"token_key" envconfig:"TOKEN_KEY" secret:"true" validate:"required_with=TokenAlgorithm,omitempty,min=16"`
+	TokenTTL         time.Duration `yaml:"token_ttl" envconfig:"TOKEN_TTL" default:"5m" validate:"gt=0"`
+	TokenUses        int           `yaml:"token_uses" envconfig:"TOKEN_USES" default:"10" validate:"gte=0"`
+	TokenBindAddress bool          `yaml:"token_bind_address" envconfig:"TOKEN_BIND_ADDRESS"`
+
+	// PrivacyPassKeyFile enables the anonymous tokens, see package privacypass: a PEM RSA key of at least
+	// 2048 bits. A solution buys PrivacyPassBatch of them. The server keeps up to PrivacyPassMaxSpent
+	// spent ones in memory, in an epoch the tokens are bound to: a restart or a full store starts a new
+	// epoch and refuses the tokens of the past ones, the clients solve for a new batch.
+	PrivacyPassKeyFile  string `yaml:"privacy_pass_key_file" envconfig:"PRIVACY_PASS_KEY_FILE"`
+	PrivacyPassIssuer   string `yaml:"privacy_pass_issuer" envconfig:"PRIVACY_PASS_ISSUER" default:"power" validate:"required,max=255"`
+	PrivacyPassBatch    int    `yaml:"privacy_pass_batch" envconfig:"PRIVACY_PASS_BATCH" default:"10" validate:"gte=1,lte=100"`
+	PrivacyPassMaxSpent int    `yaml:"privacy_pass_max_spent" envconfig:"PRIVACY_PASS_MAX_SPENT" default:"1048576" validate:"gte=1"`
+
+	// AdminAddr enables the admin API, see package admin. It should be a private address,
+	// AdminToken authenticates the requests.
+	AdminAddr  string `yaml:"admin_addr" envconfig:"ADMIN_ADDR"`
+	AdminToken string `yaml:"admin_token" envconfig:"ADMIN_TOKEN" secret:"true" validate:"required_with=AdminAddr,omitempty,min=16"`
 }
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "******"
+
+var (
+	ErrUnknownKey    = errors.New("unknown config key")
+	ErrUnknownFormat = errors.New("unknown config file format")
+	ErrInvalidFlags  = errors.New("invalid flags")
+)
+
+// Loader fills a config struct from the layers, the later ones win: the `default` tags, the YAML or
+// TOML file, the env vars and the flags.
+//
+// The fields are described by the tags: `yaml` is the key in the file (the flag is the same with dashes),
+// `envconfig` is the env var, read as PREFIX_NAME or NAME like envconfig does, `secret` hides the value
+// in Redact and `reload` marks the fields which can be changed without a restart, see MergeReloadable.
+type Loader struct {
+	// Prefix is the prefix of the env vars.
+	Prefix string
+	// File is the config file, the -config flag and the CONFIG_FILE env var override it.
+	File string
+	// Args are the command-line arguments without the program name.
+	Args []string
+	// FlagSet gets the flags of the fields, the caller can add its own flags to it.
+	FlagSet *flag.FlagSet
+	// LookupEnv is os.LookupEnv by default.
+	LookupEnv func(key string) (string, bool)
+
+	parsed bool
+	flags  map[string]string
+}
+
+// Load fills conf, a pointer to a struct, and validates it. It can be called again to reload the file.
+func (l *Loader) Load(conf any) error {
+	v := reflect.ValueOf(conf)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("config must be a pointer to a struct, got %T", conf)
+	}
+
+	fields := structFields(v.Elem())
+	if err := l.parseFlags(fields); err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		if f.defaultValue == "" {
+			continue
+		}
+		if err := setValue(f.value, f.defaultValue); err != nil {
+			return errors.Wrapf(err, "default of %s", f.key)
+		}
+	}
+
+	if l.File != "" {
+		if err := loadFile(l.File, fields); err != nil {
+			return errors.Wrapf(err, "load %s", l.File)
+		}
+	}
+
+	for _, f := range fields {
+		raw, ok := l.lookupEnv(f.env)
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value, raw); err != nil {
+			return errors.Wrapf(err, "env %s", f.env)
+		}
+	}
+
+	for _, f := range fields {
+		raw, ok := l.flags[f.key]
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value, raw); err != nil {
+			return errors.Wrapf(err, "flag -%s", f.flag())
+		}
+	}
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(conf); err != nil {
+		return errors.Wrap(err, "validate the config")
+	}
+	return nil
+}
+
+// parseFlags parses the arguments once, the raw values are applied on every Load.
+func (l *Loader) parseFlags(fields []field) error {
+	if l.parsed {
+		return nil
+	}
+	l.parsed = true
+
+	if l.FlagSet == nil {
+		l.FlagSet = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	}
+
+	l.flags = make(map[string]string)
+	for _, f := range fields {
+		usage := "see the config file key " + f.key
+		if f.env != "" {
+			usage = "env " + f.env
+		}
+		l.FlagSet.Var(&rawFlag{key: f.key, flags: l.flags, isBool: f.value.Kind() == reflect.Bool}, f.flag(), usage)
+	}
+
+	var file string
+	if l.FlagSet.Lookup("config") == nil {
+		l.FlagSet.StringVar(&file, "config", "", "the config file, .yaml, .yml or .toml (env CONFIG_FILE)")
+	}
+
+	if err := l.FlagSet.Parse(l.Args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err //nolint:wrapcheck // flag.ErrHelp is checked by the callers
+		}
+		return errors.Wrap(ErrInvalidFlags, err.Error())
+	}
+
+	if envFile, ok := l.lookupEnv("CONFIG_FILE"); ok && envFile != "" {
+		l.File = envFile
+	}
+	if file != "" {
+		l.File = file
+	}
+	return nil
+}
+
+func (l *Loader) lookupEnv(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	lookup := l.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	if l.Prefix != "" {
+		if raw, ok := lookup(strings.ToUpper(l.Prefix) + "_" + key); ok {
+			return raw, true
+		}
+	}
+	return lookup(key)
+}
+
+// rawFlag keeps the raw value of a flag until the layers below are loaded.
+type rawFlag struct {
+	key    string
+	flags  map[string]string
+	isBool bool
+}
+
+func (f *rawFlag) String() string   { return "" }
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+
+func (f *rawFlag) Set(raw string) error {
+	f.flags[f.key] = raw
+	return nil
+}
+
+func loadFile(file string, fields []field) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "read the file")
+	}
+
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return ErrUnknownFormat
+	}
+	if err != nil {
+		return errors.Wrap(err, "decode the file")
+	}
+
+	for key, value := range values {
+		f, ok := findField(fields, key)
+		if !ok {
+			return errors.Wrap(ErrUnknownKey, key)
+		}
+		if err = setValue(f.value, formatValue(value)); err != nil {
+			return errors.Wrapf(err, "key %s", key)
+		}
+	}
+	return nil
+}
+
+// formatValue converts a decoded value to the form of the env vars: the lists are comma-separated.
+func formatValue(value any) string {
+	list, ok := value.([]any)
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprint(item)
+	}
+	return strings.Join(items, ",")
+}
+
+type field struct {
+	key          string
+	env          string
+	defaultValue string
+	secret       bool
+	reload       bool
+	value        reflect.Value
+}
+
+func (f *field) flag() string {
+	return strings.ReplaceAll(f.key, "_", "-")
+}
+
+func structFields(v reflect.Value) []field {
+	fields := make([]field, 0, v.NumField())
+	for i := range v.NumField() {
+		sf := v.Type().Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		key, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(sf.Name)
+		}
+
+		fields = append(fields, field{
+			key:          key,
+			env:          sf.Tag.Get("envconfig"),
+			defaultValue: sf.Tag.Get("default"),
+			secret:       sf.Tag.Get("secret") == "true",
+			reload:       sf.Tag.Get("reload") == "true",
+			value:        v.Field(i),
+		})
+	}
+	return fields
+}
+
+func findField(fields []field, key string) (field, bool) {
+	for _, f := range fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func setValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return errors.Wrap(err, "parse a duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	//nolint:exhaustive // the config has only these kinds
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.Wrap(err, "parse a bool")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return errors.Wrap(err, "parse an int")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return errors.Wrap(err, "parse a uint")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return errors.Wrap(err, "parse a float")
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("unsupported type %s", v.Type())
+		}
+
+		var items []string
+		if raw != "" {
+			items = strings.Split(raw, ",")
+			for i := range items {
+				items[i] = strings.TrimSpace(items[i])
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Redact returns a copy of the config with the secrets hidden, it's safe to log.
+func Redact[T any](conf T) T {
+	v := reflect.ValueOf(&conf).Elem()
+	if v.Kind() != reflect.Struct {
+		return conf
+	}
+
+	for _, f := range structFields(v) {
+		if f.secret && !f.value.IsZero() && f.value.Kind() == reflect.String {
+			f.value.SetString(redacted)
+		}
+	}
+	return conf
+}
+
+// Print writes the effective config as YAML with the secrets hidden.
+func Print(w io.Writer, conf any) error {
+	v := reflect.Indirect(reflect.ValueOf(conf))
+
+	var node yaml.Node
+	node.Kind = yaml.MappingNode
+
+	for _, f := range structFields(v) {
+		var value yaml.Node
+		var err error
+		if f.secret && !f.value.IsZero() {
+			err = value.Encode(redacted)
+		} else {
+			err = value.Encode(f.value.Interface())
+		}
+		if err != nil {
+			return errors.Wrapf(err, "encode %s", f.key)
+		}
+
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}, &value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return errors.Wrap(err, "encode the config")
+	}
+	return encoder.Close() //nolint:wrapcheck // it only flushes the buffer
+}
+
+// MergeReloadable returns cur with the reloadable fields of next. It also returns the keys of the
+// changed reloadable fields and of the changed fields which need a restart.
+//
+//nolint:nonamedreturns // the lists are described by the names
+func MergeReloadable[T any](cur, next T) (merged T, changed, ignored []string) {
+	merged = cur
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	nextFields := structFields(reflect.ValueOf(&next).Elem())
+
+	for i, f := range structFields(mergedValue) {
+		nextValue := nextFields[i].value
+		if reflect.DeepEqual(f.value.Interface(), nextValue.Interface()) {
+			continue
+		}
+
+		if !f.reload {
+			ignored = append(ignored, f.key)
+			continue
+		}
+
+		f.value.Set(nextValue)
+		changed = append(changed, f.key)
+	}
+	return merged, changed, ignored
+}
@@ -0,0 +1,188 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoader_Load(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	yamlFile := filepath.Join(dir, "server.yaml")
+	require.NoError(t, os.WriteFile(yamlFile, []byte("difficulty: 2\nquotes_file: quotes.txt\nquota: 3\nws_origins: [https://a.com, https://b.com]\nupstream_idle_timeout: 1m\n"), 0o600))
+
+	tomlFile := filepath.Join(dir, "server.toml")
+	require.NoError(t, os.WriteFile(tomlFile, []byte("difficulty = 2\nquotes_file = \"quotes.txt\"\nquota = 3\nws_origins = [\"https://a.com\", \"https://b.com\"]\nupstream_idle_timeout = \"1m\"\n"), 0o600))
+
+	unknownFile := filepath.Join(dir, "unknown.yaml")
+	require.NoError(t, os.WriteFile(unknownFile, []byte("dificulty: 2\n"), 0o600))
+
+	tests := []struct {
+		name        string
+		file        string
+		env         map[string]string
+		args        []string
+		expected    func(conf *config.Config)
+		expectedErr error
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"FILE_NAME": "quotes.txt"},
+			expected: func(conf *config.Config) {
+				conf.Difficulty, conf.QuotesFileName, conf.Quota = 4, "quotes.txt", 1
+			},
+		},
+		{
+			name: "yaml file",
+			file: yamlFile,
+			expected: func(conf *config.Config) {
+				conf.Difficulty, conf.QuotesFileName, conf.Quota = 2, "quotes.txt", 3
+				conf.WebSocketOrigins = []string{"https://a.com", "https://b.com"}
+				conf.UpstreamIdleTimeout = time.Minute
+			},
+		},
+		{
+			name: "toml file",
+			args: []string{"-config", tomlFile},
+			expected: func(conf *config.Config) {
+				conf.Difficulty, conf.QuotesFileName, conf.Quota = 2, "quotes.txt", 3
+				conf.WebSocketOrigins = []string{"https://a.com", "https://b.com"}
+				conf.UpstreamIdleTimeout = time.Minute
+			},
+		},
+		{
+			name: "env over the file, the prefixed env wins",
+			file: yamlFile,
+			env:  map[string]string{"DIFFICULTY": "5", "QUOTA": "6", "SERVER_QUOTA": "7"},
+			expected: func(conf *config.Config) {
+				conf.Difficulty, conf.QuotesFileName, conf.Quota = 5, "quotes.txt", 7
+				conf.WebSocketOrigins = []string{"https://a.com", "https://b.com"}
+				conf.UpstreamIdleTimeout = time.Minute
+			},
+		},
+		{
+			name: "flags over the env",
+			file: yamlFile,
+			env:  map[string]string{"DIFFICULTY": "5"},
+			args: []string{"-difficulty", "6", "-ws-origins", "https://c.com"},
+			expected: func(conf *config.Config) {
+				conf.Difficulty, conf.QuotesFileName, conf.Quota = 6, "quotes.txt", 3
+				conf.WebSocketOrigins = []string{"https://c.com"}
+				conf.UpstreamIdleTimeout = time.Minute
+			},
+		},
+		{
+			name:        "unknown key",
+			file:        unknownFile,
+			expectedErr: config.ErrUnknownKey,
+		},
+		{
+			name: "validation",
+			env:  map[string]string{"FILE_NAME": "quotes.txt", "DIFFICULTY": "33"},
+		},
+		{
+			name: "websocket in the proxy mode",
+			env:  map[string]string{"UPSTREAM_ADDR": "localhost:6379", "WS_ADDR": ":8080"},
+		},
+		{
+			name: "secret for grpc",
+			env:  map[string]string{"FILE_NAME": "quotes.txt", "GRPC_ADDR": ":9091", "SECRET": "short"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			loader := &config.Loader{Prefix: "server", File: tt.file, Args: tt.args, LookupEnv: lookupEnv(tt.env)}
+
+			var conf config.Config
+			err := loader.Load(&conf)
+			if tt.expected == nil {
+				require.Error(t, err)
+				if tt.expectedErr != nil {
+					require.ErrorIs(t, err, tt.expectedErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+
+			expected := config.Config{
+				ServerAddr:           ":9090",
+				DifficultyMax:        32,
+				LogLevel:             "info",
+				Puzzle:               "sha256",
+				RSWBits:              2048,
+				RSWSolveTime:         time.Second,
+				CuckooEdgeBits:       20,
+				CuckooCycle:          42,
+				MaxMessageSize:       64 * 1024,
+				ReadTimeout:          time.Minute,
+				UpstreamNetwork:      "tcp",
+				UpstreamIdleTimeout:  5 * time.Minute,
+				BanMaxFailures:       10,
+				BanWindow:            time.Minute,
+				BanDuration:          time.Minute,
+				BanMaxDuration:       24 * time.Hour,
+				BanMultiplier:        2,
+				BanForgetAfter:       24 * time.Hour,
+				BanIPv4Prefix:        32,
+				BanIPv6Prefix:        64,
+				ReputationHalfLife:   time.Hour,
+				ReputationMaxClients: 100000,
+				ReputationDiscount:   1,
+				ReputationSurcharge:  2,
+				TokenTTL:             5 * time.Minute,
+				TokenUses:            10,
+				PrivacyPassIssuer:    "power",
+				PrivacyPassBatch:     10,
+				PrivacyPassMaxSpent:  1 << 20,
+			}
+			tt.expected(&expected)
+			require.Equal(t, expected, conf)
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	t.Parallel()
+
+	conf := config.Config{ServerAddr: ":9090", Secret: "0123456789abcdef"}
+
+	require.Equal(t, "******", config.Redact(conf).Secret)
+	require.Equal(t, "0123456789abcdef", conf.Secret)
+
+	var buf bytes.Buffer
+	require.NoError(t, config.Print(&buf, &conf))
+	require.Contains(t, buf.String(), "server_addr: :9090\n")
+	require.Contains(t, buf.String(), "secret: '******'\n")
+	require.NotContains(t, buf.String(), conf.Secret)
+}
+
+func TestMergeReloadable(t *testing.T) {
+	t.Parallel()
+
+	cur := config.Config{ServerAddr: ":9090", Difficulty: 1, Quota: 1}
+	next := config.Config{ServerAddr: ":9091", Difficulty: 2, Quota: 1}
+
+	merged, changed, ignored := config.MergeReloadable(cur, next)
+	require.Equal(t, config.Config{ServerAddr: ":9090", Difficulty: 2, Quota: 1}, merged)
+	require.Equal(t, []string{"difficulty"}, changed)
+	require.Equal(t, []string{"server_addr"}, ignored)
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchDebounce merges the events of one save, editors write a file in several steps.
+const watchDebounce = 100 * time.Millisecond
+
+// Watch calls reload on SIGHUP and when one of the files changes until ctx is done, e.g. the config
+// and the files it refers to. The empty names are skipped; the directories are watched because editors
+// and config management replace the files.
+func Watch(ctx context.Context, reload func(), files ...string) error {
+	var (
+		events <-chan fsnotify.Event
+		errs   <-chan error
+	)
+
+	watched := map[string]bool{}
+	for _, file := range files {
+		if file != "" {
+			watched[filepath.Clean(file)] = true
+		}
+	}
+
+	if len(watched) > 0 {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return errors.Wrap(err, "create a watcher")
+		}
+
+		dirs := map[string]bool{}
+		for file := range watched {
+			if dir := filepath.Dir(file); !dirs[dir] {
+				if err = watcher.Add(dir); err != nil {
+					watcher.Close()
+					return errors.Wrapf(err, "watch the directory of %s", file)
+				}
+				dirs[dir] = true
+			}
+		}
+
+		context.AfterFunc(ctx, func() { watcher.Close() })
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+
+		debounce := time.NewTimer(watchDebounce)
+		debounce.Stop()
+
+		var changed string
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				log.Info("reload the config on SIGHUP")
+				reload()
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				if name := filepath.Clean(event.Name); watched[name] && !event.Has(fsnotify.Chmod) {
+					changed = name
+					debounce.Reset(watchDebounce)
+				}
+			case err, ok := <-errs:
+				// the watcher blocks until its error is received
+				if !ok {
+					errs = nil
+					continue
+				}
+				log.WithError(err).Warn("watch the config files")
+			case <-debounce.C:
+				log.WithField("file", changed).Info("reload the changed config")
+				reload()
+			}
+		}
+	}()
+	return nil
+}
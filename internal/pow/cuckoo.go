@@ -0,0 +1,331 @@
+package pow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"slices"
+	"sync/atomic"
+
+	"github.com/go-faster/errors"
+)
+
+// AlgorithmCuckoo is the name of the puzzle of Cuckoo.
+const AlgorithmCuckoo = "cuckoo"
+
+const (
+	// MinCuckooEdgeBits and MaxCuckooEdgeBits bound the graph size, a solver needs 8 bytes per edge.
+	MinCuckooEdgeBits     = 8
+	MaxCuckooEdgeBits     = 30
+	DefaultCuckooEdgeBits = 20
+
+	// DefaultCuckooCycle is the cycle length of the reference Cuckoo Cycle.
+	DefaultCuckooCycle = 42
+	MaxCuckooCycle     = 254
+
+	cuckooParamsLength = 2 // edge bits + cycle length
+	cuckooNonceLength  = 4
+	// maxCuckooPath bounds the paths of the solver, a longer one drops the graph.
+	maxCuckooPath = 8192
+)
+
+// Cuckoo is the Cuckoo Cycle puzzle (J. Tromp): the seed and a nonce key a bipartite graph of
+// 2^edgeBits edges, each one joins the nodes siphash(2i) and siphash(2i+1) of the sides, and the
+// solution is a cycle of the given length in it. Finding a cycle takes a random access to the memory
+// per edge, so it's bound by the memory latency rather than the hashing; checking one computes
+// the endpoints of its edges only.
+//
+// The parameters are the edge bits and the cycle length (a byte each), the solution is the nonce
+// (4 bytes) and the ascending edge indices of the cycle (4 bytes each), big-endian.
+type Cuckoo struct {
+	edgeBits atomic.Int32
+	cycle    int
+}
+
+// NewCuckoo returns the puzzle of the graphs of 2^edgeBits edges and the cycles of the length,
+// an even number from 4 to MaxCuckooCycle.
+func NewCuckoo(edgeBits, cycle int) (*Cuckoo, error) {
+	if cycle < 4 || cycle > MaxCuckooCycle || cycle%2 != 0 {
+		return nil, errors.Errorf("the cycle of %d edges, an even length from 4 to %d is supported", cycle, MaxCuckooCycle)
+	}
+
+	c := &Cuckoo{cycle: cycle}
+	c.SetEdgeBits(edgeBits)
+	return c, nil
+}
+
+func (c *Cuckoo) Algorithm() string {
+	return AlgorithmCuckoo
+}
+
+// EdgeBits returns the graph size of the new challenges.
+func (c *Cuckoo) EdgeBits() int {
+	return int(c.edgeBits.Load())
+}
+
+// SetEdgeBits changes the graph size of the new challenges, it's clamped into MinCuckooEdgeBits and
+// MaxCuckooEdgeBits. Every bit doubles the work and the memory of a solver. It's safe for concurrent use.
+func (c *Cuckoo) SetEdgeBits(edgeBits int) {
+	c.edgeBits.Store(int32(min(max(edgeBits, MinCuckooEdgeBits), MaxCuckooEdgeBits))) //nolint:gosec // it's clamped
+}
+
+func (c *Cuckoo) Issue(_ []byte) []byte {
+	return []byte{byte(c.edgeBits.Load()), byte(c.cycle)}
+}
+
+func (c *Cuckoo) Verify(seed, params, solution []byte) bool {
+	edgeBits, cycle, err := ParseCuckooParams(params)
+	if err != nil || len(solution) != cuckooNonceLength+4*cycle {
+		return false
+	}
+
+	k0, k1 := cuckooKeys(seed, solution[:cuckooNonceLength])
+	mask := uint64(1)<<edgeBits - 1
+
+	// the endpoints of the edges, the left ones at the even indices
+	nodes := make([]uint64, 0, 2*cycle)
+	var previous uint64
+	for i := range cycle {
+		edge := uint64(binary.BigEndian.Uint32(solution[cuckooNonceLength+4*i:]))
+		if edge > mask || (i > 0 && edge <= previous) {
+			return false
+		}
+		previous = edge
+
+		nodes = append(nodes, siphash24(k0, k1, 2*edge)&mask, siphash24(k0, k1, 2*edge+1)&mask)
+	}
+
+	// follow the cycle: every node has to be shared by exactly two edges
+	length, i := 0, 0
+	for {
+		j := i
+		for k := (i + 2) % len(nodes); k != i; k = (k + 2) % len(nodes) {
+			if nodes[k] == nodes[i] {
+				if j != i {
+					return false // a branch
+				}
+				j = k
+			}
+		}
+		if j == i {
+			return false // a dead end
+		}
+
+		i = j ^ 1
+		length++
+		if i == 0 {
+			return length == cycle
+		}
+	}
+}
+
+// ParseCuckooParams decodes the edge bits and the cycle length of a Cuckoo challenge.
+func ParseCuckooParams(params []byte) (int, int, error) {
+	if len(params) != cuckooParamsLength {
+		return 0, 0, ErrInvalidParams
+	}
+
+	edgeBits, cycle := int(params[0]), int(params[1])
+	if edgeBits < MinCuckooEdgeBits || edgeBits > MaxCuckooEdgeBits || cycle < 4 || cycle%2 != 0 {
+		return 0, 0, ErrInvalidParams
+	}
+	return edgeBits, cycle, nil
+}
+
+// CuckooSolver finds the cycles of the Cuckoo challenges with the simple cuckoo hashing search
+// of the reference implementation: it walks the graph nonce by nonce until one has the cycle.
+type CuckooSolver struct {
+	// MaxEdgeBits refuses the bigger graphs with ErrTooHard, zero means no limit.
+	MaxEdgeBits int
+}
+
+func (s CuckooSolver) Solve(ctx context.Context, seed, params []byte) ([]byte, error) {
+	edgeBits, cycle, err := ParseCuckooParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxEdgeBits > 0 && edgeBits > s.MaxEdgeBits {
+		return nil, errors.Wrapf(ErrTooHard, "the graph of %d edge bits is above %d", edgeBits, s.MaxEdgeBits)
+	}
+
+	graph := newCuckooGraph(edgeBits)
+	nonce := make([]byte, cuckooNonceLength)
+	for n := uint32(0); ; n++ {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "find cycle")
+		}
+
+		binary.BigEndian.PutUint32(nonce, n)
+		k0, k1 := cuckooKeys(seed, nonce)
+		if edges := graph.findCycle(ctx, k0, k1, cycle); len(edges) == cycle {
+			solution := slices.Clone(nonce)
+			for _, edge := range edges {
+				solution = binary.BigEndian.AppendUint32(solution, uint32(edge)) //nolint:gosec // the edge is below 2^30
+			}
+			return solution, nil
+		}
+	}
+}
+
+// cuckooGraph is the memory of the solver: the directed forest of the cuckoo hashing, every node
+// points to the next one of its path to the root. The left nodes are even and the right ones odd.
+type cuckooGraph struct {
+	mask   uint64
+	next   []uint32
+	us, vs []uint32
+}
+
+const cuckooNil = ^uint32(0)
+
+func newCuckooGraph(edgeBits int) *cuckooGraph {
+	return &cuckooGraph{
+		mask: uint64(1)<<edgeBits - 1,
+		next: make([]uint32, 2<<edgeBits),
+		us:   make([]uint32, 0, maxCuckooPath),
+		vs:   make([]uint32, 0, maxCuckooPath),
+	}
+}
+
+// findCycle returns the ascending edges of a cycle of the length in the graph of the keys, nil if
+// the search doesn't find one.
+func (g *cuckooGraph) findCycle(ctx context.Context, k0, k1 uint64, cycle int) []uint64 {
+	for i := range g.next {
+		g.next[i] = cuckooNil
+	}
+
+	for edge := uint64(0); edge <= g.mask; edge++ {
+		if edge%ProgressInterval == 0 && ctx.Err() != nil {
+			return nil
+		}
+
+		u, v := g.endpoints(k0, k1, edge)
+		var ok bool
+		if g.us, ok = g.path(u, g.us); !ok {
+			return nil
+		}
+		if g.vs, ok = g.path(v, g.vs); !ok {
+			return nil
+		}
+
+		nu, nv := len(g.us)-1, len(g.vs)-1
+		if g.us[nu] == g.vs[nv] {
+			// the edge closes a cycle, it's the paths up to their junction
+			common := min(nu, nv)
+			nu, nv = nu-common, nv-common
+			for g.us[nu] != g.vs[nv] {
+				nu++
+				nv++
+			}
+			if nu+nv+1 == cycle {
+				return g.cycleEdges(k0, k1, g.us[:nu+1], g.vs[:nv+1])
+			}
+			continue
+		}
+
+		// add the edge, the shorter path is reversed
+		if nu < nv {
+			for k := nu; k > 0; k-- {
+				g.next[g.us[k]] = g.us[k-1]
+			}
+			g.next[u] = v
+		} else {
+			for k := nv; k > 0; k-- {
+				g.next[g.vs[k]] = g.vs[k-1]
+			}
+			g.next[v] = u
+		}
+	}
+	return nil
+}
+
+func (g *cuckooGraph) endpoints(k0, k1, edge uint64) (uint32, uint32) {
+	u := uint32(siphash24(k0, k1, 2*edge)&g.mask) << 1     //nolint:gosec // the node is below 2^31
+	v := uint32(siphash24(k0, k1, 2*edge+1)&g.mask)<<1 | 1 //nolint:gosec // the node is below 2^31
+	return u, v
+}
+
+// path returns the nodes from the node to its root, false if it's too long.
+func (g *cuckooGraph) path(node uint32, path []uint32) ([]uint32, bool) {
+	path = path[:0]
+	for ; node != cuckooNil; node = g.next[node] {
+		if len(path) == maxCuckooPath {
+			return path, false
+		}
+		path = append(path, node)
+	}
+	return path, true
+}
+
+// cycleEdges finds the edges of the cycle made of the two paths and the edge joining their starts.
+func (g *cuckooGraph) cycleEdges(k0, k1 uint64, us, vs []uint32) []uint64 {
+	type pair struct{ u, v uint32 }
+	ordered := func(a, b uint32) pair {
+		if a&1 == 1 {
+			a, b = b, a
+		}
+		return pair{a, b}
+	}
+
+	pairs := make(map[pair]struct{}, len(us)+len(vs)-1)
+	pairs[ordered(us[0], vs[0])] = struct{}{}
+	for _, path := range [][]uint32{us, vs} {
+		for k := 1; k < len(path); k++ {
+			pairs[ordered(path[k-1], path[k])] = struct{}{}
+		}
+	}
+
+	edges := make([]uint64, 0, len(pairs))
+	for edge := uint64(0); edge <= g.mask && len(pairs) > 0; edge++ {
+		u, v := g.endpoints(k0, k1, edge)
+		if _, ok := pairs[pair{u, v}]; ok {
+			delete(pairs, pair{u, v})
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// cuckooKeys derives the siphash keys of the graph of the seed and the nonce.
+func cuckooKeys(seed, nonce []byte) (uint64, uint64) {
+	h := sha256.New()
+	h.Write(seed)
+	h.Write(nonce)
+	sum := h.Sum(nil)
+	return binary.LittleEndian.Uint64(sum), binary.LittleEndian.Uint64(sum[8:])
+}
+
+// siphash24 is SipHash-2-4 of a 64-bit word without the length block, as Cuckoo Cycle uses it.
+func siphash24(k0, k1, word uint64) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573 ^ word
+
+	round := func() {
+		v0 += v1
+		v2 += v3
+		v1 = bits.RotateLeft64(v1, 13)
+		v3 = bits.RotateLeft64(v3, 16)
+		v1 ^= v0
+		v3 ^= v2
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v1
+		v0 += v3
+		v1 = bits.RotateLeft64(v1, 17)
+		v3 = bits.RotateLeft64(v3, 21)
+		v1 ^= v2
+		v3 ^= v0
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	round()
+	round()
+	v0 ^= word
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
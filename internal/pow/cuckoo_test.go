@@ -0,0 +1,82 @@
+package pow_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCuckoo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		edgeBits int
+		cycle    int
+	}{
+		{name: "short cycle", edgeBits: 10, cycle: 6},
+		{name: "reference cycle", edgeBits: 12, cycle: pow.DefaultCuckooCycle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cuckoo, err := pow.NewCuckoo(tt.edgeBits, tt.cycle)
+			require.NoError(t, err)
+			require.Equal(t, pow.AlgorithmCuckoo, cuckoo.Algorithm())
+
+			seed := []byte("seed")
+			params := cuckoo.Issue(seed)
+			solution, err := pow.CuckooSolver{}.Solve(context.Background(), seed, params)
+			require.NoError(t, err)
+			require.Len(t, solution, 4+4*tt.cycle)
+			require.True(t, cuckoo.Verify(seed, params, solution))
+
+			// the cycle is bound to the seed, the nonce and the graph
+			require.False(t, cuckoo.Verify([]byte("another seed"), params, solution))
+
+			nonce := binary.BigEndian.Uint32(solution)
+			another := append(binary.BigEndian.AppendUint32(nil, nonce+1), solution[4:]...)
+			require.False(t, cuckoo.Verify(seed, params, another))
+
+			cuckoo.SetEdgeBits(tt.edgeBits + 1)
+			require.False(t, cuckoo.Verify(seed, cuckoo.Issue(seed), solution))
+
+			// the edges are ascending and distinct
+			swapped := append([]byte(nil), solution...)
+			copy(swapped[4:8], solution[8:12])
+			copy(swapped[8:12], solution[4:8])
+			require.False(t, cuckoo.Verify(seed, params, swapped))
+			require.False(t, cuckoo.Verify(seed, params, solution[:len(solution)-4]))
+		})
+	}
+}
+
+func TestCuckooSolver(t *testing.T) {
+	t.Parallel()
+
+	cuckoo, err := pow.NewCuckoo(12, 6)
+	require.NoError(t, err)
+	params := cuckoo.Issue([]byte("seed"))
+
+	_, err = pow.CuckooSolver{MaxEdgeBits: 11}.Solve(context.Background(), []byte("seed"), params)
+	require.ErrorIs(t, err, pow.ErrTooHard)
+
+	_, err = pow.CuckooSolver{}.Solve(context.Background(), []byte("seed"), []byte{12, 5})
+	require.ErrorIs(t, err, pow.ErrInvalidParams)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pow.CuckooSolver{}.Solve(ctx, []byte("seed"), params)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = pow.NewCuckoo(12, 7)
+	require.Error(t, err)
+
+	cuckoo.SetEdgeBits(64)
+	require.Equal(t, pow.MaxCuckooEdgeBits, cuckoo.EdgeBits())
+}
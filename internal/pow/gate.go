@@ -0,0 +1,168 @@
+package pow
+
+import (
+	"crypto/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+const (
+	DefaultTicketTTL = time.Minute
+
+	ticketSeedSize = 16
+)
+
+var (
+	ErrInvalidSolution = errors.New("invalid solution")
+	ErrReplayed        = errors.New("challenge is already redeemed")
+)
+
+// Handler is an interface that defines the methods the gate needs from the PoW handler.
+type Handler interface {
+	GenerateHash(msg []byte, nonce int) []byte
+	IsValidHash(hash []byte, byteIndex int, byteValue byte) bool
+	GetClientConditions(clientAddr net.Addr) (byteIndex int, byteValue byte)
+}
+
+// difficultyVerifier is a Handler which checks a hash against any difficulty, e.g. *Pow.
+type difficultyVerifier interface {
+	IsValidHashAt(hash []byte, difficulty, byteIndex int, byteValue byte) bool
+}
+
+// SpentStore keeps the redeemed tickets until they expire, so a ticket is redeemed once. The replicas
+// sharing the secret have to share the store as well, or a ticket is redeemed once on every replica.
+type SpentStore interface {
+	// Spend marks the ticket redeemed until it expires, false if it already is.
+	Spend(ticket string, expiresAt time.Time) (bool, error)
+}
+
+// Gate issues and redeems sealed tickets for the stateless transports.
+// It keeps no state except the redeemed tickets, which are remembered by the SpentStore until they expire.
+type Gate struct {
+	pow    Handler
+	sealer *Sealer
+	ttl    time.Duration
+	spent  SpentStore
+	now    func() time.Time
+	// reporter and verifier are set when the handler supports both, the tickets carry the difficulty then
+	reporter DifficultyReporter
+	verifier difficultyVerifier
+}
+
+// NewGate returns the gate of the tickets sealed with the secret. A nil store remembers the redeemed
+// tickets in the memory of this process, see MemorySpentStore.
+func NewGate(handler Handler, secret []byte, ttl time.Duration, spent SpentStore) *Gate {
+	if ttl == 0 {
+		ttl = DefaultTicketTTL
+	}
+	if spent == nil {
+		spent = NewMemorySpentStore(ttl)
+	}
+
+	gate := &Gate{
+		pow:    handler,
+		sealer: NewSealer(secret),
+		ttl:    ttl,
+		spent:  spent,
+		now:    time.Now,
+	}
+
+	reporter, isReporter := handler.(DifficultyReporter)
+	verifier, isVerifier := handler.(difficultyVerifier)
+	if isReporter && isVerifier {
+		gate.reporter, gate.verifier = reporter, verifier
+	}
+	return gate
+}
+
+// Issue returns a new ticket for the client and its sealed form.
+func (g *Gate) Issue(clientAddr net.Addr) (Ticket, string) {
+	// unlike the TCP server the challenge isn't bound to a connection, the random seed keeps the tickets unique
+	seed := make([]byte, ticketSeedSize)
+	rand.Read(seed) //nolint:errcheck // crypto/rand.Read never fails
+	byteIndex, byteValue := g.pow.GetClientConditions(clientAddr)
+
+	ticket := Ticket{
+		Hash:      g.pow.GenerateHash(seed, 0),
+		ByteIndex: byteIndex,
+		ByteValue: byteValue,
+		ExpiresAt: g.now().Add(g.ttl).Truncate(time.Second),
+	}
+	if g.reporter != nil {
+		// a later change of the difficulty doesn't affect the issued tickets
+		ticket.Difficulty = g.reporter.Difficulty()
+	}
+	return ticket, g.sealer.Seal(ticket)
+}
+
+// Redeem checks the solution of the sealed ticket. A ticket can be redeemed only once.
+func (g *Gate) Redeem(sealed string, nonce int) error {
+	now := g.now()
+	ticket, err := g.sealer.Open(sealed, now)
+	if err != nil {
+		return errors.Wrap(err, "open the ticket")
+	}
+
+	if !g.isValid(g.pow.GenerateHash(ticket.Hash, nonce), &ticket) {
+		return ErrInvalidSolution
+	}
+
+	// the decoder ignores the unused bits of the last base64 character, so a ticket has several
+	// spellings; the canonical one is spent
+	fresh, err := g.spent.Spend(g.sealer.Seal(ticket), ticket.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "spend the ticket")
+	}
+	if !fresh {
+		return ErrReplayed
+	}
+	return nil
+}
+
+func (g *Gate) isValid(hash []byte, ticket *Ticket) bool {
+	if g.verifier != nil {
+		return g.verifier.IsValidHashAt(hash, ticket.Difficulty, ticket.ByteIndex, ticket.ByteValue)
+	}
+	return g.pow.IsValidHash(hash, ticket.ByteIndex, ticket.ByteValue)
+}
+
+// MemorySpentStore is a SpentStore in the memory of one process, the expired tickets are swept
+// once per interval.
+type MemorySpentStore struct {
+	mu       sync.Mutex
+	items    map[string]time.Time
+	interval time.Duration
+	next     time.Time
+	now      func() time.Time
+}
+
+var _ SpentStore = (*MemorySpentStore)(nil)
+
+func NewMemorySpentStore(interval time.Duration) *MemorySpentStore {
+	return &MemorySpentStore{items: make(map[string]time.Time), interval: interval, now: time.Now}
+}
+
+func (s *MemorySpentStore) Spend(ticket string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.After(s.next) {
+		for k, exp := range s.items {
+			if !now.Before(exp) {
+				delete(s.items, k)
+			}
+		}
+		s.next = now.Add(s.interval)
+	}
+
+	if _, ok := s.items[ticket]; ok {
+		return false, nil
+	}
+
+	s.items[ticket] = expiresAt
+	return true, nil
+}
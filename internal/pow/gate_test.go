@@ -0,0 +1,75 @@
+package pow_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStore struct{}
+
+func (failingStore) Spend(string, time.Time) (bool, error) {
+	return false, errors.New("store is down")
+}
+
+func TestGate(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("a secret of the gate")
+	spent := pow.NewMemorySpentStore(time.Minute)
+	replicas := []*pow.Gate{
+		pow.NewGate(pow.NewPow(0), secret, 0, spent),
+		pow.NewGate(pow.NewPow(0), secret, 0, spent),
+	}
+
+	ticket, sealed := replicas[0].Issue(&net.TCPAddr{})
+	another, _ := replicas[0].Issue(&net.TCPAddr{})
+	require.NotEqual(t, ticket.Hash, another.Hash)
+
+	nonce := pow.NewPow(0).FindNonce(context.Background(), ticket.Hash, ticket.ByteIndex, ticket.ByteValue)
+	require.NoError(t, replicas[0].Redeem(sealed, nonce))
+
+	// the replicas share the spent tickets
+	require.ErrorIs(t, replicas[1].Redeem(sealed, nonce), pow.ErrReplayed)
+
+	// another spelling of the same ticket is spent as well
+	payload, mac, _ := strings.Cut(sealed, ".")
+	require.ErrorIs(t, replicas[0].Redeem(respell(payload)+"."+respell(mac), nonce), pow.ErrReplayed)
+
+	failing := pow.NewGate(pow.NewPow(0), secret, 0, failingStore{})
+	require.ErrorContains(t, failing.Redeem(sealed, nonce), "store is down")
+}
+
+func TestGate_Difficulty(t *testing.T) {
+	t.Parallel()
+
+	handler := pow.NewPow(1)
+	gate := pow.NewGate(handler, []byte("a secret of the gate"), 0, nil)
+
+	ticket, sealed := gate.Issue(&net.TCPAddr{})
+	require.Equal(t, 1, ticket.Difficulty)
+
+	// the issued ticket keeps its difficulty, the new ones get the changed one
+	handler.SetDifficulty(2)
+	changed, _ := gate.Issue(&net.TCPAddr{})
+	require.Equal(t, 2, changed.Difficulty)
+
+	nonce := pow.NewPow(ticket.Difficulty).FindNonce(context.Background(), ticket.Hash, ticket.ByteIndex, ticket.ByteValue)
+	require.NoError(t, gate.Redeem(sealed, nonce))
+}
+
+// respell flips the lowest bit of the last base64url character, it's unused if the length isn't
+// a multiple of 3 bytes.
+func respell(part string) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+	last := strings.IndexByte(alphabet, part[len(part)-1])
+	return part[:len(part)-1] + string(alphabet[last^1])
+}
@@ -4,17 +4,73 @@ import (
 	"context"
 	"crypto/sha256"
 	"fmt"
+	"math"
 	"net"
+	"sync/atomic"
+
+	"github.com/go-faster/errors"
 )
 
 const PowDigestLength = 20
 
+// AlgorithmSHA256 is the name of the puzzle of Pow.
+const AlgorithmSHA256 = "sha256"
+
+// MaxDifficulty is the difficulty of a hash which is all '0' bytes.
+const MaxDifficulty = sha256.Size
+
+// ErrInvalidBounds is returned for the difficulty bounds out of 0..MaxDifficulty or in the wrong order.
+var ErrInvalidBounds = errors.New("invalid difficulty bounds")
+
 type Pow struct {
-	difficulty int
+	difficulty atomic.Int32
+	bounds     atomic.Pointer[[2]int]
 }
 
 func NewPow(difficulty int) *Pow {
-	return &Pow{difficulty: difficulty}
+	p := &Pow{}
+	p.bounds.Store(&[2]int{0, MaxDifficulty})
+	p.SetDifficulty(difficulty)
+	return p
+}
+
+// DifficultyReporter is a PoW handler which tells its difficulty to the clients, e.g. *Pow.
+type DifficultyReporter interface {
+	Difficulty() int
+}
+
+// Difficulty returns the number of the leading '0' bytes a valid hash has.
+func (p *Pow) Difficulty() int {
+	return int(p.difficulty.Load())
+}
+
+// SetDifficulty changes the difficulty of the new hashes, it's clamped into the bounds.
+// It's safe for concurrent use.
+func (p *Pow) SetDifficulty(difficulty int) {
+	p.difficulty.Store(int32(p.Clamp(difficulty))) //nolint:gosec // the difficulty is less than the hash length
+}
+
+// Bounds returns the lowest and the highest difficulty.
+func (p *Pow) Bounds() (minDifficulty, maxDifficulty int) { //nolint:nonamedreturns // the names document the order
+	bounds := p.bounds.Load()
+	return bounds[0], bounds[1]
+}
+
+// SetBounds limits the difficulty, the current one is clamped into the new bounds.
+func (p *Pow) SetBounds(minDifficulty, maxDifficulty int) error {
+	if minDifficulty < 0 || maxDifficulty > MaxDifficulty || minDifficulty > maxDifficulty {
+		return errors.Wrapf(ErrInvalidBounds, "[%d, %d]", minDifficulty, maxDifficulty)
+	}
+
+	p.bounds.Store(&[2]int{minDifficulty, maxDifficulty})
+	p.SetDifficulty(p.Difficulty())
+	return nil
+}
+
+// Clamp returns the difficulty limited by the bounds.
+func (p *Pow) Clamp(difficulty int) int {
+	minDifficulty, maxDifficulty := p.Bounds()
+	return min(max(difficulty, minDifficulty), maxDifficulty)
 }
 
 func (p *Pow) GenerateHash(msg []byte, nonce int) []byte {
@@ -24,12 +80,18 @@ func (p *Pow) GenerateHash(msg []byte, nonce int) []byte {
 }
 
 func (p *Pow) IsValidHash(hash []byte, byteIndex int, byteValue byte) bool {
-	if len(hash) < p.difficulty {
+	return p.IsValidHashAt(hash, p.Difficulty(), byteIndex, byteValue)
+}
+
+// IsValidHashAt is IsValidHash for the given difficulty, e.g. the one of a challenge issued to
+// a particular client.
+func (p *Pow) IsValidHashAt(hash []byte, difficulty, byteIndex int, byteValue byte) bool {
+	if difficulty < 0 || len(hash) < difficulty || byteIndex < 0 || byteIndex >= len(hash) {
 		return false
 	}
 
-	//nolint:intrange // we are sure that p.difficulty is in the range of hash length
-	for i := 0; i < p.difficulty; i++ {
+	//nolint:intrange // we are sure that difficulty is in the range of hash length
+	for i := 0; i < difficulty; i++ {
 		if hash[i] != '0' {
 			return false
 		}
@@ -37,6 +99,28 @@ func (p *Pow) IsValidHash(hash []byte, byteIndex int, byteValue byte) bool {
 	return hash[byteIndex] == byteValue
 }
 
+// ExpectedAttempts returns the mean number of the hashes FindNonce computes for the conditions:
+// every checked byte matches with the probability 1/256. It's +Inf for the unsolvable conditions.
+func (p *Pow) ExpectedAttempts(byteIndex int, byteValue byte) float64 {
+	return ExpectedAttempts(p.Difficulty(), byteIndex, byteValue)
+}
+
+// ExpectedAttempts is Pow.ExpectedAttempts for any difficulty.
+func ExpectedAttempts(difficulty, byteIndex int, byteValue byte) float64 {
+	if difficulty < 0 || difficulty > sha256.Size || byteIndex < 0 || byteIndex >= sha256.Size {
+		return math.Inf(1)
+	}
+
+	checkedBytes := difficulty
+	switch {
+	case byteIndex >= difficulty:
+		checkedBytes++
+	case byteValue != '0':
+		return math.Inf(1) // the byte has to be '0' and byteValue at once
+	}
+	return math.Pow(256, float64(checkedBytes))
+}
+
 func (p *Pow) GetClientConditions(clientAddr net.Addr) (int, byte) {
 	ip := clientAddr.String()
 	byteIndex := int(ip[0]) % 32
@@ -45,8 +129,30 @@ func (p *Pow) GetClientConditions(clientAddr net.Addr) (int, byte) {
 }
 
 func (p *Pow) FindNonce(ctx context.Context, hash []byte, byteIndex int, byteValue byte) int {
-	var nonce = 0
-	for {
+	return p.FindNonceFrom(ctx, hash, byteIndex, byteValue, Search{})
+}
+
+// Search splits the nonce space between several solvers: a solver checks Start, Start+Step, Start+2*Step...
+type Search struct {
+	Start int
+	// Step is 1 if zero.
+	Step int
+	// MaxAttempts stops the search with -1, zero means no limit.
+	MaxAttempts int
+	// Progress is called every ProgressInterval attempts with the number of the attempts so far.
+	Progress func(attempts int)
+}
+
+const ProgressInterval = 1 << 14
+
+func (p *Pow) FindNonceFrom(ctx context.Context, hash []byte, byteIndex int, byteValue byte, search Search) int {
+	step := search.Step
+	if step <= 0 {
+		step = 1
+	}
+
+	var nonce = search.Start
+	for attempts := 1; ; attempts++ {
 		select {
 		case <-ctx.Done():
 			return -1
@@ -55,7 +161,14 @@ func (p *Pow) FindNonce(ctx context.Context, hash []byte, byteIndex int, byteVal
 			if p.IsValidHash(clientHash, byteIndex, byteValue) {
 				return nonce
 			}
-			nonce++
+
+			if search.MaxAttempts > 0 && attempts >= search.MaxAttempts {
+				return -1
+			}
+			if search.Progress != nil && attempts%ProgressInterval == 0 {
+				search.Progress(attempts)
+			}
+			nonce += step
 		}
 	}
 }
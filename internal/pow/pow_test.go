@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"fmt"
+	"math"
 	"net"
 	"testing"
 
@@ -77,6 +78,18 @@ func TestIsValidHash(t *testing.T) {
 			byteValue: 'c',
 			expected:  false,
 		},
+		{
+			hash:      []byte("0000abcd"),
+			byteIndex: 32,
+			byteValue: 'a',
+			expected:  false,
+		},
+		{
+			hash:      []byte("0000abcd"),
+			byteIndex: -1,
+			byteValue: 'a',
+			expected:  false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -89,6 +102,76 @@ func TestIsValidHash(t *testing.T) {
 	}
 }
 
+func TestIsValidHashAt(t *testing.T) {
+	t.Parallel()
+
+	p := pow.NewPow(4)
+	hash := []byte("00abcdef")
+
+	require.False(t, p.IsValidHash(hash, 2, 'a'))
+	require.True(t, p.IsValidHashAt(hash, 2, 2, 'a'))
+	require.False(t, p.IsValidHashAt(hash, 3, 2, 'a'))
+	require.False(t, p.IsValidHashAt(hash, -1, 2, 'a'))
+}
+
+func TestExpectedAttempts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		difficulty int
+		byteIndex  int
+		byteValue  byte
+		expected   float64
+	}{
+		{name: "no difficulty", difficulty: 0, byteIndex: 4, byteValue: 'a', expected: 256},
+		{name: "difficulty", difficulty: 2, byteIndex: 4, byteValue: 'a', expected: 256 * 256 * 256},
+		{name: "index in the prefix", difficulty: 2, byteIndex: 1, byteValue: '0', expected: 256 * 256},
+		{name: "conflicting index", difficulty: 2, byteIndex: 1, byteValue: 'a', expected: math.Inf(1)},
+		{name: "index out of the hash", difficulty: 1, byteIndex: 32, byteValue: 'a', expected: math.Inf(1)},
+		{name: "negative index", difficulty: 1, byteIndex: -1, byteValue: 'a', expected: math.Inf(1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.InDelta(t, tt.expected, pow.ExpectedAttempts(tt.difficulty, tt.byteIndex, tt.byteValue), 0)
+		})
+	}
+}
+
+func TestSetBounds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		min, max   int
+		difficulty int
+		expected   int
+		err        error
+	}{
+		{name: "difficulty in the bounds", min: 2, max: 6, difficulty: 4, expected: 4},
+		{name: "difficulty under the bounds", min: 2, max: 6, difficulty: 1, expected: 2},
+		{name: "difficulty over the bounds", min: 2, max: 6, difficulty: 7, expected: 6},
+		{name: "fixed difficulty", min: 3, max: 3, difficulty: 5, expected: 3},
+		{name: "negative min", min: -1, max: 6, difficulty: 4, expected: 4, err: pow.ErrInvalidBounds},
+		{name: "max over the hash", min: 0, max: 33, difficulty: 4, expected: 4, err: pow.ErrInvalidBounds},
+		{name: "min over max", min: 6, max: 2, difficulty: 4, expected: 4, err: pow.ErrInvalidBounds},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := pow.NewPow(4)
+			require.ErrorIs(t, p.SetBounds(tt.min, tt.max), tt.err)
+
+			p.SetDifficulty(tt.difficulty)
+			require.Equal(t, tt.expected, p.Difficulty())
+		})
+	}
+}
+
 func TestGetClientConditions(t *testing.T) {
 	t.Parallel()
 
@@ -160,6 +243,52 @@ func TestFindNonce(t *testing.T) {
 	}
 }
 
+func TestFindNonceFrom(t *testing.T) {
+	t.Parallel()
+
+	p := pow.NewPow(1)
+	hash, byteIndex, byteValue := []byte("0000abcd"), 4, byte('a')
+	expected := p.FindNonce(context.Background(), hash, byteIndex, byteValue)
+
+	tests := []struct {
+		name     string
+		search   pow.Search
+		expected int
+	}{
+		{
+			name:     "worker with the solution",
+			search:   pow.Search{Start: expected % 4, Step: 4},
+			expected: expected,
+		},
+		{
+			name:     "max attempts",
+			search:   pow.Search{MaxAttempts: expected},
+			expected: -1,
+		},
+		{
+			name:     "enough attempts",
+			search:   pow.Search{MaxAttempts: expected + 1},
+			expected: expected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var progress []int
+			tt.search.Progress = func(attempts int) { progress = append(progress, attempts) }
+
+			nonce := p.FindNonceFrom(context.Background(), hash, byteIndex, byteValue, tt.search)
+			require.Equal(t, tt.expected, nonce)
+
+			for i, attempts := range progress {
+				require.Equal(t, (i+1)*pow.ProgressInterval, attempts)
+			}
+		})
+	}
+}
+
 type mockAddr struct {
 	addr string
 }
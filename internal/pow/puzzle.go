@@ -0,0 +1,27 @@
+package pow
+
+import (
+	"context"
+
+	"github.com/go-faster/errors"
+)
+
+// ErrTooHard is returned by a PuzzleSolver for a challenge above its limits.
+var ErrTooHard = errors.New("puzzle is above the solver limits")
+
+// Puzzle is a puzzle kind next to the SHA-256 search of Pow. Its solution is an opaque byte string
+// rather than a nonce, the challenge is a random seed and the parameters the puzzle derives from it.
+type Puzzle interface {
+	// Algorithm is the name of the puzzle in the challenges, e.g. AlgorithmRSW.
+	Algorithm() string
+	// Issue returns the parameters of the challenge of the seed.
+	Issue(seed []byte) []byte
+	// Verify checks the solution of the challenge issued for the seed with the parameters.
+	Verify(seed, params, solution []byte) bool
+}
+
+// PuzzleSolver solves the challenges of a Puzzle, it's the client side of the puzzle.
+type PuzzleSolver interface {
+	// Solve returns the solution of the challenge, ErrTooHard if it's above the solver limits.
+	Solve(ctx context.Context, seed, params []byte) ([]byte, error)
+}
@@ -0,0 +1,189 @@
+package pow
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// AlgorithmRSW is the name of the puzzle of RSW.
+const AlgorithmRSW = "rsw"
+
+const (
+	// MinRSWBits and MaxRSWBits bound the size of the RSW modulus.
+	MinRSWBits     = 1024
+	MaxRSWBits     = 8192
+	DefaultRSWBits = 2048
+
+	rswHeaderLength = 8 // squarings
+	// rswProbe is the number of the squarings Calibrate times.
+	rswProbe = 1 << 12
+)
+
+// ErrInvalidParams is returned for the malformed puzzle parameters.
+var ErrInvalidParams = errors.New("invalid puzzle parameters")
+
+// RSW is the Rivest-Shamir-Wagner time-lock puzzle: the solution of the seed is x^(2^T) mod N,
+// where x is derived from the seed. The squarings are sequential, so the puzzle can't be split
+// between the cores or the machines like the SHA-256 search. The server keeps the factors of N
+// and verifies a solution with one exponentiation: x^(2^T mod φ(N)) mod N.
+//
+// The parameters are T (8 bytes, big-endian) and N.
+type RSW struct {
+	n         *big.Int
+	phi       *big.Int
+	modulus   []byte
+	squarings atomic.Int64
+}
+
+// NewRSW generates an RSW modulus of the bits and returns the puzzle of the squarings, see Calibrate.
+func NewRSW(bits, squarings int) (*RSW, error) {
+	if bits < MinRSWBits || bits > MaxRSWBits {
+		return nil, errors.Errorf("the modulus has %d bits, from %d to %d are supported", bits, MinRSWBits, MaxRSWBits)
+	}
+
+	var p, q *big.Int
+	for p == nil || p.Cmp(q) == 0 {
+		var err error
+		if p, err = rand.Prime(rand.Reader, bits/2); err != nil {
+			return nil, errors.Wrap(err, "generate prime")
+		}
+		if q, err = rand.Prime(rand.Reader, bits-bits/2); err != nil {
+			return nil, errors.Wrap(err, "generate prime")
+		}
+	}
+
+	one := big.NewInt(1)
+	n := new(big.Int).Mul(p, q)
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+
+	r := &RSW{n: n, phi: phi, modulus: n.Bytes()}
+	r.SetSquarings(squarings)
+	return r, nil
+}
+
+func (r *RSW) Algorithm() string {
+	return AlgorithmRSW
+}
+
+// Squarings returns the number of the squarings of the new challenges.
+func (r *RSW) Squarings() int {
+	return int(r.squarings.Load())
+}
+
+// SetSquarings changes the number of the squarings of the new challenges, at least 1.
+// It's safe for concurrent use.
+func (r *RSW) SetSquarings(squarings int) {
+	r.squarings.Store(int64(max(squarings, 1)))
+}
+
+// Calibrate sets the squarings a solver of this machine does in about the target time and returns them.
+// A slower client needs more time, an optimized solver less.
+func (r *RSW) Calibrate(target time.Duration) int {
+	x := rswBase(make([]byte, sha256.Size), r.n)
+
+	started := time.Now()
+	square(context.Background(), x, r.n, rswProbe)
+	elapsed := max(time.Since(started), time.Nanosecond)
+
+	r.SetSquarings(int(float64(rswProbe) * float64(target) / float64(elapsed)))
+	return r.Squarings()
+}
+
+func (r *RSW) Issue(_ []byte) []byte {
+	params := make([]byte, rswHeaderLength, rswHeaderLength+len(r.modulus))
+	binary.BigEndian.PutUint64(params, uint64(r.squarings.Load())) //nolint:gosec // the squarings are positive
+	return append(params, r.modulus...)
+}
+
+func (r *RSW) Verify(seed, params, solution []byte) bool {
+	squarings, n, err := ParseRSWParams(params)
+	if err != nil || n.Cmp(r.n) != 0 || len(solution) != len(r.modulus) {
+		return false
+	}
+
+	y := new(big.Int).SetBytes(solution)
+	if y.Cmp(r.n) >= 0 {
+		return false
+	}
+
+	// the order of x divides φ(N), so 2^T can be taken modulo φ(N)
+	exponent := new(big.Int).Exp(big.NewInt(2), new(big.Int).SetUint64(squarings), r.phi)
+	expected := new(big.Int).Exp(rswBase(seed, r.n), exponent, r.n)
+	return expected.Cmp(y) == 0
+}
+
+// ParseRSWParams decodes the squarings and the modulus of an RSW challenge.
+func ParseRSWParams(params []byte) (uint64, *big.Int, error) {
+	if len(params) <= rswHeaderLength || len(params)-rswHeaderLength > MaxRSWBits/8 {
+		return 0, nil, ErrInvalidParams
+	}
+
+	squarings := binary.BigEndian.Uint64(params)
+	n := new(big.Int).SetBytes(params[rswHeaderLength:])
+	if squarings == 0 || n.Bit(0) == 0 || n.BitLen() < MinRSWBits {
+		return 0, nil, ErrInvalidParams
+	}
+	return squarings, n, nil
+}
+
+// RSWSolver does the squarings of the RSW challenges.
+type RSWSolver struct {
+	// MaxSquarings refuses the longer challenges with ErrTooHard, zero means no limit.
+	MaxSquarings uint64
+}
+
+func (s RSWSolver) Solve(ctx context.Context, seed, params []byte) ([]byte, error) {
+	squarings, n, err := ParseRSWParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxSquarings > 0 && squarings > s.MaxSquarings {
+		return nil, errors.Wrapf(ErrTooHard, "%d squarings are above %d", squarings, s.MaxSquarings)
+	}
+
+	y := square(ctx, rswBase(seed, n), n, squarings)
+	if y == nil {
+		return nil, errors.Wrap(ctx.Err(), "square")
+	}
+	return y.FillBytes(make([]byte, len(params)-rswHeaderLength)), nil
+}
+
+// rswBase derives x from the seed: SHA-256 in the counter mode stretched over N, modulo N.
+func rswBase(seed []byte, n *big.Int) *big.Int {
+	size := (n.BitLen()+7)/8 + sha256.Size // the extra bytes make the bias negligible
+	stream := make([]byte, 0, size+sha256.Size)
+
+	for counter := uint32(0); len(stream) < size; counter++ {
+		h := sha256.New()
+		h.Write(binary.BigEndian.AppendUint32(nil, counter))
+		h.Write(seed)
+		stream = h.Sum(stream)
+	}
+
+	x := new(big.Int).SetBytes(stream[:size])
+	x.Mod(x, n)
+	if x.Cmp(big.NewInt(2)) < 0 {
+		x.SetInt64(2)
+	}
+	return x
+}
+
+// square returns x^(2^squarings) mod n, nil if the context is done first.
+func square(ctx context.Context, x, n *big.Int, squarings uint64) *big.Int {
+	y := new(big.Int).Set(x)
+	for i := range squarings {
+		if i%ProgressInterval == 0 && ctx.Err() != nil {
+			return nil
+		}
+		y.Mul(y, y)
+		y.Mod(y, n)
+	}
+	return y
+}
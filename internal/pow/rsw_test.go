@@ -0,0 +1,77 @@
+package pow_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRSW(t *testing.T) {
+	t.Parallel()
+
+	rsw, err := pow.NewRSW(pow.MinRSWBits, 1000)
+	require.NoError(t, err)
+	require.Equal(t, pow.AlgorithmRSW, rsw.Algorithm())
+
+	seed := []byte("seed")
+	params := rsw.Issue(seed)
+	squarings, _, err := pow.ParseRSWParams(params)
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, squarings)
+
+	solution, err := pow.RSWSolver{}.Solve(context.Background(), seed, params)
+	require.NoError(t, err)
+	require.True(t, rsw.Verify(seed, params, solution))
+
+	// the solution is bound to the seed and the squarings
+	require.False(t, rsw.Verify([]byte("another seed"), params, solution))
+	rsw.SetSquarings(999)
+	require.False(t, rsw.Verify(seed, rsw.Issue(seed), solution))
+
+	another, err := pow.RSWSolver{}.Solve(context.Background(), seed, rsw.Issue(seed))
+	require.NoError(t, err)
+	require.False(t, rsw.Verify(seed, params, another))
+	require.False(t, rsw.Verify(seed, params, solution[1:]))
+
+	// a modulus of another server isn't accepted
+	foreign, err := pow.NewRSW(pow.MinRSWBits, 1000)
+	require.NoError(t, err)
+	require.False(t, foreign.Verify(seed, params, solution))
+}
+
+func TestRSWSolver(t *testing.T) {
+	t.Parallel()
+
+	rsw, err := pow.NewRSW(pow.MinRSWBits, 1000)
+	require.NoError(t, err)
+	params := rsw.Issue([]byte("seed"))
+
+	_, err = pow.RSWSolver{MaxSquarings: 999}.Solve(context.Background(), []byte("seed"), params)
+	require.ErrorIs(t, err, pow.ErrTooHard)
+
+	_, err = pow.RSWSolver{}.Solve(context.Background(), []byte("seed"), params[:8])
+	require.ErrorIs(t, err, pow.ErrInvalidParams)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pow.RSWSolver{}.Solve(ctx, []byte("seed"), params)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = pow.NewRSW(512, 1)
+	require.Error(t, err)
+}
+
+func TestRSW_Calibrate(t *testing.T) {
+	t.Parallel()
+
+	rsw, err := pow.NewRSW(pow.MinRSWBits, 1)
+	require.NoError(t, err)
+
+	short := rsw.Calibrate(time.Millisecond)
+	long := rsw.Calibrate(100 * time.Millisecond)
+	require.Equal(t, long, rsw.Squarings())
+	require.Greater(t, long, short)
+}
@@ -0,0 +1,122 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+const ticketHeaderLength = 8 + 1 + 1 + 1 // expiration + byte index + byte value + difficulty
+
+var (
+	ErrTicketMalformed = errors.New("malformed ticket")
+	ErrTicketForged    = errors.New("ticket signature mismatch")
+	ErrTicketExpired   = errors.New("ticket expired")
+)
+
+// Ticket is a self-contained challenge for the stateless transports (HTTP, gRPC).
+// The server seals it with a secret key, so it doesn't have to remember the issued challenges.
+type Ticket struct {
+	Hash      []byte
+	ByteIndex int
+	ByteValue byte
+	// Difficulty is the one of the handler when the ticket is issued, the solution is checked against it.
+	Difficulty int
+	ExpiresAt  time.Time
+}
+
+// Sealer signs and verifies tickets with HMAC-SHA256.
+type Sealer struct {
+	key []byte
+}
+
+func NewSealer(key []byte) *Sealer {
+	return &Sealer{key: key}
+}
+
+// Seal returns the printable form of the ticket: base64url(payload).base64url(mac).
+func (s *Sealer) Seal(t Ticket) string {
+	payload := encodeTicket(t)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// Open verifies the signature and the expiration of the sealed ticket.
+func (s *Sealer) Open(sealed string, now time.Time) (Ticket, error) {
+	rawPayload, rawMAC, found := strings.Cut(sealed, ".")
+	if !found {
+		return Ticket{}, ErrTicketMalformed
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(rawPayload)
+	if err != nil {
+		return Ticket{}, ErrTicketMalformed
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(rawMAC)
+	if err != nil {
+		return Ticket{}, ErrTicketMalformed
+	}
+
+	if !hmac.Equal(mac, s.mac(payload)) {
+		return Ticket{}, ErrTicketForged
+	}
+
+	t, err := decodeTicket(payload)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	if !now.Before(t.ExpiresAt) {
+		return Ticket{}, ErrTicketExpired
+	}
+	return t, nil
+}
+
+func (s *Sealer) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// ParseTicket decodes the sealed ticket without verifying it. Clients use it to read the conditions.
+func ParseTicket(sealed string) (Ticket, error) {
+	rawPayload, _, found := strings.Cut(sealed, ".")
+	if !found {
+		return Ticket{}, ErrTicketMalformed
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(rawPayload)
+	if err != nil {
+		return Ticket{}, ErrTicketMalformed
+	}
+	return decodeTicket(payload)
+}
+
+func encodeTicket(t Ticket) []byte {
+	payload := make([]byte, ticketHeaderLength, ticketHeaderLength+len(t.Hash))
+	binary.BigEndian.PutUint64(payload, uint64(t.ExpiresAt.Unix())) //nolint:gosec // unix time is positive
+	payload[8] = byte(t.ByteIndex)
+	payload[9] = t.ByteValue
+	payload[10] = byte(t.Difficulty)
+	return append(payload, t.Hash...)
+}
+
+func decodeTicket(payload []byte) (Ticket, error) {
+	if len(payload) <= ticketHeaderLength {
+		return Ticket{}, ErrTicketMalformed
+	}
+
+	return Ticket{
+		ExpiresAt:  time.Unix(int64(binary.BigEndian.Uint64(payload)), 0), //nolint:gosec // unix time is positive
+		ByteIndex:  int(payload[8]),
+		ByteValue:  payload[9],
+		Difficulty: int(payload[10]),
+		Hash:       payload[ticketHeaderLength:],
+	}, nil
+}
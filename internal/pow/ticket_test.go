@@ -0,0 +1,85 @@
+package pow_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealer(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	ticket := pow.Ticket{Hash: []byte("0000abcd"), ByteIndex: 4, ByteValue: 'a', Difficulty: 3, ExpiresAt: now.Add(time.Minute)}
+	sealer := pow.NewSealer([]byte("secret key"))
+	sealed := sealer.Seal(ticket)
+
+	tests := []struct {
+		name        string
+		sealer      *pow.Sealer
+		sealed      string
+		now         time.Time
+		expectedErr error
+	}{
+		{
+			name:   "valid ticket",
+			sealer: sealer,
+			sealed: sealed,
+			now:    now,
+		},
+		{
+			name:        "expired ticket",
+			sealer:      sealer,
+			sealed:      sealed,
+			now:         now.Add(time.Minute),
+			expectedErr: pow.ErrTicketExpired,
+		},
+		{
+			name:        "another key",
+			sealer:      pow.NewSealer([]byte("another key")),
+			sealed:      sealed,
+			now:         now,
+			expectedErr: pow.ErrTicketForged,
+		},
+		{
+			name:        "tampered payload",
+			sealer:      sealer,
+			sealed:      tamper(sealed),
+			now:         now,
+			expectedErr: pow.ErrTicketForged,
+		},
+		{
+			name:        "malformed ticket",
+			sealer:      sealer,
+			sealed:      strings.ReplaceAll(sealed, ".", ""),
+			now:         now,
+			expectedErr: pow.ErrTicketMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opened, err := tt.sealer.Open(tt.sealed, tt.now)
+			require.ErrorIs(t, err, tt.expectedErr)
+			if tt.expectedErr == nil {
+				require.Equal(t, ticket, opened)
+			}
+		})
+	}
+
+	parsed, err := pow.ParseTicket(sealed)
+	require.NoError(t, err)
+	require.Equal(t, ticket, parsed)
+}
+
+func tamper(sealed string) string {
+	if sealed[0] == 'A' {
+		return "B" + sealed[1:]
+	}
+	return "A" + sealed[1:]
+}
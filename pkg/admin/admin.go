@@ -0,0 +1,353 @@
+// Package admin serves the control API of a running server over HTTP, see server.Control.
+//
+// The API is meant for a separate, private listener: every request needs the bearer token
+// in the Authorization header. The bodies are JSON, an error is {"error": "..."}.
+//
+//	GET    /v1/stats                    the server counters
+//	GET    /v1/difficulty               the difficulty and its bounds
+//	PUT    /v1/difficulty               change them, the omitted fields are kept
+//	GET    /v1/connections              the active connections
+//	DELETE /v1/connections/{id}         close a connection
+//	GET    /v1/bans                     the banned prefixes
+//	POST   /v1/bans                     ban an address or a prefix and close its connections
+//	DELETE /v1/bans/{prefix}            lift a ban, e.g. /v1/bans/192.0.2.0/24
+//	GET    /v1/ban-policy               the policy of the automatic bans
+//	PUT    /v1/ban-policy               replace it, the zero fields get the defaults
+//	GET    /v1/access/{list}            the allowlist or the denylist, {list} is "allow" or "deny"
+//	POST   /v1/access/{list}            add an address or a prefix to the list
+//	DELETE /v1/access/{list}/{prefix}   remove a runtime entry, e.g. /v1/access/allow/10.0.0.0/8
+//	POST   /v1/content/reload           reload the quote store
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/common"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxBodySize = 64 * 1024
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrBadRequest   = errors.New("bad request")
+)
+
+type Dependencies struct {
+	// Control is required. It's checked by SetDefaults and not by a tag: the validator would read
+	// the fields of a running server.
+	Control server.Control `validate:"-"`
+	// Token authenticates the requests, it's sent as "Authorization: Bearer <token>".
+	Token string `validate:"required,min=16"`
+}
+
+func (d *Dependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+
+	if d.Control == nil {
+		panic("admin: Control is required")
+	}
+}
+
+// DifficultyUpdate is the body of PUT /v1/difficulty, the nil fields are kept.
+type DifficultyUpdate struct {
+	Difficulty *int `json:"difficulty,omitempty"`
+	Min        *int `json:"min,omitempty"`
+	Max        *int `json:"max,omitempty"`
+}
+
+// BanRequest is the body of POST /v1/bans. Prefix is an address or a CIDR prefix, Duration is
+// a Go duration, e.g. "10m", empty bans forever.
+type BanRequest struct {
+	Prefix   string `json:"prefix"`
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// AccessRequest is the body of POST /v1/access/{list}, Prefix is an address or a CIDR prefix.
+type AccessRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	control server.Control
+	token   []byte
+}
+
+// NewHandler returns the handler of the admin API.
+func NewHandler(deps *Dependencies) http.Handler {
+	deps.SetDefaults()
+
+	h := &handler{control: deps.Control, token: []byte(deps.Token)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/stats", h.stats)
+	mux.HandleFunc("GET /v1/difficulty", h.difficulty)
+	mux.HandleFunc("PUT /v1/difficulty", h.updateDifficulty)
+	mux.HandleFunc("GET /v1/connections", h.connections)
+	mux.HandleFunc("DELETE /v1/connections/{id}", h.killConnection)
+	mux.HandleFunc("GET /v1/bans", h.bans)
+	mux.HandleFunc("POST /v1/bans", h.ban)
+	mux.HandleFunc("DELETE /v1/bans/{prefix...}", h.unban)
+	mux.HandleFunc("GET /v1/ban-policy", h.banPolicy)
+	mux.HandleFunc("PUT /v1/ban-policy", h.updateBanPolicy)
+	mux.HandleFunc("GET /v1/access/{list}", h.accessEntries)
+	mux.HandleFunc("POST /v1/access/{list}", h.addAccess)
+	mux.HandleFunc("DELETE /v1/access/{list}/{prefix...}", h.removeAccess)
+	mux.HandleFunc("POST /v1/content/reload", h.reloadContent)
+
+	return h.authenticate(mux)
+}
+
+// authenticate compares the bearer token in constant time.
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), h.token) != 1 {
+			log.WithField("remote", r.RemoteAddr).Warn("an unauthorized admin request")
+			writeError(w, ErrUnauthorized)
+			return
+		}
+
+		log.WithFields(log.Fields{"method": r.Method, "path": r.URL.Path}).Debug("an admin request")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) stats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.control.Stats())
+}
+
+func (h *handler) difficulty(w http.ResponseWriter, _ *http.Request) {
+	settings, err := h.control.DifficultySettings()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func (h *handler) updateDifficulty(w http.ResponseWriter, r *http.Request) {
+	var update DifficultyUpdate
+	if err := readJSON(r, &update); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	settings, err := h.control.DifficultySettings()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if update.Difficulty != nil {
+		settings.Difficulty = *update.Difficulty
+	}
+	if update.Min != nil {
+		settings.Min = *update.Min
+	}
+	if update.Max != nil {
+		settings.Max = *update.Max
+	}
+
+	if settings, err = h.control.UpdateDifficulty(settings); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func (h *handler) connections(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.control.Connections())
+}
+
+func (h *handler) killConnection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, errors.Wrap(ErrBadRequest, "invalid connection id"))
+		return
+	}
+
+	if err = h.control.KillConnection(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) bans(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.control.Bans())
+}
+
+func (h *handler) ban(w http.ResponseWriter, r *http.Request) {
+	var req BanRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	prefix, err := parsePrefix(req.Prefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+			writeError(w, errors.Wrapf(ErrBadRequest, "invalid duration %q", req.Duration))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, h.control.Ban(prefix, duration, req.Reason))
+}
+
+func (h *handler) unban(w http.ResponseWriter, r *http.Request) {
+	prefix, err := parsePrefix(r.PathValue("prefix"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err = h.control.Unban(prefix); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) banPolicy(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.control.BanPolicy())
+}
+
+func (h *handler) updateBanPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy server.BanPolicy
+	if err := readJSON(r, &policy); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.control.SetBanPolicy(policy); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.control.BanPolicy())
+}
+
+func (h *handler) accessEntries(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.control.AccessEntries(server.AccessKind(r.PathValue("list")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (h *handler) addAccess(w http.ResponseWriter, r *http.Request) {
+	var req AccessRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	prefix, err := parsePrefix(req.Prefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	entry, err := h.control.AddAccess(server.AccessKind(r.PathValue("list")), prefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+func (h *handler) removeAccess(w http.ResponseWriter, r *http.Request) {
+	prefix, err := parsePrefix(r.PathValue("prefix"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err = h.control.RemoveAccess(server.AccessKind(r.PathValue("list")), prefix); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) reloadContent(w http.ResponseWriter, _ *http.Request) {
+	if err := h.control.ReloadContent(); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parsePrefix parses the prefix of a request, see common.ParsePrefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	prefix, err := common.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, errors.Wrap(ErrBadRequest, err.Error())
+	}
+	return prefix, nil
+}
+
+func readJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return errors.Wrap(ErrBadRequest, err.Error())
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Warn("write an admin response")
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), errorResponse{Error: err.Error()})
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrBadRequest), errors.Is(err, pow.ErrInvalidBounds), errors.Is(err, server.ErrInvalidBanPolicy):
+		return http.StatusBadRequest
+	case errors.Is(err, server.ErrConnectionNotFound), errors.Is(err, server.ErrBanNotFound),
+		errors.Is(err, server.ErrUnknownAccessList), errors.Is(err, server.ErrAccessNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, server.ErrNotSupported):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,199 @@
+package admin_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/admin"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "0123456789abcdef"
+
+func newTestAPI(t *testing.T, reload func() error) (*admin.Client, *server.Server, string) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serv, err := server.New(&server.Dependencies{
+		Listener:       listener,
+		MessageHandler: func() []byte { return []byte("quote") },
+		PowHandler:     pow.NewPow(2),
+		ReloadContent:  reload,
+	})
+	require.NoError(t, err)
+	go serv.Listen(ctx)
+
+	api := httptest.NewServer(admin.NewHandler(&admin.Dependencies{Control: serv, Token: testToken}))
+	t.Cleanup(api.Close)
+
+	return admin.NewClient(&admin.ClientDependencies{Address: api.URL, Token: testToken}), serv, api.URL
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestAdmin_Unauthorized(t *testing.T) {
+	t.Parallel()
+
+	_, _, api := newTestAPI(t, nil)
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{name: "no token"},
+		{name: "wrong token", header: "Bearer fedcba9876543210"},
+		{name: "not a bearer", header: testToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, api+"/v1/stats", nil)
+			require.NoError(t, err)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		})
+	}
+
+	wrong := admin.NewClient(&admin.ClientDependencies{Address: api, Token: "wrong"})
+	_, err := wrong.Stats(context.Background())
+	require.ErrorIs(t, err, admin.ErrUnauthorized)
+}
+
+func TestAdmin_Difficulty(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client, _, _ := newTestAPI(t, nil)
+
+	settings, err := client.Difficulty(ctx)
+	require.NoError(t, err)
+	require.Equal(t, server.DifficultySettings{Difficulty: 2, Min: 0, Max: pow.MaxDifficulty}, settings)
+
+	settings, err = client.UpdateDifficulty(ctx, admin.DifficultyUpdate{Difficulty: intPtr(5)})
+	require.NoError(t, err)
+	require.Equal(t, server.DifficultySettings{Difficulty: 5, Min: 0, Max: pow.MaxDifficulty}, settings)
+
+	// the difficulty is clamped into the new bounds
+	settings, err = client.UpdateDifficulty(ctx, admin.DifficultyUpdate{Min: intPtr(1), Max: intPtr(3)})
+	require.NoError(t, err)
+	require.Equal(t, server.DifficultySettings{Difficulty: 3, Min: 1, Max: 3}, settings)
+
+	_, err = client.UpdateDifficulty(ctx, admin.DifficultyUpdate{Min: intPtr(4)})
+	require.ErrorIs(t, err, admin.ErrBadRequest)
+}
+
+func TestAdmin_ConnectionsAndBans(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client, serv, _ := newTestAPI(t, nil)
+
+	conn, err := net.Dial("tcp", serv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var conns []server.ConnectionInfo
+	require.Eventually(t, func() bool {
+		conns, err = client.Connections(ctx)
+		return err == nil && len(conns) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, conn.LocalAddr().String(), conns[0].RemoteAddr)
+
+	require.ErrorIs(t, client.KillConnection(ctx, conns[0].ID+1), admin.ErrNotFound)
+	require.NoError(t, client.KillConnection(ctx, conns[0].ID))
+
+	stats, err := client.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Connections)
+
+	prefix := netip.MustParsePrefix("2001:db8::/48")
+	ban, err := client.Ban(ctx, prefix, time.Hour, "abuse")
+	require.NoError(t, err)
+	require.Equal(t, prefix, ban.Prefix)
+	require.Equal(t, "abuse", ban.Reason)
+	require.WithinDuration(t, time.Now().Add(time.Hour), ban.Until, time.Minute)
+
+	bans, err := client.Bans(ctx)
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+
+	policy, err := client.SetBanPolicy(ctx, server.BanPolicy{MaxFailures: 3, IPv4Prefix: 24})
+	require.NoError(t, err)
+	require.Equal(t, 3, policy.MaxFailures)
+	require.Equal(t, 24, policy.IPv4Prefix)
+	require.Equal(t, server.DefaultBanWindow, policy.Window)
+
+	_, err = client.SetBanPolicy(ctx, server.BanPolicy{IPv4Prefix: 33})
+	require.ErrorIs(t, err, admin.ErrBadRequest)
+
+	require.NoError(t, client.Unban(ctx, prefix))
+	require.ErrorIs(t, client.Unban(ctx, prefix), admin.ErrNotFound)
+}
+
+func TestAdmin_AccessList(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client, serv, _ := newTestAPI(t, nil)
+
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	require.NoError(t, serv.SetStaticAccess(server.Denylist, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}))
+
+	entry, err := client.AddAccess(ctx, server.Denylist, netip.MustParsePrefix("10.1.2.3/8"))
+	require.NoError(t, err)
+	require.Equal(t, server.AccessEntry{Prefix: prefix, Runtime: true}, entry)
+
+	entries, err := client.AccessEntries(ctx, server.Denylist)
+	require.NoError(t, err)
+	require.Equal(t, []server.AccessEntry{entry, {Prefix: netip.MustParsePrefix("192.0.2.0/24")}}, entries)
+
+	// the static entries are managed by their file
+	require.ErrorIs(t, client.RemoveAccess(ctx, server.Denylist, netip.MustParsePrefix("192.0.2.0/24")), admin.ErrNotFound)
+	require.NoError(t, client.RemoveAccess(ctx, server.Denylist, prefix))
+
+	_, err = client.AccessEntries(ctx, "unknown")
+	require.ErrorIs(t, err, admin.ErrNotFound)
+}
+
+func TestAdmin_ReloadContent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	reloads := 0
+	client, _, _ := newTestAPI(t, func() error {
+		reloads++
+		if reloads > 1 {
+			return errors.New("quotes file is empty")
+		}
+		return nil
+	})
+
+	require.NoError(t, client.ReloadContent(ctx))
+	require.Error(t, client.ReloadContent(ctx))
+
+	unsupported, _, _ := newTestAPI(t, nil)
+	require.ErrorIs(t, unsupported.ReloadContent(ctx), server.ErrNotSupported)
+}
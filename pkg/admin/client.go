@@ -0,0 +1,208 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+const DefaultClientTimeout = 10 * time.Second
+
+// ErrNotFound is returned by Client for an unknown connection, ban or access entry.
+var ErrNotFound = errors.New("not found")
+
+// APIError is an error response of the admin API, it unwraps to ErrUnauthorized, ErrBadRequest,
+// ErrNotFound or server.ErrNotSupported by the status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("admin api: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusBadRequest:
+		return ErrBadRequest
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusNotImplemented:
+		return server.ErrNotSupported
+	default:
+		return nil
+	}
+}
+
+type ClientDependencies struct {
+	// Address is the base URL of the admin API, e.g. "http://127.0.0.1:9095".
+	Address string `validate:"required,url"`
+	Token   string `validate:"required"`
+
+	HTTPClient *http.Client
+}
+
+func (d *ClientDependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+
+	if d.HTTPClient == nil {
+		d.HTTPClient = &http.Client{Timeout: DefaultClientTimeout}
+	}
+}
+
+// Client calls the admin API.
+type Client struct {
+	address    string
+	token      string
+	httpClient *http.Client
+}
+
+func NewClient(deps *ClientDependencies) *Client {
+	deps.SetDefaults()
+
+	return &Client{
+		address:    strings.TrimSuffix(deps.Address, "/"),
+		token:      deps.Token,
+		httpClient: deps.HTTPClient,
+	}
+}
+
+func (c *Client) Stats(ctx context.Context) (server.Stats, error) {
+	var stats server.Stats
+	err := c.do(ctx, http.MethodGet, "/v1/stats", nil, &stats)
+	return stats, err
+}
+
+func (c *Client) Difficulty(ctx context.Context) (server.DifficultySettings, error) {
+	var settings server.DifficultySettings
+	err := c.do(ctx, http.MethodGet, "/v1/difficulty", nil, &settings)
+	return settings, err
+}
+
+func (c *Client) UpdateDifficulty(ctx context.Context, update DifficultyUpdate) (server.DifficultySettings, error) {
+	var settings server.DifficultySettings
+	err := c.do(ctx, http.MethodPut, "/v1/difficulty", update, &settings)
+	return settings, err
+}
+
+func (c *Client) Connections(ctx context.Context) ([]server.ConnectionInfo, error) {
+	var conns []server.ConnectionInfo
+	err := c.do(ctx, http.MethodGet, "/v1/connections", nil, &conns)
+	return conns, err
+}
+
+func (c *Client) KillConnection(ctx context.Context, id uint64) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/v1/connections/%d", id), nil, nil)
+}
+
+func (c *Client) Bans(ctx context.Context) ([]server.Ban, error) {
+	var bans []server.Ban
+	err := c.do(ctx, http.MethodGet, "/v1/bans", nil, &bans)
+	return bans, err
+}
+
+// Ban bans the prefix, zero duration bans forever.
+func (c *Client) Ban(ctx context.Context, prefix netip.Prefix, duration time.Duration, reason string) (server.Ban, error) {
+	req := BanRequest{Prefix: prefix.String(), Reason: reason}
+	if duration > 0 {
+		req.Duration = duration.String()
+	}
+
+	var ban server.Ban
+	err := c.do(ctx, http.MethodPost, "/v1/bans", req, &ban)
+	return ban, err
+}
+
+func (c *Client) Unban(ctx context.Context, prefix netip.Prefix) error {
+	return c.do(ctx, http.MethodDelete, "/v1/bans/"+prefix.String(), nil, nil)
+}
+
+func (c *Client) BanPolicy(ctx context.Context) (server.BanPolicy, error) {
+	var policy server.BanPolicy
+	err := c.do(ctx, http.MethodGet, "/v1/ban-policy", nil, &policy)
+	return policy, err
+}
+
+func (c *Client) SetBanPolicy(ctx context.Context, policy server.BanPolicy) (server.BanPolicy, error) {
+	err := c.do(ctx, http.MethodPut, "/v1/ban-policy", policy, &policy)
+	return policy, err
+}
+
+func (c *Client) AccessEntries(ctx context.Context, kind server.AccessKind) ([]server.AccessEntry, error) {
+	var entries []server.AccessEntry
+	err := c.do(ctx, http.MethodGet, "/v1/access/"+string(kind), nil, &entries)
+	return entries, err
+}
+
+func (c *Client) AddAccess(ctx context.Context, kind server.AccessKind, prefix netip.Prefix) (server.AccessEntry, error) {
+	var entry server.AccessEntry
+	err := c.do(ctx, http.MethodPost, "/v1/access/"+string(kind), AccessRequest{Prefix: prefix.String()}, &entry)
+	return entry, err
+}
+
+func (c *Client) RemoveAccess(ctx context.Context, kind server.AccessKind, prefix netip.Prefix) error {
+	return c.do(ctx, http.MethodDelete, "/v1/access/"+string(kind)+"/"+prefix.String(), nil, nil)
+}
+
+func (c *Client) ReloadContent(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/content/reload", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "marshal request")
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.address+path, body)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errResp errorResponse
+		if dErr := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&errResp); dErr != nil {
+			errResp.Error = http.StatusText(resp.StatusCode)
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "decode response")
+	}
+	return nil
+}
@@ -7,7 +7,10 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/kriuchkov/power/internal/pow"
 	"github.com/kriuchkov/power/pkg/common"
+	"github.com/kriuchkov/power/pkg/privacypass"
+	"github.com/kriuchkov/power/pkg/token"
 
 	"github.com/go-faster/errors"
 	"github.com/go-playground/validator/v10"
@@ -16,20 +19,56 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-const DefaultClientTimeout = 2 * time.Second
+const (
+	DefaultClientTimeout = 2 * time.Second
+	DefaultDialTimeout   = 5 * time.Second
+	// MaxMessageSize limits the server messages, a bigger size prefix breaks the connection.
+	MaxMessageSize = 1 << 20
+)
 
 var (
 	ErrWrongCommand = errors.New("wrong command")
 	ErrInvalidHash  = errors.New("found invalid hash")
+	ErrNotTunnel    = errors.New("server isn't in the proxy mode")
 )
 
 type SolverHash interface {
 	FindNonce(ctx context.Context, hash []byte, byteIndex int, byteValue byte) int
 }
 
+// Dialer opens the server connections, *net.Dialer and *tls.Dialer implement it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
 type Dependencies struct {
-	ServerConn net.Conn   `validate:"required"`
-	Hasher     SolverHash `validate:"required"`
+	// ServerConn is an established connection. The client can't reconnect it, use Address for the retries.
+	ServerConn net.Conn `validate:"required_without=Address"`
+	// Address is the server address, the client dials it lazily and redials it on the retries.
+	Address string `validate:"required_without=ServerConn"`
+	// Dialer dials Address, net.Dialer with DefaultDialTimeout by default.
+	Dialer Dialer
+	// Hasher solves the challenges of the servers which don't send the puzzle parameters,
+	// its difficulty has to match the server's one.
+	Hasher SolverHash
+	// NewSolver returns the solver for the difficulty sent by the server, pow.NewPow by default.
+	NewSolver func(difficulty int) SolverHash
+	// Puzzles are the solvers of the puzzles other than the SHA-256 search by their algorithm, e.g.
+	// pow.RSWSolver for pow.AlgorithmRSW. Policy.Algorithms has to allow them as well.
+	Puzzles map[string]pow.PuzzleSolver
+
+	// Resource is sent with the challenge requests, the server rules may set the difficulty by it.
+	Resource string
+	// PrivateTokens is the batch of the anonymous tokens a solution buys, GetMessage redeems them
+	// instead of solving a challenge per request. The server has to issue them. Disabled by default.
+	// It needs Address: the tokens are redeemed on the redialed connections, a ServerConn would link
+	// them to their solution.
+	PrivateTokens int `validate:"gte=0,lte=100,excluded_with=ServerConn"`
+
+	Retry RetryPolicy
+	Hooks Hooks
+	// Policy limits the challenges the client agrees to solve.
+	Policy SolvePolicy
 }
 
 func (d *Dependencies) SetDefaults() {
@@ -37,130 +76,502 @@ func (d *Dependencies) SetDefaults() {
 	if err := validate.Struct(d); err != nil {
 		panic(err)
 	}
+
+	if d.Dialer == nil {
+		d.Dialer = &net.Dialer{Timeout: DefaultDialTimeout}
+	}
+	if d.NewSolver == nil {
+		d.NewSolver = func(difficulty int) SolverHash { return pow.NewPow(difficulty) }
+	}
+	d.Retry.setDefaults()
+	d.Policy.setDefaults()
 }
 
+// Client is a session on one server connection. It redeems a fresh challenge per GetMessage call
+// or spends the credits left on the solved one, if the server grants a quota.
+// The client isn't safe for concurrent use.
 type Client struct {
-	conn   net.Conn
-	solver SolverHash
+	conn      net.Conn
+	solver    SolverHash
+	newSolver func(difficulty int) SolverHash
+	puzzles   map[string]pow.PuzzleSolver
+	credits   uint32
+	// token is the access token of the last solved challenge, it survives the reconnects
+	token string
+	// privateTokens are the anonymous tokens, privacyPass is the client of their issuer key
+	privateTokens [][]byte
+	privacyPass   *privacypass.Client
+	privateBatch  int
+
+	prepared         bool
+	preparedSolution []byte
+
+	address  string
+	resource string
+	dialer   Dialer
+	retry    RetryPolicy
+	hooks    Hooks
+	policy   SolvePolicy
 }
 
 func New(deps *Dependencies) *Client {
 	deps.SetDefaults()
-	return &Client{conn: deps.ServerConn, solver: deps.Hasher}
+
+	return &Client{
+		conn:      deps.ServerConn,
+		solver:    deps.Hasher,
+		newSolver: deps.NewSolver,
+		puzzles:   deps.Puzzles,
+		address:   deps.Address,
+		resource:  deps.Resource,
+		dialer:    deps.Dialer,
+		retry:     deps.Retry,
+		hooks:     deps.Hooks,
+		policy:    deps.Policy,
+
+		privateBatch: deps.PrivateTokens,
+	}
 }
 
-//nolint:funlen,nonamedreturns // it's a client method
-func (c *Client) GetMessage(ctx context.Context) (response []byte, err error) {
-	err = c.conn.SetWriteDeadline(time.Now().Add(DefaultClientTimeout))
-	if err != nil {
-		return response, errors.Wrap(err, "set write deadline")
+// GetMessage returns the content. It spends a credit of the solved challenge if there is one,
+// or an anonymous token if they are enabled, otherwise it solves a new challenge.
+func (c *Client) GetMessage(ctx context.Context) ([]byte, error) {
+	var response []byte
+
+	err := c.withRetry(ctx, func() error {
+		var err error
+		response, err = c.getMessage(ctx)
+		return err
+	})
+	return response, err
+}
+
+func (c *Client) getMessage(ctx context.Context) ([]byte, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
 	}
 
-	message := &powerV1.Message{Command: powerV1.CommandType_Connect}
-	bytesMessage, err := proto.Marshal(message)
-	if err != nil {
-		return response, errors.Wrap(err, "marshal message")
+	if c.credits > 0 {
+		contentMessage, err := c.redeemCredit()
+		if !errors.Is(err, ErrInvalidHash) {
+			return contentMessage.GetBody(), err
+		}
+		log.Debug("the credits are revoked by the server")
 	}
 
-	msgSize := int32(len(bytesMessage))
-	err = binary.Write(c.conn, binary.BigEndian, msgSize)
-	if err != nil {
-		return response, errors.Wrap(err, "send a connect message size")
+	if c.privateBatch > 0 {
+		contentMessage, err := c.redeemPrivateTokens(ctx)
+		if !errors.Is(err, ErrInvalidHash) {
+			return contentMessage.GetBody(), err
+		}
+		log.Debug("the anonymous token is refused by the server")
+	}
+
+	if c.hasToken() {
+		contentMessage, err := c.redeemToken()
+		if !errors.Is(err, ErrInvalidHash) {
+			return contentMessage.GetBody(), err
+		}
+		log.Debug("the token is refused by the server")
+	}
+
+	if c.prepared {
+		c.prepared = false
+
+		contentMessage, err := c.redeemSolution(c.preparedSolution)
+		if !errors.Is(err, ErrInvalidHash) {
+			return contentMessage.GetBody(), err
+		}
+		log.Debug("the prepared solution is rejected by the server")
 	}
 
-	_, err = c.conn.Write(bytesMessage)
+	contentMessage, err := c.solveChallenge(ctx)
 	if err != nil {
-		return response, errors.Wrap(err, "send a connect message")
+		return nil, err
 	}
+	return contentMessage.GetBody(), nil
+}
 
-	log.WithFields(log.Fields{"size": msgSize, "command": message.GetCommand()}).
-		Debug("send a connect message")
+// Prepare solves the challenge of the connection in advance, the next GetMessage call only redeems it.
+// It does nothing if the client already has a prepared solution or credits.
+func (c *Client) Prepare(ctx context.Context) error {
+	return c.withRetry(ctx, func() error {
+		if err := c.connect(ctx); err != nil {
+			return err
+		}
+
+		if c.prepared || c.credits > 0 {
+			return nil
+		}
+
+		solution, err := c.findSolution(ctx)
+		if err != nil {
+			return err
+		}
+
+		c.prepared, c.preparedSolution = true, solution
+		return nil
+	})
+}
 
-	err = c.conn.SetReadDeadline(time.Now().Add(DefaultClientTimeout))
+// Ping checks the connection with a Connect message. The server repeats the challenge which
+// isn't solved yet, so a prepared solution stays valid.
+func (c *Client) Ping(ctx context.Context) error {
+	err := c.ping(ctx)
 	if err != nil {
-		return response, errors.Wrap(err, "set read deadline")
+		c.resetConn()
+	}
+	return err
+}
+
+func (c *Client) ping(ctx context.Context) error {
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	if err := c.writeMessage(&powerV1.Message{Command: powerV1.CommandType_Connect}); err != nil {
+		return errors.Wrap(err, "send a connect message")
 	}
 
-	err = binary.Read(c.conn, binary.BigEndian, &msgSize)
+	verifyMessage, err := c.readMessage()
 	if err != nil {
-		return response, errors.Wrap(err, "read a verify message size")
+		return errors.Wrap(err, "read a verify message")
 	}
 
-	verifyRawMessage := make([]byte, msgSize)
-	_, err = c.conn.Read(verifyRawMessage)
+	if verifyMessage.GetCommand() != powerV1.CommandType_Connect {
+		return ErrWrongCommand
+	}
+	return nil
+}
+
+// Challenge requests the current challenge of the connection without solving it. Together with
+// Redeem it's the low-level API for the tools which solve or forge the solutions themselves.
+// The challenge isn't checked against the solve policy.
+func (c *Client) Challenge(ctx context.Context) (*Challenge, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	challenge, _, err := c.requestChallenge()
+	if err != nil && !errors.Is(err, ErrChallengeRefused) {
+		c.resetConn()
+	}
+	return challenge, err
+}
+
+// Redeem sends the nonce of the current challenge and returns the content, ErrInvalidHash if
+// the server rejects it.
+func (c *Client) Redeem(ctx context.Context, nonce int) ([]byte, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	contentMessage, err := c.redeemNonce(nonce)
+	if err != nil && !errors.Is(err, ErrInvalidHash) {
+		c.resetConn()
+	}
+	return contentMessage.GetBody(), err
+}
+
+// Token returns the access token of the last solved challenge, if the server issues them. Other
+// services may accept it as the proof of the work, see package token.
+func (c *Client) Token() string {
+	return c.token
+}
+
+// Credits returns the number of the content requests left on the solved challenge.
+func (c *Client) Credits() int {
+	return int(c.credits)
+}
+
+// Close ends the session with the Close command and closes the connection.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	if err := c.writeMessage(&powerV1.Message{Command: powerV1.CommandType_Close}); err != nil {
+		log.WithError(err).Debug("send a close message")
+	}
+	return c.conn.Close() //nolint:wrapcheck // it's a proxy method
+}
+
+func (c *Client) redeemCredit() (*powerV1.Message, error) {
+	c.credits = 0
+
+	if err := c.writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content}); err != nil {
+		return nil, errors.Wrap(err, "send a credit message")
+	}
+
+	contentMessage, err := c.readContent()
 	if err != nil {
-		return response, errors.Wrap(err, "read a verify message")
+		return nil, err
+	}
+
+	c.credits = contentMessage.GetCredits()
+	return contentMessage, nil
+}
+
+// hasToken reports whether the client has a token which isn't expired, an expired one is dropped.
+func (c *Client) hasToken() bool {
+	if c.token == "" {
+		return false
+	}
+
+	claims, err := token.Parse(c.token)
+	if err != nil || claims.Expired(time.Now()) {
+		c.token = ""
+		return false
+	}
+	return true
+}
+
+// redeemToken sends the token instead of a nonce, a refused token is dropped.
+func (c *Client) redeemToken() (*powerV1.Message, error) {
+	if err := c.writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Token: c.token}); err != nil {
+		return nil, errors.Wrap(err, "send a token message")
 	}
 
-	var verifyMessage powerV1.Message
-	err = proto.Unmarshal(verifyRawMessage, &verifyMessage)
+	contentMessage, err := c.readContent()
+	if errors.Is(err, ErrInvalidHash) {
+		c.token = ""
+	}
+	return contentMessage, err
+}
+
+// Tunnel solves the server challenge of a server in the reverse-proxy mode and returns the connection
+// which is spliced to the upstream service. The caller owns the connection from now on.
+func (c *Client) Tunnel(ctx context.Context) (net.Conn, error) {
+	var tunnel net.Conn
+
+	err := c.withRetry(ctx, func() error {
+		if err := c.connect(ctx); err != nil {
+			return err
+		}
+
+		contentMessage, err := c.solveChallenge(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(contentMessage.GetBody()) > 0 {
+			return ErrNotTunnel
+		}
+
+		if err = c.conn.SetDeadline(time.Time{}); err != nil {
+			return errors.Wrap(err, "reset deadline")
+		}
+
+		tunnel = c.conn
+		return nil
+	})
+	return tunnel, err
+}
+
+// connect dials the server if there is no connection yet.
+func (c *Client) connect(ctx context.Context) error {
+	if c.conn != nil {
+		return nil
+	}
+
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.address)
+	if c.hooks.OnDial != nil {
+		c.hooks.OnDial(c.address, err)
+	}
 	if err != nil {
-		return response, errors.Wrap(err, "unmarshal response message")
+		return errors.Wrap(err, "dial the server")
 	}
 
-	if verifyMessage.GetCommand() != powerV1.CommandType_Connect {
-		return response, ErrWrongCommand
+	log.WithField("address", c.address).Debug("connected")
+	c.conn = conn
+	return nil
+}
+
+// resetConn drops a dialed connection, the next call redials it.
+func (c *Client) resetConn() {
+	if c.address == "" || c.conn == nil {
+		return
 	}
 
-	serverHash, byteIndex, byteValue := common.SplitMessage(verifyMessage.GetBody())
-	log.WithFields(log.Fields{"s": msgSize, "c": verifyMessage.GetCommand(), "i": byteIndex, "bv": byteValue}).
-		Debug("read a verify message")
+	c.conn.Close()
+	c.conn = nil
+	c.credits = 0
+	c.prepared = false
+}
 
-	foundNonce := c.solver.FindNonce(ctx, serverHash, byteIndex, byteValue)
+func (c *Client) solveChallenge(ctx context.Context) (*powerV1.Message, error) {
+	solution, err := c.findSolution(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.redeemSolution(solution)
+}
 
-	log.WithFields(log.Fields{"nonce": foundNonce}).Debug("found nonce")
+// findSolution requests the challenge of the connection and solves it. The solution is the nonce
+// in the decimal form, or the one of the puzzle.
+func (c *Client) findSolution(ctx context.Context) ([]byte, error) {
+	challenge, solver, err := c.requestChallenge()
+	if err != nil {
+		return nil, err
+	}
 
-	message = &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(foundNonce))}
+	if challenge.Params != nil {
+		return c.policy.solvePuzzle(ctx, c.puzzles[challenge.Algorithm], challenge)
+	}
 
-	bytesMessage, err = proto.Marshal(message)
+	foundNonce, err := c.policy.Solve(ctx, solver, challenge)
 	if err != nil {
-		return response, errors.Wrap(err, "marshal message")
+		return nil, err
 	}
 
-	msgSize = int32(len(bytesMessage))
-	err = binary.Write(c.conn, binary.BigEndian, msgSize)
+	log.WithFields(log.Fields{"nonce": foundNonce}).Debug("found nonce")
+	return []byte(strconv.Itoa(foundNonce)), nil
+}
+
+// requestChallenge sends a Connect message and returns the challenge with its solver.
+func (c *Client) requestChallenge() (*Challenge, SolverHash, error) {
+	err := c.writeMessage(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte(c.resource)})
 	if err != nil {
-		return response, errors.Wrap(err, "send a hash message size")
+		return nil, nil, errors.Wrap(err, "send a connect message")
 	}
 
-	_, err = c.conn.Write(bytesMessage)
+	verifyMessage, err := c.readMessage()
 	if err != nil {
-		return response, errors.Wrap(err, "send a hash message")
+		return nil, nil, errors.Wrap(err, "read a verify message")
 	}
 
-	log.WithFields(log.Fields{"size": msgSize, "command": message.GetCommand()}).
-		Debug("send a hash message")
+	if verifyMessage.GetCommand() != powerV1.CommandType_Connect {
+		return nil, nil, ErrWrongCommand
+	}
 
-	err = c.conn.SetReadDeadline(time.Now().Add(DefaultClientTimeout))
+	challenge, solver, err := c.parseChallenge(verifyMessage)
 	if err != nil {
-		return response, errors.Wrap(err, "set read deadline")
+		return nil, nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"c": verifyMessage.GetCommand(), "i": challenge.ByteIndex, "bv": challenge.ByteValue, "d": challenge.Difficulty,
+	}).Debug("read a verify message")
+	return challenge, solver, nil
+}
+
+// parseChallenge reads the puzzle parameters of the verify message and picks the solver for them.
+// Without the parameters the challenge is solved by the Hasher with its own difficulty.
+func (c *Client) parseChallenge(verifyMessage *powerV1.Message) (*Challenge, SolverHash, error) {
+	if params := verifyMessage.GetChallenge(); params != nil {
+		if params.GetParams() != nil {
+			// the puzzle is solved by the solver of its algorithm
+			return &Challenge{Algorithm: params.GetAlgorithm(), Hash: params.GetHash(), Params: params.GetParams()}, nil, nil
+		}
+
+		challenge := &Challenge{
+			Algorithm:  params.GetAlgorithm(),
+			Difficulty: int(params.GetDifficulty()),
+			Hash:       params.GetHash(),
+			ByteIndex:  int(params.GetByteIndex()),
+			ByteValue:  byte(params.GetByteValue()),
+		}
+		if challenge.Algorithm == "" {
+			challenge.Algorithm = pow.AlgorithmSHA256
+		}
+		return challenge, c.newSolver(challenge.Difficulty), nil
 	}
 
-	err = binary.Read(c.conn, binary.BigEndian, &msgSize)
+	challenge := &Challenge{Algorithm: pow.AlgorithmSHA256}
+
+	var err error
+	challenge.Hash, challenge.ByteIndex, challenge.ByteValue, err = common.SplitMessage(verifyMessage.GetBody())
 	if err != nil {
-		return response, errors.Wrap(err, "read a message size")
+		return nil, nil, err //nolint:wrapcheck // it's wrapped by SplitMessage
 	}
 
-	contentBytes := make([]byte, msgSize)
+	if c.solver == nil {
+		return nil, nil, &ChallengeError{Challenge: *challenge, Reason: "the server doesn't send the puzzle parameters"}
+	}
+	if solver, ok := c.solver.(pow.DifficultyReporter); ok {
+		challenge.Difficulty = solver.Difficulty()
+	}
+	return challenge, c.solver, nil
+}
+
+func (c *Client) redeemNonce(nonce int) (*powerV1.Message, error) {
+	return c.redeemSolution([]byte(strconv.Itoa(nonce)))
+}
+
+func (c *Client) redeemSolution(solution []byte) (*powerV1.Message, error) {
+	message := &powerV1.Message{Command: powerV1.CommandType_Content, Body: solution}
+	if err := c.writeMessage(message); err != nil {
+		return nil, errors.Wrap(err, "send a hash message")
+	}
 
-	_, err = c.conn.Read(contentBytes)
+	contentMessage, err := c.readContent()
 	if err != nil {
-		return response, errors.Wrap(err, "read a message")
+		return nil, err
 	}
 
-	var contentMessage powerV1.Message
-	err = proto.Unmarshal(contentBytes, &contentMessage)
+	c.credits = contentMessage.GetCredits()
+	return contentMessage, nil
+}
+
+func (c *Client) readContent() (*powerV1.Message, error) {
+	contentMessage, err := c.readMessage()
 	if err != nil {
-		return response, errors.Wrap(err, "unmarshal response message")
+		return nil, err
 	}
 
 	//nolint:exhaustive //ok
 	switch contentMessage.GetCommand() {
 	case powerV1.CommandType_ErrInvalidHash:
-		return response, ErrInvalidHash
+		return nil, ErrInvalidHash
 	case powerV1.CommandType_Content:
-		return contentMessage.GetBody(), nil
+		if issued := contentMessage.GetToken(); issued != "" {
+			c.token = issued
+		}
+		return contentMessage, nil
 	default:
-		return response, ErrWrongCommand
+		return nil, ErrWrongCommand
+	}
+}
+
+func (c *Client) writeMessage(message *powerV1.Message) error {
+	err := c.conn.SetWriteDeadline(time.Now().Add(DefaultClientTimeout))
+	if err != nil {
+		return errors.Wrap(err, "set write deadline")
+	}
+
+	bytesMessage, err := proto.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "marshal message")
+	}
+
+	msgSize := int32(len(bytesMessage))
+	if err = binary.Write(c.conn, binary.BigEndian, msgSize); err != nil {
+		return errors.Wrap(err, "write a message size")
+	}
+
+	if _, err = c.conn.Write(bytesMessage); err != nil {
+		return errors.Wrap(err, "write a message")
+	}
+
+	log.WithFields(log.Fields{"size": msgSize, "command": message.GetCommand()}).
+		Debug("send a message")
+	return nil
+}
+
+func (c *Client) readMessage() (*powerV1.Message, error) {
+	err := c.conn.SetReadDeadline(time.Now().Add(DefaultClientTimeout))
+	if err != nil {
+		return nil, errors.Wrap(err, "set read deadline")
+	}
+
+	rawMessage, err := common.ReadFrame(c.conn, MaxMessageSize)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // it's wrapped by ReadFrame
+	}
+
+	var message powerV1.Message
+	if err = proto.Unmarshal(rawMessage, &message); err != nil {
+		return nil, errors.Wrap(err, "unmarshal response message")
 	}
+	return &message, nil
 }
@@ -10,7 +10,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kriuchkov/power/internal/pow"
 	clientmocks "github.com/kriuchkov/power/pkg/client/mocks"
+	"github.com/kriuchkov/power/pkg/token"
 	powerV1 "github.com/kriuchkov/protobuf/v1"
 
 	"github.com/stretchr/testify/mock"
@@ -32,7 +34,7 @@ func TestClient_GetMessage(t *testing.T) {
 			name: "success",
 			serverResponse: func(_ *testing.T) []byte {
 				var buf bytes.Buffer
-				verifyMessage := &powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test")}
+				verifyMessage := &powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")}
 				verifyBytes, _ := proto.Marshal(verifyMessage)
 				binary.Write(&buf, binary.BigEndian, int32(len(verifyBytes)))
 				buf.Write(verifyBytes)
@@ -56,7 +58,7 @@ func TestClient_GetMessage(t *testing.T) {
 			name: "error on connect message",
 			serverResponse: func(_ *testing.T) []byte {
 				var buf bytes.Buffer
-				verifyMessage := &powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test")}
+				verifyMessage := &powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")}
 				verifyBytes, _ := proto.Marshal(verifyMessage)
 				binary.Write(&buf, binary.BigEndian, int32(len(verifyBytes)))
 				buf.Write(verifyBytes)
@@ -92,6 +94,120 @@ func TestClient_GetMessage(t *testing.T) {
 	}
 }
 
+func TestClient_Session(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	writeMessage := func(msg *powerV1.Message) {
+		msgBytes, _ := proto.Marshal(msg)
+		binary.Write(&buf, binary.BigEndian, int32(len(msgBytes)))
+		buf.Write(msgBytes)
+	}
+
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("first"), Credits: 1})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("second")})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("third")})
+
+	mockSolver := clientmocks.NewMockSolverHash(t)
+	mockSolver.EXPECT().FindNonce(mock.Anything, []byte("test"), 1, byte('a')).Return(123).Times(2)
+
+	mockConn := newMockConn(buf.Bytes())
+	cl := New(&Dependencies{ServerConn: &net.TCPConn{}, Hasher: mockSolver})
+	cl.conn = mockConn
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for _, expected := range []struct {
+		message string
+		credits int
+	}{
+		{message: "first", credits: 1},
+		{message: "second", credits: 0},
+		{message: "third", credits: 0},
+	} {
+		response, err := cl.GetMessage(ctx)
+		require.NoError(t, err)
+		require.Equal(t, expected.message, string(response))
+		require.Equal(t, expected.credits, cl.Credits())
+	}
+
+	require.NoError(t, cl.Close())
+
+	var commands []powerV1.CommandType
+	for mockConn.writeBuffer.Len() > 0 {
+		var msgSize int32
+		require.NoError(t, binary.Read(mockConn.writeBuffer, binary.BigEndian, &msgSize))
+
+		var msg powerV1.Message
+		require.NoError(t, proto.Unmarshal(mockConn.writeBuffer.Next(int(msgSize)), &msg))
+		commands = append(commands, msg.GetCommand())
+	}
+
+	require.Equal(t, []powerV1.CommandType{
+		powerV1.CommandType_Connect, powerV1.CommandType_Content,
+		powerV1.CommandType_Content,
+		powerV1.CommandType_Connect, powerV1.CommandType_Content,
+		powerV1.CommandType_Close,
+	}, commands)
+}
+
+func TestClient_Token(t *testing.T) {
+	t.Parallel()
+
+	issued, err := token.Sign(token.HMACKey("secret"), &token.Claims{ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	writeMessage := func(msg *powerV1.Message) {
+		msgBytes, _ := proto.Marshal(msg)
+		binary.Write(&buf, binary.BigEndian, int32(len(msgBytes)))
+		buf.Write(msgBytes)
+	}
+
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("first"), Token: issued})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("second")})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")})
+	writeMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("third")})
+
+	mockSolver := clientmocks.NewMockSolverHash(t)
+	mockSolver.EXPECT().FindNonce(mock.Anything, []byte("test"), 1, byte('a')).Return(123).Times(2)
+
+	mockConn := newMockConn(buf.Bytes())
+	cl := New(&Dependencies{ServerConn: &net.TCPConn{}, Hasher: mockSolver})
+	cl.conn = mockConn
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for _, expected := range []string{"first", "second", "third"} {
+		response, err := cl.GetMessage(ctx)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(response))
+		if expected == "first" {
+			require.Equal(t, issued, cl.Token())
+		}
+	}
+
+	// the refused token is dropped
+	require.Empty(t, cl.Token())
+
+	var tokens []string
+	for mockConn.writeBuffer.Len() > 0 {
+		var msgSize int32
+		require.NoError(t, binary.Read(mockConn.writeBuffer, binary.BigEndian, &msgSize))
+
+		var msg powerV1.Message
+		require.NoError(t, proto.Unmarshal(mockConn.writeBuffer.Next(int(msgSize)), &msg))
+		tokens = append(tokens, msg.GetToken())
+	}
+	require.Equal(t, []string{"", "", issued, issued, "", ""}, tokens)
+}
+
 type mockConn struct {
 	net.Conn
 	readBuffer  *bytes.Buffer
@@ -124,3 +240,106 @@ func (m *mockConn) SetWriteDeadline(_ time.Time) error {
 func (m *mockConn) SetReadDeadline(_ time.Time) error {
 	return nil
 }
+
+func TestClient_ChallengeParams(t *testing.T) {
+	t.Parallel()
+
+	mockSolver := clientmocks.NewMockSolverHash(t)
+	mockSolver.EXPECT().FindNonce(mock.Anything, []byte("test"), 4, byte('a')).Return(123)
+
+	conn, _ := scriptedConn(
+		&powerV1.Message{
+			Command:   powerV1.CommandType_Connect,
+			Body:      []byte("test|4|97"),
+			Challenge: &powerV1.Challenge{Hash: []byte("test"), ByteIndex: 4, ByteValue: 'a', Difficulty: 2},
+		},
+		&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("response")},
+	)()
+
+	var difficulty int
+	cl := New(&Dependencies{
+		ServerConn: conn,
+		NewSolver: func(d int) SolverHash {
+			difficulty = d
+			return mockSolver
+		},
+	})
+
+	response, err := cl.GetMessage(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []byte("response"), response)
+	require.Equal(t, 2, difficulty)
+
+	t.Run("without the parameters and the hasher", func(t *testing.T) {
+		t.Parallel()
+
+		conn, _ := scriptedConn(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")})()
+
+		_, err := New(&Dependencies{ServerConn: conn}).GetMessage(context.Background())
+		require.ErrorIs(t, err, ErrChallengeRefused)
+	})
+}
+
+func TestClient_ChallengeRedeem(t *testing.T) {
+	t.Parallel()
+
+	conn, _ := scriptedConn(
+		&powerV1.Message{
+			Command:   powerV1.CommandType_Connect,
+			Challenge: &powerV1.Challenge{Hash: []byte("test"), ByteIndex: 4, ByteValue: 'a', Difficulty: 2},
+		},
+		&powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash},
+		&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("response")},
+	)()
+
+	cl := New(&Dependencies{ServerConn: conn})
+
+	challenge, err := cl.Challenge(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &Challenge{Algorithm: pow.AlgorithmSHA256, Difficulty: 2, Hash: []byte("test"), ByteIndex: 4, ByteValue: 'a'}, challenge)
+
+	_, err = cl.Redeem(context.Background(), 1)
+	require.ErrorIs(t, err, ErrInvalidHash)
+
+	response, err := cl.Redeem(context.Background(), 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("response"), response)
+}
+
+// FuzzClient_GetMessage plays a hostile server: the client has to fail with an error, not a panic
+// or a spin, whatever the frames, the verify message or the puzzle parameters are.
+func FuzzClient_GetMessage(f *testing.F) {
+	frame := func(messages ...*powerV1.Message) []byte {
+		var buf bytes.Buffer
+		for _, msg := range messages {
+			raw, _ := proto.Marshal(msg)
+			binary.Write(&buf, binary.BigEndian, int32(len(raw)))
+			buf.Write(raw)
+		}
+		return buf.Bytes()
+	}
+
+	content := &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("response")}
+	f.Add(frame(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")}, content))
+	f.Add(frame(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|-1|97")}, content))
+	f.Add(frame(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|32|97")}, content))
+	f.Add(frame(&powerV1.Message{
+		Command:   powerV1.CommandType_Connect,
+		Challenge: &powerV1.Challenge{Hash: []byte("test"), ByteIndex: -1, ByteValue: 300, Difficulty: 40},
+	}, content))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x7f, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cl := New(&Dependencies{
+			ServerConn: newMockConn(data),
+			Hasher:     pow.NewPow(0),
+			Policy:     SolvePolicy{MaxDifficulty: 1, MaxExpectedAttempts: 1 << 16, SolveBudget: 100 * time.Millisecond},
+		})
+
+		response, err := cl.GetMessage(context.Background())
+		if err != nil {
+			require.Nil(t, response)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+
+	"github.com/go-faster/errors"
+)
+
+var (
+	// ErrChallengeRefused is matched by every ChallengeError.
+	ErrChallengeRefused = errors.New("challenge is refused by the solve policy")
+	// ErrSolveBudget is returned when the solver runs out of SolvePolicy.SolveBudget or gives up without
+	// a nonce.
+	ErrSolveBudget = errors.New("solve budget is exceeded")
+)
+
+// SolvePolicy limits the challenges the client agrees to solve, so a malicious or misconfigured
+// server can't make it spin. The zero limits are unlimited, the challenges which can't be solved
+// at all (e.g. the byte index is out of the hash) are always refused.
+type SolvePolicy struct {
+	// MaxDifficulty is the maximum number of the leading '0' bytes.
+	MaxDifficulty int `validate:"gte=0"`
+	// Algorithms are the allowed puzzles, pow.AlgorithmSHA256 only if empty.
+	Algorithms []string
+	// MaxExpectedAttempts is the maximum mean number of the hashes a challenge needs.
+	MaxExpectedAttempts float64 `validate:"gte=0"`
+	// SolveBudget is the maximum time the solver spends on one challenge. It's the elapsed wall-clock
+	// time, not the CPU time: a loaded or throttled machine checks fewer hashes within it.
+	SolveBudget time.Duration `validate:"gte=0"`
+}
+
+func (p *SolvePolicy) setDefaults() {
+	if len(p.Algorithms) == 0 {
+		p.Algorithms = []string{pow.AlgorithmSHA256}
+	}
+}
+
+// Challenge is the puzzle the server asks to solve.
+type Challenge struct {
+	Algorithm  string
+	Difficulty int
+	Hash       []byte
+	ByteIndex  int
+	ByteValue  byte
+	// Params are the parameters of a puzzle other than the SHA-256 search, see pow.Puzzle;
+	// the Hash is its seed then.
+	Params []byte
+}
+
+// ExpectedAttempts returns the mean number of the hashes the challenge needs.
+func (c *Challenge) ExpectedAttempts() float64 {
+	return pow.ExpectedAttempts(c.Difficulty, c.ByteIndex, c.ByteValue)
+}
+
+// ChallengeError describes why the challenge is refused, it matches ErrChallengeRefused.
+type ChallengeError struct {
+	Challenge Challenge
+	Reason    string
+}
+
+func (e *ChallengeError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrChallengeRefused, e.Reason)
+}
+
+func (e *ChallengeError) Is(target error) bool {
+	return target == ErrChallengeRefused //nolint:errorlint // it's the sentinel itself
+}
+
+// Check returns a ChallengeError if the challenge is out of the policy.
+func (p *SolvePolicy) Check(challenge *Challenge) error {
+	refuse := func(format string, args ...any) error {
+		return &ChallengeError{Challenge: *challenge, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	algorithms := p.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{pow.AlgorithmSHA256}
+	}
+	if !slices.Contains(algorithms, challenge.Algorithm) {
+		return refuse("the algorithm %q isn't allowed", challenge.Algorithm)
+	}
+	if challenge.Params != nil {
+		// the solver of the puzzle has its own limits
+		return nil
+	}
+
+	expectedAttempts := challenge.ExpectedAttempts()
+
+	switch {
+	case p.MaxDifficulty > 0 && challenge.Difficulty > p.MaxDifficulty:
+		return refuse("the difficulty %d is above %d", challenge.Difficulty, p.MaxDifficulty)
+	case math.IsInf(expectedAttempts, 1):
+		return refuse("the conditions (index %d, value %d) can't be met", challenge.ByteIndex, challenge.ByteValue)
+	case p.MaxExpectedAttempts > 0 && expectedAttempts > p.MaxExpectedAttempts:
+		return refuse("%.0f expected attempts are above %.0f", expectedAttempts, p.MaxExpectedAttempts)
+	}
+	return nil
+}
+
+// Solve checks the challenge and finds its nonce within the solve budget. The HTTP and gRPC clients
+// use it as well.
+func (p *SolvePolicy) Solve(ctx context.Context, solver SolverHash, challenge *Challenge) (int, error) {
+	if err := p.Check(challenge); err != nil {
+		return 0, err
+	}
+
+	solveCtx := ctx
+	if p.SolveBudget > 0 {
+		var cancel context.CancelFunc
+		solveCtx, cancel = context.WithTimeout(ctx, p.SolveBudget)
+		defer cancel()
+	}
+
+	nonce := solver.FindNonce(solveCtx, challenge.Hash, challenge.ByteIndex, challenge.ByteValue)
+	switch {
+	case nonce >= 0:
+		return nonce, nil
+	case ctx.Err() != nil:
+		return 0, errors.Wrap(ctx.Err(), "find nonce")
+	case solveCtx.Err() != nil:
+		return 0, errors.Wrapf(ErrSolveBudget, "find nonce in %s", p.SolveBudget)
+	default:
+		// the solver gave up, e.g. its own attempts are exhausted
+		return 0, errors.Wrap(ErrSolveBudget, "no nonce found")
+	}
+}
+
+// solvePuzzle checks the challenge and solves it with the solver of its puzzle within the solve budget.
+func (p *SolvePolicy) solvePuzzle(ctx context.Context, solver pow.PuzzleSolver, challenge *Challenge) ([]byte, error) {
+	if err := p.Check(challenge); err != nil {
+		return nil, err
+	}
+	if solver == nil {
+		return nil, &ChallengeError{Challenge: *challenge, Reason: fmt.Sprintf("no solver of %q", challenge.Algorithm)}
+	}
+
+	solveCtx := ctx
+	if p.SolveBudget > 0 {
+		var cancel context.CancelFunc
+		solveCtx, cancel = context.WithTimeout(ctx, p.SolveBudget)
+		defer cancel()
+	}
+
+	solution, err := solver.Solve(solveCtx, challenge.Hash, challenge.Params)
+	switch {
+	case err == nil:
+		return solution, nil
+	case errors.Is(err, pow.ErrTooHard):
+		return nil, &ChallengeError{Challenge: *challenge, Reason: err.Error()}
+	case ctx.Err() != nil:
+		return nil, errors.Wrap(ctx.Err(), "solve puzzle")
+	case solveCtx.Err() != nil:
+		return nil, errors.Wrapf(ErrSolveBudget, "solve puzzle in %s", p.SolveBudget)
+	default:
+		return nil, errors.Wrap(err, "solve puzzle")
+	}
+}
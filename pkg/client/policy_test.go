@@ -0,0 +1,198 @@
+//nolint:testpackage // ignore errcheck linter for this file
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	clientmocks "github.com/kriuchkov/power/pkg/client/mocks"
+	"github.com/kriuchkov/power/pkg/server"
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSolvePolicy_Check(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		policy    SolvePolicy
+		challenge Challenge
+		refused   bool
+	}{
+		{
+			name:      "no limits",
+			challenge: Challenge{Algorithm: pow.AlgorithmSHA256, Difficulty: 4, ByteIndex: 17, ByteValue: '1'},
+		},
+		{
+			name:      "unknown algorithm",
+			challenge: Challenge{Algorithm: "scrypt", Difficulty: 1, ByteIndex: 17, ByteValue: '1'},
+			refused:   true,
+		},
+		{
+			name:      "difficulty",
+			policy:    SolvePolicy{MaxDifficulty: 3},
+			challenge: Challenge{Algorithm: pow.AlgorithmSHA256, Difficulty: 4, ByteIndex: 17, ByteValue: '1'},
+			refused:   true,
+		},
+		{
+			name:      "byte index out of the hash",
+			challenge: Challenge{Algorithm: pow.AlgorithmSHA256, Difficulty: 1, ByteIndex: 32, ByteValue: '1'},
+			refused:   true,
+		},
+		{
+			name:      "expected attempts",
+			policy:    SolvePolicy{MaxExpectedAttempts: 1 << 16},
+			challenge: Challenge{Algorithm: pow.AlgorithmSHA256, Difficulty: 2, ByteIndex: 17, ByteValue: '1'},
+			refused:   true,
+		},
+		{
+			name:      "enough expected attempts",
+			policy:    SolvePolicy{MaxExpectedAttempts: 1 << 16},
+			challenge: Challenge{Algorithm: pow.AlgorithmSHA256, Difficulty: 1, ByteIndex: 17, ByteValue: '1'},
+		},
+		{
+			name:      "puzzle",
+			policy:    SolvePolicy{MaxExpectedAttempts: 1, Algorithms: []string{pow.AlgorithmRSW}},
+			challenge: Challenge{Algorithm: pow.AlgorithmRSW, Hash: []byte("seed"), Params: []byte("params")},
+		},
+		{
+			name:      "puzzle isn't allowed",
+			challenge: Challenge{Algorithm: pow.AlgorithmRSW, Hash: []byte("seed"), Params: []byte("params")},
+			refused:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.policy.setDefaults()
+			err := tt.policy.Check(&tt.challenge)
+			if !tt.refused {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrChallengeRefused)
+
+			var challengeErr *ChallengeError
+			require.ErrorAs(t, err, &challengeErr)
+			require.Equal(t, tt.challenge, challengeErr.Challenge)
+		})
+	}
+}
+
+func TestClient_Policy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("refused challenge", func(t *testing.T) {
+		t.Parallel()
+
+		var dials int
+		cl := New(&Dependencies{
+			Address: "server:9090",
+			Dialer: &fakeDialer{conns: []func() (net.Conn, error){
+				scriptedConn(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|40|97")}),
+			}},
+			Hasher: clientmocks.NewMockSolverHash(t), // FindNonce mustn't be called
+			Retry:  RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			Hooks:  Hooks{OnDial: func(string, error) { dials++ }},
+		})
+
+		_, err := cl.GetMessage(context.Background())
+		require.ErrorIs(t, err, ErrChallengeRefused)
+		require.Equal(t, 1, dials)
+	})
+
+	t.Run("solve budget", func(t *testing.T) {
+		t.Parallel()
+
+		mockSolver := clientmocks.NewMockSolverHash(t)
+		mockSolver.EXPECT().FindNonce(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			RunAndReturn(func(ctx context.Context, _ []byte, _ int, _ byte) int {
+				<-ctx.Done()
+				return -1
+			})
+
+		conn, _ := scriptedConn(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")})()
+
+		cl := New(&Dependencies{
+			ServerConn: conn,
+			Hasher:     mockSolver,
+			Policy:     SolvePolicy{SolveBudget: 10 * time.Millisecond},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		_, err := cl.GetMessage(ctx)
+		require.ErrorIs(t, err, ErrSolveBudget)
+	})
+
+	t.Run("no nonce", func(t *testing.T) {
+		t.Parallel()
+
+		mockSolver := clientmocks.NewMockSolverHash(t)
+		mockSolver.EXPECT().FindNonce(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(-1)
+
+		conn, _ := scriptedConn(&powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")})()
+
+		// the solver gives up before the budget, -1 mustn't be sent as a solution
+		cl := New(&Dependencies{ServerConn: conn, Hasher: mockSolver})
+
+		_, err := cl.GetMessage(context.Background())
+		require.ErrorIs(t, err, ErrSolveBudget)
+	})
+}
+
+func TestClient_Puzzle(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rsw, err := pow.NewRSW(pow.MinRSWBits, 100)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serv, err := server.New(&server.Dependencies{
+		Listener:       listener,
+		MessageHandler: func() []byte { return []byte("msg received") },
+		PowHandler:     pow.NewPow(0),
+		Puzzle:         rsw,
+	})
+	require.NoError(t, err)
+	go serv.Listen(ctx)
+
+	cl := New(&Dependencies{
+		Address: listener.Addr().String(),
+		Puzzles: map[string]pow.PuzzleSolver{pow.AlgorithmRSW: pow.RSWSolver{}},
+		Policy:  SolvePolicy{Algorithms: []string{pow.AlgorithmRSW}},
+	})
+	defer cl.Close()
+
+	require.NoError(t, cl.Prepare(ctx))
+	for range 2 {
+		response, err := cl.GetMessage(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "msg received", string(response))
+	}
+
+	// the solver limits refuse the challenge
+	limited := New(&Dependencies{
+		Address: listener.Addr().String(),
+		Puzzles: map[string]pow.PuzzleSolver{pow.AlgorithmRSW: pow.RSWSolver{MaxSquarings: 99}},
+		Policy:  SolvePolicy{Algorithms: []string{pow.AlgorithmRSW}},
+	})
+	defer limited.Close()
+
+	_, err = limited.GetMessage(ctx)
+	require.ErrorIs(t, err, ErrChallengeRefused)
+}
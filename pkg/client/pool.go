@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultPoolSize            = 4
+	DefaultHealthCheckInterval = 30 * time.Second
+)
+
+var ErrPoolClosed = errors.New("pool is closed")
+
+type PoolDependencies struct {
+	// Addresses are the servers, the sessions are spread over them round-robin.
+	Addresses []string `validate:"required,min=1,dive,required"`
+	Hasher    SolverHash
+	NewSolver func(difficulty int) SolverHash
+	Puzzles   map[string]pow.PuzzleSolver
+	Dialer    Dialer
+	Retry     RetryPolicy
+	Hooks     Hooks
+	Policy    SolvePolicy
+	// Resource is sent with the challenge requests of every session.
+	Resource string
+	// PrivateTokens is the batch of the anonymous tokens of every session, see Dependencies.PrivateTokens.
+	PrivateTokens int `validate:"gte=0,lte=100"`
+
+	// Size is the number of the sessions, DefaultPoolSize if zero.
+	Size int `validate:"gte=0"`
+	// PreSolve solves the next challenge of a session in the background as soon as it's released.
+	PreSolve bool
+	// HealthCheckInterval is how often the idle sessions are checked.
+	HealthCheckInterval time.Duration `validate:"gte=0"`
+}
+
+func (d *PoolDependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+
+	if d.Size == 0 {
+		d.Size = DefaultPoolSize
+	}
+	if d.HealthCheckInterval == 0 {
+		d.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+}
+
+// Pool keeps authenticated sessions to the servers and hands them out across goroutines.
+// A session belongs to one goroutine between Acquire and Release.
+type Pool struct {
+	idle     chan *pooledClient
+	preSolve bool
+	interval time.Duration
+
+	ctx    context.Context //nolint:containedctx // it's the lifetime of the background work
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	clients map[*Client]*pooledClient // read-only after NewPool
+}
+
+type pooledClient struct {
+	*Client
+	lastUsed time.Time
+}
+
+func NewPool(deps *PoolDependencies) *Pool {
+	deps.SetDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		idle:     make(chan *pooledClient, deps.Size),
+		preSolve: deps.PreSolve,
+		interval: deps.HealthCheckInterval,
+		ctx:      ctx,
+		cancel:   cancel,
+		clients:  make(map[*Client]*pooledClient, deps.Size),
+	}
+
+	for i := range deps.Size {
+		pc := &pooledClient{Client: New(&Dependencies{
+			Address:   deps.Addresses[i%len(deps.Addresses)],
+			Dialer:    deps.Dialer,
+			Hasher:    deps.Hasher,
+			NewSolver: deps.NewSolver,
+			Puzzles:   deps.Puzzles,
+			Retry:     deps.Retry,
+			Hooks:     deps.Hooks,
+			Policy:    deps.Policy,
+			Resource:  deps.Resource,
+
+			PrivateTokens: deps.PrivateTokens,
+		})}
+
+		p.clients[pc.Client] = pc
+		p.release(pc)
+	}
+
+	p.wg.Add(1)
+	go p.healthCheck()
+	return p
+}
+
+// Acquire returns an idle session. The caller must return it with Release.
+func (p *Pool) Acquire(ctx context.Context) (*Client, error) {
+	select {
+	case <-p.ctx.Done():
+		return nil, ErrPoolClosed
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "acquire a session")
+	case pc := <-p.idle:
+		return pc.Client, nil
+	}
+}
+
+// Release returns the session to the pool.
+func (p *Pool) Release(c *Client) {
+	pc, ok := p.clients[c]
+	if !ok {
+		return // not from this pool
+	}
+
+	pc.lastUsed = time.Now()
+	p.release(pc)
+}
+
+func (p *Pool) release(pc *pooledClient) {
+	if !p.preSolve {
+		p.idle <- pc
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		if err := pc.Prepare(p.ctx); err != nil {
+			log.WithError(err).Debug("pre-solve a challenge")
+		}
+		p.idle <- pc
+	}()
+}
+
+// GetMessage fetches the content with one of the sessions.
+func (p *Pool) GetMessage(ctx context.Context) ([]byte, error) {
+	c, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release(c)
+
+	return c.GetMessage(ctx)
+}
+
+// Close stops the background work and closes all the sessions, it waits for the acquired
+// ones to be released.
+func (p *Pool) Close() error {
+	p.cancel()
+	p.wg.Wait()
+
+	var errs []error
+	for range len(p.clients) {
+		pc := <-p.idle
+		if err := pc.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// healthCheck pings the sessions which have been idle for the interval, a broken session
+// is redialed (and its challenge is solved again) on the next check or use.
+func (p *Pool) healthCheck() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+func (p *Pool) checkIdle() {
+	for range cap(p.idle) {
+		var pc *pooledClient
+		select {
+		case pc = <-p.idle:
+		default:
+			return
+		}
+
+		if time.Since(pc.lastUsed) >= p.interval {
+			if err := pc.Ping(p.ctx); err != nil {
+				log.WithError(err).Debug("an idle session is broken")
+			}
+			pc.lastUsed = time.Now()
+		}
+
+		p.release(pc)
+	}
+}
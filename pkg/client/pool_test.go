@@ -0,0 +1,64 @@
+//nolint:testpackage // ignore errcheck linter for this file
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addresses := []string{"localhost:19290", "localhost:19291"}
+	for _, address := range addresses {
+		serv, err := server.New(&server.Dependencies{
+			TCPAddress:     address,
+			MessageHandler: func() []byte { return []byte("msg received") },
+			PowHandler:     pow.NewPow(1),
+			Quota:          2,
+		})
+		require.NoError(t, err)
+		go serv.Listen(ctx)
+	}
+
+	pool := NewPool(&PoolDependencies{
+		Addresses:           addresses,
+		Size:                3,
+		PreSolve:            true,
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			response, err := pool.GetMessage(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []byte("msg received"), response)
+		}()
+	}
+	wg.Wait()
+
+	time.Sleep(50 * time.Millisecond) // let the health checks run
+
+	response, err := pool.GetMessage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("msg received"), response)
+
+	require.NoError(t, pool.Close())
+
+	_, err = pool.Acquire(ctx)
+	require.ErrorIs(t, err, ErrPoolClosed)
+}
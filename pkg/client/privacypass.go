@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+
+	"github.com/kriuchkov/power/pkg/privacypass"
+
+	"github.com/go-faster/errors"
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// IssuePrivateTokens solves a challenge for a batch of the anonymous tokens, Dependencies.PrivateTokens
+// of them or less if the server issues less. The server has to issue the anonymous tokens, see
+// server.Dependencies.PrivacyPass. A dialed connection is dropped after the issuance, so the tokens
+// are redeemed on the fresh ones.
+func (c *Client) IssuePrivateTokens(ctx context.Context) error {
+	if c.privateBatch == 0 {
+		return errors.New("the anonymous tokens are disabled")
+	}
+
+	return c.withRetry(ctx, func() error {
+		if err := c.connect(ctx); err != nil {
+			return err
+		}
+		return c.issuePrivateTokens(ctx)
+	})
+}
+
+// PrivateTokens returns the number of the anonymous tokens left.
+func (c *Client) PrivateTokens() int {
+	return len(c.privateTokens)
+}
+
+func (c *Client) issuePrivateTokens(ctx context.Context) error {
+	if c.privacyPass == nil {
+		if err := c.requestIssuer(); err != nil {
+			return err
+		}
+	}
+
+	solution := c.preparedSolution
+	if !c.prepared {
+		var err error
+		if solution, err = c.findSolution(ctx); err != nil {
+			return err
+		}
+	}
+	c.prepared = false
+
+	blinded, pending, err := c.privacyPass.Blind(c.privateBatch)
+	if err != nil {
+		return err //nolint:wrapcheck // it's wrapped by Blind
+	}
+
+	err = c.writeMessage(&powerV1.Message{
+		Command:       powerV1.CommandType_IssueTokens,
+		Body:          solution,
+		PrivateTokens: &powerV1.PrivateTokens{Blinded: blinded},
+	})
+	if err != nil {
+		return errors.Wrap(err, "send an issue tokens message")
+	}
+
+	issueMessage, err := c.readIssue()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := c.privacyPass.Finalize(pending, issueMessage.GetPrivateTokens().GetBlinded())
+	if err != nil {
+		return errors.Wrap(err, "finalize tokens")
+	}
+	for _, t := range tokens {
+		c.privateTokens = append(c.privateTokens, t.Marshal())
+	}
+	log.WithField("tokens", len(tokens)).Debug("issued anonymous tokens")
+
+	// the redemptions don't share the connection of the solution
+	c.resetConn()
+	return nil
+}
+
+// requestIssuer gets the issuer key and the epoch of the anonymous tokens, the batch is cut to its limit.
+func (c *Client) requestIssuer() error {
+	if err := c.writeMessage(&powerV1.Message{Command: powerV1.CommandType_IssueTokens}); err != nil {
+		return errors.Wrap(err, "send an issuer message")
+	}
+
+	issuerMessage, err := c.readIssue()
+	if err != nil {
+		return err
+	}
+
+	issuer := issuerMessage.GetPrivateTokens()
+	if c.privacyPass, err = privacypass.NewClient(issuer.GetPublicKey(), issuer.GetIssuer(), issuer.GetEpoch()); err != nil {
+		return errors.Wrap(err, "issuer key")
+	}
+	if maxBatch := int(issuer.GetMaxBatch()); maxBatch > 0 && c.privateBatch > maxBatch {
+		c.privateBatch = maxBatch
+	}
+	return nil
+}
+
+func (c *Client) readIssue() (*powerV1.Message, error) {
+	issueMessage, err := c.readMessage()
+	if err != nil {
+		return nil, errors.Wrap(err, "read an issue tokens message")
+	}
+
+	//nolint:exhaustive //ok
+	switch issueMessage.GetCommand() {
+	case powerV1.CommandType_ErrInvalidHash:
+		return nil, ErrInvalidHash
+	case powerV1.CommandType_IssueTokens:
+		return issueMessage, nil
+	default:
+		return nil, ErrWrongCommand
+	}
+}
+
+// redeemPrivateTokens spends an anonymous token, a new batch is issued when they run out.
+func (c *Client) redeemPrivateTokens(ctx context.Context) (*powerV1.Message, error) {
+	if len(c.privateTokens) == 0 {
+		if err := c.issuePrivateTokens(ctx); err != nil {
+			return nil, err
+		}
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.redeemPrivateToken()
+}
+
+// redeemPrivateToken spends an anonymous token. A refused token drops the rest with the issuer key,
+// the server key or its epoch is likely rotated.
+func (c *Client) redeemPrivateToken() (*powerV1.Message, error) {
+	last := len(c.privateTokens) - 1
+
+	message := &powerV1.Message{Command: powerV1.CommandType_RedeemToken, Body: c.privateTokens[last]}
+	if err := c.writeMessage(message); err != nil {
+		return nil, errors.Wrap(err, "send a redeem token message")
+	}
+	// a sent token may be spent, it isn't sent twice
+	c.privateTokens = c.privateTokens[:last]
+
+	contentMessage, err := c.readContent()
+	if errors.Is(err, ErrInvalidHash) {
+		c.privateTokens, c.privacyPass = nil, nil
+	}
+	return contentMessage, err
+}
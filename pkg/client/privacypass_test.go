@@ -0,0 +1,76 @@
+//nolint:testpackage // it's internal tests
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/privacypass"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_PrivateTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := rsa.GenerateKey(rand.Reader, privacypass.MinKeyBits)
+	require.NoError(t, err)
+	issuer, err := privacypass.NewIssuer(&privacypass.Dependencies{Key: key, Name: "power", MaxBatch: 2})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serv, err := server.New(&server.Dependencies{
+		Listener:       listener,
+		MessageHandler: func() []byte { return []byte("msg received") },
+		PowHandler:     pow.NewPow(0),
+		PrivacyPass:    issuer,
+	})
+	require.NoError(t, err)
+	go serv.Listen(ctx)
+
+	var dials int
+	cl := New(&Dependencies{
+		Address:       listener.Addr().String(),
+		PrivateTokens: 3,
+		Hooks:         Hooks{OnDial: func(string, error) { dials++ }},
+	})
+	defer cl.Close()
+
+	// the batch is cut to the limit of the issuer, the tokens aren't redeemed on the connection of their solution
+	for range 4 {
+		response, err := cl.GetMessage(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "msg received", string(response))
+	}
+	require.Zero(t, cl.PrivateTokens())
+	require.Equal(t, 3, dials)
+
+	require.NoError(t, cl.IssuePrivateTokens(ctx))
+	require.Equal(t, 2, cl.PrivateTokens())
+
+	stats := serv.Stats()
+	require.EqualValues(t, 6, stats.IssuedPrivateTokens)
+	require.EqualValues(t, 4, stats.RedeemedPrivateTokens)
+	require.EqualValues(t, 3, stats.Challenges)
+}
+
+func TestClient_PrivateTokensServerConn(t *testing.T) {
+	t.Parallel()
+
+	conn, _ := net.Pipe()
+	defer conn.Close()
+
+	// the connection can't be redialed, so the anonymous tokens would be redeemed on the one of their solution
+	require.Panics(t, func() { New(&Dependencies{ServerConn: conn, PrivateTokens: 3}) })
+}
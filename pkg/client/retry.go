@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/common"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 10 * time.Second
+	DefaultMultiplier     = 2
+)
+
+// RetryPolicy is an exponential backoff with jitter. Retries need Dependencies.Address:
+// every attempt uses a new connection, so it gets a fresh challenge.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of the attempts, 1 (no retries) if zero.
+	MaxAttempts    int           `validate:"gte=0"`
+	InitialBackoff time.Duration `validate:"gte=0"`
+	MaxBackoff     time.Duration `validate:"gte=0"`
+	Multiplier     float64       `validate:"omitempty,gte=1"`
+	// Jitter is the random part of the delay in [0, 1]: the delay is reduced by up to Jitter*delay.
+	Jitter float64 `validate:"gte=0,lte=1"`
+	// RetryInvalidHash retries when the server rejects the solution.
+	RetryInvalidHash bool
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 1
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = DefaultInitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultMaxBackoff
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = DefaultMultiplier
+	}
+}
+
+// backoff returns the delay before the next attempt, attempt starts with 1.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	delay = math.Min(delay, float64(p.MaxBackoff))
+	delay -= delay * p.Jitter * rand.Float64() //nolint:gosec // it's ok for the jitter
+	return time.Duration(delay)
+}
+
+// Hooks observe the connection lifecycle and the retries. All of them are optional.
+type Hooks struct {
+	// OnDial is called after every dial attempt.
+	OnDial func(address string, err error)
+	// OnRetry is called before the client sleeps for delay and makes the attempt number attempt+1.
+	OnRetry func(attempt int, delay time.Duration, err error)
+	// OnGiveUp is called when the attempts are exhausted or the error can't be retried.
+	OnGiveUp func(attempts int, err error)
+}
+
+// withRetry calls fn until it succeeds, the error can't be retried or the attempts are exhausted.
+func (c *Client) withRetry(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		retryable := c.isRetryable(ctx, err)
+		if retryable {
+			c.resetConn() // a new connection gets a fresh challenge
+		}
+
+		if attempt >= c.retry.MaxAttempts || !retryable {
+			if c.hooks.OnGiveUp != nil {
+				c.hooks.OnGiveUp(attempt, err)
+			}
+			return err
+		}
+
+		delay := c.retry.backoff(attempt)
+		log.WithError(err).WithFields(log.Fields{"attempt": attempt, "delay": delay}).Debug("retry")
+
+		if c.hooks.OnRetry != nil {
+			c.hooks.OnRetry(attempt, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(ctx.Err(), err.Error())
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) isRetryable(ctx context.Context, err error) bool {
+	switch {
+	case c.address == "":
+		return false // the connection was given by the caller and can't be redialed
+	case ctx.Err() != nil:
+		return false
+	case errors.Is(err, ErrInvalidHash):
+		return c.retry.RetryInvalidHash
+	case errors.Is(err, ErrWrongCommand), errors.Is(err, ErrNotTunnel):
+		return false
+	case errors.Is(err, common.ErrMalformedVerifyMessage), errors.Is(err, common.ErrInvalidSize),
+		errors.Is(err, common.ErrMessageTooLarge):
+		return false // the server breaks the protocol
+	case errors.Is(err, ErrChallengeRefused), errors.Is(err, ErrSolveBudget):
+		return false // the same server sends the same conditions
+	default:
+		return true // the network errors
+	}
+}
@@ -0,0 +1,147 @@
+//nolint:testpackage // ignore errcheck linter for this file
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	clientmocks "github.com/kriuchkov/power/pkg/client/mocks"
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	t.Parallel()
+
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	policy.setDefaults()
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, delay := range expected {
+		require.Equal(t, delay, policy.backoff(i+1))
+	}
+
+	policy.Jitter = 0.5
+	for attempt := 1; attempt < 10; attempt++ {
+		delay := policy.backoff(attempt)
+		require.LessOrEqual(t, delay, time.Second)
+		require.GreaterOrEqual(t, delay, 50*time.Millisecond)
+	}
+}
+
+type fakeDialer struct {
+	conns []func() (net.Conn, error)
+	dials int
+}
+
+func (d *fakeDialer) DialContext(_ context.Context, _, _ string) (net.Conn, error) {
+	conn := d.conns[d.dials]
+	d.dials++
+	return conn()
+}
+
+func scriptedConn(messages ...*powerV1.Message) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		var buf bytes.Buffer
+		for _, msg := range messages {
+			msgBytes, _ := proto.Marshal(msg)
+			binary.Write(&buf, binary.BigEndian, int32(len(msgBytes)))
+			buf.Write(msgBytes)
+		}
+		return newMockConn(buf.Bytes()), nil
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	t.Parallel()
+
+	errRefused := errors.New("connection refused")
+	refused := func() (net.Conn, error) { return nil, errRefused }
+
+	verifyMessage := &powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("test|1|97")}
+	contentMessage := &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("response")}
+	invalidMessage := &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash}
+
+	tests := []struct {
+		name            string
+		conns           []func() (net.Conn, error)
+		policy          RetryPolicy
+		expectedMessage []byte
+		expectedErr     error
+		expectedRetries int
+		expectedDials   int
+	}{
+		{
+			name:            "dial errors",
+			conns:           []func() (net.Conn, error){refused, refused, scriptedConn(verifyMessage, contentMessage)},
+			policy:          RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			expectedMessage: []byte("response"),
+			expectedRetries: 2,
+			expectedDials:   3,
+		},
+		{
+			name:            "attempts are exhausted",
+			conns:           []func() (net.Conn, error){refused, refused},
+			policy:          RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			expectedErr:     errRefused,
+			expectedRetries: 1,
+			expectedDials:   2,
+		},
+		{
+			name:            "invalid hash",
+			conns:           []func() (net.Conn, error){scriptedConn(verifyMessage, invalidMessage), scriptedConn(verifyMessage, contentMessage)},
+			policy:          RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryInvalidHash: true},
+			expectedMessage: []byte("response"),
+			expectedRetries: 1,
+			expectedDials:   2,
+		},
+		{
+			name:          "invalid hash without retries",
+			conns:         []func() (net.Conn, error){scriptedConn(verifyMessage, invalidMessage)},
+			policy:        RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			expectedErr:   ErrInvalidHash,
+			expectedDials: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockSolver := clientmocks.NewMockSolverHash(t)
+			mockSolver.EXPECT().FindNonce(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(123).Maybe()
+
+			dialer := &fakeDialer{conns: tt.conns}
+
+			var retries, dials int
+			cl := New(&Dependencies{
+				Address: "server:9090",
+				Dialer:  dialer,
+				Hasher:  mockSolver,
+				Retry:   tt.policy,
+				Hooks: Hooks{
+					OnDial:  func(string, error) { dials++ },
+					OnRetry: func(int, time.Duration, error) { retries++ },
+				},
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			response, err := cl.GetMessage(ctx)
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Equal(t, tt.expectedMessage, response)
+			require.Equal(t, tt.expectedRetries, retries)
+			require.Equal(t, tt.expectedDials, dials)
+			require.Equal(t, tt.expectedDials, dialer.dials)
+		})
+	}
+}
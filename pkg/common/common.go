@@ -1,30 +1,85 @@
 package common
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"strconv"
-	"strings"
+
+	"github.com/go-faster/errors"
+)
+
+var (
+	// ErrMalformedVerifyMessage is returned for a verify message which isn't "hash|index|value".
+	ErrMalformedVerifyMessage = errors.New("malformed verify message")
+	// ErrMalformedNonce is returned for a nonce which isn't a non-negative decimal number.
+	ErrMalformedNonce = errors.New("malformed nonce")
+	// ErrInvalidSize is returned for a zero or negative frame size, the frame has no body to skip.
+	ErrInvalidSize = errors.New("invalid message size")
+	// ErrMessageTooLarge is returned for a frame size over the limit, the body isn't read.
+	ErrMessageTooLarge = errors.New("message too large")
 )
 
 func ConvetVerfyMessageToBytes(primaryHash []byte, byteIndex int, byteValue byte) []byte {
 	return []byte(fmt.Sprintf("%s|%d|%d", primaryHash, byteIndex, byteValue))
 }
 
+// SplitMessage parses the verify message. The hash is raw bytes and can hold '|' itself,
+// so the index and the value are taken from the end.
+//
 //nolint:nonamedreturns // it's a helper function
-func SplitMessage(body []byte) (hash []byte, byteIndex int, byteValue byte) {
-	split := strings.Split(string(body), "|")
-	if len(split) != 3 {
-		return nil, 0, 0
+func SplitMessage(body []byte) (hash []byte, byteIndex int, byteValue byte, err error) {
+	valueSep := bytes.LastIndexByte(body, '|')
+	if valueSep < 0 {
+		return nil, 0, 0, errors.Wrap(ErrMalformedVerifyMessage, "no separator")
+	}
+
+	indexSep := bytes.LastIndexByte(body[:valueSep], '|')
+	if indexSep <= 0 {
+		return nil, 0, 0, errors.Wrap(ErrMalformedVerifyMessage, "no hash")
+	}
+
+	index, err := strconv.ParseUint(string(body[indexSep+1:valueSep]), 10, 31)
+	if err != nil {
+		return nil, 0, 0, errors.Wrapf(ErrMalformedVerifyMessage, "byte index: %v", err)
+	}
+
+	value, err := strconv.ParseUint(string(body[valueSep+1:]), 10, 8)
+	if err != nil {
+		return nil, 0, 0, errors.Wrapf(ErrMalformedVerifyMessage, "byte value: %v", err)
 	}
 
-	hash = []byte(split[0])
-	byteIndex, _ = strconv.Atoi(split[1])
-	byteValueInt, _ := strconv.Atoi(split[2])
-	byteValue = byte(byteValueInt)
-	return hash, byteIndex, byteValue
+	return body[:indexSep], int(index), byte(value), nil
 }
 
-func GetNonceFromMessage(data []byte) int {
-	clientNonce, _ := strconv.Atoi(string(data))
-	return clientNonce
+// GetNonceFromMessage parses the nonce of a content message.
+func GetNonceFromMessage(data []byte) (int, error) {
+	nonce, err := strconv.ParseUint(string(data), 10, 63)
+	if err != nil {
+		return 0, errors.Wrap(ErrMalformedNonce, err.Error())
+	}
+	return int(nonce), nil
+}
+
+// ReadFrame reads a message with the big-endian int32 size prefix. The body of a frame over
+// maxSize isn't allocated, zero maxSize means no limit.
+func ReadFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var size int32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, errors.Wrap(err, "read message size")
+	}
+
+	switch {
+	case size <= 0:
+		return nil, errors.Wrapf(ErrInvalidSize, "size %d", size)
+	case maxSize > 0 && int(size) > maxSize:
+		return nil, errors.Wrapf(ErrMessageTooLarge, "size %d, limit %d", size, maxSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errors.Wrap(err, "read message")
+	}
+	return body, nil
 }
@@ -2,7 +2,13 @@
 package common
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"strconv"
 	"testing"
 
 	require "github.com/stretchr/testify/require"
@@ -10,11 +16,12 @@ import (
 
 func TestSplitMessage(t *testing.T) {
 	tests := []struct {
-		name      string
-		body      []byte
-		hash      []byte
-		byteIndex int
-		byteValue byte
+		name        string
+		body        []byte
+		hash        []byte
+		byteIndex   int
+		byteValue   byte
+		expectedErr error
 	}{
 		{
 			name:      "valid message",
@@ -24,20 +31,134 @@ func TestSplitMessage(t *testing.T) {
 			byteValue: 'a',
 		},
 		{
-			name:      "invalid message",
-			body:      []byte("invalid message"),
-			hash:      []byte(nil),
-			byteIndex: 0,
+			name:      "separator in the hash",
+			body:      []byte("ha|sh|31|0"),
+			hash:      []byte("ha|sh"),
+			byteIndex: 31,
 			byteValue: 0,
 		},
+		{name: "invalid message", body: []byte("invalid message"), expectedErr: ErrMalformedVerifyMessage},
+		{name: "empty hash", body: []byte("|1|97"), expectedErr: ErrMalformedVerifyMessage},
+		{name: "negative index", body: []byte("hash|-1|97"), expectedErr: ErrMalformedVerifyMessage},
+		{name: "index overflow", body: []byte("hash|4294967296|97"), expectedErr: ErrMalformedVerifyMessage},
+		{name: "not a number", body: []byte("hash|a|97"), expectedErr: ErrMalformedVerifyMessage},
+		{name: "value over a byte", body: []byte("hash|1|256"), expectedErr: ErrMalformedVerifyMessage},
+		{name: "empty value", body: []byte("hash|1|"), expectedErr: ErrMalformedVerifyMessage},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotHash, gotIndex, gotValue := SplitMessage(tt.body)
+			gotHash, gotIndex, gotValue, err := SplitMessage(tt.body)
+			require.ErrorIs(t, err, tt.expectedErr)
 			require.Equal(t, tt.hash, gotHash)
 			require.Equal(t, tt.byteIndex, gotIndex)
 			require.Equal(t, tt.byteValue, gotValue)
 		})
 	}
 }
+
+func FuzzSplitMessage(f *testing.F) {
+	f.Add([]byte("hash|1|97"))
+	f.Add([]byte("ha|sh|31|0"))
+	f.Add([]byte("hash|-1|97"))
+	f.Add([]byte("hash|32|255"))
+	f.Add([]byte("||"))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		hash, byteIndex, byteValue, err := SplitMessage(body)
+		if err != nil {
+			require.ErrorIs(t, err, ErrMalformedVerifyMessage)
+			require.Nil(t, hash)
+			return
+		}
+
+		require.NotEmpty(t, hash)
+		require.GreaterOrEqual(t, byteIndex, 0)
+
+		// a parsed message survives the round trip
+		gotHash, gotIndex, gotValue, err := SplitMessage(ConvetVerfyMessageToBytes(hash, byteIndex, byteValue))
+		require.NoError(t, err)
+		require.Equal(t, hash, gotHash)
+		require.Equal(t, byteIndex, gotIndex)
+		require.Equal(t, byteValue, gotValue)
+	})
+}
+
+func FuzzGetNonceFromMessage(f *testing.F) {
+	f.Add([]byte("123"))
+	f.Add([]byte("-1"))
+	f.Add([]byte("+1"))
+	f.Add([]byte("9223372036854775808"))
+	f.Add([]byte(""))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		nonce, err := GetNonceFromMessage(data)
+		if err != nil {
+			require.ErrorIs(t, err, ErrMalformedNonce)
+			return
+		}
+
+		require.GreaterOrEqual(t, nonce, 0)
+
+		got, err := GetNonceFromMessage([]byte(strconv.Itoa(nonce)))
+		require.NoError(t, err)
+		require.Equal(t, nonce, got)
+	})
+}
+
+func FuzzReadFrame(f *testing.F) {
+	const maxSize = 64
+
+	frame := func(size int32, body string) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(size)), body...) //nolint:gosec // the sizes lie on purpose
+	}
+
+	f.Add(frame(4, "body"))
+	f.Add(frame(4, "bo"))
+	f.Add(frame(0, ""))
+	f.Add(frame(-1, ""))
+	f.Add(frame(math.MaxInt32, "body"))
+	f.Add(frame(maxSize+1, "body"))
+	f.Add([]byte{0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+
+		body, err := ReadFrame(r, maxSize)
+		if err != nil {
+			require.Nil(t, body)
+			return
+		}
+
+		require.NotEmpty(t, body)
+		require.LessOrEqual(t, len(body), maxSize)
+		require.Equal(t, data[4:4+len(body)], body)
+
+		rest, _ := io.ReadAll(r)
+		require.Len(t, rest, len(data)-4-len(body))
+	})
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected netip.Prefix
+	}{
+		{input: "192.0.2.1", expected: netip.MustParsePrefix("192.0.2.1/32")},
+		{input: "10.1.2.3/8", expected: netip.MustParsePrefix("10.0.0.0/8")},
+		{input: "::ffff:192.0.2.1", expected: netip.MustParsePrefix("192.0.2.1/32")},
+		{input: "::ffff:198.51.100.7/120", expected: netip.MustParsePrefix("198.51.100.0/24")},
+		{input: "2001:db8::1/32", expected: netip.MustParsePrefix("2001:db8::/32")},
+	}
+
+	for _, tt := range tests {
+		prefix, err := ParsePrefix(tt.input)
+		require.NoError(t, err, tt.input)
+		require.Equal(t, tt.expected, prefix, tt.input)
+	}
+
+	for _, input := range []string{"", "example.com", "10.0.0.0/33", "10.0.0.0/"} {
+		_, err := ParsePrefix(input)
+		require.Error(t, err, input)
+	}
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/go-faster/errors"
+)
+
+// ParsePrefix parses an address or a CIDR prefix, an address is a full-length prefix. The prefix is
+// normalized, see NormalizePrefix, so the same input matches the same addresses in every layer.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, errors.Wrapf(err, "invalid prefix %q", s)
+		}
+		return NormalizePrefix(prefix), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, errors.Wrapf(err, "invalid address %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// NormalizePrefix unmaps an IPv4-mapped prefix and clears the host bits.
+func NormalizePrefix(prefix netip.Prefix) netip.Prefix {
+	addr, bits := prefix.Addr(), prefix.Bits()
+	if addr.Is4In6() {
+		addr, bits = addr.Unmap(), max(bits-96, 0)
+	}
+
+	masked, err := addr.Prefix(bits)
+	if err != nil {
+		return prefix
+	}
+	return masked
+}
@@ -0,0 +1,144 @@
+// Package geoip enriches the client addresses with the country and the autonomous system from the local
+// MaxMind DB files, e.g. GeoLite2-Country and GeoLite2-ASN. There are no network lookups.
+//
+// Several files are merged: the first one which knows a field wins, so a country and an ASN database
+// complete each other. A nil *DB is a valid one without the files, its lookups return the zero Info.
+package geoip
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-faster/errors"
+	"github.com/oschwald/maxminddb-golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Info is what the databases know about an address, the unknown fields are zero.
+type Info struct {
+	// Country is the ISO 3166-1 alpha-2 code, e.g. "DE".
+	Country string `json:"country,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// IsZero reports whether nothing is known.
+func (i Info) IsZero() bool {
+	return i == Info{}
+}
+
+// Fields returns the known fields for a log entry.
+func (i Info) Fields() log.Fields {
+	fields := log.Fields{}
+	if i.Country != "" {
+		fields["country"] = i.Country
+	}
+	if i.ASN != 0 {
+		fields["asn"] = i.ASN
+	}
+	return fields
+}
+
+// String returns e.g. "DE AS3320", "-" if nothing is known.
+func (i Info) String() string {
+	switch {
+	case i.Country != "" && i.ASN != 0:
+		return i.Country + " AS" + strconv.FormatUint(uint64(i.ASN), 10)
+	case i.Country != "":
+		return i.Country
+	case i.ASN != 0:
+		return "AS" + strconv.FormatUint(uint64(i.ASN), 10)
+	default:
+		return "-"
+	}
+}
+
+// record is the part of the GeoIP2/GeoLite2 Country, City and ASN schemas in use.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry is the fallback of the addresses without a country, e.g. the anycast ones.
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN   uint32 `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB looks the addresses up in the database files. It's safe for concurrent use, Reload swaps the
+// files under the running lookups.
+type DB struct {
+	files   []string
+	readers atomic.Pointer[[]*maxminddb.Reader]
+}
+
+// Open reads the database files, they're kept in memory.
+func Open(files ...string) (*DB, error) {
+	db := &DB{files: files}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Files returns the database files.
+func (d *DB) Files() []string {
+	if d == nil {
+		return nil
+	}
+	return d.files
+}
+
+// Reload re-reads the database files, a broken file keeps the loaded ones.
+func (d *DB) Reload() error {
+	readers := make([]*maxminddb.Reader, 0, len(d.files))
+	for _, file := range d.files {
+		// the files are read instead of mapped, so the replaced readers need no closing
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return errors.Wrap(err, "read geoip database")
+		}
+
+		reader, err := maxminddb.FromBytes(data)
+		if err != nil {
+			return errors.Wrapf(err, "open geoip database %s", file)
+		}
+		readers = append(readers, reader)
+	}
+
+	d.readers.Store(&readers)
+	return nil
+}
+
+// Lookup returns what the databases know about the address, a failed lookup is logged and skipped.
+func (d *DB) Lookup(addr netip.Addr) Info {
+	var info Info
+	if d == nil || !addr.IsValid() {
+		return info
+	}
+
+	ip := net.IP(addr.Unmap().AsSlice())
+	for _, reader := range *d.readers.Load() {
+		var rec record
+		if err := reader.Lookup(ip, &rec); err != nil {
+			// e.g. an IPv6 address in an IPv4 database
+			log.WithError(err).WithField("addr", addr).Debug("look the address up")
+			continue
+		}
+
+		if info.Country == "" {
+			info.Country = rec.Country.ISOCode
+			if info.Country == "" {
+				info.Country = rec.RegisteredCountry.ISOCode
+			}
+		}
+		if info.ASN == 0 {
+			info.ASN, info.ASOrg = rec.ASN, rec.ASOrg
+		}
+	}
+	return info
+}
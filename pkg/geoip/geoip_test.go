@@ -0,0 +1,103 @@
+package geoip_test
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kriuchkov/power/pkg/geoip"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/require"
+)
+
+// writeDB writes a database of the networks.
+func writeDB(t *testing.T, name, databaseType string, networks map[string]mmdbtype.Map) {
+	t.Helper()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: databaseType, IncludeReservedNetworks: true})
+	require.NoError(t, err)
+
+	for cidr, value := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, value))
+	}
+
+	file, err := os.Create(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = tree.WriteTo(file)
+	require.NoError(t, err)
+}
+
+func country(code string) mmdbtype.Map {
+	return mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String(code)}}
+}
+
+func TestDB(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	countries, asns := filepath.Join(dir, "country.mmdb"), filepath.Join(dir, "asn.mmdb")
+
+	writeDB(t, countries, "GeoLite2-Country", map[string]mmdbtype.Map{
+		"192.0.2.0/24":    country("DE"),
+		"2001:db8::/32":   country("NL"),
+		"198.51.100.0/24": {"registered_country": mmdbtype.Map{"iso_code": mmdbtype.String("US")}},
+	})
+	writeDB(t, asns, "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"192.0.2.0/25": {
+			"autonomous_system_number":       mmdbtype.Uint32(64496),
+			"autonomous_system_organization": mmdbtype.String("Example"),
+		},
+	})
+
+	db, err := geoip.Open(countries, asns)
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr     string
+		expected geoip.Info
+	}{
+		{addr: "192.0.2.1", expected: geoip.Info{Country: "DE", ASN: 64496, ASOrg: "Example"}},
+		{addr: "::ffff:192.0.2.1", expected: geoip.Info{Country: "DE", ASN: 64496, ASOrg: "Example"}},
+		{addr: "192.0.2.200", expected: geoip.Info{Country: "DE"}},
+		{addr: "198.51.100.1", expected: geoip.Info{Country: "US"}},
+		{addr: "2001:db8::1", expected: geoip.Info{Country: "NL"}},
+		{addr: "203.0.113.1"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, db.Lookup(netip.MustParseAddr(tt.addr)), tt.addr)
+	}
+	require.Equal(t, "DE AS64496", db.Lookup(netip.MustParseAddr("192.0.2.1")).String())
+
+	// a broken file keeps the loaded databases
+	require.NoError(t, os.WriteFile(asns, []byte("broken"), 0o600))
+	require.Error(t, db.Reload())
+	require.EqualValues(t, 64496, db.Lookup(netip.MustParseAddr("192.0.2.1")).ASN)
+
+	writeDB(t, asns, "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"192.0.2.0/24": {"autonomous_system_number": mmdbtype.Uint32(64497)},
+	})
+	require.NoError(t, db.Reload())
+	require.Equal(t, geoip.Info{Country: "DE", ASN: 64497}, db.Lookup(netip.MustParseAddr("192.0.2.200")))
+
+	_, err = geoip.Open(filepath.Join(dir, "missing.mmdb"))
+	require.Error(t, err)
+}
+
+func TestDB_Nil(t *testing.T) {
+	t.Parallel()
+
+	var db *geoip.DB
+	info := db.Lookup(netip.MustParseAddr("192.0.2.1"))
+	require.True(t, info.IsZero())
+	require.Equal(t, "-", info.String())
+	require.Empty(t, info.Fields())
+}
@@ -0,0 +1,145 @@
+// Package grpcpow serves the PoW exchange over gRPC and protects other gRPC services with a challenge.
+package grpcpow
+
+import (
+	"context"
+	"net"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/server"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MessageHandler is a function that returns a message.
+type MessageHandler func() []byte
+
+// PowHandler is an interface that defines the methods for the PoW handler.
+type PowHandler interface {
+	GenerateHash(msg []byte, nonce int) []byte
+	IsValidHash(hash []byte, byteIndex int, byteValue byte) bool
+	GetClientConditions(clientAddr net.Addr) (byteIndex int, byteValue byte)
+}
+
+type Dependencies struct {
+	MessageHandler MessageHandler `validate:"required"`
+	PowHandler     PowHandler     `validate:"required"`
+	// Secret signs the tickets of the stateless exchange. All the replicas must share it.
+	Secret []byte `validate:"required,min=16"`
+	// Spent remembers the redeemed tickets, in memory by default. All the replicas must share it too.
+	Spent pow.SpentStore
+	// Server serves Exchange and checks the callers against its access lists and bans, so the gRPC
+	// clients are treated like the TCP ones. Optional, Exchange is unimplemented without it.
+	Server *server.Server
+}
+
+func (d *Dependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+}
+
+// Service implements powerV1.PowerServer.
+type Service struct {
+	powerV1.UnimplementedPowerServer
+
+	msgHandler MessageHandler
+	gate       *pow.Gate
+	server     *server.Server
+}
+
+func New(deps *Dependencies) *Service {
+	deps.SetDefaults()
+
+	return &Service{
+		msgHandler: deps.MessageHandler,
+		gate:       pow.NewGate(deps.PowHandler, deps.Secret, 0, deps.Spent),
+		server:     deps.Server,
+	}
+}
+
+func (s *Service) GetChallenge(ctx context.Context, _ *powerV1.ChallengeRequest) (*powerV1.Challenge, error) {
+	if _, err := s.checkAccess(ctx); err != nil {
+		return nil, err
+	}
+
+	ticket, sealed := s.gate.Issue(peerAddr(ctx))
+
+	challenge := &powerV1.Challenge{
+		Hash:      ticket.Hash,
+		ByteIndex: int32(ticket.ByteIndex), //nolint:gosec // the index is less than the hash length
+		ByteValue: uint32(ticket.ByteValue),
+		Ticket:    sealed,
+		ExpiresAt: ticket.ExpiresAt.Unix(),
+	}
+	if ticket.Difficulty > 0 {
+		// the sealed difficulty, the one the solution is checked against
+		challenge.Difficulty = int32(ticket.Difficulty) //nolint:gosec // the difficulty is less than the hash length
+		challenge.Algorithm = pow.AlgorithmSHA256
+	}
+	return challenge, nil
+}
+
+// Redeem returns the content for a solution, the allowlisted callers get it without one.
+func (s *Service) Redeem(ctx context.Context, solution *powerV1.Solution) (*powerV1.Message, error) {
+	allowed, err := s.checkAccess(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if allowed {
+		return &powerV1.Message{Command: powerV1.CommandType_Content, Body: s.msgHandler()}, nil
+	}
+
+	err = s.gate.Redeem(solution.GetTicket(), int(solution.GetNonce()))
+	switch {
+	case errors.Is(err, pow.ErrInvalidSolution):
+		return &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash}, nil
+	case err != nil:
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return &powerV1.Message{Command: powerV1.CommandType_Content, Body: s.msgHandler()}, nil
+}
+
+// Exchange serves the TCP protocol on the stream, see server.Server.ServeStream. It's unimplemented
+// without Dependencies.Server.
+func (s *Service) Exchange(stream powerV1.Power_ExchangeServer) error {
+	if s.server == nil {
+		return status.Error(codes.Unimplemented, "the exchange needs the server")
+	}
+
+	err := s.server.ServeStream(stream, peerAddr(stream.Context()))
+	switch {
+	case errors.Is(err, server.ErrAccessRefused):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, server.ErrNotSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	return err //nolint:wrapcheck // it's nil or one of the errors above
+}
+
+// checkAccess refuses the denied and the banned addresses and reports the allowlisted ones, if there is
+// the server.
+func (s *Service) checkAccess(ctx context.Context) (bool, error) {
+	if s.server == nil {
+		return false, nil
+	}
+
+	allowed, err := s.server.CheckAccess(peerAddr(ctx))
+	if err != nil {
+		return false, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return allowed, nil
+}
+
+func peerAddr(ctx context.Context) net.Addr {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr
+	}
+	return &net.TCPAddr{}
+}
@@ -0,0 +1,283 @@
+package grpcpow_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+	"github.com/kriuchkov/power/pkg/common"
+	"github.com/kriuchkov/power/pkg/grpcpow"
+	"github.com/kriuchkov/power/pkg/server"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthV1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var testSecret = []byte("0123456789abcdef")
+
+// newPowServer returns the server of the Exchange streams, its TCP listener isn't served.
+func newPowServer(t *testing.T, deps *server.Dependencies) *server.Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	deps.Listener = listener
+	deps.MessageHandler = func() []byte { return []byte("msg received") }
+	deps.PowHandler = pow.NewPow(1)
+
+	serv, err := server.New(deps)
+	require.NoError(t, err)
+	return serv
+}
+
+func newTestServer(t *testing.T, opts ...grpc.ServerOption) *bufconn.Listener {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	serve(t, listener, newPowServer(t, &server.Dependencies{}), opts...)
+	return listener
+}
+
+func serve(t *testing.T, listener net.Listener, serv *server.Server, opts ...grpc.ServerOption) {
+	t.Helper()
+
+	srv := grpc.NewServer(opts...)
+	powerV1.RegisterPowerServer(srv, grpcpow.New(&grpcpow.Dependencies{
+		MessageHandler: func() []byte { return []byte("msg received") },
+		PowHandler:     pow.NewPow(1),
+		Secret:         testSecret,
+		Server:         serv,
+	}))
+	healthV1.RegisterHealthServer(srv, health.NewServer())
+
+	go srv.Serve(listener) //nolint:errcheck // it's ok here
+	t.Cleanup(srv.Stop)
+}
+
+func dial(t *testing.T, listener *bufconn.Listener, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestService_Redeem(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	client := powerV1.NewPowerClient(dial(t, newTestServer(t)))
+
+	challenge, err := client.GetChallenge(ctx, &powerV1.ChallengeRequest{})
+	require.NoError(t, err)
+
+	nonce := pow.NewPow(int(challenge.GetDifficulty())).FindNonce(ctx, challenge.GetHash(), int(challenge.GetByteIndex()), byte(challenge.GetByteValue()))
+
+	tests := []struct {
+		name            string
+		solution        *powerV1.Solution
+		responseMessage *powerV1.Message
+		expectedCode    codes.Code
+	}{
+		{
+			name:            "invalid nonce",
+			solution:        &powerV1.Solution{Ticket: challenge.GetTicket(), Nonce: int64(nonce) + 1},
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash},
+		},
+		{
+			name:            "forged ticket",
+			solution:        &powerV1.Solution{Ticket: challenge.GetTicket() + "A", Nonce: int64(nonce)},
+			expectedCode:    codes.PermissionDenied,
+			responseMessage: nil,
+		},
+		{
+			name:            "valid nonce",
+			solution:        &powerV1.Solution{Ticket: challenge.GetTicket(), Nonce: int64(nonce)},
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("msg received")},
+		},
+		{
+			name:         "replayed nonce",
+			solution:     &powerV1.Solution{Ticket: challenge.GetTicket(), Nonce: int64(nonce)},
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		response, err := client.Redeem(ctx, tt.solution)
+		require.Equal(t, tt.expectedCode, status.Code(err), tt.name)
+		require.Equal(t, tt.responseMessage.GetCommand(), response.GetCommand(), tt.name)
+		require.Equal(t, tt.responseMessage.GetBody(), response.GetBody(), tt.name)
+	}
+}
+
+func TestService_Exchange(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	client := powerV1.NewPowerClient(dial(t, newTestServer(t)))
+
+	stream, err := client.Exchange(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&powerV1.Message{Command: powerV1.CommandType_Connect}))
+	verifyMessage, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, powerV1.CommandType_Connect, verifyMessage.GetCommand())
+
+	solver := pow.NewPow(1)
+	hash, byteIndex, byteValue, err := common.SplitMessage(verifyMessage.GetBody())
+	require.NoError(t, err)
+	nonce := solver.FindNonce(ctx, hash, byteIndex, byteValue)
+
+	require.NoError(t, stream.Send(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))}))
+	contentMessage, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, powerV1.CommandType_Content, contentMessage.GetCommand())
+	require.Equal(t, []byte("msg received"), contentMessage.GetBody())
+
+	// the session of the server is used, a solved challenge isn't replayed
+	require.NoError(t, stream.Send(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))}))
+	replayedMessage, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, powerV1.CommandType_ErrInvalidHash, replayedMessage.GetCommand())
+
+	require.NoError(t, stream.Send(&powerV1.Message{Command: powerV1.CommandType_Close}))
+	_, err = stream.Recv()
+	require.Error(t, err)
+}
+
+func TestService_Access(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		deps         *server.Dependencies
+		expectedCode codes.Code
+		expected     *powerV1.Message
+	}{
+		{
+			name:         "denied",
+			deps:         &server.Dependencies{Denylist: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:     "allowed",
+			deps:     &server.Dependencies{Allowlist: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}},
+			expected: &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("msg received")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			// the bufconn peers have no IP address, the access lists need a TCP one
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			serve(t, listener, newPowServer(t, tt.deps))
+
+			conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			t.Cleanup(func() { conn.Close() })
+			client := powerV1.NewPowerClient(conn)
+
+			_, err = client.GetChallenge(ctx, &powerV1.ChallengeRequest{})
+			require.Equal(t, tt.expectedCode, status.Code(err))
+
+			response, err := client.Redeem(ctx, &powerV1.Solution{})
+			require.Equal(t, tt.expectedCode, status.Code(err))
+			require.Equal(t, tt.expected.GetBody(), response.GetBody())
+
+			stream, err := client.Exchange(ctx)
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(&powerV1.Message{Command: powerV1.CommandType_Content}))
+
+			response, err = stream.Recv()
+			require.Equal(t, tt.expectedCode, status.Code(err))
+			require.Equal(t, tt.expected.GetCommand(), response.GetCommand())
+		})
+	}
+}
+
+func TestGuard(t *testing.T) {
+	t.Parallel()
+
+	guard := grpcpow.NewGuard(&grpcpow.GuardDependencies{
+		PowHandler:  pow.NewPow(1),
+		Secret:      testSecret,
+		SkipMethods: []string{powerV1.Power_GetChallenge_FullMethodName},
+	})
+
+	listener := newTestServer(t,
+		grpc.ChainUnaryInterceptor(guard.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(guard.StreamServerInterceptor()),
+	)
+
+	t.Run("without solver", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		conn := dial(t, listener)
+
+		_, err := healthV1.NewHealthClient(conn).Check(ctx, &healthV1.HealthCheckRequest{})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = powerV1.NewPowerClient(conn).GetChallenge(ctx, &powerV1.ChallengeRequest{})
+		require.NoError(t, err)
+	})
+
+	t.Run("with solver", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		conn := dial(t, listener, grpc.WithUnaryInterceptor(grpcpow.UnaryClientInterceptor(nil)))
+
+		response, err := healthV1.NewHealthClient(conn).Check(ctx, &healthV1.HealthCheckRequest{})
+		require.NoError(t, err)
+		require.Equal(t, healthV1.HealthCheckResponse_SERVING, response.GetStatus())
+	})
+
+	t.Run("refused by the policy", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		solver := &grpcpow.Solver{Policy: client.SolvePolicy{MaxExpectedAttempts: 1}}
+		conn := dial(t, listener, grpc.WithUnaryInterceptor(grpcpow.UnaryClientInterceptor(solver)))
+
+		_, err := healthV1.NewHealthClient(conn).Check(ctx, &healthV1.HealthCheckRequest{})
+		require.ErrorIs(t, err, client.ErrChallengeRefused)
+	})
+}
@@ -0,0 +1,195 @@
+package grpcpow
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	MetadataChallenge = "x-pow-challenge"
+	MetadataSolution  = "x-pow-solution"
+)
+
+var ErrNoSolution = errors.New("pow solution is required")
+
+type GuardDependencies struct {
+	PowHandler PowHandler `validate:"required"`
+	// Secret signs the issued challenges. All the replicas must share it.
+	Secret []byte        `validate:"required,min=16"`
+	TTL    time.Duration `validate:"gte=0"`
+	// Spent remembers the redeemed challenges, in memory by default. All the replicas must share it too.
+	Spent pow.SpentStore
+	// SkipMethods are the full method names that are served without a challenge.
+	SkipMethods []string
+}
+
+func (d *GuardDependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+}
+
+// Guard protects gRPC services with a challenge carried in metadata.
+//
+// A call without a solution fails with codes.Unauthenticated, the sealed challenge is sent in the
+// x-pow-challenge trailer. The client repeats the call with the x-pow-challenge and x-pow-solution metadata.
+type Guard struct {
+	gate *pow.Gate
+	skip map[string]struct{}
+}
+
+func NewGuard(deps *GuardDependencies) *Guard {
+	deps.SetDefaults()
+
+	skip := make(map[string]struct{}, len(deps.SkipMethods))
+	for _, method := range deps.SkipMethods {
+		skip[method] = struct{}{}
+	}
+	return &Guard{gate: pow.NewGate(deps.PowHandler, deps.Secret, deps.TTL, deps.Spent), skip: skip}
+}
+
+func (g *Guard) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := g.check(ctx, info.FullMethod); err != nil {
+			if tErr := grpc.SetTrailer(ctx, g.challenge(ctx)); tErr != nil {
+				log.WithError(tErr).Warn("set a challenge trailer")
+			}
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (g *Guard) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := g.check(ss.Context(), info.FullMethod); err != nil {
+			ss.SetTrailer(g.challenge(ss.Context()))
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (g *Guard) check(ctx context.Context, fullMethod string) error {
+	if _, ok := g.skip[fullMethod]; ok {
+		return nil
+	}
+
+	err := g.verify(ctx)
+	if err == nil {
+		return nil
+	}
+
+	log.WithError(err).WithField("method", fullMethod).Debug("a challenge is required")
+	return status.Error(codes.Unauthenticated, err.Error())
+}
+
+func (g *Guard) verify(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	sealed := md.Get(MetadataChallenge)
+	solution := md.Get(MetadataSolution)
+	if len(sealed) == 0 || len(solution) == 0 {
+		return ErrNoSolution
+	}
+
+	nonce, err := strconv.Atoi(solution[0])
+	if err != nil {
+		return pow.ErrInvalidSolution
+	}
+	return g.gate.Redeem(sealed[0], nonce) //nolint:wrapcheck // the gate errors are exported
+}
+
+func (g *Guard) challenge(ctx context.Context) metadata.MD {
+	_, sealed := g.gate.Issue(peerAddr(ctx))
+	return metadata.Pairs(MetadataChallenge, sealed)
+}
+
+// SolverHash is an interface that defines the methods for the PoW solver.
+type SolverHash interface {
+	FindNonce(ctx context.Context, hash []byte, byteIndex int, byteValue byte) int
+}
+
+// Solver solves the challenges of the guarded services, the zero value is ready to use.
+type Solver struct {
+	// NewSolver returns the solver for the difficulty sealed in the challenge, pow.NewPow if nil. The
+	// difficulty is 0 if the guard's handler doesn't report it, NewSolver returns the solver of the
+	// guard's difficulty then.
+	NewSolver func(difficulty int) SolverHash
+	// Policy limits the challenges the client agrees to solve, like the one of the TCP client.
+	// The challenges above it fail the call with client.ErrChallengeRefused.
+	Policy client.SolvePolicy
+}
+
+// UnaryClientInterceptor solves the challenge of a guarded service and repeats the call once.
+// A nil solver is the zero Solver.
+func UnaryClientInterceptor(solver *Solver) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		var trailer metadata.MD
+
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+		if status.Code(err) != codes.Unauthenticated || len(trailer.Get(MetadataChallenge)) == 0 {
+			return err
+		}
+
+		solvedCtx, sErr := Solve(ctx, solver, trailer)
+		if sErr != nil {
+			return sErr
+		}
+		return invoker(solvedCtx, method, req, reply, cc, opts...)
+	}
+}
+
+// Solve solves the challenge from the trailer of a rejected call and returns a context for the next call.
+// Streaming clients use it directly, a stream can't be repeated transparently. A nil solver is the zero Solver.
+func Solve(ctx context.Context, solver *Solver, trailer metadata.MD) (context.Context, error) {
+	challenge := trailer.Get(MetadataChallenge)
+	if len(challenge) == 0 {
+		return nil, ErrNoSolution
+	}
+
+	ticket, err := pow.ParseTicket(challenge[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "parse the challenge")
+	}
+
+	if solver == nil {
+		solver = &Solver{}
+	}
+
+	hasher := SolverHash(pow.NewPow(ticket.Difficulty))
+	if solver.NewSolver != nil {
+		hasher = solver.NewSolver(ticket.Difficulty)
+	}
+
+	nonce, err := solver.Policy.Solve(ctx, hasher, &client.Challenge{
+		Algorithm:  pow.AlgorithmSHA256,
+		Difficulty: ticket.Difficulty,
+		Hash:       ticket.Hash,
+		ByteIndex:  ticket.ByteIndex,
+		ByteValue:  ticket.ByteValue,
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the policy errors are exported
+	}
+
+	return metadata.AppendToOutgoingContext(ctx,
+		MetadataChallenge, challenge[0],
+		MetadataSolution, strconv.Itoa(nonce),
+	), nil
+}
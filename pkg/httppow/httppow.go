@@ -0,0 +1,170 @@
+// Package httppow speaks the PoW protocol over HTTP.
+//
+// The middleware answers unauthenticated requests with a sealed challenge in the X-Pow-Challenge header
+// (and a JSON body). The client solves it and repeats the request with the same X-Pow-Challenge header
+// and the found nonce in the X-Pow-Solution header. The challenges are stateless: they're signed with
+// a secret key, only the redeemed ones are remembered until they expire to prevent replays, see
+// Dependencies.Spent.
+package httppow
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderChallenge = "X-Pow-Challenge"
+	HeaderSolution  = "X-Pow-Solution"
+
+	DefaultTTL = pow.DefaultTicketTTL
+)
+
+var (
+	ErrNoSolution      = errors.New("solution is required")
+	ErrInvalidSolution = pow.ErrInvalidSolution
+	ErrReplayed        = pow.ErrReplayed
+)
+
+// PowHandler is an interface that defines the methods for the PoW handler.
+type PowHandler interface {
+	GenerateHash(msg []byte, nonce int) []byte
+	IsValidHash(hash []byte, byteIndex int, byteValue byte) bool
+	GetClientConditions(clientAddr net.Addr) (byteIndex int, byteValue byte)
+}
+
+type Dependencies struct {
+	PowHandler PowHandler `validate:"required"`
+	// Secret signs the issued challenges. All the replicas behind a balancer must share it.
+	Secret []byte `validate:"required,min=16"`
+	// Spent remembers the redeemed challenges until they expire, in the memory of this process by default.
+	// The replicas behind a balancer must share it as well, or a challenge is redeemed once on each of them.
+	Spent pow.SpentStore
+	// TTL is the lifetime of a challenge.
+	TTL time.Duration `validate:"gte=0"`
+	// Status is the response code of the challenge response: 401 (default) or 429.
+	Status int `validate:"omitempty,oneof=401 429"`
+}
+
+func (d *Dependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+
+	if d.TTL == 0 {
+		d.TTL = DefaultTTL
+	}
+	if d.Status == 0 {
+		d.Status = http.StatusUnauthorized
+	}
+}
+
+// Challenge is the JSON body of the challenge response.
+type Challenge struct {
+	Challenge string    `json:"challenge"`
+	Hash      string    `json:"hash"`
+	ByteIndex int       `json:"byte_index"`
+	ByteValue byte      `json:"byte_value"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Difficulty and Algorithm are set if the handler reports its difficulty, it's sealed in the challenge.
+	Difficulty int    `json:"difficulty,omitempty"`
+	Algorithm  string `json:"algorithm,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type Middleware struct {
+	gate   *pow.Gate
+	status int
+}
+
+func New(deps *Dependencies) *Middleware {
+	deps.SetDefaults()
+
+	return &Middleware{
+		gate:   pow.NewGate(deps.PowHandler, deps.Secret, deps.TTL, deps.Spent),
+		status: deps.Status,
+	}
+}
+
+// Wrap returns a handler that calls next only for the requests with a valid solution.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.verify(r); err != nil {
+			log.WithError(err).WithField("remote", r.RemoteAddr).Debug("a challenge is required")
+			m.writeChallenge(w, r, m.status, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ChallengeHandler issues a challenge on every request, so clients can solve it in advance.
+func (m *Middleware) ChallengeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.writeChallenge(w, r, http.StatusOK, nil)
+	})
+}
+
+func (m *Middleware) verify(r *http.Request) error {
+	sealed := r.Header.Get(HeaderChallenge)
+	solution := r.Header.Get(HeaderSolution)
+	if sealed == "" || solution == "" {
+		return ErrNoSolution
+	}
+
+	nonce, err := strconv.Atoi(solution)
+	if err != nil {
+		return ErrInvalidSolution
+	}
+	return m.gate.Redeem(sealed, nonce) //nolint:wrapcheck // the gate errors are exported
+}
+
+func (m *Middleware) writeChallenge(w http.ResponseWriter, r *http.Request, status int, cause error) {
+	ticket, sealed := m.gate.Issue(remoteAddr(r.RemoteAddr))
+
+	body := Challenge{
+		Challenge: sealed,
+		Hash:      hex.EncodeToString(ticket.Hash),
+		ByteIndex: ticket.ByteIndex,
+		ByteValue: ticket.ByteValue,
+		ExpiresAt: ticket.ExpiresAt,
+	}
+	if ticket.Difficulty > 0 {
+		body.Difficulty, body.Algorithm = ticket.Difficulty, pow.AlgorithmSHA256
+	}
+
+	if cause != nil && !errors.Is(cause, ErrNoSolution) {
+		body.Error = cause.Error()
+	}
+
+	w.Header().Set("WWW-Authenticate", "PoW")
+	w.Header().Set(HeaderChallenge, body.Challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.WithError(err).Warn("write a challenge")
+	}
+}
+
+// remoteAddr adapts http.Request.RemoteAddr to net.Addr.
+type remoteAddr string
+
+func (a remoteAddr) Network() string {
+	return "tcp"
+}
+
+func (a remoteAddr) String() string {
+	return string(a)
+}
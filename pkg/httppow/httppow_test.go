@@ -0,0 +1,188 @@
+package httppow_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+	"github.com/kriuchkov/power/pkg/httppow"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	middleware := httppow.New(&httppow.Dependencies{
+		PowHandler: pow.NewPow(1),
+		Secret:     []byte("0123456789abcdef"),
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/challenge", middleware.ChallengeHandler())
+	mux.Handle("/", middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte("protected:"), body...))
+	})))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get(httppow.HeaderChallenge))
+
+	var challenge httppow.Challenge
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+	require.Equal(t, resp.Header.Get(httppow.HeaderChallenge), challenge.Challenge)
+	require.Empty(t, challenge.Error)
+
+	ticket, err := pow.ParseTicket(challenge.Challenge)
+	require.NoError(t, err)
+
+	solver := pow.NewPow(1)
+	nonce := solver.FindNonce(context.Background(), ticket.Hash, ticket.ByteIndex, ticket.ByteValue)
+
+	tests := []struct {
+		name           string
+		solution       string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "invalid solution",
+			solution:       "not a nonce",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  httppow.ErrInvalidSolution.Error(),
+		},
+		{
+			name:           "valid solution",
+			solution:       strconv.Itoa(nonce),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "replayed solution",
+			solution:       strconv.Itoa(nonce),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  httppow.ErrReplayed.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+
+		req.Header.Set(httppow.HeaderChallenge, challenge.Challenge)
+		req.Header.Set(httppow.HeaderSolution, tt.solution)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err, tt.name)
+
+		var response httppow.Challenge
+		if tt.expectedStatus != http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&response), tt.name)
+		}
+		resp.Body.Close()
+
+		require.Equal(t, tt.expectedStatus, resp.StatusCode, tt.name)
+		require.Equal(t, tt.expectedError, response.Error, tt.name)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	client := &http.Client{Transport: &httppow.Transport{}}
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		expected string
+	}{
+		{name: "get", method: http.MethodGet, expected: "protected:"},
+		{name: "post", method: http.MethodPost, body: "payload", expected: "protected:payload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, srv.URL, strings.NewReader(tt.body))
+			require.NoError(t, err)
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, tt.expected, string(body))
+		})
+	}
+}
+
+func TestTransport_Difficulty(t *testing.T) {
+	t.Parallel()
+
+	handler := pow.NewPow(2)
+	middleware := httppow.New(&httppow.Dependencies{PowHandler: handler, Secret: []byte("0123456789abcdef")})
+
+	// the middleware is created with 2, the challenges are solved with the changed difficulty
+	handler.SetDifficulty(1)
+
+	srv := httptest.NewServer(middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("protected"))
+	})))
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+
+	var challenge httppow.Challenge
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+	resp.Body.Close()
+	require.Equal(t, 1, challenge.Difficulty)
+
+	var difficulties []int
+	client := &http.Client{Transport: &httppow.Transport{NewSolver: func(difficulty int) httppow.SolverHash {
+		difficulties = append(difficulties, difficulty)
+		return pow.NewPow(difficulty)
+	}}}
+
+	resp, err = client.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []int{1}, difficulties)
+}
+
+func TestTransport_Policy(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	transport := &httppow.Transport{Policy: client.SolvePolicy{MaxExpectedAttempts: 1}}
+
+	_, err := (&http.Client{Transport: transport}).Get(srv.URL)
+	require.ErrorIs(t, err, client.ErrChallengeRefused)
+}
@@ -0,0 +1,113 @@
+package httppow
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrNotRewindable = errors.New("request body can't be sent twice")
+
+// SolverHash is an interface that defines the methods for the PoW solver.
+type SolverHash interface {
+	FindNonce(ctx context.Context, hash []byte, byteIndex int, byteValue byte) int
+}
+
+// Transport is an http.RoundTripper that solves the challenges automatically.
+// A request that is answered with a challenge is repeated once with the solution.
+type Transport struct {
+	// Base is the underlying round tripper, http.DefaultTransport if nil.
+	Base http.RoundTripper
+	// Solver solves the challenges without a difficulty, its difficulty has to match the server's one.
+	Solver SolverHash
+	// NewSolver returns the solver for the difficulty of the challenge, pow.NewPow if nil.
+	NewSolver func(difficulty int) SolverHash
+	// Policy limits the challenges the transport agrees to solve, like the one of the TCP client.
+	// The challenges above it fail the request with client.ErrChallengeRefused.
+	Policy client.SolvePolicy
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the error of the base transport is returned as is
+	}
+
+	sealed := resp.Header.Get(HeaderChallenge)
+	if sealed == "" || !isChallengeStatus(resp.StatusCode) {
+		return resp, nil
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil // the caller gets the challenge response, see ErrNotRewindable
+	}
+
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck // drain the body to reuse the connection
+	resp.Body.Close()
+
+	solution, err := t.solve(req.Context(), sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, errors.Wrap(ErrNotRewindable, err.Error())
+		}
+	}
+
+	retry.Header.Set(HeaderChallenge, sealed)
+	retry.Header.Set(HeaderSolution, solution)
+	return t.base().RoundTrip(retry)
+}
+
+func (t *Transport) solve(ctx context.Context, sealed string) (string, error) {
+	ticket, err := pow.ParseTicket(sealed)
+	if err != nil {
+		return "", errors.Wrap(err, "parse the challenge")
+	}
+
+	nonce, err := t.Policy.Solve(ctx, t.solver(ticket.Difficulty), &client.Challenge{
+		Algorithm:  pow.AlgorithmSHA256,
+		Difficulty: ticket.Difficulty,
+		Hash:       ticket.Hash,
+		ByteIndex:  ticket.ByteIndex,
+		ByteValue:  ticket.ByteValue,
+	})
+	if err != nil {
+		return "", err //nolint:wrapcheck // the policy errors are exported
+	}
+
+	log.WithField("nonce", nonce).Debug("found nonce")
+	return strconv.Itoa(nonce), nil
+}
+
+func (t *Transport) solver(difficulty int) SolverHash {
+	switch {
+	case difficulty == 0 && t.Solver != nil:
+		return t.Solver
+	case t.NewSolver != nil:
+		return t.NewSolver(difficulty)
+	default:
+		return pow.NewPow(difficulty)
+	}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func isChallengeStatus(code int) bool {
+	return code == http.StatusUnauthorized || code == http.StatusTooManyRequests
+}
@@ -0,0 +1,298 @@
+// Package policy sets the puzzle parameters of every challenge by a declarative rule set.
+//
+// A rule set is YAML, the rules are applied in order to the base difficulty of the server:
+//
+//	dry_run: false            # log the matched rules and the result, but issue the base difficulty
+//	rules:
+//	  - name: internal
+//	    match: {prefixes: [10.0.0.0/8]}
+//	    difficulty: 0         # set the difficulty, "min" and "max" are the bounds of the server
+//	    stop: true            # skip the next rules
+//	  - name: evening
+//	    match: {hours: "18:00-22:00"}  # UTC, it may wrap over midnight
+//	    add: 2
+//	  - name: suspicious
+//	    match: {score_below: 0.3}      # the reputation score, see package reputation
+//	    difficulty: max
+//	  - name: premium
+//	    match: {resources: [premium]}  # the resource the client asks for
+//	    difficulty: 3
+//	  - name: hosting
+//	    match: {countries: [XX], asns: [64496]}  # see package geoip
+//	    add: 1
+//
+// The conditions of a rule all have to match, a rule without conditions always matches.
+// The score conditions never match a client without a score.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/common"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidRules is returned for a malformed rule set.
+var ErrInvalidRules = errors.New("invalid rule set")
+
+// Request is what the rules know about a challenge request.
+type Request struct {
+	Addr netip.Addr
+	Time time.Time
+	// Resource is the name the client asks for, e.g. the body of the Connect message.
+	Resource string
+	// Country and ASN are the geoip enrichment of the address, zero if unknown, see package geoip.
+	Country string
+	ASN     uint32
+	// Score is the reputation score, it's valid only if HasScore.
+	Score    float64
+	HasScore bool
+}
+
+// Params are the puzzle parameters of a challenge.
+type Params struct {
+	Difficulty int
+}
+
+// Bounds are the lowest and the highest difficulty, the "min" and "max" levels of the rules.
+type Bounds struct {
+	Min, Max int
+}
+
+// Level is the difficulty of a rule: a number of the '0' bytes, "min" or "max".
+type Level struct {
+	value int
+	bound string
+}
+
+func (l *Level) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Value {
+	case "min", "max":
+		l.bound = node.Value
+		return nil
+	}
+
+	value, err := strconv.Atoi(node.Value)
+	if err != nil || value < 0 {
+		return errors.Errorf("line %d: the difficulty is a non-negative number, min or max, got %q", node.Line, node.Value)
+	}
+	l.value = value
+	return nil
+}
+
+func (l *Level) resolve(bounds Bounds) int {
+	switch l.bound {
+	case "min":
+		return bounds.Min
+	case "max":
+		return bounds.Max
+	default:
+		return l.value
+	}
+}
+
+func (l *Level) String() string {
+	if l.bound != "" {
+		return l.bound
+	}
+	return strconv.Itoa(l.value)
+}
+
+// Match are the conditions of a rule.
+type Match struct {
+	Prefixes   []string `yaml:"prefixes"`
+	Hours      string   `yaml:"hours"`
+	ScoreBelow *float64 `yaml:"score_below"`
+	ScoreAbove *float64 `yaml:"score_above"`
+	Resources  []string `yaml:"resources"`
+	// Countries are the ISO 3166-1 alpha-2 codes, ASNs the autonomous system numbers. They never
+	// match an address without the geoip data.
+	Countries []string `yaml:"countries"`
+	ASNs      []uint32 `yaml:"asns"`
+}
+
+// Rule changes the difficulty of the matched requests: Difficulty sets it, then Add adds to it.
+type Rule struct {
+	Name       string `yaml:"name"`
+	Match      Match  `yaml:"match"`
+	Difficulty *Level `yaml:"difficulty"`
+	Add        int    `yaml:"add"`
+	Stop       bool   `yaml:"stop"`
+
+	prefixes []netip.Prefix
+	// from and to are the minutes of the day of Match.Hours, to is exclusive
+	from, to int
+}
+
+// RuleSet is the parsed rule set, it's immutable and safe for concurrent use.
+type RuleSet struct {
+	DryRun bool   `yaml:"dry_run"`
+	Rules  []Rule `yaml:"rules"`
+}
+
+// Parse parses and checks a rule set.
+func Parse(data []byte) (*RuleSet, error) {
+	var set RuleSet
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// an empty file is an empty rule set
+	if err := decoder.Decode(&set); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(ErrInvalidRules, err.Error())
+	}
+
+	for i := range set.Rules {
+		rule := &set.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, errors.Wrapf(ErrInvalidRules, "rule %s: %s", rule.Name, err)
+		}
+	}
+	return &set, nil
+}
+
+// Load reads and parses the rule set file.
+func Load(name string) (*RuleSet, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read rule set")
+	}
+	return Parse(data)
+}
+
+func (r *Rule) compile() error {
+	if r.Difficulty == nil && r.Add == 0 && !r.Stop {
+		return errors.New("the rule changes nothing")
+	}
+
+	for _, s := range r.Match.Prefixes {
+		prefix, err := common.ParsePrefix(s)
+		if err != nil {
+			return errors.Wrapf(err, "prefix %q", s)
+		}
+		r.prefixes = append(r.prefixes, prefix)
+	}
+
+	if r.Match.Hours != "" {
+		from, to, ok := strings.Cut(r.Match.Hours, "-")
+		if !ok {
+			return errors.Errorf("hours %q aren't HH:MM-HH:MM", r.Match.Hours)
+		}
+
+		var err error
+		if r.from, err = parseMinutes(from); err != nil {
+			return err
+		}
+		if r.to, err = parseMinutes(to); err != nil {
+			return err
+		}
+		if r.from == r.to {
+			return errors.Errorf("hours %q are empty", r.Match.Hours)
+		}
+	}
+	return nil
+}
+
+// parseMinutes parses HH:MM into the minutes of the day, 24:00 is the end of the day.
+func parseMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err == nil {
+		return t.Hour()*60 + t.Minute(), nil
+	}
+	if strings.TrimSpace(s) == "24:00" {
+		return 24 * 60, nil
+	}
+	return 0, errors.Errorf("invalid time %q, HH:MM is expected", s)
+}
+
+func (r *Rule) matches(req *Request) bool {
+	m := &r.Match
+
+	if len(r.prefixes) > 0 && !slices.ContainsFunc(r.prefixes, func(p netip.Prefix) bool { return p.Contains(req.Addr.Unmap()) }) {
+		return false
+	}
+
+	if m.Hours != "" {
+		utc := req.Time.UTC()
+		minute := utc.Hour()*60 + utc.Minute()
+		if r.from <= r.to && (minute < r.from || minute >= r.to) {
+			return false
+		}
+		if r.from > r.to && minute < r.from && minute >= r.to {
+			return false
+		}
+	}
+
+	if m.ScoreBelow != nil && (!req.HasScore || req.Score >= *m.ScoreBelow) {
+		return false
+	}
+	if m.ScoreAbove != nil && (!req.HasScore || req.Score <= *m.ScoreAbove) {
+		return false
+	}
+
+	if len(m.Resources) > 0 && !slices.Contains(m.Resources, req.Resource) {
+		return false
+	}
+	if len(m.Countries) > 0 && (req.Country == "" ||
+		!slices.ContainsFunc(m.Countries, func(c string) bool { return strings.EqualFold(c, req.Country) })) {
+		return false
+	}
+	if len(m.ASNs) > 0 && (req.ASN == 0 || !slices.Contains(m.ASNs, req.ASN)) {
+		return false
+	}
+	return true
+}
+
+// Evaluate applies the rules to the base parameters and returns the result clamped into the bounds with
+// the names of the matched rules. In the dry-run mode the result is only logged and base is returned.
+func (s *RuleSet) Evaluate(req *Request, base Params, bounds Bounds) (Params, []string) {
+	params := base
+
+	var matched []string
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		if !rule.matches(req) {
+			continue
+		}
+
+		matched = append(matched, rule.Name)
+		if rule.Difficulty != nil {
+			params.Difficulty = rule.Difficulty.resolve(bounds)
+		}
+		params.Difficulty += rule.Add
+
+		if rule.Stop {
+			break
+		}
+	}
+	params.Difficulty = min(max(params.Difficulty, bounds.Min), bounds.Max)
+
+	if s.DryRun {
+		if len(matched) > 0 {
+			log.WithFields(log.Fields{
+				"addr": req.Addr, "resource": req.Resource, "rules": matched, "difficulty": params.Difficulty,
+			}).Info("dry run: the rules match")
+		}
+		return base, matched
+	}
+
+	if len(matched) > 0 {
+		log.WithFields(log.Fields{
+			"addr": req.Addr, "rules": matched, "difficulty": params.Difficulty,
+		}).Debug("the rules match")
+	}
+	return params, matched
+}
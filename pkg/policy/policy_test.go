@@ -0,0 +1,183 @@
+package policy_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/policy"
+
+	"github.com/stretchr/testify/require"
+)
+
+const rules = `
+rules:
+  - name: internal
+    match: {prefixes: [10.0.0.0/8, 192.0.2.1, "::ffff:203.0.113.0/120"]}
+    difficulty: min
+    stop: true
+  - name: night
+    match: {hours: "22:00-06:00"}
+    add: 1
+  - name: suspicious
+    match: {score_below: -0.5}
+    difficulty: max
+  - name: trusted
+    match: {score_above: 0.5}
+    add: -1
+  - name: premium
+    match: {resources: [premium]}
+    difficulty: 3
+  - name: hosting
+    match: {countries: [nl], asns: [64496]}
+    add: 2
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{name: "valid", rules: rules},
+		{name: "empty", rules: ""},
+		{name: "unknown key", rules: "rules: [{name: a, add: 1, weight: 2}]", err: "weight"},
+		{name: "no effect", rules: "rules: [{name: a}]", err: "rule a: the rule changes nothing"},
+		{name: "bad prefix", rules: "rules: [{match: {prefixes: [10.0.0.0/33]}, add: 1}]", err: "rule #1: prefix"},
+		{name: "bad level", rules: "rules: [{difficulty: hard}]", err: "min or max"},
+		{name: "bad hours", rules: "rules: [{match: {hours: '18:00'}, add: 1}]", err: "HH:MM-HH:MM"},
+		{name: "bad time", rules: "rules: [{match: {hours: '18:00-25:00'}, add: 1}]", err: "invalid time"},
+		{name: "empty hours", rules: "rules: [{match: {hours: '18:00-18:00'}, add: 1}]", err: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := policy.Parse([]byte(tt.rules))
+			if tt.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, policy.ErrInvalidRules)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestRuleSet_Evaluate(t *testing.T) {
+	t.Parallel()
+
+	set, err := policy.Parse([]byte(rules))
+	require.NoError(t, err)
+
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 1, 1, 2, 30, 0, 0, time.UTC)
+	client := netip.MustParseAddr("198.51.100.1")
+	bounds := policy.Bounds{Min: 1, Max: 6}
+
+	tests := []struct {
+		name       string
+		req        policy.Request
+		difficulty int
+		matched    []string
+	}{
+		{name: "no match", req: policy.Request{Addr: client, Time: noon}, difficulty: 4},
+		{
+			name:       "internal stops",
+			req:        policy.Request{Addr: netip.MustParseAddr("10.1.2.3"), Time: night, Resource: "premium"},
+			difficulty: 1,
+			matched:    []string{"internal"},
+		},
+		{
+			name:       "mapped address",
+			req:        policy.Request{Addr: netip.MustParseAddr("::ffff:192.0.2.1"), Time: noon},
+			difficulty: 1,
+			matched:    []string{"internal"},
+		},
+		{
+			name:       "mapped prefix",
+			req:        policy.Request{Addr: netip.MustParseAddr("203.0.113.7"), Time: noon},
+			difficulty: 1,
+			matched:    []string{"internal"},
+		},
+		{
+			name:       "wrapped hours",
+			req:        policy.Request{Addr: client, Time: night},
+			difficulty: 5,
+			matched:    []string{"night"},
+		},
+		{
+			name:       "suspicious at night",
+			req:        policy.Request{Addr: client, Time: night, Score: -0.8, HasScore: true},
+			difficulty: 6,
+			matched:    []string{"night", "suspicious"},
+		},
+		{
+			name:       "trusted",
+			req:        policy.Request{Addr: client, Time: noon, Score: 0.8, HasScore: true},
+			difficulty: 3,
+			matched:    []string{"trusted"},
+		},
+		{
+			name:       "no score",
+			req:        policy.Request{Addr: client, Time: noon, Score: -0.8},
+			difficulty: 4,
+		},
+		{
+			name:       "resource",
+			req:        policy.Request{Addr: client, Time: noon, Resource: "premium", Score: 0.8, HasScore: true},
+			difficulty: 3,
+			matched:    []string{"trusted", "premium"},
+		},
+		{
+			name:       "geoip",
+			req:        policy.Request{Addr: client, Time: noon, Country: "NL", ASN: 64496},
+			difficulty: 6,
+			matched:    []string{"hosting"},
+		},
+		{
+			name:       "other asn",
+			req:        policy.Request{Addr: client, Time: noon, Country: "NL", ASN: 64497},
+			difficulty: 4,
+		},
+		{
+			name:       "no geoip",
+			req:        policy.Request{Addr: client, Time: noon, ASN: 64496},
+			difficulty: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			params, matched := set.Evaluate(&tt.req, policy.Params{Difficulty: 4}, bounds)
+			require.Equal(t, tt.difficulty, params.Difficulty)
+			require.Equal(t, tt.matched, matched)
+		})
+	}
+}
+
+func TestRuleSet_EvaluateClamp(t *testing.T) {
+	t.Parallel()
+
+	set, err := policy.Parse([]byte("rules: [{add: 10}]"))
+	require.NoError(t, err)
+
+	params, _ := set.Evaluate(&policy.Request{}, policy.Params{Difficulty: 4}, policy.Bounds{Min: 1, Max: 6})
+	require.Equal(t, 6, params.Difficulty)
+}
+
+func TestRuleSet_DryRun(t *testing.T) {
+	t.Parallel()
+
+	set, err := policy.Parse([]byte("dry_run: true\nrules: [{name: all, difficulty: max}]"))
+	require.NoError(t, err)
+
+	params, matched := set.Evaluate(&policy.Request{}, policy.Params{Difficulty: 2}, policy.Bounds{Min: 0, Max: 6})
+	require.Equal(t, 2, params.Difficulty)
+	require.Equal(t, []string{"all"}, matched)
+}
@@ -0,0 +1,365 @@
+// Package privacypass issues and redeems the anonymous tokens of the clients which solved a challenge,
+// Privacy Pass-style.
+//
+// A client which solves a challenge gets a batch of tokens signed blindly with RSA (RSABSSA-SHA384-PSS-
+// Deterministic, RFC 9474): the issuer signs the blinded messages and never sees the tokens, so a
+// redemption can't be linked to the solution, the connection or the address it was issued to. The token
+// layout follows the publicly verifiable tokens of RFC 9578:
+//
+//	token_type (0x0002) | nonce (32) | challenge_digest (32) | token_key_id (32) | authenticator (Nk)
+//
+// The challenge is the issuer name with the epoch of the Store as the redemption context, so the tokens
+// of an issuer epoch are interchangeable. Every token is redeemed once, the issuer keeps the spent nonces
+// of the epoch in the Store and refuses the tokens of the past epochs. The epoch is the same for all the
+// clients, the issuer publishes it with the key.
+package privacypass
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+
+	"github.com/cloudflare/circl/blindsign/blindrsa"
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// TokenType is the publicly verifiable token type of RFC 9578.
+	TokenType = 0x0002
+
+	NonceSize  = 32
+	digestSize = sha256.Size
+	KeyIDSize  = sha256.Size
+
+	// MinKeyBits is the smallest RSA key of an issuer.
+	MinKeyBits = 2048
+
+	DefaultMaxBatch = 10
+	// MaxBatch bounds the batch size of any issuer, a blind signature is a private key operation.
+	MaxBatch = 100
+
+	variant   = blindrsa.SHA384PSSDeterministic
+	inputSize = 2 + NonceSize + digestSize + KeyIDSize
+)
+
+var (
+	ErrMalformed   = errors.New("malformed token")
+	ErrUnknownKey  = errors.New("token of another issuer key or epoch")
+	ErrForged      = errors.New("token signature mismatch")
+	ErrDoubleSpend = errors.New("token is already spent")
+	ErrBatch       = errors.New("invalid token batch")
+)
+
+// Token is a finalized token, it's redeemed in the Marshal form.
+type Token struct {
+	Nonce           [NonceSize]byte
+	ChallengeDigest [digestSize]byte
+	KeyID           [KeyIDSize]byte
+	Authenticator   []byte
+}
+
+// input returns the signed part of the token.
+func (t *Token) input() []byte {
+	input := make([]byte, 0, inputSize)
+	input = binary.BigEndian.AppendUint16(input, TokenType)
+	input = append(input, t.Nonce[:]...)
+	input = append(input, t.ChallengeDigest[:]...)
+	return append(input, t.KeyID[:]...)
+}
+
+func (t *Token) Marshal() []byte {
+	return append(t.input(), t.Authenticator...)
+}
+
+// ParseToken decodes a token of the key of size bytes.
+func ParseToken(data []byte, size int) (Token, error) {
+	if len(data) != inputSize+size || binary.BigEndian.Uint16(data) != TokenType {
+		return Token{}, ErrMalformed
+	}
+
+	var t Token
+	data = data[2:]
+	data = data[copy(t.Nonce[:], data):]
+	data = data[copy(t.ChallengeDigest[:], data):]
+	data = data[copy(t.KeyID[:], data):]
+	t.Authenticator = data
+	return t, nil
+}
+
+// challengeDigest returns the digest of the TokenChallenge (RFC 9577) of the issuer name with the epoch
+// as the redemption context and without the origin info.
+func challengeDigest(issuer string, epoch [EpochSize]byte) [digestSize]byte {
+	challenge := binary.BigEndian.AppendUint16(nil, TokenType)
+	challenge = binary.BigEndian.AppendUint16(challenge, uint16(len(issuer))) //nolint:gosec // the name is validated
+	challenge = append(challenge, issuer...)
+	challenge = append(challenge, EpochSize)
+	challenge = append(challenge, epoch[:]...) // redemption_context
+	challenge = append(challenge, 0, 0)        // origin_info
+	return sha256.Sum256(challenge)
+}
+
+// keyID returns the ID of the public key: SHA-256 of its PKIX DER form.
+func keyID(der []byte) [KeyIDSize]byte {
+	return sha256.Sum256(der)
+}
+
+// ReadKey reads the RSA private key of an issuer from a PEM file, PKCS #1 or PKCS #8.
+func ReadKey(name string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read key")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("%s isn't PEM", name)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse key")
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("%s isn't an RSA key", name)
+	}
+	return key, nil
+}
+
+type Dependencies struct {
+	Key *rsa.PrivateKey `validate:"required"`
+	// Name is the issuer name in the token challenge, the clients get it with the public key.
+	Name string `validate:"required,max=255"`
+	// Store keeps the spent tokens and their epoch, a MemoryStore of DefaultCapacity by default.
+	Store Store
+	// MaxBatch is the number of the tokens a solution buys, DefaultMaxBatch by default.
+	MaxBatch int `validate:"gte=0,lte=100"`
+}
+
+func (d *Dependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+
+	if d.Store == nil {
+		d.Store = NewMemoryStore(DefaultCapacity)
+	}
+	if d.MaxBatch == 0 {
+		d.MaxBatch = DefaultMaxBatch
+	}
+}
+
+// Issuer signs the blinded tokens and redeems the finalized ones. It's safe for concurrent use
+// as long as the store is.
+type Issuer struct {
+	signer    blindrsa.Signer
+	verifier  blindrsa.Verifier
+	publicKey []byte
+	keyID     [KeyIDSize]byte
+	modulus   *big.Int
+	size      int
+	name      string
+	store     Store
+	maxBatch  int
+}
+
+func NewIssuer(deps *Dependencies) (*Issuer, error) {
+	deps.SetDefaults()
+
+	if bits := deps.Key.N.BitLen(); bits < MinKeyBits {
+		return nil, errors.Errorf("the key has %d bits, at least %d are needed", bits, MinKeyBits)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&deps.Key.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal public key")
+	}
+
+	verifier, err := blindrsa.NewVerifier(variant, &deps.Key.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "create verifier")
+	}
+
+	return &Issuer{
+		signer:    blindrsa.NewSigner(deps.Key),
+		verifier:  verifier,
+		publicKey: publicKey,
+		keyID:     keyID(publicKey),
+		modulus:   deps.Key.N,
+		size:      deps.Key.Size(),
+		name:      deps.Name,
+		store:     deps.Store,
+		maxBatch:  deps.MaxBatch,
+	}, nil
+}
+
+// PublicKey returns the PKIX DER form of the public key.
+func (i *Issuer) PublicKey() []byte {
+	return i.publicKey
+}
+
+func (i *Issuer) Name() string {
+	return i.name
+}
+
+// Epoch returns the epoch the tokens are issued in, the clients blind the tokens with it.
+func (i *Issuer) Epoch() [EpochSize]byte {
+	return i.store.Epoch()
+}
+
+func (i *Issuer) MaxBatch() int {
+	return i.maxBatch
+}
+
+// CheckBatch checks a batch of the blinded token requests without signing it, Sign refuses the batches
+// it returns ErrBatch for.
+func (i *Issuer) CheckBatch(blinded [][]byte) error {
+	if len(blinded) == 0 || len(blinded) > i.maxBatch {
+		return errors.Wrapf(ErrBatch, "%d tokens, from 1 to %d are issued", len(blinded), i.maxBatch)
+	}
+	for _, request := range blinded {
+		if len(request) != i.size {
+			return errors.Wrapf(ErrBatch, "a request of %d bytes, %d are expected", len(request), i.size)
+		}
+		if new(big.Int).SetBytes(request).Cmp(i.modulus) >= 0 {
+			return errors.Wrap(ErrBatch, "a request out of the key range")
+		}
+	}
+	return nil
+}
+
+// Sign signs a batch of the blinded token requests, up to MaxBatch of them.
+func (i *Issuer) Sign(blinded [][]byte) ([][]byte, error) {
+	if err := i.CheckBatch(blinded); err != nil {
+		return nil, err
+	}
+
+	signatures := make([][]byte, 0, len(blinded))
+	for _, request := range blinded {
+		signature, err := i.signer.BlindSign(request)
+		if err != nil {
+			return nil, errors.Wrap(ErrBatch, err.Error())
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
+
+// Redeem verifies a token of the current epoch and marks it spent, the tokens of the past epochs
+// return ErrUnknownKey, or ErrStaleEpoch if the epoch ends meanwhile.
+func (i *Issuer) Redeem(data []byte) error {
+	t, err := ParseToken(data, i.size)
+	if err != nil {
+		return err
+	}
+	epoch := i.store.Epoch()
+	if t.KeyID != i.keyID || t.ChallengeDigest != challengeDigest(i.name, epoch) {
+		return ErrUnknownKey
+	}
+	if err = i.verifier.Verify(t.input(), t.Authenticator); err != nil {
+		return ErrForged
+	}
+
+	fresh, err := i.store.Spend(epoch, t.Nonce)
+	if err != nil {
+		return errors.Wrap(err, "spend token")
+	}
+	if !fresh {
+		return ErrDoubleSpend
+	}
+	return nil
+}
+
+// Client blinds the token requests and finalizes the signed ones for an issuer.
+type Client struct {
+	client blindrsa.Client
+	keyID  [KeyIDSize]byte
+	digest [digestSize]byte
+	size   int
+}
+
+// Pending is a blinded token request waiting for its signature.
+type Pending struct {
+	token Token
+	state blindrsa.State
+}
+
+// NewClient returns a client of the issuer by its public key, name and epoch.
+func NewClient(publicKey []byte, name string, epoch []byte) (*Client, error) {
+	if len(epoch) != EpochSize {
+		return nil, errors.Errorf("the epoch has %d bytes, %d are expected", len(epoch), EpochSize)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse public key")
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok || key.N.BitLen() < MinKeyBits {
+		return nil, errors.Errorf("the issuer key isn't an RSA key of at least %d bits", MinKeyBits)
+	}
+
+	client, err := blindrsa.NewClient(variant, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "create client")
+	}
+	return &Client{
+		client: client,
+		keyID:  keyID(publicKey),
+		digest: challengeDigest(name, [EpochSize]byte(epoch)),
+		size:   key.Size(),
+	}, nil
+}
+
+// Blind returns n blinded token requests to send to the issuer and their pending state.
+func (c *Client) Blind(n int) ([][]byte, []Pending, error) {
+	blinded := make([][]byte, 0, n)
+	pending := make([]Pending, 0, n)
+
+	for range n {
+		p := Pending{token: Token{ChallengeDigest: c.digest, KeyID: c.keyID}}
+		rand.Read(p.token.Nonce[:]) //nolint:errcheck // crypto/rand.Read never fails
+
+		request, state, err := c.client.Blind(rand.Reader, p.token.input())
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "blind")
+		}
+		p.state = state
+
+		blinded = append(blinded, request)
+		pending = append(pending, p)
+	}
+	return blinded, pending, nil
+}
+
+// Finalize unblinds the signatures of the issuer and returns the tokens, a wrong signature fails
+// the batch.
+func (c *Client) Finalize(pending []Pending, signatures [][]byte) ([]Token, error) {
+	if len(signatures) != len(pending) {
+		return nil, errors.Wrapf(ErrBatch, "%d signatures of %d requests", len(signatures), len(pending))
+	}
+
+	tokens := make([]Token, 0, len(pending))
+	for i, p := range pending {
+		authenticator, err := c.client.Finalize(p.state, signatures[i])
+		if err != nil {
+			return nil, errors.Wrap(ErrForged, err.Error())
+		}
+
+		t := p.token
+		t.Authenticator = authenticator
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
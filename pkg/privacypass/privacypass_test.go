@@ -0,0 +1,193 @@
+package privacypass_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/kriuchkov/power/pkg/privacypass"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newIssuer(t *testing.T, deps *privacypass.Dependencies) *privacypass.Issuer {
+	t.Helper()
+
+	key, err := privacypass.ReadKey("testdata/issuer.pem")
+	require.NoError(t, err)
+
+	deps.Key = key
+	issuer, err := privacypass.NewIssuer(deps)
+	require.NoError(t, err)
+	return issuer
+}
+
+func issue(t *testing.T, issuer *privacypass.Issuer, name string, n int) []privacypass.Token {
+	t.Helper()
+
+	epoch := issuer.Epoch()
+	client, err := privacypass.NewClient(issuer.PublicKey(), name, epoch[:])
+	require.NoError(t, err)
+
+	blinded, pending, err := client.Blind(n)
+	require.NoError(t, err)
+	signatures, err := issuer.Sign(blinded)
+	require.NoError(t, err)
+	tokens, err := client.Finalize(pending, signatures)
+	require.NoError(t, err)
+	return tokens
+}
+
+func TestIssuer(t *testing.T) {
+	t.Parallel()
+
+	issuer := newIssuer(t, &privacypass.Dependencies{Name: "power"})
+	require.Equal(t, privacypass.DefaultMaxBatch, issuer.MaxBatch())
+
+	tokens := issue(t, issuer, "power", 3)
+	require.Len(t, tokens, 3)
+	require.NotEqual(t, tokens[0].Nonce, tokens[1].Nonce)
+
+	for _, token := range tokens {
+		require.NoError(t, issuer.Redeem(token.Marshal()))
+	}
+	require.ErrorIs(t, issuer.Redeem(tokens[0].Marshal()), privacypass.ErrDoubleSpend)
+
+	parsed, err := privacypass.ParseToken(tokens[1].Marshal(), len(tokens[1].Authenticator))
+	require.NoError(t, err)
+	require.Equal(t, tokens[1], parsed)
+}
+
+func TestIssuer_Redeem(t *testing.T) {
+	t.Parallel()
+
+	issuer := newIssuer(t, &privacypass.Dependencies{Name: "power"})
+	token := issue(t, issuer, "power", 1)[0]
+
+	forged := token
+	forged.Authenticator = append([]byte(nil), token.Authenticator...)
+	forged.Authenticator[0] ^= 1
+
+	tampered := token
+	tampered.Nonce[0] ^= 1
+
+	// the tokens of another issuer name don't pass
+	another := issue(t, issuer, "another", 1)[0]
+
+	tests := []struct {
+		name  string
+		token []byte
+		err   error
+	}{
+		{name: "forged", token: forged.Marshal(), err: privacypass.ErrForged},
+		{name: "tampered", token: tampered.Marshal(), err: privacypass.ErrForged},
+		{name: "issuer name", token: another.Marshal(), err: privacypass.ErrUnknownKey},
+		{name: "truncated", token: token.Marshal()[1:], err: privacypass.ErrMalformed},
+		{name: "empty", err: privacypass.ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.ErrorIs(t, issuer.Redeem(tt.token), tt.err)
+		})
+	}
+}
+
+func TestIssuer_Sign(t *testing.T) {
+	t.Parallel()
+
+	issuer := newIssuer(t, &privacypass.Dependencies{Name: "power", MaxBatch: 2})
+	epoch := issuer.Epoch()
+	client, err := privacypass.NewClient(issuer.PublicKey(), "power", epoch[:])
+	require.NoError(t, err)
+
+	blinded, pending, err := client.Blind(3)
+	require.NoError(t, err)
+
+	_, err = issuer.Sign(blinded)
+	require.ErrorIs(t, err, privacypass.ErrBatch)
+	_, err = issuer.Sign(nil)
+	require.ErrorIs(t, err, privacypass.ErrBatch)
+	_, err = issuer.Sign([][]byte{blinded[0][1:]})
+	require.ErrorIs(t, err, privacypass.ErrBatch)
+	require.ErrorIs(t, issuer.CheckBatch([][]byte{bytes.Repeat([]byte{0xff}, len(blinded[0]))}), privacypass.ErrBatch)
+	require.NoError(t, issuer.CheckBatch(blinded[:2]))
+
+	signatures, err := issuer.Sign(blinded[:2])
+	require.NoError(t, err)
+	_, err = client.Finalize(pending, signatures)
+	require.ErrorIs(t, err, privacypass.ErrBatch)
+
+	// the signatures of another request don't finalize
+	_, err = client.Finalize(pending[:2], [][]byte{signatures[1], signatures[0]})
+	require.ErrorIs(t, err, privacypass.ErrForged)
+}
+
+func TestIssuer_Epoch(t *testing.T) {
+	t.Parallel()
+
+	issuer := newIssuer(t, &privacypass.Dependencies{Name: "power", Store: privacypass.NewMemoryStore(2)})
+	tokens := issue(t, issuer, "power", 3)
+	require.NoError(t, issuer.Redeem(tokens[0].Marshal()))
+
+	// a restart forgets the spent tokens along with their epoch
+	restarted := newIssuer(t, &privacypass.Dependencies{Name: "power"})
+	require.NotEqual(t, issuer.Epoch(), restarted.Epoch())
+	require.ErrorIs(t, restarted.Redeem(tokens[0].Marshal()), privacypass.ErrUnknownKey)
+
+	// a full store moves to a new epoch instead of forgetting the spent tokens of the current one
+	epoch := issuer.Epoch()
+	require.NoError(t, issuer.Redeem(tokens[1].Marshal()))
+	require.ErrorIs(t, issuer.Redeem(tokens[2].Marshal()), privacypass.ErrStaleEpoch)
+	require.NotEqual(t, epoch, issuer.Epoch())
+	require.ErrorIs(t, issuer.Redeem(tokens[0].Marshal()), privacypass.ErrUnknownKey)
+	require.NoError(t, issuer.Redeem(issue(t, issuer, "power", 1)[0].Marshal()))
+
+	_, err := privacypass.NewClient(issuer.PublicKey(), "power", epoch[1:])
+	require.Error(t, err)
+}
+
+func TestNewIssuer_SmallKey(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	_, err = privacypass.NewIssuer(&privacypass.Dependencies{Key: key, Name: "power"})
+	require.Error(t, err)
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	store := privacypass.NewMemoryStore(1)
+	epoch := store.Epoch()
+
+	fresh, err := store.Spend(epoch, [privacypass.NonceSize]byte{1})
+	require.NoError(t, err)
+	require.True(t, fresh)
+
+	fresh, err = store.Spend(epoch, [privacypass.NonceSize]byte{1})
+	require.NoError(t, err)
+	require.False(t, fresh)
+
+	// a full store moves to a new epoch
+	_, err = store.Spend(epoch, [privacypass.NonceSize]byte{2})
+	require.ErrorIs(t, err, privacypass.ErrStaleEpoch)
+	require.NotEqual(t, epoch, store.Epoch())
+	require.Zero(t, store.Len())
+
+	_, err = store.Spend(epoch, [privacypass.NonceSize]byte{1})
+	require.ErrorIs(t, err, privacypass.ErrStaleEpoch)
+	fresh, err = store.Spend(store.Epoch(), [privacypass.NonceSize]byte{1})
+	require.NoError(t, err)
+	require.True(t, fresh)
+
+	// a capacity that isn't positive falls back to the default
+	store = privacypass.NewMemoryStore(0)
+	fresh, err = store.Spend(store.Epoch(), [privacypass.NonceSize]byte{1})
+	require.NoError(t, err)
+	require.True(t, fresh)
+}
@@ -0,0 +1,90 @@
+package privacypass
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/go-faster/errors"
+)
+
+const (
+	// DefaultCapacity is the number of the spent tokens a MemoryStore keeps by default, about 50 MB.
+	DefaultCapacity = 1 << 20
+
+	EpochSize = 32
+)
+
+// ErrStaleEpoch is returned for the tokens of an epoch the store no longer covers.
+var ErrStaleEpoch = errors.New("token of a past epoch")
+
+// Store keeps the spent token nonces of an epoch, so a token can't be redeemed twice. The epoch is
+// the redemption context of the tokens: a store which forgets the spent nonces, e.g. on a restart or
+// when it's full, has to move to a new epoch, the tokens of the past ones are refused then. A persistent
+// store keeps its epoch along with the nonces.
+type Store interface {
+	// Epoch returns the current epoch, the tokens are issued in it.
+	Epoch() [EpochSize]byte
+	// Spend marks the nonce of the epoch spent, false if it already is. It returns ErrStaleEpoch if
+	// the epoch isn't the current one.
+	Spend(epoch [EpochSize]byte, nonce [NonceSize]byte) (bool, error)
+}
+
+// MemoryStore is a Store in memory of a bounded capacity. It draws a random epoch on the start and
+// a new one when it's full, forgetting the spent tokens: a restart or a full store refuses all the
+// tokens issued before, the clients solve a challenge for a new batch.
+type MemoryStore struct {
+	mu       sync.Mutex
+	epoch    [EpochSize]byte
+	spent    map[[NonceSize]byte]struct{}
+	capacity int
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a store of capacity spent tokens, DefaultCapacity if it isn't positive.
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	s := &MemoryStore{capacity: capacity}
+	s.rotate()
+	return s
+}
+
+func (s *MemoryStore) Epoch() [EpochSize]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.epoch
+}
+
+func (s *MemoryStore) Spend(epoch [EpochSize]byte, nonce [NonceSize]byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if epoch != s.epoch {
+		return false, ErrStaleEpoch
+	}
+	if _, ok := s.spent[nonce]; ok {
+		return false, nil
+	}
+	if len(s.spent) >= s.capacity {
+		s.rotate()
+		return false, ErrStaleEpoch
+	}
+	s.spent[nonce] = struct{}{}
+	return true, nil
+}
+
+// Len returns the number of the spent tokens of the current epoch.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.spent)
+}
+
+// rotate moves to a new epoch.
+func (s *MemoryStore) rotate() {
+	rand.Read(s.epoch[:]) //nolint:errcheck // crypto/rand.Read never fails
+	s.spent = make(map[[NonceSize]byte]struct{})
+}
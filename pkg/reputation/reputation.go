@@ -0,0 +1,285 @@
+// Package reputation scores the clients by their history, so the well-behaved repeat clients get
+// easier challenges and the suspicious ones harder.
+//
+// The history is kept by prefix: the solved challenges, the invalid solutions, the timeouts, the request
+// rate and the age. The counters decay exponentially, an old misbehavior is forgotten and an old good
+// record doesn't cover a new attack. The records live in a Store, MemoryStore by default.
+package reputation
+
+import (
+	"math"
+	"net/netip"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultHalfLife      = time.Hour
+	DefaultRateHalfLife  = time.Minute
+	DefaultMaturityAge   = 10 * time.Minute
+	DefaultRateLimit     = 60
+	DefaultInvalidWeight = 4
+	DefaultTimeoutWeight = 1
+	DefaultRateWeight    = 1
+	DefaultPrior         = 2
+	DefaultDiscount      = 1
+	DefaultSurcharge     = 2
+	DefaultIPv4Prefix    = 32
+	DefaultIPv6Prefix    = 64
+)
+
+// Event is a client action which changes its reputation.
+type Event int
+
+const (
+	// Solved is a valid solution of a challenge.
+	Solved Event = iota + 1
+	// Invalid is a wrong or replayed solution.
+	Invalid
+	// Timeout is a connection dropped for its silence.
+	Timeout
+)
+
+func (e Event) String() string {
+	switch e {
+	case Solved:
+		return "solved"
+	case Invalid:
+		return "invalid"
+	case Timeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// Record is the decayed history of a prefix.
+type Record struct {
+	Solved   float64 `json:"solved"`
+	Invalid  float64 `json:"invalid"`
+	Timeouts float64 `json:"timeouts"`
+	// Requests are the challenge requests, they decay with Policy.RateHalfLife to measure the rate.
+	Requests  float64   `json:"requests"`
+	FirstSeen time.Time `json:"first_seen"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// decay brings the counters to the time.
+func (r *Record) decay(now time.Time, policy *Policy) {
+	if r.FirstSeen.IsZero() {
+		r.FirstSeen, r.UpdatedAt = now, now
+		return
+	}
+
+	elapsed := now.Sub(r.UpdatedAt)
+	if elapsed <= 0 {
+		return
+	}
+
+	factor := halve(elapsed, policy.HalfLife)
+	r.Solved *= factor
+	r.Invalid *= factor
+	r.Timeouts *= factor
+	r.Requests *= halve(elapsed, policy.RateHalfLife)
+	r.UpdatedAt = now
+}
+
+func halve(elapsed, halfLife time.Duration) float64 {
+	return math.Exp2(-float64(elapsed) / float64(halfLife))
+}
+
+// Policy turns a record into a score and the score into a difficulty offset.
+//
+// The score is (good - bad) / (good + bad + Prior), from -1 to 1 and 0 for an unknown client. The good is
+// the solved challenges, scaled by the age up to MaturityAge, so a fresh prefix can't buy the trust
+// quickly. The bad is the weighted invalid solutions and timeouts plus RateWeight for every RateLimit
+// of the excess challenge requests per minute. A positive score lowers the difficulty by up to Discount,
+// a negative one raises it by up to Surcharge. The zero fields get the defaults, Discount and Surcharge
+// only when both are zero, so one direction can be disabled.
+type Policy struct {
+	HalfLife     time.Duration `json:"half_life" validate:"gte=0"`
+	RateHalfLife time.Duration `json:"rate_half_life" validate:"gte=0"`
+	MaturityAge  time.Duration `json:"maturity_age" validate:"gte=0"`
+
+	// RateLimit is the number of the challenge requests per minute which isn't suspicious.
+	RateLimit     float64 `json:"rate_limit" validate:"gte=0"`
+	InvalidWeight float64 `json:"invalid_weight" validate:"gte=0"`
+	TimeoutWeight float64 `json:"timeout_weight" validate:"gte=0"`
+	RateWeight    float64 `json:"rate_weight" validate:"gte=0"`
+	Prior         float64 `json:"prior" validate:"gte=0"`
+
+	Discount  int `json:"discount" validate:"gte=0"`
+	Surcharge int `json:"surcharge" validate:"gte=0"`
+
+	// IPv4Prefix and IPv6Prefix are the lengths of the scored prefixes.
+	IPv4Prefix int `json:"ipv4_prefix" validate:"gte=0,lte=32"`
+	IPv6Prefix int `json:"ipv6_prefix" validate:"gte=0,lte=128"`
+}
+
+func (p *Policy) setDefaults() {
+	setDefault(&p.HalfLife, DefaultHalfLife)
+	setDefault(&p.RateHalfLife, DefaultRateHalfLife)
+	setDefault(&p.MaturityAge, DefaultMaturityAge)
+	setDefault(&p.RateLimit, DefaultRateLimit)
+	setDefault(&p.InvalidWeight, DefaultInvalidWeight)
+	setDefault(&p.TimeoutWeight, DefaultTimeoutWeight)
+	setDefault(&p.RateWeight, DefaultRateWeight)
+	setDefault(&p.Prior, DefaultPrior)
+	if p.Discount == 0 && p.Surcharge == 0 {
+		p.Discount, p.Surcharge = DefaultDiscount, DefaultSurcharge
+	}
+	setDefault(&p.IPv4Prefix, DefaultIPv4Prefix)
+	setDefault(&p.IPv6Prefix, DefaultIPv6Prefix)
+}
+
+func setDefault[T comparable](field *T, value T) {
+	var zero T
+	if *field == zero {
+		*field = value
+	}
+}
+
+// Score returns the score of the record at the time, see Policy.
+func (p *Policy) Score(rec Record, now time.Time) float64 {
+	maturity := 1.0
+	if age := now.Sub(rec.FirstSeen); age < p.MaturityAge {
+		maturity = max(float64(age), 0) / float64(p.MaturityAge)
+	}
+	good := rec.Solved * maturity
+
+	// the decayed counter of a steady rate r per minute is r * RateHalfLife / ln 2
+	rate := rec.Requests * math.Ln2 / p.RateHalfLife.Minutes()
+	bad := rec.Invalid*p.InvalidWeight + rec.Timeouts*p.TimeoutWeight +
+		max(rate-p.RateLimit, 0)/p.RateLimit*p.RateWeight
+
+	if good+bad+p.Prior == 0 {
+		return 0
+	}
+	return (good - bad) / (good + bad + p.Prior)
+}
+
+// Offset returns the difficulty change of the score.
+func (p *Policy) Offset(score float64) int {
+	if score >= 0 {
+		return -int(math.Round(score * float64(p.Discount)))
+	}
+	return int(math.Round(-score * float64(p.Surcharge)))
+}
+
+func (p *Policy) prefix(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+
+	bits := p.IPv6Prefix
+	if addr.Is4() {
+		bits = p.IPv4Prefix
+	}
+
+	prefix, _ := addr.Prefix(bits) //nolint:errcheck // the lengths are validated
+	return prefix
+}
+
+type Dependencies struct {
+	// Store keeps the records, a MemoryStore of DefaultCapacity by default.
+	Store  Store
+	Policy Policy
+
+	// Now is time.Now by default, the tests move the clock.
+	Now func() time.Time
+}
+
+func (d *Dependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+
+	if d.Store == nil {
+		d.Store = NewMemoryStore(DefaultCapacity)
+	}
+	if d.Now == nil {
+		d.Now = time.Now
+	}
+	d.Policy.setDefaults()
+}
+
+// Tracker records the client events and scores the clients. It's safe for concurrent use as long as
+// the store is.
+type Tracker struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+func New(deps *Dependencies) *Tracker {
+	deps.SetDefaults()
+	return &Tracker{store: deps.Store, policy: deps.Policy, now: deps.Now}
+}
+
+// Policy returns the policy with the defaults.
+func (t *Tracker) Policy() Policy {
+	return t.policy
+}
+
+// Record counts an event of the address.
+func (t *Tracker) Record(addr netip.Addr, event Event) {
+	t.update(addr, func(rec *Record) {
+		switch event {
+		case Solved:
+			rec.Solved++
+		case Invalid:
+			rec.Invalid++
+		case Timeout:
+			rec.Timeouts++
+		}
+	})
+}
+
+// Score returns the current score of the address, 0 for an unknown one. It's a read-only lookup,
+// the record isn't stored back.
+func (t *Tracker) Score(addr netip.Addr) float64 {
+	now := t.now()
+	rec, ok, err := t.store.Get(t.policy.prefix(addr))
+	if err != nil {
+		log.WithError(err).WithField("addr", addr).Warn("get the reputation")
+		return 0
+	}
+	if !ok {
+		return 0
+	}
+
+	rec.decay(now, &t.policy)
+	return t.policy.Score(rec, now)
+}
+
+// Request counts a challenge request of the address and returns its score, it reports false if
+// the store fails.
+func (t *Tracker) Request(addr netip.Addr) (float64, bool) {
+	now := t.now()
+	rec, ok := t.update(addr, func(rec *Record) { rec.Requests++ })
+	if !ok {
+		return 0, false
+	}
+	return t.policy.Score(rec, now), true
+}
+
+// Offset returns the difficulty change of the score by the policy.
+func (t *Tracker) Offset(score float64) int {
+	return t.policy.Offset(score)
+}
+
+// update applies fn to the decayed record of the address prefix, a failing store is logged and
+// reports false, so the client is treated as unknown.
+func (t *Tracker) update(addr netip.Addr, fn func(rec *Record)) (Record, bool) {
+	now := t.now()
+	rec, err := t.store.Update(t.policy.prefix(addr), func(rec *Record) {
+		rec.decay(now, &t.policy)
+		fn(rec)
+	})
+	if err != nil {
+		log.WithError(err).WithField("addr", addr).Warn("update the reputation")
+		return Record{}, false
+	}
+	return rec, true
+}
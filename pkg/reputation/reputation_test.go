@@ -0,0 +1,143 @@
+package reputation_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/reputation"
+
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a manual time source.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTracker(policy reputation.Policy) (*reputation.Tracker, *clock) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return reputation.New(&reputation.Dependencies{Policy: policy, Now: c.Now}), c
+}
+
+// difficulty counts a challenge request of the address and returns base changed by its offset.
+func difficulty(tracker *reputation.Tracker, addr netip.Addr, base int) int {
+	score, _ := tracker.Request(addr)
+	return base + tracker.Offset(score)
+}
+
+func TestTracker_Difficulty(t *testing.T) {
+	t.Parallel()
+
+	tracker, c := newTracker(reputation.Policy{})
+	good := netip.MustParseAddr("192.0.2.1")
+	bad := netip.MustParseAddr("198.51.100.1")
+
+	// an unknown client pays the base
+	require.Equal(t, 4, difficulty(tracker, good, 4))
+	require.Equal(t, 4, difficulty(tracker, bad, 4))
+
+	// a fresh prefix doesn't buy the trust at once
+	for range 5 {
+		tracker.Record(good, reputation.Solved)
+	}
+	require.Equal(t, 4, difficulty(tracker, good, 4))
+
+	c.Advance(reputation.DefaultMaturityAge)
+	require.Equal(t, 3, difficulty(tracker, good, 4))
+
+	for range 3 {
+		tracker.Record(bad, reputation.Invalid)
+	}
+	require.Equal(t, 6, difficulty(tracker, bad, 4))
+	require.Less(t, tracker.Score(bad), -0.5)
+
+	// the score is read without a request
+	require.Zero(t, tracker.Score(netip.MustParseAddr("203.0.113.1")))
+	require.Equal(t, 6, difficulty(tracker, bad, 4))
+
+	// the misbehavior is forgotten
+	c.Advance(10 * reputation.DefaultHalfLife)
+	require.Equal(t, 4, difficulty(tracker, bad, 4))
+}
+
+func TestTracker_Rate(t *testing.T) {
+	t.Parallel()
+
+	tracker, c := newTracker(reputation.Policy{RateLimit: 10})
+	addr := netip.MustParseAddr("2001:db8::1")
+	neighbor := netip.MustParseAddr("2001:db8::2") // the same /64
+
+	// 10 requests a second for half a minute
+	last := 0
+	for range 300 {
+		last = difficulty(tracker, addr, 4)
+		c.Advance(100 * time.Millisecond)
+	}
+	require.Equal(t, 6, last)
+	require.Equal(t, 6, difficulty(tracker, neighbor, 4))
+
+	c.Advance(10 * reputation.DefaultRateHalfLife)
+	require.Equal(t, 4, difficulty(tracker, addr, 4))
+}
+
+func TestPolicy_Offset(t *testing.T) {
+	t.Parallel()
+
+	policy := reputation.Policy{Discount: 2, Surcharge: 4}
+
+	tests := []struct {
+		score    float64
+		expected int
+	}{
+		{score: 1, expected: -2},
+		{score: 0.3, expected: -1},
+		{score: 0, expected: 0},
+		{score: -0.1, expected: 0},
+		{score: -0.5, expected: 2},
+		{score: -1, expected: 4},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, policy.Offset(tt.score), tt.score)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	store := reputation.NewMemoryStore(2)
+	increment := func(rec *reputation.Record) { rec.Solved++ }
+
+	a, b, c := netip.MustParsePrefix("192.0.2.0/32"), netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("192.0.2.2/32")
+	for _, prefix := range []netip.Prefix{a, b, a, c} {
+		_, err := store.Update(prefix, increment)
+		require.NoError(t, err)
+	}
+
+	// b is the least recently updated one
+	require.Equal(t, 2, store.Len())
+	rec, err := store.Update(a, increment)
+	require.NoError(t, err)
+	require.InDelta(t, 3, rec.Solved, 0)
+
+	// a lookup neither adds a prefix nor refreshes one
+	_, ok, err := store.Get(b)
+	require.NoError(t, err)
+	require.False(t, ok)
+	rec, ok, err = store.Get(c)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.InDelta(t, 1, rec.Solved, 0)
+	require.Equal(t, 2, store.Len())
+
+	rec, err = store.Update(b, func(*reputation.Record) {})
+	require.NoError(t, err)
+	require.Zero(t, rec.Solved)
+
+	// c stays the least recently updated one
+	_, ok, err = store.Get(c)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,85 @@
+package reputation
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+)
+
+// DefaultCapacity is the number of the prefixes MemoryStore keeps by default, about 25 MB.
+const DefaultCapacity = 100_000
+
+// Store keeps the records by prefix, e.g. a shared one for several servers.
+type Store interface {
+	// Update calls fn with the record of the prefix, the zero one for a new prefix, keeps the changed
+	// record and returns it. The calls for the same prefix mustn't interleave.
+	Update(prefix netip.Prefix, fn func(rec *Record)) (Record, error)
+	// Get returns the record of the prefix, false for an unknown one. It changes nothing, so a lookup
+	// doesn't evict a record.
+	Get(prefix netip.Prefix) (Record, bool, error)
+}
+
+// MemoryStore is a Store in memory bounded by the number of the prefixes, the least recently
+// updated one is evicted for a new one.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	// order is the LRU list of *memoryEntry, the front is the most recent one.
+	order   *list.List
+	records map[netip.Prefix]*list.Element
+}
+
+type memoryEntry struct {
+	prefix netip.Prefix
+	record Record
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a store of capacity prefixes, DefaultCapacity if it isn't positive.
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &MemoryStore{capacity: capacity, order: list.New(), records: map[netip.Prefix]*list.Element{}}
+}
+
+func (s *MemoryStore) Update(prefix netip.Prefix, fn func(rec *Record)) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.records[prefix]
+	if ok {
+		s.order.MoveToFront(elem)
+	} else {
+		if s.order.Len() >= s.capacity {
+			oldest := s.order.Back()
+			delete(s.records, oldest.Value.(*memoryEntry).prefix) //nolint:forcetypeassert // the list has only entries
+			s.order.Remove(oldest)
+		}
+		elem = s.order.PushFront(&memoryEntry{prefix: prefix})
+		s.records[prefix] = elem
+	}
+
+	entry := elem.Value.(*memoryEntry) //nolint:forcetypeassert // the list has only entries
+	fn(&entry.record)
+	return entry.record, nil
+}
+
+func (s *MemoryStore) Get(prefix netip.Prefix) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.records[prefix]
+	if !ok {
+		return Record{}, false, nil
+	}
+	return elem.Value.(*memoryEntry).record, true, nil //nolint:forcetypeassert // the list has only entries
+}
+
+// Len returns the number of the kept prefixes.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kriuchkov/power/pkg/common"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// AccessKind names an access list: the allowlist skips the PoW, the denylist refuses the connections.
+type AccessKind string
+
+const (
+	Allowlist AccessKind = "allow"
+	Denylist  AccessKind = "deny"
+)
+
+var (
+	// ErrUnknownAccessList is returned for an access kind other than Allowlist and Denylist.
+	ErrUnknownAccessList = errors.New("unknown access list")
+	// ErrAccessNotFound is returned by RemoveAccess for a prefix which isn't a runtime entry.
+	ErrAccessNotFound = errors.New("access entry not found")
+	// ErrInvalidAccessList is returned by ParseAccessList for a malformed line.
+	ErrInvalidAccessList = errors.New("invalid access list")
+)
+
+// AccessEntry is a prefix of an access list. The static entries come from Dependencies or a file
+// and are replaced by SetStaticAccess, the runtime ones are managed by AddAccess and RemoveAccess.
+type AccessEntry struct {
+	Prefix  netip.Prefix `json:"prefix"`
+	Runtime bool         `json:"runtime"`
+}
+
+// ParseAccessList reads an access list: an address or a CIDR prefix per line, the blank lines and
+// the text after # are ignored.
+func ParseAccessList(r io.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+
+		prefix, err := common.ParsePrefix(text)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidAccessList, "line %d: %s", line, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read access list")
+	}
+	return prefixes, nil
+}
+
+// ReadAccessList parses the access list file, see ParseAccessList.
+func ReadAccessList(name string) ([]netip.Prefix, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "open access list")
+	}
+	defer f.Close()
+
+	return ParseAccessList(f)
+}
+
+// trieNode is a node of a binary trie of the prefix bits, terminal marks the end of a prefix.
+type trieNode struct {
+	children [2]*trieNode
+	terminal bool
+}
+
+// prefixTrie matches an address against a set of prefixes in at most 32 or 128 steps, however many
+// prefixes there are. It's immutable once built, so the lookups need no lock.
+type prefixTrie struct {
+	v4, v6 trieNode
+}
+
+func newPrefixTrie(prefixes []netip.Prefix) *prefixTrie {
+	t := &prefixTrie{}
+	for _, prefix := range prefixes {
+		t.insert(prefix)
+	}
+	return t
+}
+
+func (t *prefixTrie) root(addr netip.Addr) *trieNode {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+func (t *prefixTrie) insert(prefix netip.Prefix) {
+	addr := prefix.Addr()
+	bytes := addr.AsSlice()
+
+	node := t.root(addr)
+	for i := range prefix.Bits() {
+		if node.terminal {
+			return // a shorter prefix already covers it
+		}
+
+		bit := bytes[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal, node.children = true, [2]*trieNode{}
+}
+
+func (t *prefixTrie) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	var bytes [16]byte
+	if addr.Is4() {
+		b := addr.As4()
+		copy(bytes[:], b[:])
+	} else {
+		bytes = addr.As16()
+	}
+
+	node := t.root(addr)
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == addr.BitLen() {
+			return false
+		}
+		node = node.children[bytes[i/8]>>(7-i%8)&1]
+	}
+	return false
+}
+
+// accessList is a set of the static and the runtime prefixes. Every change rebuilds the trie,
+// the changes are rare and the lookup on every accepted connection stays lock-free.
+type accessList struct {
+	mu      sync.Mutex
+	static  map[netip.Prefix]struct{}
+	runtime map[netip.Prefix]struct{}
+	trie    atomic.Pointer[prefixTrie]
+}
+
+func (l *accessList) contains(addr netip.Addr) bool {
+	trie := l.trie.Load()
+	return trie != nil && trie.contains(addr)
+}
+
+func (l *accessList) setStatic(prefixes []netip.Prefix) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.static = make(map[netip.Prefix]struct{}, len(prefixes))
+	for _, prefix := range prefixes {
+		l.static[common.NormalizePrefix(prefix)] = struct{}{}
+	}
+	l.rebuild()
+}
+
+// add adds a runtime prefix, it reports false for a known one.
+func (l *accessList) add(prefix netip.Prefix) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.runtime[prefix]; ok {
+		return false
+	}
+	if l.runtime == nil {
+		l.runtime = map[netip.Prefix]struct{}{}
+	}
+	l.runtime[prefix] = struct{}{}
+	l.rebuild()
+	return true
+}
+
+func (l *accessList) remove(prefix netip.Prefix) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.runtime[prefix]; !ok {
+		return false
+	}
+	delete(l.runtime, prefix)
+	l.rebuild()
+	return true
+}
+
+// list returns the entries ordered by prefix, a prefix in both sets is listed once as a runtime one.
+func (l *accessList) list() []AccessEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]AccessEntry, 0, len(l.static)+len(l.runtime))
+	for prefix := range l.runtime {
+		entries = append(entries, AccessEntry{Prefix: prefix, Runtime: true})
+	}
+	for prefix := range l.static {
+		if _, ok := l.runtime[prefix]; !ok {
+			entries = append(entries, AccessEntry{Prefix: prefix})
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b AccessEntry) int { return comparePrefixes(a.Prefix, b.Prefix) })
+	return entries
+}
+
+func (l *accessList) rebuild() {
+	prefixes := make([]netip.Prefix, 0, len(l.static)+len(l.runtime))
+	for prefix := range l.static {
+		prefixes = append(prefixes, prefix)
+	}
+	for prefix := range l.runtime {
+		prefixes = append(prefixes, prefix)
+	}
+	l.trie.Store(newPrefixTrie(prefixes))
+}
+
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+func (h *Server) accessList(kind AccessKind) (*accessList, error) {
+	switch kind {
+	case Allowlist:
+		return &h.allowlist, nil
+	case Denylist:
+		return &h.denylist, nil
+	default:
+		return nil, errors.Wrapf(ErrUnknownAccessList, "%q", kind)
+	}
+}
+
+// AccessEntries returns the entries of the access list.
+func (h *Server) AccessEntries(kind AccessKind) ([]AccessEntry, error) {
+	list, err := h.accessList(kind)
+	if err != nil {
+		return nil, err
+	}
+	return list.list(), nil
+}
+
+// SetStaticAccess replaces the static entries of the access list, e.g. on a reload of its file.
+// A denied prefix closes its active connections.
+func (h *Server) SetStaticAccess(kind AccessKind, prefixes []netip.Prefix) error {
+	list, err := h.accessList(kind)
+	if err != nil {
+		return err
+	}
+
+	list.setStatic(prefixes)
+	log.WithFields(log.Fields{"list": kind, "prefixes": len(prefixes)}).Info("the access list is loaded")
+
+	if kind == Denylist {
+		for _, prefix := range prefixes {
+			h.closePrefix(common.NormalizePrefix(prefix))
+		}
+	}
+	return nil
+}
+
+// AddAccess adds a runtime entry to the access list. A denied prefix closes its active connections.
+func (h *Server) AddAccess(kind AccessKind, prefix netip.Prefix) (AccessEntry, error) {
+	list, err := h.accessList(kind)
+	if err != nil {
+		return AccessEntry{}, err
+	}
+
+	prefix = common.NormalizePrefix(prefix)
+	if list.add(prefix) {
+		log.WithFields(log.Fields{"list": kind, "prefix": prefix}).Info("add the access entry")
+	}
+
+	if kind == Denylist {
+		h.closePrefix(prefix)
+	}
+	return AccessEntry{Prefix: prefix, Runtime: true}, nil
+}
+
+// RemoveAccess removes a runtime entry of the access list, the static ones are changed by their file.
+func (h *Server) RemoveAccess(kind AccessKind, prefix netip.Prefix) error {
+	list, err := h.accessList(kind)
+	if err != nil {
+		return err
+	}
+
+	prefix = common.NormalizePrefix(prefix)
+	if !list.remove(prefix) {
+		return errors.Wrapf(ErrAccessNotFound, "%s list, prefix %s", kind, prefix)
+	}
+
+	log.WithFields(log.Fields{"list": kind, "prefix": prefix}).Info("remove the access entry")
+	return nil
+}
+
+// remoteIP returns the IP of a remote address, a TCP one without the string round trip.
+func remoteIP(remote net.Addr) (netip.Addr, bool) {
+	if tcp, ok := remote.(*net.TCPAddr); ok {
+		return tcp.AddrPort().Addr().Unmap(), true
+	}
+	return parseIP(remote.String())
+}
@@ -0,0 +1,94 @@
+//nolint:testpackage // it's internal tests
+package server
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	t.Parallel()
+
+	trie := newPrefixTrie([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("10.1.0.0/16"), // covered by 10.0.0.0/8
+		netip.MustParsePrefix("192.0.2.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	})
+
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{addr: "10.0.0.1", expected: true},
+		{addr: "10.255.255.255", expected: true},
+		{addr: "11.0.0.0", expected: false},
+		{addr: "192.0.2.7", expected: true},
+		{addr: "192.0.2.8", expected: false},
+		{addr: "::ffff:10.2.3.4", expected: true},
+		{addr: "2001:db8:ffff::1", expected: true},
+		{addr: "2001:db9::1", expected: false},
+		// the families don't mix
+		{addr: "::a00:1", expected: false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, trie.contains(netip.MustParseAddr(tt.addr)), tt.addr)
+	}
+
+	all := newPrefixTrie([]netip.Prefix{netip.MustParsePrefix("::/0")})
+	require.True(t, all.contains(netip.MustParseAddr("2001:db8::1")))
+	require.False(t, all.contains(netip.MustParseAddr("192.0.2.1")))
+}
+
+func TestParseAccessList(t *testing.T) {
+	t.Parallel()
+
+	prefixes, err := ParseAccessList(strings.NewReader(`
+# monitoring
+10.1.2.3/8   # the host bits are cleared
+192.0.2.1
+::ffff:198.51.100.0/120
+
+2001:db8::/32
+`))
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, prefixes)
+
+	_, err = ParseAccessList(strings.NewReader("10.0.0.0/8\nexample.com\n"))
+	require.ErrorIs(t, err, ErrInvalidAccessList)
+	require.ErrorContains(t, err, "line 2")
+}
+
+func TestAccessList(t *testing.T) {
+	t.Parallel()
+
+	var l accessList
+	addr := netip.MustParseAddr("192.0.2.1")
+	require.False(t, l.contains(addr))
+
+	prefix := netip.MustParsePrefix("192.0.2.0/24")
+	require.True(t, l.add(prefix))
+	require.False(t, l.add(prefix))
+	require.True(t, l.contains(addr))
+
+	// the static entries are replaced, the runtime ones are kept
+	l.setStatic([]netip.Prefix{netip.MustParsePrefix("198.51.100.0/24"), prefix})
+	l.setStatic([]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")})
+	require.Equal(t, []AccessEntry{
+		{Prefix: prefix, Runtime: true},
+		{Prefix: netip.MustParsePrefix("203.0.113.0/24")},
+	}, l.list())
+
+	require.True(t, l.remove(prefix))
+	require.False(t, l.remove(prefix))
+	require.False(t, l.contains(addr))
+}
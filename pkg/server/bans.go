@@ -0,0 +1,320 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/geoip"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultBanWindow      = time.Minute
+	DefaultBanDuration    = time.Minute
+	DefaultBanMaxDuration = 24 * time.Hour
+	DefaultBanMultiplier  = 2
+	DefaultBanForgetAfter = 24 * time.Hour
+	DefaultBanIPv4Prefix  = 32
+	DefaultBanIPv6Prefix  = 64
+
+	// minOffendersSweep is the number of the tracked prefixes which triggers the first sweep
+	// of the stale ones, the next sweep happens when the number doubles.
+	minOffendersSweep = 1024
+)
+
+// Ban refuses the connections of a prefix until the time, the zero one never expires.
+// A single address is a full-length prefix.
+type Ban struct {
+	Prefix netip.Prefix `json:"prefix"`
+	Until  time.Time    `json:"until"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// Permanent reports whether the ban never expires.
+func (b Ban) Permanent() bool {
+	return b.Until.IsZero()
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return !b.Permanent() && !now.Before(b.Until)
+}
+
+// BanPolicy bans the sources of the invalid solutions, fail2ban-style: MaxFailures wrong or replayed
+// nonces within Window close the connection and ban the source prefix. Every repeated ban of the prefix
+// is Multiplier times longer, up to MaxDuration; the escalation is forgotten after ForgetAfter without
+// bans. Zero MaxFailures disables the policy, the other zero fields get the defaults.
+type BanPolicy struct {
+	MaxFailures int           `json:"max_failures" validate:"gte=0"`
+	Window      time.Duration `json:"window" validate:"gte=0"`
+	Duration    time.Duration `json:"duration" validate:"gte=0"`
+	MaxDuration time.Duration `json:"max_duration" validate:"gte=0"`
+	Multiplier  float64       `json:"multiplier" validate:"omitempty,gte=1"`
+	ForgetAfter time.Duration `json:"forget_after" validate:"gte=0"`
+	// IPv4Prefix and IPv6Prefix are the lengths of the banned prefixes, e.g. 24 bans the whole subnet.
+	IPv4Prefix int `json:"ipv4_prefix" validate:"gte=0,lte=32"`
+	IPv6Prefix int `json:"ipv6_prefix" validate:"gte=0,lte=128"`
+}
+
+// ErrInvalidBanPolicy is returned by SetBanPolicy for a policy out of the bounds.
+var ErrInvalidBanPolicy = errors.New("invalid ban policy")
+
+func (p *BanPolicy) setDefaults() error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(p); err != nil {
+		return errors.Wrap(ErrInvalidBanPolicy, err.Error())
+	}
+
+	if p.Window == 0 {
+		p.Window = DefaultBanWindow
+	}
+	if p.Duration == 0 {
+		p.Duration = DefaultBanDuration
+	}
+	if p.MaxDuration == 0 {
+		p.MaxDuration = max(DefaultBanMaxDuration, p.Duration)
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = DefaultBanMultiplier
+	}
+	if p.ForgetAfter == 0 {
+		p.ForgetAfter = DefaultBanForgetAfter
+	}
+	if p.IPv4Prefix == 0 {
+		p.IPv4Prefix = DefaultBanIPv4Prefix
+	}
+	if p.IPv6Prefix == 0 {
+		p.IPv6Prefix = DefaultBanIPv6Prefix
+	}
+
+	if p.MaxDuration < p.Duration {
+		return errors.Wrap(ErrInvalidBanPolicy, "max duration is shorter than duration")
+	}
+	return nil
+}
+
+// duration returns the length of the ban number strike, counting from 1.
+func (p *BanPolicy) duration(strike int) time.Duration {
+	d := float64(p.Duration) * math.Pow(p.Multiplier, float64(strike-1))
+	if d >= float64(p.MaxDuration) {
+		return p.MaxDuration
+	}
+	return time.Duration(d)
+}
+
+// prefix returns the banned prefix of the address.
+func (p *BanPolicy) prefix(addr netip.Addr) netip.Prefix {
+	bits := p.IPv6Prefix
+	if addr.Is4() {
+		bits = p.IPv4Prefix
+	}
+
+	prefix, _ := addr.Prefix(bits) //nolint:errcheck // the lengths are validated
+	return prefix
+}
+
+// banList keeps the bans, the expired ones are dropped lazily.
+type banList struct {
+	mu   sync.Mutex
+	bans map[netip.Prefix]Ban
+	// lengths counts the bans by the prefix length, an address is looked up by each length.
+	lengths map[int]int
+}
+
+func (l *banList) add(ban Ban) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.bans == nil {
+		l.bans, l.lengths = map[netip.Prefix]Ban{}, map[int]int{}
+	}
+	if _, ok := l.bans[ban.Prefix]; !ok {
+		l.lengths[ban.Prefix.Bits()]++
+	}
+	l.bans[ban.Prefix] = ban
+}
+
+func (l *banList) remove(prefix netip.Prefix) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.delete(prefix)
+}
+
+func (l *banList) delete(prefix netip.Prefix) bool {
+	if _, ok := l.bans[prefix]; !ok {
+		return false
+	}
+
+	delete(l.bans, prefix)
+	if l.lengths[prefix.Bits()]--; l.lengths[prefix.Bits()] == 0 {
+		delete(l.lengths, prefix.Bits())
+	}
+	return true
+}
+
+func (l *banList) banned(addr netip.Addr, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for bits := range l.lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue // an IPv6 length for an IPv4 address
+		}
+
+		ban, ok := l.bans[prefix]
+		if !ok {
+			continue
+		}
+		if !ban.expired(now) {
+			return true
+		}
+		l.delete(prefix)
+	}
+	return false
+}
+
+// list returns the active bans ordered by prefix.
+func (l *banList) list(now time.Time) []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bans := make([]Ban, 0, len(l.bans))
+	for prefix, ban := range l.bans {
+		if ban.expired(now) {
+			l.delete(prefix)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	slices.SortFunc(bans, func(a, b Ban) int { return comparePrefixes(a.Prefix, b.Prefix) })
+	return bans
+}
+
+// offender is the record of a prefix which sends invalid solutions.
+type offender struct {
+	// failures are the times of the last failures within the window.
+	failures []time.Time
+	strikes  int
+	lastBan  time.Time
+}
+
+// offenders counts the invalid solutions by prefix. The stale records are swept when their number
+// doubles, so the memory is bounded by the number of the recent offenders.
+type offenders struct {
+	mu      sync.Mutex
+	records map[netip.Prefix]*offender
+	sweepAt int
+}
+
+// fail records an invalid solution, it returns the strike number when the prefix is to ban.
+func (o *offenders) fail(prefix netip.Prefix, policy *BanPolicy, now time.Time) (int, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.records == nil {
+		o.records, o.sweepAt = map[netip.Prefix]*offender{}, minOffendersSweep
+	}
+
+	rec, ok := o.records[prefix]
+	if !ok {
+		if len(o.records) >= o.sweepAt {
+			o.sweep(policy, now)
+		}
+		rec = &offender{}
+		o.records[prefix] = rec
+	}
+
+	if rec.strikes > 0 && now.Sub(rec.lastBan) >= policy.ForgetAfter {
+		rec.strikes = 0
+	}
+
+	// keep the failures within the window only
+	cut := now.Add(-policy.Window)
+	rec.failures = slices.DeleteFunc(rec.failures, func(t time.Time) bool { return !t.After(cut) })
+	rec.failures = append(rec.failures, now)
+
+	if len(rec.failures) < policy.MaxFailures {
+		return 0, false
+	}
+
+	rec.failures = rec.failures[:0]
+	rec.strikes++
+	rec.lastBan = now
+	return rec.strikes, true
+}
+
+func (o *offenders) forget(prefix netip.Prefix) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.records, prefix)
+}
+
+// sweep drops the records with no failures in the window and no escalation to remember.
+func (o *offenders) sweep(policy *BanPolicy, now time.Time) {
+	for prefix, rec := range o.records {
+		recent := len(rec.failures) > 0 && now.Sub(rec.failures[len(rec.failures)-1]) < policy.Window
+		escalated := rec.strikes > 0 && now.Sub(rec.lastBan) < policy.ForgetAfter
+		if !recent && !escalated {
+			delete(o.records, prefix)
+		}
+	}
+	o.sweepAt = max(minOffendersSweep, 2*len(o.records))
+}
+
+// failSolution counts an invalid solution of the remote address and bans its prefix by the policy.
+// It reports whether the prefix is banned, then the connection is already closed.
+func (h *Server) failSolution(remote net.Addr, geo geoip.Info) bool {
+	h.counters.invalid.Add(1)
+
+	policy := h.banPolicy.Load()
+	if policy == nil || policy.MaxFailures == 0 {
+		return false
+	}
+
+	ip, ok := parseIP(remote.String())
+	if !ok {
+		return false
+	}
+
+	prefix := policy.prefix(ip)
+	strike, ban := h.offenders.fail(prefix, policy, time.Now())
+	if !ban {
+		return false
+	}
+
+	duration := policy.duration(strike)
+	h.counters.autoBans.Add(1)
+	log.WithFields(log.Fields{
+		"prefix": prefix, "remote": remote, "strike": strike, "duration": duration,
+	}).WithFields(geo.Fields()).Warn("ban the source of the invalid solutions")
+
+	reason := fmt.Sprintf("%d invalid solutions within %s, strike %d", policy.MaxFailures, policy.Window, strike)
+	h.Ban(prefix, duration, reason)
+	return true
+}
+
+// closePrefix closes the active connections from the prefix.
+func (h *Server) closePrefix(prefix netip.Prefix) {
+	for _, c := range h.registry.list() {
+		if ip, ok := parseIP(c.info.RemoteAddr); ok && prefix.Contains(ip) {
+			c.close() //nolint:errcheck // the connection may be already closed
+		}
+	}
+}
+
+func parseIP(hostPort string) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(hostPort)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
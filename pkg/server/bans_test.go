@@ -0,0 +1,111 @@
+//nolint:testpackage // it's internal tests
+package server
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanPolicy_Duration(t *testing.T) {
+	t.Parallel()
+
+	policy := BanPolicy{MaxFailures: 3, Duration: time.Minute, MaxDuration: 10 * time.Minute}
+	require.NoError(t, policy.setDefaults())
+
+	tests := []struct {
+		strike   int
+		expected time.Duration
+	}{
+		{strike: 1, expected: time.Minute},
+		{strike: 2, expected: 2 * time.Minute},
+		{strike: 3, expected: 4 * time.Minute},
+		{strike: 4, expected: 8 * time.Minute},
+		{strike: 5, expected: 10 * time.Minute},
+		{strike: 1000, expected: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, policy.duration(tt.strike), tt.strike)
+	}
+
+	invalid := BanPolicy{Duration: time.Hour, MaxDuration: time.Minute}
+	require.ErrorIs(t, invalid.setDefaults(), ErrInvalidBanPolicy)
+
+	invalid = BanPolicy{IPv6Prefix: 129}
+	require.ErrorIs(t, invalid.setDefaults(), ErrInvalidBanPolicy)
+}
+
+func TestBanPolicy_Prefix(t *testing.T) {
+	t.Parallel()
+
+	policy := BanPolicy{IPv4Prefix: 24}
+	require.NoError(t, policy.setDefaults())
+
+	require.Equal(t, netip.MustParsePrefix("192.0.2.0/24"), policy.prefix(netip.MustParseAddr("192.0.2.77")))
+	require.Equal(t, netip.MustParsePrefix("2001:db8:1:2::/64"), policy.prefix(netip.MustParseAddr("2001:db8:1:2::7")))
+}
+
+func TestOffenders_Fail(t *testing.T) {
+	t.Parallel()
+
+	policy := BanPolicy{MaxFailures: 3, Window: time.Minute, ForgetAfter: time.Hour}
+	require.NoError(t, policy.setDefaults())
+
+	var o offenders
+	prefix := netip.MustParsePrefix("192.0.2.1/32")
+	now := time.Now()
+
+	fail := func(at time.Duration) (int, bool) { return o.fail(prefix, &policy, now.Add(at)) }
+
+	// the failures out of the window don't count
+	for _, at := range []time.Duration{0, 30 * time.Second, 61 * time.Second} {
+		_, ban := fail(at)
+		require.False(t, ban)
+	}
+
+	strike, ban := fail(62 * time.Second)
+	require.True(t, ban)
+	require.Equal(t, 1, strike)
+
+	// the counting starts over after a ban, the strikes grow
+	for range 2 {
+		_, ban = fail(2 * time.Minute)
+		require.False(t, ban)
+	}
+	strike, ban = fail(2 * time.Minute)
+	require.True(t, ban)
+	require.Equal(t, 2, strike)
+
+	// the strikes are forgotten after ForgetAfter without bans
+	for range 2 {
+		fail(3 * time.Hour)
+	}
+	strike, ban = fail(3 * time.Hour)
+	require.True(t, ban)
+	require.Equal(t, 1, strike)
+}
+
+func TestOffenders_Sweep(t *testing.T) {
+	t.Parallel()
+
+	policy := BanPolicy{MaxFailures: 10, Window: time.Minute, ForgetAfter: time.Hour}
+	require.NoError(t, policy.setDefaults())
+
+	var o offenders
+	now := time.Now()
+
+	addr := netip.MustParseAddr("10.0.0.0")
+	for range minOffendersSweep {
+		o.fail(netip.PrefixFrom(addr, 32), &policy, now)
+		addr = addr.Next()
+	}
+	require.Len(t, o.records, minOffendersSweep)
+
+	// the stale records are dropped on the next new prefix
+	o.fail(netip.MustParsePrefix("192.0.2.1/32"), &policy, now.Add(2*time.Minute))
+	require.Len(t, o.records, 1)
+	require.Equal(t, minOffendersSweep, o.sweepAt)
+}
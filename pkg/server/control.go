@@ -0,0 +1,389 @@
+package server
+
+import (
+	"cmp"
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/common"
+	"github.com/kriuchkov/power/pkg/geoip"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotSupported       = errors.New("not supported")
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrBanNotFound        = errors.New("ban not found")
+)
+
+// Control manages a running server, e.g. from an admin endpoint. It's implemented by *Server.
+type Control interface {
+	Stats() Stats
+
+	// DifficultySettings and UpdateDifficulty return ErrNotSupported if the PoW handler isn't
+	// a DifficultyController.
+	DifficultySettings() (DifficultySettings, error)
+	UpdateDifficulty(settings DifficultySettings) (DifficultySettings, error)
+
+	Connections() []ConnectionInfo
+	KillConnection(id uint64) error
+
+	Bans() []Ban
+	// Ban closes the connections of the prefix and refuses the new ones, zero duration bans forever.
+	Ban(prefix netip.Prefix, duration time.Duration, reason string) Ban
+	Unban(prefix netip.Prefix) error
+
+	// BanPolicy and SetBanPolicy manage the automatic bans of the invalid solutions.
+	BanPolicy() BanPolicy
+	SetBanPolicy(policy BanPolicy) error
+
+	// AccessEntries, AddAccess and RemoveAccess manage the allowlist and the denylist, the runtime
+	// entries are kept over the reloads of the static ones.
+	AccessEntries(kind AccessKind) ([]AccessEntry, error)
+	AddAccess(kind AccessKind, prefix netip.Prefix) (AccessEntry, error)
+	RemoveAccess(kind AccessKind, prefix netip.Prefix) error
+
+	// ReloadContent reloads the content of MessageHandler, see Dependencies.ReloadContent.
+	ReloadContent() error
+}
+
+var _ Control = (*Server)(nil)
+
+// DifficultyController is a PoW handler which difficulty can be changed at runtime, e.g. *pow.Pow.
+type DifficultyController interface {
+	Difficulty() int
+	SetDifficulty(difficulty int)
+	Bounds() (minDifficulty, maxDifficulty int)
+	SetBounds(minDifficulty, maxDifficulty int) error
+}
+
+// Stats is a snapshot of the server counters.
+type Stats struct {
+	ActiveConnections int64 `json:"active_connections"`
+	// Connections is the number of the accepted connections, BannedConnections and DeniedConnections
+	// of the refused ones. AllowedConnections are the accepted ones served without the PoW.
+	Connections        int64 `json:"connections"`
+	BannedConnections  int64 `json:"banned_connections"`
+	DeniedConnections  int64 `json:"denied_connections"`
+	AllowedConnections int64 `json:"allowed_connections"`
+
+	Challenges int64 `json:"challenges"`
+	// Served and Rejected count the content requests.
+	Served   int64 `json:"served"`
+	Rejected int64 `json:"rejected"`
+
+	// InvalidSolutions counts the wrong and the replayed nonces, AutoBans the bans they caused.
+	InvalidSolutions int64 `json:"invalid_solutions"`
+	AutoBans         int64 `json:"auto_bans"`
+
+	// IssuedTokens and RedeemedTokens count the access tokens, see Dependencies.Tokens.
+	IssuedTokens   int64 `json:"issued_tokens"`
+	RedeemedTokens int64 `json:"redeemed_tokens"`
+	// IssuedPrivateTokens and RedeemedPrivateTokens count the anonymous tokens, see Dependencies.PrivacyPass.
+	IssuedPrivateTokens   int64 `json:"issued_private_tokens"`
+	RedeemedPrivateTokens int64 `json:"redeemed_private_tokens"`
+
+	Quota  int           `json:"quota"`
+	Bans   int           `json:"bans"`
+	Uptime time.Duration `json:"uptime"`
+
+	Proxy *ProxyStats `json:"proxy,omitempty"`
+
+	// Countries and ASNs count the accepted connections by the geoip data, see Dependencies.GeoIP.
+	// The unknown ones are counted under "unknown" and 0, so are the ASNs above maxGeoLabels.
+	Countries map[string]int64 `json:"countries,omitempty"`
+	ASNs      map[uint32]int64 `json:"asns,omitempty"`
+}
+
+// DifficultySettings are the current difficulty and its bounds.
+type DifficultySettings struct {
+	Difficulty int `json:"difficulty"`
+	Min        int `json:"min"`
+	Max        int `json:"max"`
+}
+
+// ConnectionInfo describes an active client connection.
+type ConnectionInfo struct {
+	ID          uint64    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Transport   string    `json:"transport"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Served      int64     `json:"served"`
+	// Country and ASN are the geoip data of the remote address, if known.
+	Country string `json:"country,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+}
+
+type counters struct {
+	active      atomic.Int64
+	connections atomic.Int64
+	banned      atomic.Int64
+	challenges  atomic.Int64
+	served      atomic.Int64
+	rejected    atomic.Int64
+	invalid     atomic.Int64
+	autoBans    atomic.Int64
+	allowed     atomic.Int64
+	denied      atomic.Int64
+
+	issuedTokens   atomic.Int64
+	redeemedTokens atomic.Int64
+
+	issuedPrivateTokens   atomic.Int64
+	redeemedPrivateTokens atomic.Int64
+}
+
+// maxGeoLabels bounds the ASNs counted apart, a country code has only 26*26 values.
+const maxGeoLabels = 1000
+
+// geoCounters count the connections by the country and the ASN.
+type geoCounters struct {
+	mu        sync.Mutex
+	countries map[string]int64
+	asns      map[uint32]int64
+}
+
+func (g *geoCounters) add(info geoip.Info) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.countries == nil {
+		g.countries, g.asns = map[string]int64{}, map[uint32]int64{}
+	}
+
+	country := info.Country
+	if country == "" {
+		country = "unknown"
+	}
+	g.countries[country]++
+
+	asn := info.ASN
+	if _, ok := g.asns[asn]; !ok && len(g.asns) >= maxGeoLabels {
+		asn = 0
+	}
+	g.asns[asn]++
+}
+
+// snapshot returns the copies of the counters, nil if nothing is counted.
+func (g *geoCounters) snapshot() (map[string]int64, map[uint32]int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.countries == nil {
+		return nil, nil
+	}
+	return maps.Clone(g.countries), maps.Clone(g.asns)
+}
+
+// connection is an entry of the registry of the active connections.
+type connection struct {
+	info   ConnectionInfo
+	served atomic.Int64
+	close  func() error
+}
+
+type registry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*connection
+}
+
+func (r *registry) add(conn transport, transportName string, geo geoip.Info) *connection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conns == nil {
+		r.conns = map[uint64]*connection{}
+	}
+
+	r.nextID++
+	c := &connection{
+		info: ConnectionInfo{
+			ID:          r.nextID,
+			RemoteAddr:  conn.RemoteAddr().String(),
+			Transport:   transportName,
+			ConnectedAt: time.Now(),
+			Country:     geo.Country,
+			ASN:         geo.ASN,
+		},
+		close: conn.Close,
+	}
+	r.conns[c.info.ID] = c
+	return c
+}
+
+func (r *registry) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, id)
+}
+
+func (r *registry) get(id uint64) (*connection, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.conns[id]
+	return c, ok
+}
+
+// list returns the connections ordered by ID.
+func (r *registry) list() []*connection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conns := make([]*connection, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+
+	slices.SortFunc(conns, func(a, b *connection) int { return cmp.Compare(a.info.ID, b.info.ID) })
+	return conns
+}
+
+// Stats returns a snapshot of the server counters.
+func (h *Server) Stats() Stats {
+	stats := Stats{
+		ActiveConnections:  h.counters.active.Load(),
+		Connections:        h.counters.connections.Load(),
+		BannedConnections:  h.counters.banned.Load(),
+		DeniedConnections:  h.counters.denied.Load(),
+		AllowedConnections: h.counters.allowed.Load(),
+		Challenges:         h.counters.challenges.Load(),
+		Served:             h.counters.served.Load(),
+		Rejected:           h.counters.rejected.Load(),
+		InvalidSolutions:   h.counters.invalid.Load(),
+		AutoBans:           h.counters.autoBans.Load(),
+		IssuedTokens:       h.counters.issuedTokens.Load(),
+		RedeemedTokens:     h.counters.redeemedTokens.Load(),
+		Quota:              int(h.quota.Load()),
+		Bans:               len(h.bans.list(time.Now())),
+		Uptime:             time.Since(h.startedAt),
+
+		IssuedPrivateTokens:   h.counters.issuedPrivateTokens.Load(),
+		RedeemedPrivateTokens: h.counters.redeemedPrivateTokens.Load(),
+	}
+
+	if h.proxy != nil {
+		proxyStats := h.proxy.stats()
+		stats.Proxy = &proxyStats
+	}
+	stats.Countries, stats.ASNs = h.geoCounters.snapshot()
+	return stats
+}
+
+// DifficultySettings returns the difficulty of the new challenges and its bounds.
+func (h *Server) DifficultySettings() (DifficultySettings, error) {
+	controller, ok := h.pow.(DifficultyController)
+	if !ok {
+		return DifficultySettings{}, errors.Wrap(ErrNotSupported, "the pow handler has a fixed difficulty")
+	}
+
+	minDifficulty, maxDifficulty := controller.Bounds()
+	return DifficultySettings{Difficulty: controller.Difficulty(), Min: minDifficulty, Max: maxDifficulty}, nil
+}
+
+// UpdateDifficulty changes the bounds, then the difficulty, which is clamped into them.
+// The sessions keep their current challenges, the new ones get the new difficulty.
+func (h *Server) UpdateDifficulty(settings DifficultySettings) (DifficultySettings, error) {
+	controller, ok := h.pow.(DifficultyController)
+	if !ok {
+		return DifficultySettings{}, errors.Wrap(ErrNotSupported, "the pow handler has a fixed difficulty")
+	}
+
+	if err := controller.SetBounds(settings.Min, settings.Max); err != nil {
+		return DifficultySettings{}, errors.Wrap(err, "set bounds")
+	}
+	controller.SetDifficulty(settings.Difficulty)
+
+	updated, err := h.DifficultySettings()
+	log.WithField("difficulty", updated).Info("the difficulty is updated")
+	return updated, err
+}
+
+// Connections returns the active connections.
+func (h *Server) Connections() []ConnectionInfo {
+	conns := h.registry.list()
+
+	infos := make([]ConnectionInfo, 0, len(conns))
+	for _, c := range conns {
+		info := c.info
+		info.Served = c.served.Load()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// KillConnection closes an active connection.
+func (h *Server) KillConnection(id uint64) error {
+	c, ok := h.registry.get(id)
+	if !ok {
+		return errors.Wrapf(ErrConnectionNotFound, "id %d", id)
+	}
+
+	log.WithField("remote", c.info.RemoteAddr).Info("kill the connection")
+	return c.close()
+}
+
+// Bans returns the active bans.
+func (h *Server) Bans() []Ban {
+	return h.bans.list(time.Now())
+}
+
+// Ban refuses the new connections of the prefix and closes its active ones.
+func (h *Server) Ban(prefix netip.Prefix, duration time.Duration, reason string) Ban {
+	ban := Ban{Prefix: common.NormalizePrefix(prefix), Reason: reason}
+	if duration > 0 {
+		ban.Until = time.Now().Add(duration)
+	}
+	h.bans.add(ban)
+
+	log.WithFields(log.Fields{"prefix": ban.Prefix, "until": ban.Until, "reason": reason}).Info("ban the prefix")
+
+	h.closePrefix(ban.Prefix)
+	return ban
+}
+
+// Unban lifts the ban of the prefix and forgets its invalid solutions.
+func (h *Server) Unban(prefix netip.Prefix) error {
+	prefix = common.NormalizePrefix(prefix)
+	if !h.bans.remove(prefix) {
+		return errors.Wrapf(ErrBanNotFound, "prefix %s", prefix)
+	}
+	h.offenders.forget(prefix)
+
+	log.WithField("prefix", prefix).Info("unban the prefix")
+	return nil
+}
+
+// BanPolicy returns the policy of the automatic bans.
+func (h *Server) BanPolicy() BanPolicy {
+	if policy := h.banPolicy.Load(); policy != nil {
+		return *policy
+	}
+	return BanPolicy{}
+}
+
+// SetBanPolicy validates and changes the policy of the automatic bans, the zero fields get
+// the defaults. The counted failures are kept.
+func (h *Server) SetBanPolicy(policy BanPolicy) error {
+	if err := policy.setDefaults(); err != nil {
+		return err
+	}
+
+	h.banPolicy.Store(&policy)
+	log.WithField("policy", policy).Info("the ban policy is updated")
+	return nil
+}
+
+// ReloadContent calls Dependencies.ReloadContent.
+func (h *Server) ReloadContent() error {
+	if h.reloadContent == nil {
+		return errors.Wrap(ErrNotSupported, "the content can't be reloaded")
+	}
+	return h.reloadContent()
+}
@@ -0,0 +1,229 @@
+package server_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	server "github.com/kriuchkov/power/pkg/server"
+	mocks "github.com/kriuchkov/power/pkg/server/mocks"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// startServer serves the dependencies on a loopback listener until the test ends. The content is
+// "msg received" and the PoW handler is pow.NewPow(0) unless they are set.
+func startServer(t *testing.T, deps *server.Dependencies) *server.Server {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if deps.Listener == nil {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		deps.Listener = listener
+	}
+	if deps.MessageHandler == nil && deps.Upstream == nil {
+		deps.MessageHandler = func() []byte { return []byte("msg received") }
+	}
+	if deps.PowHandler == nil {
+		deps.PowHandler = pow.NewPow(0)
+	}
+
+	serv, err := server.New(deps)
+	require.NoError(t, err)
+
+	go serv.Listen(ctx)
+	return serv
+}
+
+// dial connects to the server, the connection is closed when the test ends.
+func dial(t *testing.T, serv *server.Server) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", serv.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitConnections polls the registry, the connections are registered by the serving goroutines.
+func waitConnections(t *testing.T, serv *server.Server, count int) []server.ConnectionInfo {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		conns := serv.Connections()
+		if len(conns) == count || time.Now().After(deadline) {
+			require.Len(t, conns, count)
+			return conns
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func requireConnClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	var size int32
+	require.ErrorIs(t, binary.Read(conn, binary.BigEndian, &size), io.EOF)
+}
+
+// exchange sends a message and reads the response.
+func exchange(t *testing.T, conn net.Conn, msg *powerV1.Message) *powerV1.Message {
+	t.Helper()
+
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	require.NoError(t, binary.Write(conn, binary.BigEndian, int32(len(data))))
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+
+	var size int32
+	require.NoError(t, binary.Read(conn, binary.BigEndian, &size))
+	response := make([]byte, size)
+	_, err = io.ReadFull(conn, response)
+	require.NoError(t, err)
+
+	var responseMessage powerV1.Message
+	require.NoError(t, proto.Unmarshal(response, &responseMessage))
+	return &responseMessage
+}
+
+func TestControl_KillConnection(t *testing.T) {
+	t.Parallel()
+
+	serv := startServer(t, &server.Dependencies{})
+
+	conn := dial(t, serv)
+
+	conns := waitConnections(t, serv, 1)
+	require.Equal(t, "tcp", conns[0].Transport)
+	require.Equal(t, conn.LocalAddr().String(), conns[0].RemoteAddr)
+
+	require.ErrorIs(t, serv.KillConnection(conns[0].ID+1), server.ErrConnectionNotFound)
+	require.NoError(t, serv.KillConnection(conns[0].ID))
+	requireConnClosed(t, conn)
+	waitConnections(t, serv, 0)
+
+	stats := serv.Stats()
+	require.Equal(t, int64(1), stats.Connections)
+	require.Equal(t, int64(0), stats.ActiveConnections)
+}
+
+func TestControl_Ban(t *testing.T) {
+	t.Parallel()
+
+	serv := startServer(t, &server.Dependencies{})
+	loopback := netip.MustParsePrefix("127.0.0.1/32")
+
+	conn := dial(t, serv)
+	waitConnections(t, serv, 1)
+
+	// the ban closes the active connections of the address
+	ban := serv.Ban(loopback, time.Minute, "test")
+	require.False(t, ban.Permanent())
+	requireConnClosed(t, conn)
+	require.Equal(t, []server.Ban{ban}, serv.Bans())
+
+	// and refuses the new ones
+	refused := dial(t, serv)
+	requireConnClosed(t, refused)
+	require.Equal(t, int64(1), serv.Stats().BannedConnections)
+
+	require.NoError(t, serv.Unban(loopback))
+	require.ErrorIs(t, serv.Unban(loopback), server.ErrBanNotFound)
+	require.Empty(t, serv.Bans())
+
+	// a mapped prefix is unmapped and the host bits are cleared
+	ban = serv.Ban(netip.MustParsePrefix("::ffff:10.0.0.1/120"), time.Nanosecond, "")
+	require.Equal(t, netip.MustParsePrefix("10.0.0.0/24"), ban.Prefix)
+
+	// an expired ban is dropped
+	time.Sleep(time.Millisecond)
+	require.Empty(t, serv.Bans())
+
+	dial(t, serv)
+	waitConnections(t, serv, 1)
+}
+
+func TestControl_Difficulty(t *testing.T) {
+	t.Parallel()
+
+	serv := startServer(t, &server.Dependencies{PowHandler: pow.NewPow(4)})
+
+	settings, err := serv.DifficultySettings()
+	require.NoError(t, err)
+	require.Equal(t, server.DifficultySettings{Difficulty: 4, Min: 0, Max: pow.MaxDifficulty}, settings)
+
+	settings, err = serv.UpdateDifficulty(server.DifficultySettings{Difficulty: 8, Min: 1, Max: 6})
+	require.NoError(t, err)
+	require.Equal(t, server.DifficultySettings{Difficulty: 6, Min: 1, Max: 6}, settings)
+
+	_, err = serv.UpdateDifficulty(server.DifficultySettings{Difficulty: 2, Min: 6, Max: 1})
+	require.ErrorIs(t, err, pow.ErrInvalidBounds)
+
+	// a handler with a fixed difficulty can't be controlled
+	powMock := mocks.NewMockPowHandler(t)
+	powMock.EXPECT().GetClientConditions(mock.Anything).Return(0, 0).Maybe()
+	powMock.EXPECT().GenerateHash(mock.Anything, mock.Anything).Return([]byte("hash")).Maybe()
+
+	fixed := startServer(t, &server.Dependencies{PowHandler: powMock})
+	_, err = fixed.DifficultySettings()
+	require.ErrorIs(t, err, server.ErrNotSupported)
+	require.ErrorIs(t, fixed.ReloadContent(), server.ErrNotSupported)
+}
+
+func TestControl_AccessList(t *testing.T) {
+	t.Parallel()
+
+	// nobody solves the challenges of the maximal difficulty in the test time
+	serv := startServer(t, &server.Dependencies{PowHandler: pow.NewPow(pow.MaxDifficulty)})
+	loopback := netip.MustParsePrefix("127.0.0.0/8")
+
+	_, err := serv.AddAccess("unknown", loopback)
+	require.ErrorIs(t, err, server.ErrUnknownAccessList)
+
+	entry, err := serv.AddAccess(server.Allowlist, netip.MustParsePrefix("127.0.0.1/8"))
+	require.NoError(t, err)
+	require.Equal(t, server.AccessEntry{Prefix: loopback, Runtime: true}, entry)
+
+	// an allowed address gets the content without a solution
+	conn := dial(t, serv)
+
+	for range 2 {
+		response := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content})
+		require.Equal(t, powerV1.CommandType_Content, response.GetCommand())
+		require.Equal(t, "msg received", string(response.GetBody()))
+	}
+
+	// the denylist wins, its active connections are closed
+	require.NoError(t, serv.SetStaticAccess(server.Denylist, []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}))
+	requireConnClosed(t, conn)
+
+	refused := dial(t, serv)
+	requireConnClosed(t, refused)
+
+	stats := serv.Stats()
+	require.Equal(t, int64(1), stats.AllowedConnections)
+	require.Equal(t, int64(1), stats.DeniedConnections)
+	require.Equal(t, int64(2), stats.Served)
+
+	require.ErrorIs(t, serv.RemoveAccess(server.Denylist, loopback), server.ErrAccessNotFound)
+	require.NoError(t, serv.SetStaticAccess(server.Denylist, nil))
+	require.NoError(t, serv.RemoveAccess(server.Allowlist, loopback))
+
+	entries, err := serv.AccessEntries(server.Allowlist)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,82 @@
+//nolint:testpackage // the fuzz targets feed the unexported transport and session
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// FuzzHandleConnection feeds the raw client bytes through the size prefix, proto.Unmarshal and
+// the session. The connection has to end without a panic however malformed the input is.
+func FuzzHandleConnection(f *testing.F) {
+	frame := func(messages ...*powerV1.Message) []byte {
+		var data []byte
+		for _, msg := range messages {
+			raw, _ := proto.Marshal(msg)
+			data = binary.BigEndian.AppendUint32(data, uint32(len(raw))) //nolint:gosec // the seeds are small
+			data = append(data, raw...)
+		}
+		return data
+	}
+
+	connect := &powerV1.Message{Command: powerV1.CommandType_Connect}
+	f.Add(frame(connect, &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("123")}))
+	f.Add(frame(connect, &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("-1")}))
+	f.Add(frame(&powerV1.Message{Command: powerV1.CommandType_Content}))
+	f.Add(frame(&powerV1.Message{Command: powerV1.CommandType(300)}, connect))
+	f.Add(frame(&powerV1.Message{Command: powerV1.CommandType_Close}))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 0, 0, 4, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x7f, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(_ *testing.T, data []byte) {
+		h := &Server{
+			pow:            pow.NewPow(0),
+			msgHandler:     func() []byte { return []byte("msg received") },
+			maxMessageSize: 1024,
+			readTimeout:    time.Second,
+		}
+		h.SetQuota(2)
+
+		serverConn, clientConn := net.Pipe()
+		go io.Copy(io.Discard, clientConn) //nolint:errcheck // it drains the responses until the close
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h.handleConnection(context.Background(), &tcpTransport{
+				conn: serverConn, maxMessageSize: h.maxMessageSize, readTimeout: h.readTimeout,
+			}, false)
+		}()
+
+		clientConn.Write(data) //nolint:errcheck // the server may close the connection early
+		clientConn.Close()
+		<-done
+	})
+}
+
+func FuzzSessionRedeem(f *testing.F) {
+	f.Add([]byte("123"))
+	f.Add([]byte(""))
+	f.Add([]byte("-1"))
+	f.Add([]byte("99999999999999999999"))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		handler := pow.NewPow(0)
+		s := newSession(handler, nil, 1, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+		valid := s.redeem(body)
+		if valid && s.redeem(body) {
+			t.Fatal("a solution is redeemed twice")
+		}
+	})
+}
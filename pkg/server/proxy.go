@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultUpstreamDialTimeout = 5 * time.Second
+	DefaultUpstreamIdleTimeout = 5 * time.Minute
+
+	proxyBufferSize = 32 * 1024
+)
+
+// Upstream is a service the client connection is spliced to after a valid solution.
+// Once the splice happens the server stops speaking the PoW protocol and proxies raw bytes both ways.
+type Upstream struct {
+	// Network is "tcp" (default) or "unix".
+	Network string `validate:"omitempty,oneof=tcp tcp4 tcp6 unix"`
+	// Address is a host:port pair or a path to the unix socket.
+	Address string `validate:"required"`
+
+	DialTimeout time.Duration `validate:"gte=0"`
+	// IdleTimeout closes the tunnel when no bytes are moved in either direction.
+	IdleTimeout time.Duration `validate:"gte=0"`
+}
+
+// ProxyStats is a snapshot of the reverse-proxy counters.
+type ProxyStats struct {
+	Tunnels       int64 `json:"tunnels"`
+	ActiveTunnels int64 `json:"active_tunnels"`
+	DialErrors    int64 `json:"dial_errors"`
+	// BytesUpstream is the number of bytes sent from clients to the upstream.
+	BytesUpstream int64 `json:"bytes_upstream"`
+	// BytesDownstream is the number of bytes sent from the upstream to clients.
+	BytesDownstream int64 `json:"bytes_downstream"`
+}
+
+type proxy struct {
+	network     string
+	address     string
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+
+	tunnels         atomic.Int64
+	activeTunnels   atomic.Int64
+	dialErrors      atomic.Int64
+	bytesUpstream   atomic.Int64
+	bytesDownstream atomic.Int64
+}
+
+func newProxy(upstream *Upstream) *proxy {
+	p := &proxy{
+		network:     upstream.Network,
+		address:     upstream.Address,
+		dialTimeout: upstream.DialTimeout,
+		idleTimeout: upstream.IdleTimeout,
+	}
+
+	if p.network == "" {
+		p.network = "tcp"
+	}
+	if p.dialTimeout == 0 {
+		p.dialTimeout = DefaultUpstreamDialTimeout
+	}
+	if p.idleTimeout == 0 {
+		p.idleTimeout = DefaultUpstreamIdleTimeout
+	}
+	return p
+}
+
+func (p *proxy) stats() ProxyStats {
+	return ProxyStats{
+		Tunnels:         p.tunnels.Load(),
+		ActiveTunnels:   p.activeTunnels.Load(),
+		DialErrors:      p.dialErrors.Load(),
+		BytesUpstream:   p.bytesUpstream.Load(),
+		BytesDownstream: p.bytesDownstream.Load(),
+	}
+}
+
+// splice dials the upstream and copies bytes between it and the client until both sides are done,
+// the tunnel is idle for too long or the context is canceled. The caller owns the client connection.
+func (p *proxy) splice(ctx context.Context, client net.Conn) {
+	dialer := net.Dialer{Timeout: p.dialTimeout}
+	upstream, err := dialer.DialContext(ctx, p.network, p.address)
+	if err != nil {
+		p.dialErrors.Add(1)
+		log.WithError(err).WithField("upstream", p.address).Error("dial the upstream")
+		return
+	}
+	defer upstream.Close()
+
+	p.tunnels.Add(1)
+	p.activeTunnels.Add(1)
+	defer p.activeTunnels.Add(-1)
+
+	// the deadlines must be reset, the handshake may have left some of them on the client connection
+	if err = client.SetDeadline(time.Time{}); err != nil {
+		log.WithError(err).Warn("reset the client deadline")
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	t := &tunnel{idleTimeout: p.idleTimeout}
+	t.touch()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	var sent, received int64
+	go func() {
+		defer wg.Done()
+		sent = t.pipe(upstream, client, &p.bytesUpstream)
+	}()
+	go func() {
+		defer wg.Done()
+		received = t.pipe(client, upstream, &p.bytesDownstream)
+	}()
+	wg.Wait()
+
+	log.WithFields(log.Fields{
+		"client":   client.RemoteAddr(),
+		"upstream": p.address,
+		"sent":     sent,
+		"received": received,
+	}).Debug("the tunnel is closed")
+}
+
+type tunnel struct {
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+	closeOnce    sync.Once
+}
+
+func (t *tunnel) touch() {
+	t.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (t *tunnel) idleSince() time.Duration {
+	return time.Since(time.Unix(0, t.lastActivity.Load()))
+}
+
+// pipe copies src to dst. When src reaches EOF the write side of dst is closed, so the peer sees the half-close
+// while the opposite direction keeps flowing. The tunnel is considered idle only if both directions are idle.
+func (t *tunnel) pipe(dst, src net.Conn, counter *atomic.Int64) int64 {
+	var written int64
+
+	buf := make([]byte, proxyBufferSize)
+	for {
+		if err := src.SetReadDeadline(time.Now().Add(t.idleTimeout)); err != nil {
+			t.abort(dst, src)
+			return written
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+
+			if _, wErr := dst.Write(buf[:n]); wErr != nil {
+				log.WithError(wErr).Debug("write to the tunnel")
+				t.abort(dst, src)
+				return written
+			}
+
+			written += int64(n)
+			counter.Add(int64(n))
+		}
+
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if t.idleSince() < t.idleTimeout {
+				continue // the opposite direction is still active
+			}
+			log.Debug("the tunnel is idle")
+			t.abort(dst, src)
+		case errors.Is(err, io.EOF):
+			closeWrite(dst)
+		default:
+			t.abort(dst, src)
+		}
+		return written
+	}
+}
+
+// abort tears down both sides of the tunnel and unblocks the opposite pipe.
+func (t *tunnel) abort(dst, src net.Conn) {
+	t.closeOnce.Do(func() {
+		dst.Close()
+		src.Close()
+	})
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err != nil {
+			log.WithError(err).Debug("close the write side")
+		}
+		return
+	}
+	conn.Close()
+}
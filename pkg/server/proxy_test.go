@@ -0,0 +1,133 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/client"
+	server "github.com/kriuchkov/power/pkg/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		address  string
+		upstream func(t *testing.T, conn net.Conn)
+		client   func(t *testing.T, conn net.Conn)
+		stats    server.ProxyStats
+	}{
+		{
+			name:    "half-close",
+			address: ":19190",
+			upstream: func(t *testing.T, conn net.Conn) {
+				request, err := io.ReadAll(conn)
+				require.NoError(t, err)
+				require.Equal(t, []byte("PING"), request)
+
+				_, err = conn.Write([]byte("PONG"))
+				require.NoError(t, err)
+			},
+			client: func(t *testing.T, conn net.Conn) {
+				_, err := conn.Write([]byte("PING"))
+				require.NoError(t, err)
+				require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+				response, err := io.ReadAll(conn)
+				require.NoError(t, err)
+				require.Equal(t, []byte("PONG"), response)
+			},
+			stats: server.ProxyStats{Tunnels: 1, BytesUpstream: 4, BytesDownstream: 4},
+		},
+		{
+			name:    "idle timeout",
+			address: ":19191",
+			upstream: func(_ *testing.T, conn net.Conn) {
+				io.Copy(io.Discard, conn) //nolint:errcheck // it's ok here
+			},
+			client: func(t *testing.T, conn net.Conn) {
+				_, err := conn.Write([]byte("PING"))
+				require.NoError(t, err)
+
+				_, err = io.ReadAll(conn)
+				require.NoError(t, err)
+			},
+			stats: server.ProxyStats{Tunnels: 1, BytesUpstream: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			upstreamListener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer upstreamListener.Close()
+
+			upstreamDone := make(chan struct{})
+			go func() {
+				defer close(upstreamDone)
+
+				conn, aErr := upstreamListener.Accept()
+				if aErr != nil {
+					return
+				}
+				defer conn.Close()
+				tt.upstream(t, conn)
+			}()
+
+			serv, err := server.New(&server.Dependencies{
+				TCPAddress: tt.address,
+				PowHandler: pow.NewPow(1),
+				Upstream: &server.Upstream{
+					Address:     upstreamListener.Addr().String(),
+					IdleTimeout: 200 * time.Millisecond,
+				},
+			})
+			require.NoError(t, err)
+
+			go serv.Listen(ctx)
+
+			conn, err := net.Dial("tcp", tt.address)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			cl := client.New(&client.Dependencies{ServerConn: conn, Hasher: pow.NewPow(1)})
+			tunnel, err := cl.Tunnel(ctx)
+			require.NoError(t, err)
+
+			tt.client(t, tunnel)
+			<-upstreamDone
+
+			require.Eventually(t, func() bool {
+				return serv.ProxyStats() == tt.stats
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestProxy_NotTCP(t *testing.T) {
+	t.Parallel()
+
+	// only the TCP connections can be spliced, the others are refused before they pay
+	serv := startServer(t, &server.Dependencies{Upstream: &server.Upstream{Address: "127.0.0.1:1"}})
+
+	recorder := httptest.NewRecorder()
+	serv.WebSocketHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	require.Equal(t, http.StatusNotImplemented, recorder.Code)
+
+	err := serv.ServeStream(newTestStream(t), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234})
+	require.ErrorIs(t, err, server.ErrNotSupported)
+}
@@ -2,19 +2,25 @@ package server
 
 import (
 	"context"
-	"encoding/binary"
 	"io"
-	"math/rand"
 	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/kriuchkov/power/internal/pow"
 	"github.com/kriuchkov/power/pkg/common"
+	"github.com/kriuchkov/power/pkg/geoip"
+	"github.com/kriuchkov/power/pkg/policy"
+	"github.com/kriuchkov/power/pkg/privacypass"
+	"github.com/kriuchkov/power/pkg/reputation"
+	"github.com/kriuchkov/power/pkg/token"
 
 	powerV1 "github.com/kriuchkov/protobuf/v1"
 
 	"github.com/go-playground/validator/v10"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/go-faster/errors"
 )
@@ -29,10 +35,74 @@ type PowHandler interface {
 	GetClientConditions(clientAddr net.Addr) (byteIndex int, byteValue byte)
 }
 
+// DifficultyVerifier is a PoW handler which checks a hash against any difficulty, e.g. *pow.Pow.
+// Dependencies.Reputation needs it to check the challenges of the per-client difficulty.
+type DifficultyVerifier interface {
+	IsValidHashAt(hash []byte, difficulty, byteIndex int, byteValue byte) bool
+}
+
+const (
+	DefaultMaxMessageSize = 64 * 1024
+	DefaultReadTimeout    = time.Minute
+
+	// maxResourceLength limits the resource name of a Connect message, a longer one is ignored.
+	maxResourceLength = 256
+)
+
 type Dependencies struct {
-	TCPAddress     string         `validate:"required"`
-	MessageHandler MessageHandler `validate:"required"`
+	TCPAddress string `validate:"required_without=Listener"`
+	// Listener is a listening socket to serve instead of TCPAddress, e.g. an in-process one in the tests.
+	Listener net.Listener
+
+	MessageHandler MessageHandler `validate:"required_without=Upstream"`
 	PowHandler     PowHandler     `validate:"required"`
+	// Puzzle replaces the SHA-256 search of the challenges, e.g. pow.RSW. The PowHandler still
+	// derives the seeds. Optional, the reputation and the rules don't support it.
+	Puzzle pow.Puzzle
+
+	// Upstream switches the server to the reverse-proxy mode, see Upstream.
+	Upstream *Upstream `validate:"omitempty"`
+
+	// Quota is the number of the content requests a solved challenge buys, 1 by default.
+	Quota int `validate:"gte=0"`
+
+	// MaxMessageSize limits the size of a client message, the connection is closed on a bigger one.
+	// DefaultMaxMessageSize by default.
+	MaxMessageSize int `validate:"gte=0,lte=2147483647"`
+	// ReadTimeout is the time a client has to send the next message, so the idle and the slow
+	// connections are closed. DefaultReadTimeout by default.
+	ReadTimeout time.Duration `validate:"gte=0"`
+
+	// WebSocketOrigins are the cross-origin pages allowed to use WebSocketHandler, e.g. "https://example.com".
+	WebSocketOrigins []string `validate:"dive,url"`
+
+	// ReloadContent reloads the content of MessageHandler, see Control.ReloadContent. Optional.
+	ReloadContent func() error
+
+	// BanPolicy bans the sources of the invalid solutions, it's disabled by default.
+	BanPolicy BanPolicy
+
+	// Reputation sets the difficulty of every challenge by the history of the client prefix, see package
+	// reputation. The PowHandler has to be a pow.DifficultyReporter and a DifficultyVerifier. Optional.
+	Reputation *reputation.Tracker
+	// Rules set the puzzle parameters of every challenge, after the reputation, see package policy and
+	// SetRules. The PowHandler has to be a pow.DifficultyReporter and a DifficultyVerifier. Optional.
+	Rules *policy.RuleSet
+	// GeoIP enriches the client addresses with the country and the ASN for the rules, the logs, the
+	// connection list and the stats, see package geoip. Optional.
+	GeoIP *geoip.DB
+	// Tokens issue an access token with the content of every solved challenge, the later Content requests
+	// may carry it instead of a nonce, see package token. Optional.
+	Tokens *token.Issuer
+	// PrivacyPass issues a batch of the anonymous tokens for a solved challenge on the IssueTokens requests,
+	// they are redeemed by the RedeemToken ones and can't be linked to the solution or the address, see
+	// package privacypass. Optional, the IssueTokens and RedeemToken commands are unknown without it.
+	PrivacyPass *privacypass.Issuer
+
+	// Allowlist and Denylist are the static access lists, see SetStaticAccess. The allowed addresses
+	// are served without the PoW and never banned, the denied ones are refused; the denylist wins.
+	Allowlist []netip.Prefix
+	Denylist  []netip.Prefix
 }
 
 func (d *Dependencies) SetDefaults() {
@@ -41,30 +111,135 @@ func (d *Dependencies) SetDefaults() {
 	if err != nil {
 		panic(err)
 	}
+
+	if d.Quota == 0 {
+		d.Quota = 1
+	}
+	if d.MaxMessageSize == 0 {
+		d.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if d.ReadTimeout == 0 {
+		d.ReadTimeout = DefaultReadTimeout
+	}
 }
 
 type Server struct {
 	listener   net.Listener
 	msgHandler MessageHandler
 	pow        PowHandler
+	puzzle     pow.Puzzle
+	proxy      *proxy
+	quota      atomic.Int64
+
+	maxMessageSize int
+	readTimeout    time.Duration
+
+	webSocketOrigins []string
+
+	reloadContent func() error
+	startedAt     time.Time
+	counters      counters
+	registry      registry
+	bans          banList
+	banPolicy     atomic.Pointer[BanPolicy]
+	offenders     offenders
+	allowlist     accessList
+	denylist      accessList
+	reputation    *reputation.Tracker
+	rules         atomic.Pointer[policy.RuleSet]
+	geo           *geoip.DB
+	geoCounters   geoCounters
+	tokens        *token.Issuer
+	privacyPass   *privacypass.Issuer
+	// perClient reports whether the handler supports the per-client difficulty
+	perClient bool
 }
 
 func New(deps *Dependencies) (*Server, error) {
 	deps.SetDefaults()
 
-	listener, err := net.Listen("tcp", deps.TCPAddress)
-	if err != nil {
-		return nil, errors.Wrap(err, "get a listener")
+	banPolicy := deps.BanPolicy
+	if err := banPolicy.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	_, reporter := deps.PowHandler.(pow.DifficultyReporter)
+	_, verifier := deps.PowHandler.(DifficultyVerifier)
+	perClient := reporter && verifier
+	if (deps.Reputation != nil || deps.Rules != nil) && !perClient {
+		return nil, errors.Wrap(ErrNotSupported, "the reputation and the rules need a pow handler with the per-client difficulty")
+	}
+	if (deps.Reputation != nil || deps.Rules != nil) && deps.Puzzle != nil {
+		return nil, errors.Wrap(ErrNotSupported, "the reputation and the rules set the difficulty of the sha256 challenges only")
+	}
+
+	listener := deps.Listener
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", deps.TCPAddress); err != nil {
+			return nil, errors.Wrap(err, "get a listener")
+		}
 	}
 
 	tcp := &Server{
 		listener:   listener,
 		msgHandler: deps.MessageHandler,
 		pow:        deps.PowHandler,
+		puzzle:     deps.Puzzle,
+
+		maxMessageSize: deps.MaxMessageSize,
+		readTimeout:    deps.ReadTimeout,
+
+		webSocketOrigins: deps.WebSocketOrigins,
+
+		reloadContent: deps.ReloadContent,
+		startedAt:     time.Now(),
+		reputation:    deps.Reputation,
+		geo:           deps.GeoIP,
+		tokens:        deps.Tokens,
+		privacyPass:   deps.PrivacyPass,
+		perClient:     perClient,
+	}
+
+	tcp.SetQuota(deps.Quota)
+	tcp.banPolicy.Store(&banPolicy)
+	tcp.allowlist.setStatic(deps.Allowlist)
+	tcp.denylist.setStatic(deps.Denylist)
+	tcp.rules.Store(deps.Rules)
+	if deps.Upstream != nil {
+		tcp.proxy = newProxy(deps.Upstream)
 	}
 	return tcp, nil
 }
 
+// Addr returns the address the server listens on.
+func (h *Server) Addr() net.Addr {
+	return h.listener.Addr()
+}
+
+// SetRules replaces the rules of the puzzle parameters, nil removes them. The new challenges use them.
+func (h *Server) SetRules(rules *policy.RuleSet) error {
+	if rules != nil && !h.perClient {
+		return errors.Wrap(ErrNotSupported, "the rules need a pow handler with the per-client difficulty")
+	}
+
+	h.rules.Store(rules)
+	return nil
+}
+
+// SetQuota changes the number of the content requests a solution buys, the new sessions use it.
+func (h *Server) SetQuota(quota int) {
+	h.quota.Store(int64(quota))
+}
+
+// ProxyStats returns the reverse-proxy counters. It's zero when the server isn't in the proxy mode.
+func (h *Server) ProxyStats() ProxyStats {
+	if h.proxy == nil {
+		return ProxyStats{}
+	}
+	return h.proxy.stats()
+}
+
 func (h *Server) Listen(ctx context.Context) {
 	done := make(chan struct{})
 
@@ -87,97 +262,265 @@ func (h *Server) Listen(ctx context.Context) {
 				continue
 			}
 
-			go h.handleTCPConnection(ctx, conn)
+			var allowed bool
+			if ip, ok := remoteIP(conn.RemoteAddr()); ok {
+				var refused bool
+				if allowed, refused = h.checkAccess(ip); refused {
+					conn.Close()
+					continue
+				}
+			}
+
+			go h.handleConnection(ctx, &tcpTransport{
+				conn: conn, maxMessageSize: h.maxMessageSize, readTimeout: h.readTimeout,
+			}, allowed)
 		}
 	}
 }
 
-//nolint:gocognit // it's a server method
-func (h *Server) handleTCPConnection(ctx context.Context, conn net.Conn) {
+// checkAccess checks the remote IP against the denylist, the allowlist and the bans.
+// A refused address is counted and logged, the caller closes its connection.
+func (h *Server) checkAccess(ip netip.Addr) (allowed, refused bool) {
+	switch {
+	case h.denylist.contains(ip):
+		h.counters.denied.Add(1)
+		log.WithField("remote", ip).Debug("refuse a denied address")
+		return false, true
+	case h.allowlist.contains(ip):
+		h.counters.allowed.Add(1)
+		return true, false
+	case h.bans.banned(ip, time.Now()):
+		h.counters.banned.Add(1)
+		log.WithField("remote", ip).Debug("refuse a banned address")
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// handleConnection serves the PoW protocol on a connection of any transport. The allowed connections
+// get the content without solving the challenges.
+func (h *Server) handleConnection(ctx context.Context, conn transport, allowed bool) {
 	defer conn.Close()
 
-	nonce := rand.Intn(pow.PowDigestLength) - 1 //nolint:gosec // it's ok here
-	primaryHash := h.pow.GenerateHash(nil, nonce)
-	byteIndex, byteValue := h.pow.GetClientConditions(conn.RemoteAddr())
+	// unblock the read of a waiting connection on the shutdown
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	h.counters.connections.Add(1)
+	h.counters.active.Add(1)
+	defer h.counters.active.Add(-1)
+
+	ip, hasIP := remoteIP(conn.RemoteAddr())
+	var subject string
+	if hasIP {
+		subject = ip.String()
+	}
+
+	var geo geoip.Info
+	if hasIP && h.geo != nil {
+		geo = h.geo.Lookup(ip)
+		h.geoCounters.add(geo)
+	}
+
+	entry := h.registry.add(conn, transportName(conn), geo)
+	defer h.registry.remove(entry.info.ID)
+
+	sess := newSession(h.pow, h.puzzle, int(h.quota.Load()), conn.RemoteAddr())
+	sess.trusted = allowed
+
+	// the allowed clients don't solve the challenges, so they have no reputation and no rules
+	hasIP = hasIP && !allowed
+	scored := hasIP && h.reputation != nil
+	var malformed int
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			var msgSize int32
-			if err := binary.Read(conn, binary.BigEndian, &msgSize); err != nil {
-				if errors.Is(err, io.EOF) {
+			protoMessage, err := conn.ReadMessage()
+			if err != nil {
+				if errors.Is(err, errMalformedMessage) {
+					if malformed++; malformed < maxMalformedMessages {
+						log.WithError(err).Debug("skip a malformed message")
+						continue
+					}
+					log.WithError(err).WithField("remote", conn.RemoteAddr()).WithFields(geo.Fields()).
+						Warn("drop the connection of the malformed messages")
 					return
 				}
-				continue
-			}
-
-			if msgSize <= 0 {
-				log.WithField("s", msgSize).Error("incorrect message size")
-				continue
-			}
-
-			log.WithField("s", msgSize).Debug("read message size")
-
-			msgBuffer := make([]byte, msgSize)
-			n, err := conn.Read(msgBuffer)
-			if err != nil {
-				log.WithError(err).Error("read message")
-				continue
-			}
-
-			var protoMessage powerV1.Message
-			if err = proto.Unmarshal(msgBuffer[:n], &protoMessage); err != nil {
-				log.WithError(err).Error("unmarshal message")
-				continue
+				if scored && errors.Is(err, os.ErrDeadlineExceeded) {
+					h.reputation.Record(ip, reputation.Timeout)
+				}
+				if errors.Is(err, common.ErrMessageTooLarge) || errors.Is(err, os.ErrDeadlineExceeded) {
+					log.WithError(err).WithField("remote", conn.RemoteAddr()).WithFields(geo.Fields()).
+						Warn("drop the connection")
+					return
+				}
+				if !errors.Is(err, io.EOF) {
+					log.WithError(err).Debug("the connection is broken")
+				}
+				return
 			}
 
-			var body []byte
+			var (
+				body      []byte
+				credits   uint32
+				challenge *powerV1.Challenge
+				issued    string
+				private   *powerV1.PrivateTokens
+			)
 
 			command := protoMessage.GetCommand()
 
-			//nolint:exhaustive // ok
+			//nolint:exhaustive // the unknown commands are ignored
 			switch command {
 			case powerV1.CommandType_Connect:
-				body = common.ConvetVerfyMessageToBytes(primaryHash, byteIndex, byteValue)
+				difficulty := -1
+				if hasIP {
+					difficulty = h.challengeDifficulty(ip, geo, protoMessage.GetBody())
+				}
+				body, challenge = sess.challenge(difficulty)
+				h.counters.challenges.Add(1)
 				log.WithField("body", string(body)).Debug("a connect message")
 
 			case powerV1.CommandType_Content:
-				clientHash := h.pow.GenerateHash(primaryHash, common.GetNonceFromMessage(protoMessage.GetBody()))
-				isValid := h.pow.IsValidHash(clientHash, byteIndex, byteValue)
-				if !isValid {
-					command = powerV1.CommandType_ErrInvalidHash
+				nonce := protoMessage.GetBody()
+				presented := protoMessage.GetToken()
+
+				var isValid bool
+				if presented != "" {
+					// a token is paid by an earlier solution, the body is ignored
+					nonce = nil
+					isValid = h.redeemToken(presented, subject)
 				} else {
-					body = h.msgHandler()
+					isValid = sess.redeem(nonce)
 				}
-
-				log.WithFields(log.Fields{"is_valid": isValid, "nonce": nonce}).
+				log.WithFields(log.Fields{"is_valid": isValid, "credits": sess.credits, "token": presented != ""}).
 					Debug("a content message")
 
-			case powerV1.CommandType_Close:
-				return
-			}
+				if scored && len(nonce) > 0 {
+					event := reputation.Invalid
+					if isValid {
+						event = reputation.Solved
+					}
+					h.reputation.Record(ip, event)
+				}
 
-			if len(body) > 0 || command > powerV1.CommandType_Content {
-				msg := powerV1.Message{Command: command, Body: body}
+				if isValid {
+					h.counters.served.Add(1)
+					entry.served.Add(1)
+				} else {
+					h.counters.rejected.Add(1)
+					if len(nonce) > 0 && h.failSolution(conn.RemoteAddr(), geo) {
+						return
+					}
+				}
+
+				// a fresh solution buys a token, the trusted sessions don't pay
+				if isValid && len(nonce) > 0 && !allowed {
+					issued = h.issueToken(subject, sess.paidDifficulty())
+				}
+
+				switch {
+				case !isValid:
+					command = powerV1.CommandType_ErrInvalidHash
+				case h.proxy != nil:
+					h.spliceConnection(ctx, conn, issued)
+					return
+				default:
+					body = h.msgHandler()
+					credits = uint32(sess.credits) //nolint:gosec // the quota is validated
+				}
 
-				var response []byte
-				response, err = proto.Marshal(&msg)
-				if err != nil {
-					log.WithError(err).Error("marshal message")
+			case powerV1.CommandType_IssueTokens:
+				if h.privacyPass == nil {
+					log.WithField("command", command).Debug("an unknown command")
 					continue
 				}
 
-				size := sizeOfMessage(response)
-				if err = binary.Write(conn, binary.BigEndian, size); err != nil {
-					log.WithError(err).Error("write message size")
+				nonce := protoMessage.GetBody()
+				if len(nonce) == 0 {
+					// the issuer key, the client blinds the token requests with it
+					private = h.privateTokenKey()
+					break
+				}
+
+				// a wrong batch doesn't spend the solution
+				blinded := protoMessage.GetPrivateTokens().GetBlinded()
+				if err := h.privacyPass.CheckBatch(blinded); err != nil {
+					log.WithError(err).Debug("refuse a token batch")
+					h.counters.rejected.Add(1)
+					command = powerV1.CommandType_ErrInvalidHash
+					break
+				}
+
+				// the trusted sessions don't pay, so they get no tokens
+				isValid := !allowed && sess.redeem(nonce)
+				log.WithField("is_valid", isValid).Debug("an issue tokens message")
+
+				if scored {
+					event := reputation.Invalid
+					if isValid {
+						event = reputation.Solved
+					}
+					h.reputation.Record(ip, event)
+				}
+
+				if isValid {
+					private = h.issuePrivateTokens(blinded)
+					if private != nil {
+						// the solution buys the tokens instead of the content requests
+						sess.credits = 0
+					}
+				} else {
+					h.counters.rejected.Add(1)
+					if !allowed && h.failSolution(conn.RemoteAddr(), geo) {
+						return
+					}
+				}
+				if private == nil {
+					command = powerV1.CommandType_ErrInvalidHash
+				}
+
+			case powerV1.CommandType_RedeemToken:
+				if h.privacyPass == nil {
+					log.WithField("command", command).Debug("an unknown command")
 					continue
 				}
 
-				log.WithFields(log.Fields{"size": size, "command": command}).
-					Debug("send a message")
+				isValid := h.redeemPrivateToken(protoMessage.GetBody())
+				log.WithField("is_valid", isValid).Debug("a redeem token message")
+
+				switch {
+				case !isValid:
+					h.counters.rejected.Add(1)
+					command = powerV1.CommandType_ErrInvalidHash
+				case h.proxy != nil:
+					h.counters.served.Add(1)
+					entry.served.Add(1)
+					h.spliceConnection(ctx, conn, "")
+					return
+				default:
+					h.counters.served.Add(1)
+					entry.served.Add(1)
+					command = powerV1.CommandType_Content
+					body = h.msgHandler()
+				}
+
+			case powerV1.CommandType_Close:
+				return
+
+			default:
+				log.WithField("command", command).Debug("an unknown command")
+				continue
+			}
 
-				if _, err = conn.Write(response); err != nil {
+			if len(body) > 0 || command > powerV1.CommandType_Content {
+				if err = conn.WriteMessage(&powerV1.Message{
+					Command: command, Body: body, Credits: credits, Challenge: challenge, Token: issued,
+					PrivateTokens: private,
+				}); err != nil {
 					log.WithError(err).Warn("write message")
 				}
 			}
@@ -185,6 +528,66 @@ func (h *Server) handleTCPConnection(ctx context.Context, conn net.Conn) {
 	}
 }
 
-func sizeOfMessage(msg []byte) int32 {
-	return int32(len(msg))
+// challengeDifficulty returns the difficulty of a challenge for the address by its reputation and the rules,
+// clamped into the bounds; -1 for the current one of the handler. The resource is the Connect message body.
+func (h *Server) challengeDifficulty(ip netip.Addr, geo geoip.Info, resource []byte) int {
+	rules := h.rules.Load()
+	if h.reputation == nil && rules == nil {
+		return -1
+	}
+
+	bounds := policy.Bounds{Min: 0, Max: pow.MaxDifficulty}
+	if controller, ok := h.pow.(DifficultyController); ok {
+		bounds.Min, bounds.Max = controller.Bounds()
+	}
+
+	difficulty := h.pow.(pow.DifficultyReporter).Difficulty() //nolint:forcetypeassert // it's checked by New
+	req := policy.Request{Addr: ip, Time: time.Now(), Country: geo.Country, ASN: geo.ASN}
+	if len(resource) <= maxResourceLength {
+		req.Resource = string(resource)
+	}
+
+	if h.reputation != nil {
+		if req.Score, req.HasScore = h.reputation.Request(ip); req.HasScore {
+			if offset := h.reputation.Offset(req.Score); offset != 0 {
+				log.WithFields(log.Fields{"addr": ip, "score": req.Score, "offset": offset}).Debug("adjust the difficulty")
+				difficulty += offset
+			}
+		}
+	}
+
+	params := policy.Params{Difficulty: min(max(difficulty, bounds.Min), bounds.Max)}
+	if rules != nil {
+		params, _ = rules.Evaluate(&req, params, bounds)
+	}
+	return params.Difficulty
+}
+
+// spliceConnection acknowledges the solution and hands the connection over to the proxy.
+// Only the raw TCP connections can be spliced.
+func (h *Server) spliceConnection(ctx context.Context, conn transport, issued string) {
+	tcp, ok := conn.(*tcpTransport)
+	if !ok {
+		log.WithField("remote", conn.RemoteAddr()).Warn("the transport doesn't support the proxy mode")
+		return
+	}
+
+	if err := tcp.WriteMessage(&powerV1.Message{Command: powerV1.CommandType_Content, Token: issued}); err != nil {
+		log.WithError(err).Error("write a proxy acknowledgement")
+		return
+	}
+	h.proxy.splice(ctx, tcp.conn)
+}
+
+func transportName(conn transport) string {
+	switch conn.(type) {
+	case *tcpTransport:
+		return "tcp"
+	case *webSocketTransport:
+		return "websocket"
+	case *streamTransport:
+		return "grpc"
+	default:
+		return "unknown"
+	}
 }
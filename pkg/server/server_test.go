@@ -2,17 +2,31 @@ package server_test
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/binary"
 	"io"
 	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/common"
+	"github.com/kriuchkov/power/pkg/geoip"
+	"github.com/kriuchkov/power/pkg/policy"
+	"github.com/kriuchkov/power/pkg/privacypass"
+	"github.com/kriuchkov/power/pkg/reputation"
 	server "github.com/kriuchkov/power/pkg/server"
 	mocks "github.com/kriuchkov/power/pkg/server/mocks"
+	"github.com/kriuchkov/power/pkg/token"
 
 	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -72,7 +86,7 @@ func TestHandleConnection(t *testing.T) {
 			byteValue:             'a',
 			powGenerateHashCaller: powGenerateHashCaller{callsCount: 1, hash: []byte("valid hash")},
 			powIsValidHashCaller:  powIsValidHashCaller{callsCount: 1, hash: []byte("valid hash"), byteIndex: 1, byteValue: 'a', valid: true},
-			inputMessage:          &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("valid hash")},
+			inputMessage:          &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("123")},
 			responseMessage:       &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("msg received")},
 		},
 		{
@@ -83,7 +97,17 @@ func TestHandleConnection(t *testing.T) {
 			byteValue:             'a',
 			powGenerateHashCaller: powGenerateHashCaller{callsCount: 1, hash: []byte("invalid hash")},
 			powIsValidHashCaller:  powIsValidHashCaller{callsCount: 1, hash: []byte("invalid hash"), byteIndex: 1, byteValue: 'a', valid: false},
-			inputMessage:          &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("invalid hash")},
+			inputMessage:          &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("456")},
+			responseMessage:       &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash},
+		},
+		{
+			name:                  "content message with malformed nonce",
+			address:               ":19095",
+			messageHandler:        func() []byte { return []byte("msg received") },
+			byteIndex:             1,
+			byteValue:             'a',
+			powGenerateHashCaller: powGenerateHashCaller{callsCount: 1, hash: []byte("primary hash")},
+			inputMessage:          &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("-1")},
 			responseMessage:       &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash},
 		},
 		{
@@ -168,3 +192,396 @@ func TestHandleConnection(t *testing.T) {
 		})
 	}
 }
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	serv, err := server.New(&server.Dependencies{
+		TCPAddress:     ":19094",
+		MessageHandler: func() []byte { return []byte("msg received") },
+		PowHandler:     pow.NewPow(1),
+		Quota:          2,
+	})
+	require.NoError(t, err)
+
+	go serv.Listen(ctx)
+
+	conn, err := net.Dial("tcp", ":19094")
+	require.NoError(t, err)
+
+	exchange := func(msg *powerV1.Message) *powerV1.Message {
+		msgBytes, mErr := proto.Marshal(msg)
+		require.NoError(t, mErr)
+		require.NoError(t, binary.Write(conn, binary.BigEndian, int32(len(msgBytes))))
+		_, mErr = conn.Write(msgBytes)
+		require.NoError(t, mErr)
+
+		var responseSize int32
+		require.NoError(t, binary.Read(conn, binary.BigEndian, &responseSize))
+		response := make([]byte, responseSize)
+		_, mErr = io.ReadFull(conn, response)
+		require.NoError(t, mErr)
+
+		var responseMessage powerV1.Message
+		require.NoError(t, proto.Unmarshal(response, &responseMessage))
+		return &responseMessage
+	}
+
+	solve := func() []byte {
+		verifyMessage := exchange(&powerV1.Message{Command: powerV1.CommandType_Connect})
+		hash, byteIndex, byteValue, err := common.SplitMessage(verifyMessage.GetBody())
+		require.NoError(t, err)
+		nonce := pow.NewPow(1).FindNonce(ctx, hash, byteIndex, byteValue)
+		return []byte(strconv.Itoa(nonce))
+	}
+
+	tests := []struct {
+		name            string
+		body            func() []byte
+		responseMessage *powerV1.Message
+	}{
+		{
+			name:            "credit without a solution",
+			body:            func() []byte { return nil },
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash},
+		},
+		{
+			name:            "solution",
+			body:            solve,
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("msg received"), Credits: 1},
+		},
+		{
+			name:            "credit",
+			body:            func() []byte { return nil },
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("msg received")},
+		},
+		{
+			name:            "no credits left",
+			body:            func() []byte { return nil },
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash},
+		},
+		{
+			name: "replayed solution",
+			body: func() []byte {
+				nonce := solve()
+				exchange(&powerV1.Message{Command: powerV1.CommandType_Content, Body: nonce})
+				return nonce
+			},
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_ErrInvalidHash},
+		},
+		{
+			name:            "next challenge",
+			body:            solve,
+			responseMessage: &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("msg received"), Credits: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		response := exchange(&powerV1.Message{Command: powerV1.CommandType_Content, Body: tt.body()})
+		require.Equal(t, tt.responseMessage.GetCommand(), response.GetCommand(), tt.name)
+		require.Equal(t, tt.responseMessage.GetBody(), response.GetBody(), tt.name)
+		require.Equal(t, tt.responseMessage.GetCredits(), response.GetCredits(), tt.name)
+	}
+
+	require.NoError(t, conn.Close())
+}
+
+func TestMalformedMessages(t *testing.T) {
+	t.Parallel()
+
+	conn := dial(t, startServer(t, &server.Dependencies{}))
+
+	// a zero size and an undecodable body are skipped
+	require.NoError(t, binary.Write(conn, binary.BigEndian, int32(0)))
+	require.NoError(t, binary.Write(conn, binary.BigEndian, int32(1)))
+	_, err := conn.Write([]byte{0xff})
+	require.NoError(t, err)
+	response := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect})
+	require.Equal(t, powerV1.CommandType_Connect, response.GetCommand())
+
+	// the third one closes the connection
+	require.NoError(t, binary.Write(conn, binary.BigEndian, int32(0)))
+	requireConnClosed(t, conn)
+}
+
+func TestReputation(t *testing.T) {
+	t.Parallel()
+
+	// the solutions count at once
+	tracker := reputation.New(&reputation.Dependencies{
+		Policy: reputation.Policy{Surcharge: 1, MaturityAge: time.Nanosecond},
+	})
+	loopback := netip.MustParseAddr("127.0.0.1")
+	for range 3 {
+		tracker.Record(loopback, reputation.Invalid)
+	}
+
+	_, err := server.New(&server.Dependencies{
+		TCPAddress:     "127.0.0.1:0",
+		MessageHandler: func() []byte { return []byte("msg received") },
+		PowHandler:     mocks.NewMockPowHandler(t),
+		Reputation:     tracker,
+	})
+	require.ErrorIs(t, err, server.ErrNotSupported)
+
+	serv := startServer(t, &server.Dependencies{Reputation: tracker})
+	conn := dial(t, serv)
+
+	// the suspicious client gets a harder challenge and has to solve it
+	challenge := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect}).GetChallenge()
+	require.EqualValues(t, 1, challenge.GetDifficulty())
+	before := tracker.Score(loopback)
+
+	nonce := pow.NewPow(int(challenge.GetDifficulty())).
+		FindNonce(context.Background(), challenge.GetHash(), int(challenge.GetByteIndex()), byte(challenge.GetByteValue()))
+	response := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))})
+	require.Equal(t, powerV1.CommandType_Content, response.GetCommand())
+	// well above the decay of the invalid solutions in the meantime
+	require.Greater(t, tracker.Score(loopback), before+0.1)
+}
+
+func TestRules(t *testing.T) {
+	t.Parallel()
+
+	rules, err := policy.Parse([]byte(`
+rules:
+  - match: {resources: [premium]}
+    difficulty: 1
+`))
+	require.NoError(t, err)
+
+	serv := startServer(t, &server.Dependencies{Rules: rules})
+
+	connect := func(resource string) int32 {
+		message := &powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte(resource)}
+		return exchange(t, dial(t, serv), message).GetChallenge().GetDifficulty()
+	}
+
+	require.EqualValues(t, 1, connect("premium"))
+	require.EqualValues(t, 0, connect("basic"))
+
+	require.NoError(t, serv.SetRules(nil))
+	require.EqualValues(t, 0, connect("premium"))
+}
+
+func TestRules_Outstanding(t *testing.T) {
+	t.Parallel()
+
+	rules, err := policy.Parse([]byte(`
+rules:
+  - match: {resources: [premium]}
+    difficulty: 1
+`))
+	require.NoError(t, err)
+
+	conn := dial(t, startServer(t, &server.Dependencies{Rules: rules}))
+
+	premium := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect, Body: []byte("premium")}).GetChallenge()
+	require.EqualValues(t, 1, premium.GetDifficulty())
+
+	// a Connect without the resource, e.g. Client.Ping, repeats the outstanding challenge
+	ping := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect}).GetChallenge()
+	require.Equal(t, premium.GetHash(), ping.GetHash())
+	require.EqualValues(t, 1, ping.GetDifficulty())
+
+	// a nonce of the difficulty 0 doesn't solve it, unless it happens to solve the difficulty 1 too
+	var nonce int
+	for ; ; nonce++ {
+		hash := pow.NewPow(0).GenerateHash(premium.GetHash(), nonce)
+		if pow.NewPow(0).IsValidHash(hash, int(premium.GetByteIndex()), byte(premium.GetByteValue())) &&
+			!pow.NewPow(1).IsValidHash(hash, int(premium.GetByteIndex()), byte(premium.GetByteValue())) {
+			break
+		}
+	}
+	content := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))})
+	require.Equal(t, powerV1.CommandType_ErrInvalidHash, content.GetCommand())
+}
+
+func TestGeoIP(t *testing.T) {
+	t.Parallel()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-Country", IncludeReservedNetworks: true})
+	require.NoError(t, err)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, tree.Insert(loopback, mmdbtype.Map{
+		"country":                  mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+		"autonomous_system_number": mmdbtype.Uint32(64496),
+	}))
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "geo.mmdb"))
+	require.NoError(t, err)
+	_, err = tree.WriteTo(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	db, err := geoip.Open(file.Name())
+	require.NoError(t, err)
+
+	rules, err := policy.Parse([]byte("rules: [{match: {countries: [DE]}, difficulty: 1}]"))
+	require.NoError(t, err)
+
+	serv := startServer(t, &server.Dependencies{Rules: rules, GeoIP: db})
+
+	challenge := exchange(t, dial(t, serv), &powerV1.Message{Command: powerV1.CommandType_Connect}).GetChallenge()
+	require.EqualValues(t, 1, challenge.GetDifficulty())
+
+	conns := serv.Connections()
+	require.Len(t, conns, 1)
+	require.Equal(t, "DE", conns[0].Country)
+	require.EqualValues(t, 64496, conns[0].ASN)
+
+	stats := serv.Stats()
+	require.Equal(t, map[string]int64{"DE": 1}, stats.Countries)
+	require.Equal(t, map[uint32]int64{64496: 1}, stats.ASNs)
+}
+
+// solve requests a challenge of the sha256 search on the connection and returns its nonce.
+func solve(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+
+	challenge := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect}).GetChallenge()
+	nonce := pow.NewPow(int(challenge.GetDifficulty())).
+		FindNonce(context.Background(), challenge.GetHash(), int(challenge.GetByteIndex()), byte(challenge.GetByteValue()))
+	return []byte(strconv.Itoa(nonce))
+}
+
+func TestTokens(t *testing.T) {
+	t.Parallel()
+
+	key := token.NewEd25519Key([]byte("a secret of the token key"))
+	serv := startServer(t, &server.Dependencies{
+		Tokens: token.NewIssuer(&token.Dependencies{Signer: key, Uses: 2}),
+	})
+
+	conn := dial(t, serv)
+	response := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content, Body: solve(t, conn)})
+	require.Equal(t, powerV1.CommandType_Content, response.GetCommand())
+
+	// the token is verified offline with the public key
+	issued := response.GetToken()
+	claims, err := token.Verify(key.PublicKey(), issued, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", claims.Subject)
+
+	// a fresh connection pays with the token until it's used up
+	conn = dial(t, serv)
+	for _, expected := range []powerV1.CommandType{
+		powerV1.CommandType_Content, powerV1.CommandType_Content, powerV1.CommandType_ErrInvalidHash,
+	} {
+		response = exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content, Token: issued})
+		require.Equal(t, expected, response.GetCommand())
+		require.Empty(t, response.GetToken())
+	}
+
+	forged := &powerV1.Message{Command: powerV1.CommandType_Content, Token: issued[:len(issued)-4] + "AAAA"}
+	require.Equal(t, powerV1.CommandType_ErrInvalidHash, exchange(t, conn, forged).GetCommand())
+
+	stats := serv.Stats()
+	require.EqualValues(t, 1, stats.IssuedTokens)
+	require.EqualValues(t, 2, stats.RedeemedTokens)
+}
+
+func TestPuzzle(t *testing.T) {
+	t.Parallel()
+
+	rsw, err := pow.NewRSW(pow.MinRSWBits, 100)
+	require.NoError(t, err)
+	cuckoo, err := pow.NewCuckoo(10, 6)
+	require.NoError(t, err)
+
+	tests := []struct {
+		puzzle pow.Puzzle
+		solver pow.PuzzleSolver
+	}{
+		{puzzle: rsw, solver: pow.RSWSolver{}},
+		{puzzle: cuckoo, solver: pow.CuckooSolver{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.puzzle.Algorithm(), func(t *testing.T) {
+			t.Parallel()
+
+			conn := dial(t, startServer(t, &server.Dependencies{Puzzle: tt.puzzle}))
+
+			challenge := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect}).GetChallenge()
+			require.Equal(t, tt.puzzle.Algorithm(), challenge.GetAlgorithm())
+
+			// a nonce of the sha256 search isn't a solution
+			nonce := &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("1")}
+			require.Equal(t, powerV1.CommandType_ErrInvalidHash, exchange(t, conn, nonce).GetCommand())
+
+			solution, err := tt.solver.Solve(context.Background(), challenge.GetHash(), challenge.GetParams())
+			require.NoError(t, err)
+			response := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content, Body: solution})
+			require.Equal(t, powerV1.CommandType_Content, response.GetCommand())
+			require.Equal(t, []byte("msg received"), response.GetBody())
+
+			// the solved challenge is replaced
+			next := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect}).GetChallenge()
+			require.NotEqual(t, challenge.GetHash(), next.GetHash())
+
+			_, err = server.New(&server.Dependencies{
+				TCPAddress:     "127.0.0.1:0",
+				MessageHandler: func() []byte { return nil },
+				PowHandler:     pow.NewPow(0),
+				Puzzle:         tt.puzzle,
+				Reputation:     reputation.New(&reputation.Dependencies{}),
+			})
+			require.ErrorIs(t, err, server.ErrNotSupported)
+		})
+	}
+}
+
+func TestPrivacyPass(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, privacypass.MinKeyBits)
+	require.NoError(t, err)
+	issuer, err := privacypass.NewIssuer(&privacypass.Dependencies{Key: key, Name: "power", MaxBatch: 2})
+	require.NoError(t, err)
+
+	serv := startServer(t, &server.Dependencies{PrivacyPass: issuer})
+	conn := dial(t, serv)
+
+	issuerKey := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_IssueTokens}).GetPrivateTokens()
+	require.Equal(t, issuer.PublicKey(), issuerKey.GetPublicKey())
+	require.Equal(t, "power", issuerKey.GetIssuer())
+	require.EqualValues(t, 2, issuerKey.GetMaxBatch())
+
+	client, err := privacypass.NewClient(issuerKey.GetPublicKey(), issuerKey.GetIssuer(), issuerKey.GetEpoch())
+	require.NoError(t, err)
+	blinded, pending, err := client.Blind(2)
+	require.NoError(t, err)
+
+	// a batch over the limit isn't signed and doesn't spend the solution
+	request := &powerV1.Message{
+		Command: powerV1.CommandType_IssueTokens, Body: solve(t, conn),
+		PrivateTokens: &powerV1.PrivateTokens{Blinded: append(blinded, blinded[0])},
+	}
+	require.Equal(t, powerV1.CommandType_ErrInvalidHash, exchange(t, conn, request).GetCommand())
+
+	// the solution buys the tokens and can't be replayed
+	request.PrivateTokens.Blinded = blinded
+	response := exchange(t, conn, request)
+	require.Equal(t, powerV1.CommandType_IssueTokens, response.GetCommand())
+	require.Equal(t, powerV1.CommandType_ErrInvalidHash, exchange(t, conn, request).GetCommand())
+
+	tokens, err := client.Finalize(pending, response.GetPrivateTokens().GetBlinded())
+	require.NoError(t, err)
+
+	// the tokens are redeemed once, on any connection
+	for _, tok := range tokens {
+		redeem := &powerV1.Message{Command: powerV1.CommandType_RedeemToken, Body: tok.Marshal()}
+		response = exchange(t, dial(t, serv), redeem)
+		require.Equal(t, powerV1.CommandType_Content, response.GetCommand())
+		require.Equal(t, []byte("msg received"), response.GetBody())
+		require.Equal(t, powerV1.CommandType_ErrInvalidHash, exchange(t, conn, redeem).GetCommand())
+	}
+
+	stats := serv.Stats()
+	require.EqualValues(t, 2, stats.IssuedPrivateTokens)
+	require.EqualValues(t, 2, stats.RedeemedPrivateTokens)
+}
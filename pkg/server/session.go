@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/rand"
+	"net"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/common"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+)
+
+const challengeSeedSize = 16
+
+// session is the state of one client connection: the current challenge and the paid content requests.
+//
+// A solved challenge can't be redeemed twice, the next Connect message issues a fresh one. The solution
+// buys quota content requests, the ones after the first are redeemed by Content messages without a nonce.
+// A trusted session, e.g. of an allowlisted address, redeems any Content message.
+//
+// With a puzzle the challenge is its seed and parameters instead of the SHA-256 search, and the body
+// of a Content message is the solution instead of a nonce.
+type session struct {
+	pow     PowHandler
+	puzzle  pow.Puzzle
+	quota   int
+	trusted bool
+
+	primaryHash []byte
+	byteIndex   int
+	byteValue   byte
+	params      []byte
+	// difficulty is the one of the issued challenge, negative for the current one of the handler.
+	// It's pinned while the challenge is issued and unsolved.
+	difficulty int
+	issued     bool
+	solved     bool
+	credits    int
+}
+
+func newSession(handler PowHandler, puzzle pow.Puzzle, quota int, clientAddr net.Addr) *session {
+	s := &session{pow: handler, puzzle: puzzle, quota: quota, difficulty: -1}
+	s.byteIndex, s.byteValue = handler.GetClientConditions(clientAddr)
+	s.rotate()
+	return s
+}
+
+// rotate issues a fresh challenge. The hash is derived from random bytes, so a solved challenge
+// doesn't come back and its nonce can't be replayed.
+func (s *session) rotate() {
+	seed := make([]byte, challengeSeedSize)
+	rand.Read(seed) //nolint:errcheck // crypto/rand.Read never fails
+	s.primaryHash = s.pow.GenerateHash(seed, 0)
+	if s.puzzle != nil {
+		s.params = s.puzzle.Issue(s.primaryHash)
+	}
+	s.issued, s.solved = false, false
+}
+
+// challenge returns the verify message of the current challenge, a solved one is replaced.
+// A non-negative difficulty is the one of this client, e.g. by its reputation, the solution is checked
+// against it; the handler has to be a DifficultyVerifier then. The difficulty of an issued unsolved
+// challenge is kept, so a repeated Connect (e.g. Client.Ping) can't lower it and a prepared solution
+// stays valid. The puzzle parameters are nil if the handler doesn't report its difficulty.
+func (s *session) challenge(difficulty int) ([]byte, *powerV1.Challenge) {
+	if s.solved {
+		s.rotate()
+	}
+	if !s.issued {
+		s.difficulty, s.issued = difficulty, true
+	}
+	difficulty = s.difficulty
+
+	body := common.ConvetVerfyMessageToBytes(s.primaryHash, s.byteIndex, s.byteValue)
+
+	if s.puzzle != nil {
+		return body, &powerV1.Challenge{
+			Hash:      s.primaryHash,
+			ByteIndex: int32(s.byteIndex), //nolint:gosec // the index is less than the hash length
+			ByteValue: uint32(s.byteValue),
+			Algorithm: s.puzzle.Algorithm(),
+			Params:    s.params,
+		}
+	}
+
+	reporter, ok := s.pow.(pow.DifficultyReporter)
+	if !ok {
+		return body, nil
+	}
+	if difficulty < 0 {
+		difficulty = reporter.Difficulty()
+	}
+
+	return body, &powerV1.Challenge{
+		Hash:       s.primaryHash,
+		ByteIndex:  int32(s.byteIndex), //nolint:gosec // the index is less than the hash length
+		ByteValue:  uint32(s.byteValue),
+		Difficulty: int32(difficulty), //nolint:gosec // the difficulty is less than the hash length
+		Algorithm:  pow.AlgorithmSHA256,
+	}
+}
+
+// redeem spends a credit for an empty body, otherwise checks the nonce of the current challenge.
+func (s *session) redeem(body []byte) bool {
+	if s.trusted {
+		return true
+	}
+
+	if len(body) == 0 {
+		if s.credits == 0 {
+			return false
+		}
+		s.credits--
+		return true
+	}
+
+	if s.solved {
+		return false
+	}
+
+	if !s.isSolution(body) {
+		return false
+	}
+
+	s.solved = true
+	s.credits = s.quota - 1
+	return true
+}
+
+// paidDifficulty returns the difficulty of the current challenge, 0 if the handler doesn't report it
+// or the challenge is a puzzle.
+func (s *session) paidDifficulty() int {
+	if s.puzzle != nil {
+		return 0
+	}
+	if s.difficulty >= 0 {
+		return s.difficulty
+	}
+	if reporter, ok := s.pow.(pow.DifficultyReporter); ok {
+		return reporter.Difficulty()
+	}
+	return 0
+}
+
+// isSolution checks the body of a Content message against the current challenge.
+func (s *session) isSolution(body []byte) bool {
+	if s.puzzle != nil {
+		return s.puzzle.Verify(s.primaryHash, s.params, body)
+	}
+
+	nonce, err := common.GetNonceFromMessage(body)
+	if err != nil {
+		return false
+	}
+	return s.isValid(s.pow.GenerateHash(s.primaryHash, nonce))
+}
+
+func (s *session) isValid(hash []byte) bool {
+	if verifier, ok := s.pow.(DifficultyVerifier); ok && s.difficulty >= 0 {
+		return verifier.IsValidHashAt(hash, s.difficulty, s.byteIndex, s.byteValue)
+	}
+	return s.pow.IsValidHash(hash, s.byteIndex, s.byteValue)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrAccessRefused is returned for the denied and the banned addresses.
+var ErrAccessRefused = errors.New("access refused")
+
+// MessageStream carries the powerV1.Message of one client, e.g. powerV1.Power_ExchangeServer.
+// Its context is done when the client goes away.
+type MessageStream interface {
+	Context() context.Context
+	Send(msg *powerV1.Message) error
+	Recv() (*powerV1.Message, error)
+}
+
+// ServeStream serves the PoW protocol on a message stream of the remote address, e.g. the gRPC Exchange.
+// The stream is handled like a TCP connection: the same session, access lists, bans, reputation and
+// rules. It returns ErrAccessRefused for the denied and the banned addresses and ErrNotSupported in
+// the reverse-proxy mode, a stream can't be spliced; otherwise it returns when the client closes
+// the stream or the connection is dropped.
+func (h *Server) ServeStream(stream MessageStream, remote net.Addr) error {
+	if h.proxy != nil {
+		return errors.Wrap(ErrNotSupported, "the proxy mode splices the tcp connections only")
+	}
+
+	allowed, err := h.CheckAccess(remote)
+	if err != nil {
+		return err
+	}
+
+	h.handleConnection(stream.Context(), &streamTransport{
+		stream: stream, remote: remote, readTimeout: h.readTimeout, closed: make(chan struct{}),
+	}, allowed)
+	return nil
+}
+
+// CheckAccess checks the address against the access lists and the bans, like the TCP listener does.
+// It returns ErrAccessRefused for the refused addresses and reports whether the address is allowlisted.
+func (h *Server) CheckAccess(remote net.Addr) (bool, error) {
+	ip, ok := remoteIP(remote)
+	if !ok {
+		return false, nil
+	}
+
+	allowed, refused := h.checkAccess(ip)
+	if refused {
+		return false, errors.Wrap(ErrAccessRefused, ip.String())
+	}
+	return allowed, nil
+}
+
+type received struct {
+	msg *powerV1.Message
+	err error
+}
+
+// streamTransport adapts a MessageStream. A stream has no read deadline, so every read waits for
+// the message in a goroutine; the one left by a timeout returns when the stream ends.
+type streamTransport struct {
+	stream      MessageStream
+	remote      net.Addr
+	readTimeout time.Duration
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *streamTransport) ReadMessage() (*powerV1.Message, error) {
+	select {
+	case <-t.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	result := make(chan received, 1)
+	go func() {
+		msg, err := t.stream.Recv()
+		result <- received{msg: msg, err: err}
+	}()
+
+	var timeout <-chan time.Time
+	if t.readTimeout > 0 {
+		timer := time.NewTimer(t.readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case r := <-result:
+		if r.err != nil {
+			return nil, errors.Wrap(r.err, "receive message")
+		}
+		return r.msg, nil
+	case <-timeout:
+		return nil, errors.Wrap(os.ErrDeadlineExceeded, "receive message")
+	case <-t.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (t *streamTransport) WriteMessage(msg *powerV1.Message) error {
+	log.WithField("command", msg.GetCommand()).Debug("send a message")
+
+	if err := t.stream.Send(msg); err != nil {
+		return errors.Wrap(err, "send message")
+	}
+	return nil
+}
+
+func (t *streamTransport) RemoteAddr() net.Addr {
+	return t.remote
+}
+
+// Close unblocks the read, the stream itself ends when ServeStream returns.
+func (t *streamTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/common"
+	server "github.com/kriuchkov/power/pkg/server"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// testStream is an in-memory server.MessageStream.
+type testStream struct {
+	ctx  context.Context
+	in   chan *powerV1.Message
+	sent chan *powerV1.Message
+}
+
+func newTestStream(t *testing.T) *testStream {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &testStream{ctx: ctx, in: make(chan *powerV1.Message), sent: make(chan *powerV1.Message, 1)}
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+func (s *testStream) Send(msg *powerV1.Message) error {
+	s.sent <- msg
+	return nil
+}
+
+func (s *testStream) Recv() (*powerV1.Message, error) {
+	select {
+	case msg := <-s.in:
+		return msg, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *testStream) exchange(t *testing.T, msg *powerV1.Message) *powerV1.Message {
+	t.Helper()
+
+	s.in <- msg
+	select {
+	case response := <-s.sent:
+		return response
+	case <-time.After(time.Second):
+		require.FailNow(t, "no response")
+		return nil
+	}
+}
+
+func TestServeStream(t *testing.T) {
+	t.Parallel()
+
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+
+	t.Run("exchange", func(t *testing.T) {
+		t.Parallel()
+
+		serv := startServer(t, &server.Dependencies{ReadTimeout: 300 * time.Millisecond})
+		stream := newTestStream(t)
+
+		served := make(chan error, 1)
+		go func() { served <- serv.ServeStream(stream, remote) }()
+
+		verifyMessage := stream.exchange(t, &powerV1.Message{Command: powerV1.CommandType_Connect})
+		require.Equal(t, powerV1.CommandType_Connect, verifyMessage.GetCommand())
+
+		hash, byteIndex, byteValue, err := common.SplitMessage(verifyMessage.GetBody())
+		require.NoError(t, err)
+		nonce := pow.NewPow(0).FindNonce(context.Background(), hash, byteIndex, byteValue)
+
+		contentMessage := stream.exchange(t, &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))})
+		require.Equal(t, []byte("msg received"), contentMessage.GetBody())
+		require.Equal(t, "grpc", waitConnections(t, serv, 1)[0].Transport)
+
+		// an idle stream is dropped by the read timeout
+		select {
+		case err = <-served:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			require.FailNow(t, "the idle stream isn't dropped")
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		t.Parallel()
+
+		serv := startServer(t, &server.Dependencies{Denylist: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}})
+		require.ErrorIs(t, serv.ServeStream(newTestStream(t), remote), server.ErrAccessRefused)
+	})
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+)
+
+// issueToken returns the access token of a solved challenge, empty without Dependencies.Tokens.
+func (h *Server) issueToken(subject string, difficulty int) string {
+	if h.tokens == nil {
+		return ""
+	}
+
+	issued, claims, err := h.tokens.Issue(subject, difficulty)
+	if err != nil {
+		log.WithError(err).Error("issue a token")
+		return ""
+	}
+
+	h.counters.issuedTokens.Add(1)
+	log.WithFields(log.Fields{"subject": subject, "expires_at": claims.ExpiresAt, "uses": claims.Uses}).
+		Debug("issue a token")
+	return issued
+}
+
+// redeemToken spends a use of the token presented by the subject.
+func (h *Server) redeemToken(presented, subject string) bool {
+	if h.tokens == nil {
+		return false
+	}
+
+	claims, left, err := h.tokens.Redeem(presented, subject)
+	if err != nil {
+		log.WithError(err).WithField("subject", subject).Debug("refuse a token")
+		return false
+	}
+
+	h.counters.redeemedTokens.Add(1)
+	log.WithFields(log.Fields{"subject": subject, "id": claims.ID, "left": left}).Debug("redeem a token")
+	return true
+}
+
+// privateTokenKey returns the issuer key and the epoch of the anonymous tokens.
+func (h *Server) privateTokenKey() *powerV1.PrivateTokens {
+	epoch := h.privacyPass.Epoch()
+	return &powerV1.PrivateTokens{
+		PublicKey: h.privacyPass.PublicKey(),
+		Issuer:    h.privacyPass.Name(),
+		MaxBatch:  uint32(h.privacyPass.MaxBatch()), //nolint:gosec // the batch is validated
+		Epoch:     epoch[:],
+	}
+}
+
+// issuePrivateTokens signs the blinded anonymous tokens of a solved challenge, nil on a wrong batch.
+func (h *Server) issuePrivateTokens(blinded [][]byte) *powerV1.PrivateTokens {
+	signatures, err := h.privacyPass.Sign(blinded)
+	if err != nil {
+		log.WithError(err).Debug("refuse a token batch")
+		return nil
+	}
+
+	h.counters.issuedPrivateTokens.Add(int64(len(signatures)))
+	log.WithField("tokens", len(signatures)).Debug("issue anonymous tokens")
+	return &powerV1.PrivateTokens{Blinded: signatures}
+}
+
+// redeemPrivateToken spends an anonymous token. Nothing about the client is logged, the tokens are
+// not linkable by design.
+func (h *Server) redeemPrivateToken(presented []byte) bool {
+	if err := h.privacyPass.Redeem(presented); err != nil {
+		log.WithError(err).Debug("refuse an anonymous token")
+		return false
+	}
+
+	h.counters.redeemedPrivateTokens.Add(1)
+	return true
+}
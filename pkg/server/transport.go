@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/common"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+
+	"github.com/go-faster/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// errMalformedMessage is returned for the frames which can be skipped, the connection is closed after
+// maxMalformedMessages of them.
+var errMalformedMessage = errors.New("malformed message")
+
+// maxMalformedMessages malformed frames close the connection, so the junk neither floods the log nor
+// keeps the connection open past the read timeout.
+const maxMalformedMessages = 3
+
+// transport carries the powerV1.Message frames of one client connection.
+type transport interface {
+	ReadMessage() (*powerV1.Message, error)
+	WriteMessage(msg *powerV1.Message) error
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// tcpTransport frames the messages with a big-endian int32 size prefix, see common.ReadFrame.
+// A message has to arrive within readTimeout.
+type tcpTransport struct {
+	conn           net.Conn
+	maxMessageSize int
+	readTimeout    time.Duration
+}
+
+func (t *tcpTransport) ReadMessage() (*powerV1.Message, error) {
+	if t.readTimeout > 0 {
+		if err := t.conn.SetReadDeadline(time.Now().Add(t.readTimeout)); err != nil {
+			return nil, errors.Wrap(err, "set read deadline")
+		}
+	}
+
+	msgBuffer, err := common.ReadFrame(t.conn, t.maxMessageSize)
+	if err != nil {
+		if errors.Is(err, common.ErrInvalidSize) {
+			return nil, errors.Wrap(errMalformedMessage, err.Error())
+		}
+		return nil, err //nolint:wrapcheck // it's wrapped by ReadFrame
+	}
+
+	var protoMessage powerV1.Message
+	if err = proto.Unmarshal(msgBuffer, &protoMessage); err != nil {
+		return nil, errors.Wrap(errMalformedMessage, err.Error())
+	}
+	return &protoMessage, nil
+}
+
+func (t *tcpTransport) WriteMessage(msg *powerV1.Message) error {
+	response, err := proto.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "marshal message")
+	}
+
+	size := sizeOfMessage(response)
+	if err = binary.Write(t.conn, binary.BigEndian, size); err != nil {
+		return errors.Wrap(err, "write message size")
+	}
+
+	log.WithFields(log.Fields{"size": size, "command": msg.GetCommand()}).
+		Debug("send a message")
+
+	if _, err = t.conn.Write(response); err != nil {
+		return errors.Wrap(err, "write message")
+	}
+	return nil
+}
+
+func (t *tcpTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close() //nolint:wrapcheck // it's a proxy method
+}
+
+func sizeOfMessage(msg []byte) int32 {
+	return int32(len(msg))
+}
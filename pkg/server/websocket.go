@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+
+	"github.com/go-faster/errors"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+const webSocketWriteTimeout = 5 * time.Second
+
+// WebSocketHandler serves the PoW protocol for browsers. Every binary WebSocket message carries
+// one powerV1.Message, the size prefix of the TCP framing isn't needed. The connection handling
+// is shared with the TCP listener, so both transports behave the same. Only the TCP connections
+// can be spliced, so it answers 501 in the reverse-proxy mode before the client pays.
+func (h *Server) WebSocketHandler() http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.proxy != nil {
+			http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+			return
+		}
+
+		var allowed bool
+		if ip, ok := parseIP(r.RemoteAddr); ok {
+			var refused bool
+			if allowed, refused = h.checkAccess(ip); refused {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.WithError(err).Debug("upgrade to websocket")
+			return // the upgrader has already replied
+		}
+
+		conn.SetReadLimit(int64(h.maxMessageSize))
+		h.handleConnection(r.Context(), &webSocketTransport{conn: conn, readTimeout: h.readTimeout}, allowed)
+	})
+}
+
+// checkOrigin allows the same origin and the origins from Dependencies.WebSocketOrigins.
+func (h *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host || slices.Contains(h.webSocketOrigins, origin)
+}
+
+type webSocketTransport struct {
+	conn        *websocket.Conn
+	readTimeout time.Duration
+}
+
+func (t *webSocketTransport) ReadMessage() (*powerV1.Message, error) {
+	if t.readTimeout > 0 {
+		if err := t.conn.SetReadDeadline(time.Now().Add(t.readTimeout)); err != nil {
+			return nil, errors.Wrap(err, "set read deadline")
+		}
+	}
+
+	messageType, data, err := t.conn.ReadMessage()
+	if err != nil {
+		return nil, errors.Wrap(err, "read message")
+	}
+
+	if messageType != websocket.BinaryMessage {
+		return nil, errors.Wrap(errMalformedMessage, "not a binary message")
+	}
+
+	var protoMessage powerV1.Message
+	if err = proto.Unmarshal(data, &protoMessage); err != nil {
+		return nil, errors.Wrap(errMalformedMessage, err.Error())
+	}
+	return &protoMessage, nil
+}
+
+func (t *webSocketTransport) WriteMessage(msg *powerV1.Message) error {
+	response, err := proto.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "marshal message")
+	}
+
+	if err = t.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout)); err != nil {
+		return errors.Wrap(err, "set write deadline")
+	}
+
+	log.WithFields(log.Fields{"size": len(response), "command": msg.GetCommand()}).
+		Debug("send a message")
+
+	if err = t.conn.WriteMessage(websocket.BinaryMessage, response); err != nil {
+		return errors.Wrap(err, "write message")
+	}
+	return nil
+}
+
+func (t *webSocketTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+func (t *webSocketTransport) Close() error {
+	deadline := time.Now().Add(webSocketWriteTimeout)
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	t.conn.WriteControl(websocket.CloseMessage, message, deadline) //nolint:errcheck // the peer may be gone
+	return t.conn.Close()                                          //nolint:wrapcheck // it's a proxy method
+}
@@ -0,0 +1,100 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/common"
+	server "github.com/kriuchkov/power/pkg/server"
+
+	"github.com/gorilla/websocket"
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func newWebSocketServer(t *testing.T, address string) *httptest.Server {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	serv, err := server.New(&server.Dependencies{
+		TCPAddress:     address,
+		MessageHandler: func() []byte { return []byte("msg received") },
+		PowHandler:     pow.NewPow(1),
+	})
+	require.NoError(t, err)
+
+	go serv.Listen(ctx)
+
+	httpServer := httptest.NewServer(serv.WebSocketHandler())
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	t.Parallel()
+
+	httpServer := newWebSocketServer(t, ":19195")
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	defer conn.Close()
+
+	exchange := func(msg *powerV1.Message) *powerV1.Message {
+		data, mErr := proto.Marshal(msg)
+		require.NoError(t, mErr)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, data))
+
+		messageType, response, rErr := conn.ReadMessage()
+		require.NoError(t, rErr)
+		require.Equal(t, websocket.BinaryMessage, messageType)
+
+		var responseMessage powerV1.Message
+		require.NoError(t, proto.Unmarshal(response, &responseMessage))
+		return &responseMessage
+	}
+
+	verifyMessage := exchange(&powerV1.Message{Command: powerV1.CommandType_Connect})
+	require.Equal(t, powerV1.CommandType_Connect, verifyMessage.GetCommand())
+
+	hash, byteIndex, byteValue, err := common.SplitMessage(verifyMessage.GetBody())
+	require.NoError(t, err)
+	nonce := pow.NewPow(1).FindNonce(context.Background(), hash, byteIndex, byteValue)
+
+	invalidMessage := exchange(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte("-1")})
+	require.Equal(t, powerV1.CommandType_ErrInvalidHash, invalidMessage.GetCommand())
+
+	contentMessage := exchange(&powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))})
+	require.Equal(t, powerV1.CommandType_Content, contentMessage.GetCommand())
+	require.Equal(t, []byte("msg received"), contentMessage.GetBody())
+}
+
+func TestWebSocketHandler_ReferenceSolver(t *testing.T) {
+	t.Parallel()
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	httpServer := newWebSocketServer(t, ":19196")
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, node, "--experimental-websocket", "../../web/cli.mjs", url)
+	output, err := cmd.Output()
+	require.NoError(t, err)
+	require.Equal(t, "msg received", string(output))
+}
@@ -0,0 +1,190 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	DefaultTTL = 5 * time.Minute
+
+	idSize = 16
+	// minSpentSweep is the number of the remembered tokens which triggers the first sweep of the
+	// expired ones, the next sweep happens when the number doubles.
+	minSpentSweep = 1024
+)
+
+type Dependencies struct {
+	Signer Signer `validate:"required"`
+	// TTL is the lifetime of a token, DefaultTTL by default.
+	TTL time.Duration `validate:"gte=0"`
+	// Uses is the number of the requests a token is worth, zero is unlimited until the expiration.
+	Uses int `validate:"gte=0"`
+	// Store counts the uses of the tokens, in the memory of this process by default. A restart resets
+	// such counters, and the servers sharing the key have to share the store, or a token is worth
+	// Uses requests on every one of them.
+	Store UseStore
+	// Issuer names the server in the tokens. Optional.
+	Issuer string
+	// BindSubject accepts a token only from the subject it's issued to, e.g. the client address.
+	BindSubject bool
+
+	// Now is time.Now by default, the tests move the clock.
+	Now func() time.Time
+}
+
+func (d *Dependencies) SetDefaults() {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(d); err != nil {
+		panic(err)
+	}
+
+	if d.TTL == 0 {
+		d.TTL = DefaultTTL
+	}
+	if d.Now == nil {
+		d.Now = time.Now
+	}
+	if d.Store == nil {
+		d.Store = NewMemoryUseStore(d.Now)
+	}
+}
+
+// UseStore counts the uses of the tokens until they expire.
+type UseStore interface {
+	// Use spends a use of the token worth the uses, it returns the uses left or ErrExhausted.
+	Use(id string, uses int, expiresAt time.Time) (int, error)
+}
+
+// Issuer issues the tokens and redeems them, counting the uses. It's safe for concurrent use.
+type Issuer struct {
+	signer Signer
+	ttl    time.Duration
+	uses   int
+	name   string
+	bind   bool
+	now    func() time.Time
+	store  UseStore
+}
+
+func NewIssuer(deps *Dependencies) *Issuer {
+	deps.SetDefaults()
+
+	return &Issuer{
+		signer: deps.Signer,
+		ttl:    deps.TTL,
+		uses:   deps.Uses,
+		name:   deps.Issuer,
+		bind:   deps.BindSubject,
+		now:    deps.Now,
+		store:  deps.Store,
+	}
+}
+
+// Signer returns the key of the tokens, e.g. to publish the public key.
+func (i *Issuer) Signer() Signer {
+	return i.signer
+}
+
+// Issue returns a new token of the subject which solved a challenge of the difficulty.
+func (i *Issuer) Issue(subject string, difficulty int) (string, Claims, error) {
+	id := make([]byte, idSize)
+	rand.Read(id) //nolint:errcheck // crypto/rand.Read never fails
+
+	now := i.now()
+	claims := Claims{
+		ID:         base64.RawURLEncoding.EncodeToString(id),
+		Issuer:     i.name,
+		Subject:    subject,
+		IssuedAt:   now.Unix(),
+		ExpiresAt:  now.Add(i.ttl).Unix(),
+		Uses:       i.uses,
+		Difficulty: difficulty,
+	}
+
+	token, err := Sign(i.signer, &claims)
+	return token, claims, err
+}
+
+// Redeem verifies the token of the subject and spends a use of it. It returns the uses left,
+// -1 for an unlimited token.
+func (i *Issuer) Redeem(token, subject string) (Claims, int, error) {
+	now := i.now()
+
+	claims, err := Verify(i.signer, token, now)
+	if err != nil {
+		return Claims{}, 0, err
+	}
+	if i.bind && claims.Subject != subject {
+		return Claims{}, 0, errors.Wrapf(ErrWrongHolder, "issued to %s", claims.Subject)
+	}
+	if claims.Uses == 0 {
+		return claims, -1, nil
+	}
+
+	left, err := i.store.Use(claims.ID, claims.Uses, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return Claims{}, 0, errors.Wrap(err, "use the token")
+	}
+	return claims, left, nil
+}
+
+// MemoryUseStore is a UseStore in the memory of one process, the tokens are remembered until they expire.
+type MemoryUseStore struct {
+	now func() time.Time
+
+	mu sync.Mutex
+	// spent are the uses left by token ID
+	spent   map[string]*spentToken
+	sweepAt int
+}
+
+var _ UseStore = (*MemoryUseStore)(nil)
+
+type spentToken struct {
+	left      int
+	expiresAt int64
+}
+
+// NewMemoryUseStore returns an empty store, now is time.Now if nil.
+func NewMemoryUseStore(now func() time.Time) *MemoryUseStore {
+	if now == nil {
+		now = time.Now
+	}
+	return &MemoryUseStore{now: now, spent: map[string]*spentToken{}, sweepAt: minSpentSweep}
+}
+
+func (s *MemoryUseStore) Use(id string, uses int, expiresAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spent, ok := s.spent[id]
+	if !ok {
+		if len(s.spent) >= s.sweepAt {
+			s.sweep(s.now())
+		}
+		spent = &spentToken{left: uses, expiresAt: expiresAt.Unix()}
+		s.spent[id] = spent
+	}
+
+	if spent.left == 0 {
+		return 0, ErrExhausted
+	}
+	spent.left--
+	return spent.left, nil
+}
+
+// sweep forgets the expired tokens, they're refused by the expiration anyway.
+func (s *MemoryUseStore) sweep(now time.Time) {
+	for id, spent := range s.spent {
+		if now.Unix() >= spent.expiresAt {
+			delete(s.spent, id)
+		}
+	}
+	s.sweepAt = max(minSpentSweep, 2*len(s.spent))
+}
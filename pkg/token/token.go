@@ -0,0 +1,206 @@
+// Package token issues and verifies the access tokens of the clients which solved a challenge.
+//
+// A token is "<algorithm>.<claims>.<signature>", the claims are base64url JSON and the signature
+// covers "<algorithm>.<claims>", e.g.:
+//
+//	ed25519.eyJqdGkiOiI...In0.Yq3v...
+//
+// The Ed25519 tokens can be verified by any service with the public key of the server, offline;
+// the HS256 ones by the services sharing the secret. The expiration is checked by Verify, the number
+// of the uses only by the Issuer, which remembers the spent tokens.
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+const (
+	Ed25519 = "ed25519"
+	HS256   = "hs256"
+)
+
+var (
+	ErrMalformed   = errors.New("malformed token")
+	ErrForged      = errors.New("token signature mismatch")
+	ErrExpired     = errors.New("token expired")
+	ErrAlgorithm   = errors.New("unexpected token algorithm")
+	ErrExhausted   = errors.New("token is used up")
+	ErrWrongHolder = errors.New("token is issued to another address")
+)
+
+// Claims are the signed content of a token.
+type Claims struct {
+	// ID is random, the issuer counts the uses by it.
+	ID        string `json:"jti"`
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// Uses is the number of the requests the token is worth, zero is unlimited until the expiration.
+	Uses int `json:"uses,omitempty"`
+	// Difficulty is the one of the solved challenge.
+	Difficulty int `json:"dif"`
+}
+
+// Expired reports whether the token is expired at the time.
+func (c *Claims) Expired(now time.Time) bool {
+	return now.Unix() >= c.ExpiresAt
+}
+
+// Verifier checks the signatures of an algorithm.
+type Verifier interface {
+	Algorithm() string
+	Verify(message, signature []byte) bool
+}
+
+// Signer signs the tokens and verifies its own ones.
+type Signer interface {
+	Verifier
+	Sign(message []byte) []byte
+}
+
+// HMACKey signs the tokens with HMAC-SHA256, the verifiers share the secret.
+type HMACKey []byte
+
+var _ Signer = HMACKey(nil)
+
+func (k HMACKey) Algorithm() string {
+	return HS256
+}
+
+func (k HMACKey) Sign(message []byte) []byte {
+	h := hmac.New(sha256.New, k)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+func (k HMACKey) Verify(message, signature []byte) bool {
+	return hmac.Equal(signature, k.Sign(message))
+}
+
+// Ed25519Key signs the tokens with Ed25519, the verifiers need only PublicKey.
+type Ed25519Key struct {
+	private ed25519.PrivateKey
+}
+
+var _ Signer = (*Ed25519Key)(nil)
+
+// NewEd25519Key derives the key from a secret of any length, the same secret gives the same key.
+func NewEd25519Key(secret []byte) *Ed25519Key {
+	seed := sha256.Sum256(secret)
+	return &Ed25519Key{private: ed25519.NewKeyFromSeed(seed[:])}
+}
+
+func (k *Ed25519Key) Algorithm() string {
+	return Ed25519
+}
+
+func (k *Ed25519Key) Sign(message []byte) []byte {
+	return ed25519.Sign(k.private, message)
+}
+
+func (k *Ed25519Key) Verify(message, signature []byte) bool {
+	return k.PublicKey().Verify(message, signature)
+}
+
+// PublicKey returns the key of the verifiers.
+func (k *Ed25519Key) PublicKey() Ed25519PublicKey {
+	return Ed25519PublicKey(k.private.Public().(ed25519.PublicKey)) //nolint:forcetypeassert // it's always ed25519
+}
+
+// Ed25519PublicKey verifies the Ed25519 tokens.
+type Ed25519PublicKey ed25519.PublicKey
+
+var _ Verifier = Ed25519PublicKey(nil)
+
+// ParseEd25519PublicKey decodes the base64url form of String.
+func ParseEd25519PublicKey(s string) (Ed25519PublicKey, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.Wrap(ErrMalformed, "the public key is 32 bytes in base64url")
+	}
+	return Ed25519PublicKey(key), nil
+}
+
+func (k Ed25519PublicKey) Algorithm() string {
+	return Ed25519
+}
+
+func (k Ed25519PublicKey) Verify(message, signature []byte) bool {
+	return len(k) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(k), message, signature)
+}
+
+// String returns the key in base64url.
+func (k Ed25519PublicKey) String() string {
+	return base64.RawURLEncoding.EncodeToString(k)
+}
+
+// Sign returns the signed token of the claims.
+func Sign(signer Signer, claims *Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal claims")
+	}
+
+	signed := signer.Algorithm() + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signer.Sign([]byte(signed))), nil
+}
+
+// Verify checks the algorithm, the signature and the expiration of the token and returns its claims.
+func Verify(verifier Verifier, token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	if parts[0] != verifier.Algorithm() {
+		return Claims{}, errors.Wrapf(ErrAlgorithm, "got %q, expected %q", parts[0], verifier.Algorithm())
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if !verifier.Verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return Claims{}, ErrForged
+	}
+
+	claims, err := parseClaims(parts[1])
+	if err != nil {
+		return Claims{}, err
+	}
+	if claims.Expired(now) {
+		return Claims{}, ErrExpired
+	}
+	return claims, nil
+}
+
+// Parse decodes the claims without verifying them. Clients use it to read the expiration.
+func Parse(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+	return parseClaims(parts[1])
+}
+
+func parseClaims(raw string) (Claims, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	var claims Claims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, errors.Wrap(ErrMalformed, err.Error())
+	}
+	return claims, nil
+}
@@ -0,0 +1,148 @@
+package token_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/token"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ed := token.NewEd25519Key([]byte("a secret of the ed25519 key"))
+	hs := token.HMACKey("a secret of the hmac key")
+
+	claims := token.Claims{ID: "id", Subject: "192.0.2.1", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	edToken, err := token.Sign(ed, &claims)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(edToken, "ed25519."))
+	hsToken, err := token.Sign(hs, &claims)
+	require.NoError(t, err)
+
+	// the verifiers need only the public key
+	public, err := token.ParseEd25519PublicKey(ed.PublicKey().String())
+	require.NoError(t, err)
+
+	forged := edToken[:len(edToken)-2] + "AA"
+	if forged == edToken {
+		forged = edToken[:len(edToken)-2] + "BB"
+	}
+
+	tests := []struct {
+		name     string
+		verifier token.Verifier
+		token    string
+		now      time.Time
+		err      error
+	}{
+		{name: "ed25519", verifier: public, token: edToken, now: now},
+		{name: "hs256", verifier: hs, token: hsToken, now: now},
+		{name: "expired", verifier: public, token: edToken, now: now.Add(time.Minute), err: token.ErrExpired},
+		{name: "forged", verifier: public, token: forged, now: now, err: token.ErrForged},
+		{name: "another key", verifier: token.NewEd25519Key([]byte("another")), token: edToken, now: now, err: token.ErrForged},
+		{name: "algorithm", verifier: public, token: hsToken, now: now, err: token.ErrAlgorithm},
+		{name: "malformed", verifier: public, token: "ed25519.e30", now: now, err: token.ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			verified, err := token.Verify(tt.verifier, tt.token, tt.now)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, claims, verified)
+		})
+	}
+
+	parsed, err := token.Parse(forged)
+	require.NoError(t, err)
+	require.Equal(t, claims, parsed)
+}
+
+func TestIssuer(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	issuer := token.NewIssuer(&token.Dependencies{
+		Signer:      token.HMACKey("a secret of the hmac key"),
+		TTL:         time.Minute,
+		Uses:        2,
+		Issuer:      "power",
+		BindSubject: true,
+		Now:         func() time.Time { return now },
+	})
+
+	issued, claims, err := issuer.Issue("192.0.2.1", 3)
+	require.NoError(t, err)
+	require.Equal(t, "power", claims.Issuer)
+	require.Equal(t, 3, claims.Difficulty)
+
+	_, _, err = issuer.Redeem(issued, "192.0.2.2")
+	require.ErrorIs(t, err, token.ErrWrongHolder)
+
+	_, left, err := issuer.Redeem(issued, "192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, 1, left)
+	_, left, err = issuer.Redeem(issued, "192.0.2.1")
+	require.NoError(t, err)
+	require.Zero(t, left)
+	_, _, err = issuer.Redeem(issued, "192.0.2.1")
+	require.ErrorIs(t, err, token.ErrExhausted)
+
+	// a fresh token is worth its uses again
+	another, _, err := issuer.Issue("192.0.2.1", 3)
+	require.NoError(t, err)
+	_, _, err = issuer.Redeem(another, "192.0.2.1")
+	require.NoError(t, err)
+}
+
+func TestIssuer_Unlimited(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	issuer := token.NewIssuer(&token.Dependencies{
+		Signer: token.NewEd25519Key([]byte("a secret")),
+		Now:    func() time.Time { return now },
+	})
+
+	issued, _, err := issuer.Issue("192.0.2.1", 1)
+	require.NoError(t, err)
+
+	for range 5 {
+		_, left, err := issuer.Redeem(issued, "192.0.2.2")
+		require.NoError(t, err)
+		require.Equal(t, -1, left)
+	}
+
+	now = now.Add(token.DefaultTTL)
+	_, _, err = issuer.Redeem(issued, "192.0.2.1")
+	require.ErrorIs(t, err, token.ErrExpired)
+}
+
+func TestIssuer_Store(t *testing.T) {
+	t.Parallel()
+
+	// the replicas sharing the key share the use counters
+	store := token.NewMemoryUseStore(nil)
+	replicas := []*token.Issuer{
+		token.NewIssuer(&token.Dependencies{Signer: token.HMACKey("a secret of the hmac key"), Uses: 1, Store: store}),
+		token.NewIssuer(&token.Dependencies{Signer: token.HMACKey("a secret of the hmac key"), Uses: 1, Store: store}),
+	}
+
+	issued, _, err := replicas[0].Issue("192.0.2.1", 1)
+	require.NoError(t, err)
+
+	_, _, err = replicas[0].Redeem(issued, "192.0.2.1")
+	require.NoError(t, err)
+	_, _, err = replicas[1].Redeem(issued, "192.0.2.1")
+	require.ErrorIs(t, err, token.ErrExhausted)
+}
@@ -0,0 +1,196 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.26.1
+// source: v1/power.proto
+
+package v1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Power_GetChallenge_FullMethodName = "/power.Power/GetChallenge"
+	Power_Redeem_FullMethodName       = "/power.Power/Redeem"
+	Power_Exchange_FullMethodName     = "/power.Power/Exchange"
+)
+
+// PowerClient is the client API for Power service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PowerClient interface {
+	// GetChallenge and Redeem are the stateless exchange, any replica can redeem the ticket.
+	GetChallenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*Challenge, error)
+	Redeem(ctx context.Context, in *Solution, opts ...grpc.CallOption) (*Message, error)
+	// Exchange carries the same messages as the TCP protocol.
+	Exchange(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Message, Message], error)
+}
+
+type powerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPowerClient(cc grpc.ClientConnInterface) PowerClient {
+	return &powerClient{cc}
+}
+
+func (c *powerClient) GetChallenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*Challenge, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Challenge)
+	err := c.cc.Invoke(ctx, Power_GetChallenge_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *powerClient) Redeem(ctx context.Context, in *Solution, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, Power_Redeem_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *powerClient) Exchange(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Message, Message], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Power_ServiceDesc.Streams[0], Power_Exchange_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Message, Message]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Power_ExchangeClient = grpc.BidiStreamingClient[Message, Message]
+
+// PowerServer is the server API for Power service.
+// All implementations must embed UnimplementedPowerServer
+// for forward compatibility.
+type PowerServer interface {
+	// GetChallenge and Redeem are the stateless exchange, any replica can redeem the ticket.
+	GetChallenge(context.Context, *ChallengeRequest) (*Challenge, error)
+	Redeem(context.Context, *Solution) (*Message, error)
+	// Exchange carries the same messages as the TCP protocol.
+	Exchange(grpc.BidiStreamingServer[Message, Message]) error
+	mustEmbedUnimplementedPowerServer()
+}
+
+// UnimplementedPowerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPowerServer struct{}
+
+func (UnimplementedPowerServer) GetChallenge(context.Context, *ChallengeRequest) (*Challenge, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChallenge not implemented")
+}
+func (UnimplementedPowerServer) Redeem(context.Context, *Solution) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Redeem not implemented")
+}
+func (UnimplementedPowerServer) Exchange(grpc.BidiStreamingServer[Message, Message]) error {
+	return status.Errorf(codes.Unimplemented, "method Exchange not implemented")
+}
+func (UnimplementedPowerServer) mustEmbedUnimplementedPowerServer() {}
+func (UnimplementedPowerServer) testEmbeddedByValue()               {}
+
+// UnsafePowerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PowerServer will
+// result in compilation errors.
+type UnsafePowerServer interface {
+	mustEmbedUnimplementedPowerServer()
+}
+
+func RegisterPowerServer(s grpc.ServiceRegistrar, srv PowerServer) {
+	// If the following call pancis, it indicates UnimplementedPowerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Power_ServiceDesc, srv)
+}
+
+func _Power_GetChallenge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChallengeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PowerServer).GetChallenge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Power_GetChallenge_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PowerServer).GetChallenge(ctx, req.(*ChallengeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Power_Redeem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Solution)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PowerServer).Redeem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Power_Redeem_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PowerServer).Redeem(ctx, req.(*Solution))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Power_Exchange_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PowerServer).Exchange(&grpc.GenericServerStream[Message, Message]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Power_ExchangeServer = grpc.BidiStreamingServer[Message, Message]
+
+// Power_ServiceDesc is the grpc.ServiceDesc for Power service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Power_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "power.Power",
+	HandlerType: (*PowerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetChallenge",
+			Handler:    _Power_GetChallenge_Handler,
+		},
+		{
+			MethodName: "Redeem",
+			Handler:    _Power_Redeem_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Exchange",
+			Handler:       _Power_Exchange_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "v1/power.proto",
+}
@@ -0,0 +1,320 @@
+//nolint:paralleltest // the tests measure the goroutines and the memory of the whole process
+package e2e_test
+
+import (
+	"bytes"
+	"math"
+	"net/netip"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/pkg/server"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttack_OversizedFrame(t *testing.T) {
+	h := startServer(t, server.Dependencies{MaxMessageSize: 1024})
+
+	tests := []struct {
+		name string
+		size int32
+	}{
+		{name: "over the limit", size: 1025},
+		{name: "max int32", size: math.MaxInt32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			conn := h.dial()
+			writeFrame(t, conn, tt.size, []byte("the rest doesn't matter"))
+			requireClosed(t, conn, time.Second)
+
+			runtime.ReadMemStats(&after)
+			require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20), "the frame body is allocated")
+		})
+	}
+
+	h.requireServes()
+}
+
+func TestAttack_TruncatedFrame(t *testing.T) {
+	h := startServer(t, server.Dependencies{})
+
+	t.Run("truncated size", func(t *testing.T) {
+		conn := h.dial()
+		_, err := conn.Write([]byte{0, 0})
+		require.NoError(t, err)
+		require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite()) //nolint:forcetypeassert // it's a TCP connection
+		requireClosed(t, conn, time.Second)
+	})
+
+	t.Run("truncated body", func(t *testing.T) {
+		conn := h.dial()
+		writeFrame(t, conn, 100, []byte("ten bytes."))
+		require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite()) //nolint:forcetypeassert // it's a TCP connection
+		requireClosed(t, conn, time.Second)
+	})
+
+	h.requireServes()
+}
+
+func TestAttack_GarbageProtobuf(t *testing.T) {
+	h := startServer(t, server.Dependencies{})
+
+	frames := map[string][]byte{
+		"invalid tag":     {0xff, 0xff, 0xff, 0xff},
+		"long field":      {0x0a, 0x7f, 'a'},
+		"varint overflow": {0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"continuations":   bytes.Repeat([]byte{0x80}, 64),
+	}
+	for name, payload := range frames {
+		t.Run(name, func(t *testing.T) {
+			conn := h.dial()
+			writeFrame(t, conn, int32(len(payload)), payload) //nolint:gosec // the payloads are small
+
+			// an unknown command is ignored
+			writeMessage(t, conn, &powerV1.Message{Command: powerV1.CommandType(900)})
+
+			// the connection survives a malformed frame and stays in sync
+			response := redeem(t, conn, h.solve(challenge(t, conn)))
+			require.Equal(t, powerV1.CommandType_Content, response.GetCommand())
+			require.Equal(t, testContent, string(response.GetBody()))
+		})
+	}
+
+	t.Run("flood", func(t *testing.T) {
+		conn := h.dial()
+
+		// the zero and negative sizes are malformed too, the third frame closes the connection
+		writeFrame(t, conn, 0, nil)
+		writeFrame(t, conn, -1, nil)
+		writeFrame(t, conn, 0, nil)
+		requireClosed(t, conn, time.Second)
+	})
+
+	h.requireServes()
+}
+
+func TestAttack_ReplayedNonce(t *testing.T) {
+	h := startServer(t, server.Dependencies{})
+	conn := h.dial()
+
+	solved := challenge(t, conn)
+	nonce := h.solve(solved)
+
+	response := redeem(t, conn, nonce)
+	require.Equal(t, powerV1.CommandType_Content, response.GetCommand())
+
+	t.Run("the same challenge", func(t *testing.T) {
+		response := redeem(t, conn, nonce)
+		require.Equal(t, powerV1.CommandType_ErrInvalidHash, response.GetCommand())
+	})
+
+	t.Run("a fresh challenge", func(t *testing.T) {
+		fresh := challenge(t, conn)
+		require.NotEqual(t, solved.GetHash(), fresh.GetHash())
+
+		if h.solves(fresh, nonce) {
+			t.Skip("the nonce solves the fresh challenge by chance")
+		}
+		response := redeem(t, conn, nonce)
+		require.Equal(t, powerV1.CommandType_ErrInvalidHash, response.GetCommand())
+	})
+
+	t.Run("another connection", func(t *testing.T) {
+		other := h.dial()
+		fresh := challenge(t, other)
+
+		if h.solves(fresh, nonce) {
+			t.Skip("the nonce solves the challenge by chance")
+		}
+		response := redeem(t, other, nonce)
+		require.Equal(t, powerV1.CommandType_ErrInvalidHash, response.GetCommand())
+	})
+
+	t.Run("the challenges don't repeat", func(t *testing.T) {
+		seen := map[string]bool{}
+		for range 200 {
+			c := challenge(t, h.dial())
+			require.False(t, seen[string(c.GetHash())], "a challenge is issued twice")
+			seen[string(c.GetHash())] = true
+		}
+	})
+}
+
+func TestAttack_BruteForce(t *testing.T) {
+	const (
+		maxFailures = 3
+		banDuration = 200 * time.Millisecond
+	)
+
+	h := startServer(t, server.Dependencies{BanPolicy: server.BanPolicy{
+		MaxFailures: maxFailures, Duration: banDuration, MaxDuration: time.Hour,
+	}})
+	loopback := netip.MustParsePrefix("127.0.0.1/32")
+
+	// guess sends the wrong nonces until the server closes the connection
+	guess := func() {
+		conn := h.dial()
+		c := challenge(t, conn)
+
+		nonce := 0
+		for failures := range maxFailures {
+			for h.solves(c, nonce) {
+				nonce++
+			}
+
+			if failures < maxFailures-1 {
+				response := redeem(t, conn, nonce)
+				require.Equal(t, powerV1.CommandType_ErrInvalidHash, response.GetCommand())
+			} else {
+				writeMessage(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))})
+			}
+			nonce++
+		}
+		requireClosed(t, conn, time.Second)
+	}
+
+	for strike := 1; strike <= 2; strike++ {
+		guess()
+
+		// the address is refused while the ban lasts
+		requireClosed(t, h.dial(), time.Second)
+
+		bans := h.serv.Bans()
+		require.Len(t, bans, 1)
+		require.Equal(t, loopback, bans[0].Prefix)
+
+		// the repeated ban is twice as long
+		expected := banDuration << (strike - 1)
+		require.InDelta(t, expected, time.Until(bans[0].Until), float64(banDuration/2))
+
+		time.Sleep(time.Until(bans[0].Until))
+	}
+
+	stats := h.serv.Stats()
+	require.Equal(t, int64(2), stats.AutoBans)
+	require.Equal(t, int64(2*maxFailures), stats.InvalidSolutions)
+	require.Equal(t, int64(2), stats.BannedConnections)
+
+	h.requireServes()
+}
+
+func TestAttack_ConnectFlood(t *testing.T) {
+	const (
+		floodConns    = 16
+		floodMessages = 300
+	)
+
+	h := startServer(t, server.Dependencies{})
+	flood := bytes.Repeat(frame(t, &powerV1.Message{Command: powerV1.CommandType_Connect}), floodMessages)
+
+	var wg sync.WaitGroup
+	for range floodConns {
+		conn := h.dial()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			go conn.Write(flood) //nolint:errcheck // the reads below fail if the write does
+
+			// an unsolved challenge is repeated, the flood doesn't buy a cheaper one
+			var first []byte
+			for i := range floodMessages {
+				msg, err := receive(conn)
+				if !assert.NoError(t, err) {
+					return
+				}
+				if i == 0 {
+					first = msg.GetChallenge().GetHash()
+				}
+				assert.Equal(t, first, msg.GetChallenge().GetHash())
+			}
+		}()
+	}
+
+	// the server keeps serving during the flood
+	h.requireServes()
+	wg.Wait()
+}
+
+func TestAttack_Slowloris(t *testing.T) {
+	const readTimeout = 200 * time.Millisecond
+
+	h := startServer(t, server.Dependencies{ReadTimeout: readTimeout})
+
+	t.Run("trickled frame", func(t *testing.T) {
+		conn := h.dial()
+		start := time.Now()
+
+		// a byte per 50ms never completes the 100 bytes frame in time
+		writeFrame(t, conn, 100, nil)
+		go func() {
+			for range 100 {
+				if _, err := conn.Write([]byte{0x0a}); err != nil {
+					return
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+
+		requireClosed(t, conn, 2*time.Second)
+		require.Less(t, time.Since(start), 2*readTimeout)
+	})
+
+	t.Run("idle connection", func(t *testing.T) {
+		conn := h.dial()
+		start := time.Now()
+
+		requireClosed(t, conn, 2*time.Second)
+		require.GreaterOrEqual(t, time.Since(start), readTimeout)
+	})
+
+	t.Run("many idle connections", func(t *testing.T) {
+		conns := make([]func(), 0, 100)
+		for range 100 {
+			conn := h.dial()
+			conns = append(conns, func() { requireClosed(t, conn, 2*time.Second) })
+		}
+		for _, check := range conns {
+			check()
+		}
+	})
+
+	t.Run("active connection", func(t *testing.T) {
+		conn := h.dial()
+
+		// the timeout is per message, a client which talks in time stays connected
+		for range 9 { // three read timeouts
+			challenge(t, conn)
+			time.Sleep(readTimeout / 3)
+		}
+	})
+}
+
+func TestAttack_Shutdown(t *testing.T) {
+	h := startServer(t, server.Dependencies{})
+
+	conns := make([]func(), 0, 10)
+	for range 10 {
+		conn := h.dial()
+		challenge(t, conn)
+		conns = append(conns, func() { requireClosed(t, conn, time.Second) })
+	}
+
+	// the waiting connections are closed with the server, not after the read timeout
+	h.cancel()
+	for _, check := range conns {
+		check()
+	}
+}
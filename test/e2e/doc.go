@@ -0,0 +1,3 @@
+// Package e2e holds the end-to-end tests of the server: a real Server with the real pow.Pow on
+// an in-process listener and the scripted adversarial clients.
+package e2e
@@ -0,0 +1,212 @@
+package e2e_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kriuchkov/power/internal/pow"
+	"github.com/kriuchkov/power/pkg/server"
+
+	powerV1 "github.com/kriuchkov/protobuf/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	testDifficulty = 0 // about 256 hashes per challenge, the tests are about the protocol
+	testContent    = "quote"
+	// ioTimeout bounds every read and write of the attackers, a hung server fails the test.
+	ioTimeout = 5 * time.Second
+)
+
+// harness is a server on an in-process listener.
+type harness struct {
+	t      *testing.T
+	addr   string
+	pow    *pow.Pow
+	serv   *server.Server
+	cancel context.CancelFunc
+}
+
+// startServer starts the server and stops it at the end of the test. The server goroutines have to
+// be gone by then, the leaks fail the test.
+func startServer(t *testing.T, deps server.Dependencies) *harness {
+	t.Helper()
+
+	baseline := runtime.NumGoroutine()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	powHandler := pow.NewPow(testDifficulty)
+	deps.Listener = listener
+	deps.PowHandler = powHandler
+	deps.MessageHandler = func() []byte { return []byte(testContent) }
+
+	serv, err := server.New(&deps)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serv.Listen(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		requireGoroutines(t, baseline)
+	})
+	return &harness{t: t, addr: serv.Addr().String(), pow: powHandler, serv: serv, cancel: cancel}
+}
+
+// requireGoroutines waits for the number of the goroutines to drop to the baseline.
+func requireGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+
+	// polls in the test goroutine, require.Eventually starts its own ones
+	deadline := time.Now().Add(ioTimeout)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), baseline, "the goroutines leak")
+}
+
+func (h *harness) dial() net.Conn {
+	h.t.Helper()
+
+	conn, err := net.DialTimeout("tcp", h.addr, ioTimeout)
+	require.NoError(h.t, err)
+	h.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// writeFrame writes the size prefix and the payload as is, the size can lie.
+func writeFrame(t *testing.T, conn net.Conn, size int32, payload []byte) {
+	t.Helper()
+
+	require.NoError(t, conn.SetWriteDeadline(time.Now().Add(ioTimeout)))
+	require.NoError(t, binary.Write(conn, binary.BigEndian, size))
+	_, err := conn.Write(payload)
+	require.NoError(t, err)
+}
+
+// frame returns the message with the size prefix.
+func frame(t *testing.T, msg *powerV1.Message) []byte {
+	t.Helper()
+
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(data))), data...) //nolint:gosec // the test messages are small
+}
+
+func writeMessage(t *testing.T, conn net.Conn, msg *powerV1.Message) {
+	t.Helper()
+
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	writeFrame(t, conn, int32(len(data)), data) //nolint:gosec // the test messages are small
+}
+
+func readMessage(t *testing.T, conn net.Conn) *powerV1.Message {
+	t.Helper()
+
+	msg, err := receive(conn)
+	require.NoError(t, err)
+	return msg
+}
+
+// receive reads a message, unlike readMessage it's safe outside of the test goroutine.
+func receive(conn net.Conn) (*powerV1.Message, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(ioTimeout)); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+
+	var msg powerV1.Message
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// exchange sends the message and reads the response.
+func exchange(t *testing.T, conn net.Conn, msg *powerV1.Message) *powerV1.Message {
+	t.Helper()
+
+	writeMessage(t, conn, msg)
+	return readMessage(t, conn)
+}
+
+// requireClosed checks that the server closes the connection within the timeout.
+func requireClosed(t *testing.T, conn net.Conn, timeout time.Duration) {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+
+	// EOF or a reset mean the server has closed it
+	_, err := io.Copy(io.Discard, conn)
+	require.False(t, errors.Is(err, os.ErrDeadlineExceeded), "the connection is still open")
+}
+
+// challenge requests the challenge of the connection.
+func challenge(t *testing.T, conn net.Conn) *powerV1.Challenge {
+	t.Helper()
+
+	response := exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Connect})
+	require.Equal(t, powerV1.CommandType_Connect, response.GetCommand())
+	require.NotNil(t, response.GetChallenge())
+	return response.GetChallenge()
+}
+
+// solve finds the nonce of the challenge with the real solver.
+func (h *harness) solve(c *powerV1.Challenge) int {
+	h.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ioTimeout)
+	defer cancel()
+
+	nonce := pow.NewPow(int(c.GetDifficulty())).
+		FindNonce(ctx, c.GetHash(), int(c.GetByteIndex()), byte(c.GetByteValue()))
+	require.GreaterOrEqual(h.t, nonce, 0, "the challenge isn't solved")
+	return nonce
+}
+
+// solves reports whether the nonce solves the challenge.
+func (h *harness) solves(c *powerV1.Challenge, nonce int) bool {
+	return h.pow.IsValidHash(h.pow.GenerateHash(c.GetHash(), nonce), int(c.GetByteIndex()), byte(c.GetByteValue()))
+}
+
+func redeem(t *testing.T, conn net.Conn, nonce int) *powerV1.Message {
+	t.Helper()
+
+	return exchange(t, conn, &powerV1.Message{Command: powerV1.CommandType_Content, Body: []byte(strconv.Itoa(nonce))})
+}
+
+// requireServes checks that a well-behaved client still gets the content.
+func (h *harness) requireServes() {
+	h.t.Helper()
+
+	conn := h.dial()
+	response := redeem(h.t, conn, h.solve(challenge(h.t, conn)))
+	require.Equal(h.t, powerV1.CommandType_Content, response.GetCommand())
+	require.Equal(h.t, testContent, string(response.GetBody()))
+}
@@ -0,0 +1,16 @@
+// Runs the reference solver from Node.js: node --experimental-websocket web/cli.mjs <ws-url>
+import { fetchContent } from "./solver.js";
+
+const [url] = process.argv.slice(2);
+if (!url) {
+  console.error("usage: cli.mjs <ws-url>");
+  process.exit(2);
+}
+
+try {
+  const content = await fetchContent(url);
+  process.stdout.write(new TextDecoder().decode(content));
+} catch (err) {
+  console.error(err.message);
+  process.exit(1);
+}
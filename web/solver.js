@@ -0,0 +1,187 @@
+// Reference solver of the PoW protocol for browsers and Node.js.
+//
+// The WebSocket endpoint (see Server.WebSocketHandler) carries one power.Message per binary message:
+// connect -> verify message "hash|byteIndex|byteValue" -> content with the found nonce -> the content.
+// The difficulty comes with the verify message in power.Message.challenge, the server checks the solution
+// against it.
+// The hash format must stay in sync with Pow.GenerateHash: sha256("<msg>:<nonce>").
+
+export const CommandType = Object.freeze({
+  None: 0,
+  Connect: 100,
+  Content: 200,
+  ErrInvalidHash: 400,
+  Close: 999,
+});
+
+const ZERO = 0x30; // Pow.IsValidHash compares the leading bytes with the '0' character
+const PIPE = 0x7c;
+const encoder = new TextEncoder();
+
+function writeVarint(out, value) {
+  while (value > 0x7f) {
+    out.push((value & 0x7f) | 0x80);
+    value >>>= 7;
+  }
+  out.push(value);
+}
+
+function readVarint(bytes, offset) {
+  let value = 0;
+  let shift = 0;
+  for (;;) {
+    if (offset >= bytes.length) throw new Error("truncated varint");
+    const b = bytes[offset++];
+    value += (b & 0x7f) * 2 ** shift;
+    if (b < 0x80) return [value, offset];
+    shift += 7;
+  }
+}
+
+// encodeMessage serializes power.Message: command = 1 (varint), body = 2 (bytes).
+export function encodeMessage({ command, body }) {
+  const out = [];
+  if (command) {
+    out.push(0x08);
+    writeVarint(out, command);
+  }
+  if (body && body.length > 0) {
+    out.push(0x12);
+    writeVarint(out, body.length);
+    out.push(...body);
+  }
+  return new Uint8Array(out);
+}
+
+// decodeFields calls onField(field, value) for the varint and the length-delimited fields of a protobuf message.
+function decodeFields(bytes, onField) {
+  let offset = 0;
+  while (offset < bytes.length) {
+    let key;
+    [key, offset] = readVarint(bytes, offset);
+    const field = Math.floor(key / 8);
+    const wireType = key & 7;
+    if (wireType === 0) {
+      let value;
+      [value, offset] = readVarint(bytes, offset);
+      onField(field, value);
+    } else if (wireType === 2) {
+      let length;
+      [length, offset] = readVarint(bytes, offset);
+      if (offset + length > bytes.length) throw new Error("truncated field");
+      onField(field, bytes.slice(offset, offset + length));
+      offset += length;
+    } else {
+      throw new Error(`unsupported wire type ${wireType}`);
+    }
+  }
+}
+
+// decodeMessage parses power.Message: command = 1, body = 2, challenge = 4. The unknown fields are skipped.
+export function decodeMessage(bytes) {
+  const message = { command: CommandType.None, body: new Uint8Array(), challenge: null };
+  decodeFields(bytes, (field, value) => {
+    if (field === 1) message.command = value;
+    if (field === 2) message.body = value;
+    if (field === 4) message.challenge = decodeChallenge(value);
+  });
+  return message;
+}
+
+// decodeChallenge parses power.Challenge: difficulty = 6, algorithm = 7. The conditions come in the verify message.
+export function decodeChallenge(bytes) {
+  const challenge = { difficulty: 0, algorithm: "" };
+  decodeFields(bytes, (field, value) => {
+    if (field === 6) challenge.difficulty = value;
+    if (field === 7) challenge.algorithm = new TextDecoder().decode(value);
+  });
+  return challenge;
+}
+
+// challengeDifficulty returns the difficulty of the verify message, only the SHA-256 search is supported.
+export function challengeDifficulty(message) {
+  const { challenge } = message;
+  if (!challenge) throw new Error("the server doesn't report the difficulty");
+  if (challenge.algorithm && challenge.algorithm !== "sha256") {
+    throw new Error(`unsupported algorithm ${challenge.algorithm}`);
+  }
+  return challenge.difficulty;
+}
+
+// splitChallenge parses the verify message. The hash is raw bytes, so the separators are searched from the end.
+export function splitChallenge(body) {
+  const second = body.lastIndexOf(PIPE);
+  const first = body.lastIndexOf(PIPE, second - 1);
+  if (first < 0 || second < 0) throw new Error("malformed challenge");
+
+  const text = new TextDecoder().decode(body.slice(first + 1));
+  const [byteIndex, byteValue] = text.split("|").map(Number);
+  return { hash: body.slice(0, first), byteIndex, byteValue };
+}
+
+export async function generateHash(msg, nonce) {
+  const suffix = encoder.encode(`:${nonce}`);
+  const data = new Uint8Array(msg.length + suffix.length);
+  data.set(msg);
+  data.set(suffix, msg.length);
+  return new Uint8Array(await crypto.subtle.digest("SHA-256", data));
+}
+
+export function isValidHash(hash, difficulty, byteIndex, byteValue) {
+  for (let i = 0; i < difficulty; i++) {
+    if (hash[i] !== ZERO) return false;
+  }
+  return hash[byteIndex] === byteValue;
+}
+
+// findNonce searches nonces start, start+step, ... so several workers can split the range.
+export async function findNonce(challenge, difficulty, { start = 0, step = 1, signal, onProgress } = {}) {
+  for (let nonce = start; ; nonce += step) {
+    if (signal?.aborted) throw signal.reason ?? new Error("aborted");
+    const hash = await generateHash(challenge.hash, nonce);
+    if (isValidHash(hash, difficulty, challenge.byteIndex, challenge.byteValue)) return nonce;
+    if (onProgress && nonce % 4096 === start % 4096) onProgress(nonce);
+  }
+}
+
+// fetchContent runs the whole exchange over a WebSocket and resolves with the content bytes.
+export function fetchContent(url, { signal, onProgress } = {}) {
+  return new Promise((resolve, reject) => {
+    const ws = new WebSocket(url);
+    ws.binaryType = "arraybuffer";
+
+    const send = (command, body) => ws.send(encodeMessage({ command, body }));
+    const fail = (err) => {
+      ws.close();
+      reject(err);
+    };
+
+    ws.onopen = () => send(CommandType.Connect);
+    ws.onerror = () => fail(new Error("websocket error"));
+    ws.onmessage = async (event) => {
+      try {
+        const message = decodeMessage(new Uint8Array(event.data));
+        switch (message.command) {
+          case CommandType.Connect: {
+            const difficulty = challengeDifficulty(message);
+            const nonce = await findNonce(splitChallenge(message.body), difficulty, { signal, onProgress });
+            send(CommandType.Content, encoder.encode(String(nonce)));
+            break;
+          }
+          case CommandType.Content:
+            send(CommandType.Close);
+            ws.close();
+            resolve(message.body);
+            break;
+          case CommandType.ErrInvalidHash:
+            fail(new Error("invalid hash"));
+            break;
+          default:
+            fail(new Error(`unexpected command ${message.command}`));
+        }
+      } catch (err) {
+        fail(err);
+      }
+    };
+  });
+}
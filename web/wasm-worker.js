@@ -0,0 +1,19 @@
+// Web Worker running the Go solver compiled to WebAssembly (cmd/wasm-solver).
+// It expects wasm_exec.js and solver.wasm next to it, see `make wasm`.
+/* global Go, powSolve */
+importScripts("wasm_exec.js");
+
+const ready = (async () => {
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiateStreaming(fetch("solver.wasm"), go.importObject);
+  go.run(instance);
+})();
+
+self.onmessage = async ({ data }) => {
+  await ready;
+  const nonce = powSolve({
+    ...data,
+    onProgress: (attempts) => self.postMessage({ type: "progress", attempts }),
+  });
+  self.postMessage({ type: "result", nonce });
+};
@@ -0,0 +1,39 @@
+// Parallel solver on top of the WebAssembly build of the Go solver.
+//
+// Every worker checks its own slice of the nonce space: start = i, step = workers.
+// The first found nonce wins and the other workers are terminated.
+// message is the power.Message answering Connect, the solver reads the difficulty from its challenge.
+
+export function solveParallel(message, { workers = navigator.hardwareConcurrency || 4, onProgress, signal } = {}) {
+  return new Promise((resolve, reject) => {
+    const pool = [];
+    const attempts = new Array(workers).fill(0);
+    const stop = () => pool.forEach((worker) => worker.terminate());
+
+    signal?.addEventListener("abort", () => {
+      stop();
+      reject(signal.reason ?? new Error("aborted"));
+    });
+
+    for (let i = 0; i < workers; i++) {
+      const worker = new Worker(new URL("./wasm-worker.js", import.meta.url));
+      worker.onerror = (event) => {
+        stop();
+        reject(event.error ?? new Error(event.message));
+      };
+      worker.onmessage = ({ data }) => {
+        if (data.type === "progress") {
+          attempts[i] = data.attempts;
+          onProgress?.(attempts.reduce((a, b) => a + b, 0));
+          return;
+        }
+        if (data.nonce >= 0) {
+          stop();
+          resolve(data.nonce);
+        }
+      };
+      worker.postMessage({ message, start: i, step: workers });
+      pool.push(worker);
+    }
+  });
+}